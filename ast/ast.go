@@ -10,6 +10,8 @@ import (
 type Node interface {
 	node()
 	String() string
+	Pos() token.Position // позиция первого символа узла
+	End() token.Position // позиция сразу за последним символом узла
 }
 
 // Expression Выражение
@@ -42,6 +44,7 @@ func (*Literal) node() {}
 func (*AssignExpr) node()   {}
 func (*BinaryExpr) node()   {}
 func (*CallExpr) node()     {}
+func (*FunctionExpr) node() {}
 func (*GetExpr) node()      {}
 func (*GroupingExpr) node() {}
 func (*LogicalExpr) node()  {}
@@ -53,24 +56,52 @@ func (*VariableExpr) node() {}
 
 func (*ArrayExpr) node()  {}
 func (*ArrayIndex) node() {}
+func (*MapExpr) node()    {}
+
+func (*BadExpr) node() {}
+func (*BadStmt) node() {}
 
 func (*BlockStmt) node()    {}
+func (*BreakStmt) node()    {}
 func (*ClassStmt) node()    {}
+func (*ContinueStmt) node() {}
 func (*ExprStmt) node()     {}
 func (*FunctionStmt) node() {}
 func (*IfStmt) node()       {}
 func (*PrintStmt) node()    {}
 func (*ReturnStmt) node()   {}
+func (*ThrowStmt) node()    {}
+func (*TryStmt) node()      {}
 func (*VarStmt) node()      {}
 func (*WhileStmt) node()    {}
 
+// BadExpr - заглушка на месте выражения с синтаксической ошибкой
+type BadExpr struct {
+	token.Span
+}
+
+func (*BadExpr) expr() {}
+
+func (*BadExpr) String() string { return "<bad expression>" }
+
+// BadStmt - заглушка на месте оператора с синтаксической ошибкой
+type BadStmt struct {
+	token.Span
+}
+
+func (*BadStmt) stmt() {}
+
+func (*BadStmt) String() string { return "<bad statement>" }
+
 type Identifier struct {
+	token.Span
 	Name string
 }
 
 func (ident *Identifier) String() string { return ident.Name }
 
 type Literal struct {
+	token.Span
 	Token token.Token
 	Value string
 }
@@ -93,31 +124,51 @@ func (lit *Literal) String() string {
 
 type (
 	AssignExpr struct {
+		token.Span
 		Left  LeftExpr
 		Value Expression
 	}
 	BinaryExpr struct {
+		token.Span
 		Left     Expression
 		Operator token.Token
 		Right    Expression
 	}
 	CallExpr struct {
+		token.Span
 		Callee    Expression
 		Arguments []Expression
 	}
+	// FunctionExpr - анонимная функция: fun (a, b) { ... } или (a, b) => a + b
+	FunctionExpr struct {
+		token.Span
+		Params []*Identifier
+		Body   []Statement
+		Arrow  bool // короткая форма, Body состоит из одного ReturnStmt
+	}
 
 	// ----
 
 	ArrayExpr struct {
+		token.Span
 		Elements []Expression
 	}
 
 	ArrayIndex struct {
+		token.Span
 		Array Expression
 		Index Expression
 	}
 
+	// MapExpr - литерал словаря {key: value, ...}
+	MapExpr struct {
+		token.Span
+		Keys   []Expression
+		Values []Expression
+	}
+
 	ArrayAppendExpr struct {
+		token.Span
 		Array ArrayExpr
 		Value Expression
 	}
@@ -125,33 +176,41 @@ type (
 	// ----
 
 	GetExpr struct {
+		token.Span
 		Object Expression
 		Name   string
 	}
 	GroupingExpr struct {
+		token.Span
 		Expression Expression
 	}
 	LogicalExpr struct {
+		token.Span
 		Left     Expression
 		Operator token.Token
 		Right    Expression
 	}
 	SetExpr struct {
+		token.Span
 		Object Expression
 		Name   string
 		Value  Expression
 	}
 	SuperExpr struct {
-		// Method  Identifier
-		Keyword token.Token
-		Method  token.Token
+		token.Span
+		Method   string
+		Distance int // расстояние до окружения, в котором определен super
+	}
+	ThisExpr struct {
+		token.Span
 	}
-	ThisExpr  struct{}
 	UnaryExpr struct {
+		token.Span
 		Operator token.Token
 		Right    Expression
 	}
 	VariableExpr struct {
+		token.Span
 		Name     string
 		Distance int // NOTE!! -1 используем, когда переменная ГЛОБАЛЬНАЯ
 	}
@@ -160,6 +219,7 @@ type (
 func (*AssignExpr) expr()   {}
 func (*BinaryExpr) expr()   {}
 func (*CallExpr) expr()     {}
+func (*FunctionExpr) expr() {}
 func (*GetExpr) expr()      {}
 func (*GroupingExpr) expr() {}
 func (*LogicalExpr) expr()  {}
@@ -172,6 +232,7 @@ func (*VariableExpr) expr() {}
 func (*ArrayExpr) expr()       {}
 func (*ArrayIndex) expr()      {}
 func (*ArrayAppendExpr) expr() {}
+func (*MapExpr) expr()         {}
 
 func (*GetExpr) dotExpr()         {}
 func (*ArrayAppendExpr) dotExpr() {}
@@ -192,6 +253,25 @@ func (e *CallExpr) String() string {
 	return fmt.Sprintf("%s(%s)", e.Callee, strings.Join(args, ", "))
 }
 
+func (e *FunctionExpr) String() string {
+	params := make([]string, len(e.Params))
+	for i, p := range e.Params {
+		params[i] = p.Name
+	}
+	if e.Arrow {
+		return fmt.Sprintf("(%s) => %s", strings.Join(params, ", "), e.Body[0].(*ReturnStmt).Value)
+	}
+	var sb strings.Builder
+	sb.WriteString("fun (")
+	sb.WriteString(strings.Join(params, ", "))
+	sb.WriteString(") { ")
+	for _, stmt := range e.Body {
+		sb.WriteString(stmt.String())
+	}
+	sb.WriteString(" }")
+	return sb.String()
+}
+
 func (e *ArrayExpr) String() string {
 	var elements []string
 	for _, el := range e.Elements {
@@ -204,6 +284,14 @@ func (e *ArrayIndex) String() string {
 	return fmt.Sprintf("%s[%s]", e.Array.String(), e.Index.String())
 }
 
+func (e *MapExpr) String() string {
+	entries := make([]string, len(e.Keys))
+	for i, key := range e.Keys {
+		entries[i] = key.String() + ": " + e.Values[i].String()
+	}
+	return "{" + strings.Join(entries, ", ") + "}"
+}
+
 func (e *GetExpr) String() string {
 	return e.Object.String() + "." + e.Name
 }
@@ -221,7 +309,7 @@ func (e *SetExpr) String() string {
 }
 
 func (e *SuperExpr) String() string {
-	return "TODO"
+	return "super." + e.Method
 }
 
 func (e *ThisExpr) String() string {
@@ -238,51 +326,84 @@ func (e *VariableExpr) String() string {
 
 type (
 	BlockStmt struct {
+		token.Span
 		Statements []Statement
 	}
+	BreakStmt struct {
+		token.Span
+	}
 	ClassStmt struct {
+		token.Span
 		Name       string
-		SuperClass VariableExpr
+		NameSpan   token.Span
+		SuperClass *VariableExpr // nil, если у класса нет родителя
 		Methods    []*FunctionStmt
 	}
+	ContinueStmt struct {
+		token.Span
+	}
 	ExprStmt struct {
+		token.Span
 		Expression Expression
 	}
 	FunctionStmt struct {
+		token.Span
 		Name          string
+		NameSpan      token.Span
 		Params        []*Identifier
 		Body          []Statement
 		IsInitializer bool
 	}
 	IfStmt struct {
+		token.Span
 		Condition  Expression
 		ThenBranch Statement
 		ElseBranch Statement
 	}
 	PrintStmt struct {
+		token.Span
 		Expression Expression
 	}
 	ReturnStmt struct {
+		token.Span
 		Keyword token.Token
 		Value   Expression
 	}
+	ThrowStmt struct {
+		token.Span
+		Value Expression
+	}
+	TryStmt struct {
+		token.Span
+		Body        *BlockStmt
+		CatchName   *Identifier // nil, если нет catch
+		CatchBody   *BlockStmt
+		FinallyBody *BlockStmt // nil, если нет finally
+	}
 	VarStmt struct {
+		token.Span
 		Name        *Identifier
 		Initializer Expression
 	}
 	WhileStmt struct {
+		token.Span
 		Condition Expression
 		Body      Statement
+		Increment Expression // шаг цикла for, выполняется после тела и при continue
 	}
 )
 
 func (*BlockStmt) stmt()    {}
+func (*BreakStmt) stmt()    {}
 func (*ClassStmt) stmt()    {}
+func (*ContinueStmt) stmt() {}
 func (*ExprStmt) stmt()     {}
 func (*FunctionStmt) stmt() {}
 func (*IfStmt) stmt()       {}
 func (*PrintStmt) stmt()    {}
 func (*ReturnStmt) stmt()   {}
+func (*ThrowStmt) stmt()    {}
+func (*TryStmt) stmt()      {}
 func (*VarStmt) stmt()      {}
 func (*WhileStmt) stmt()    {}
 
@@ -296,10 +417,21 @@ func (s *BlockStmt) String() string {
 	return sb.String()
 }
 
+func (s *BreakStmt) String() string {
+	return "break;"
+}
+
 func (s *ClassStmt) String() string {
+	if s.SuperClass != nil {
+		return "class " + s.Name + " < " + s.SuperClass.Name
+	}
 	return "class " + s.Name
 }
 
+func (s *ContinueStmt) String() string {
+	return "continue;"
+}
+
 func (s *ExprStmt) String() string {
 	return s.Expression.String() + ";"
 }
@@ -351,6 +483,27 @@ func (s *ReturnStmt) String() string {
 	return str + ";"
 }
 
+func (s *ThrowStmt) String() string {
+	return "throw " + s.Value.String() + ";"
+}
+
+func (s *TryStmt) String() string {
+	var sb strings.Builder
+	sb.WriteString("try ")
+	sb.WriteString(s.Body.String())
+	if s.CatchBody != nil {
+		sb.WriteString(" catch (")
+		sb.WriteString(s.CatchName.String())
+		sb.WriteString(") ")
+		sb.WriteString(s.CatchBody.String())
+	}
+	if s.FinallyBody != nil {
+		sb.WriteString(" finally ")
+		sb.WriteString(s.FinallyBody.String())
+	}
+	return sb.String()
+}
+
 func (s *VarStmt) String() string {
 	var sb strings.Builder
 	sb.WriteString("var ")
@@ -366,7 +519,15 @@ func (s *WhileStmt) String() string {
 	sb.WriteString("while (")
 	sb.WriteString(s.Condition.String())
 	sb.WriteString(") ")
+	if s.Increment == nil {
+		sb.WriteString(s.Body.String())
+		return sb.String()
+	}
+	// печатаем в том же виде, в каком for раскрывается в while
+	sb.WriteString("{ ")
 	sb.WriteString(s.Body.String())
+	sb.WriteString(s.Increment.String())
+	sb.WriteString("; }")
 	return sb.String()
 }
 
@@ -0,0 +1,112 @@
+package ast
+
+// Inspect обходит дерево в глубину, начиная с node, и вызывает f для каждого узла.
+// Если f возвращает false, дети узла не обходятся
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+	switch n := node.(type) {
+	case *AssignExpr:
+		Inspect(n.Left, f)
+		Inspect(n.Value, f)
+	case *BinaryExpr:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+	case *LogicalExpr:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+	case *UnaryExpr:
+		Inspect(n.Right, f)
+	case *CallExpr:
+		Inspect(n.Callee, f)
+		inspectList(n.Arguments, f)
+	case *FunctionExpr:
+		inspectIdentifiers(n.Params, f)
+		inspectStatements(n.Body, f)
+	case *ArrayExpr:
+		inspectList(n.Elements, f)
+	case *ArrayIndex:
+		Inspect(n.Array, f)
+		Inspect(n.Index, f)
+	case *MapExpr:
+		for i := range n.Keys {
+			Inspect(n.Keys[i], f)
+			Inspect(n.Values[i], f)
+		}
+	case *GetExpr:
+		Inspect(n.Object, f)
+	case *SetExpr:
+		Inspect(n.Object, f)
+		Inspect(n.Value, f)
+	case *GroupingExpr:
+		Inspect(n.Expression, f)
+
+	case *BlockStmt:
+		inspectStatements(n.Statements, f)
+	case *ClassStmt:
+		if n.SuperClass != nil {
+			Inspect(n.SuperClass, f)
+		}
+		for _, method := range n.Methods {
+			Inspect(method, f)
+		}
+	case *ExprStmt:
+		Inspect(n.Expression, f)
+	case *FunctionStmt:
+		inspectIdentifiers(n.Params, f)
+		inspectStatements(n.Body, f)
+	case *IfStmt:
+		Inspect(n.Condition, f)
+		Inspect(n.ThenBranch, f)
+		if n.ElseBranch != nil {
+			Inspect(n.ElseBranch, f)
+		}
+	case *PrintStmt:
+		Inspect(n.Expression, f)
+	case *ReturnStmt:
+		if n.Value != nil {
+			Inspect(n.Value, f)
+		}
+	case *ThrowStmt:
+		Inspect(n.Value, f)
+	case *TryStmt:
+		Inspect(n.Body, f)
+		if n.CatchBody != nil {
+			Inspect(n.CatchName, f)
+			Inspect(n.CatchBody, f)
+		}
+		if n.FinallyBody != nil {
+			Inspect(n.FinallyBody, f)
+		}
+	case *VarStmt:
+		Inspect(n.Name, f)
+		if n.Initializer != nil {
+			Inspect(n.Initializer, f)
+		}
+	case *WhileStmt:
+		Inspect(n.Condition, f)
+		Inspect(n.Body, f)
+		if n.Increment != nil {
+			Inspect(n.Increment, f)
+		}
+	}
+}
+
+func inspectList(exprs []Expression, f func(Node) bool) {
+	for _, expr := range exprs {
+		Inspect(expr, f)
+	}
+}
+
+func inspectStatements(statements []Statement, f func(Node) bool) {
+	for _, stmt := range statements {
+		Inspect(stmt, f)
+	}
+}
+
+func inspectIdentifiers(idents []*Identifier, f func(Node) bool) {
+	for _, ident := range idents {
+		Inspect(ident, f)
+	}
+}
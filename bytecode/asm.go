@@ -0,0 +1,372 @@
+package bytecode_gen
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Dor1ma/Strawberry/token"
+)
+
+// Assemble собирает программу из листинга в формате Disassemble. Кроме того, что печатает
+// дизассемблер, листинг может содержать то, что удобно писать руками:
+//
+//	loop:                  метка, на нее можно перейти по имени: JUMP loop
+//	PUSH_CONST "hi"        константа прямо в операнде, она добавится в пул
+//	DEFINE_VAR x           имя вместо номера константы
+//	FUNC f                 функция по имени вместо номера
+//
+// Смещения в начале строк необязательны, но если они есть, то проверяются. Позиция [строка:колонка]
+// действует до следующей позиции. Комментарии начинаются с ';'. name - имя листинга для сообщений об ошибках
+func Assemble(name string, in io.Reader) (*Program, error) {
+	a := &assembler{name: name, filename: name, program: &Program{}}
+	if err := a.run(in); err != nil {
+		return nil, err
+	}
+	if err := a.program.Validate(); err != nil {
+		return nil, err
+	}
+	return a.program, nil
+}
+
+// asmError - ошибка в строке листинга, assembler выходит с ней из разбора
+type asmError struct {
+	line int
+	msg  string
+}
+
+// funcRef - операнд FUNC с именем функции, которая может быть объявлена ниже
+type funcRef struct {
+	fn   *Function
+	at   int
+	name string
+	line int
+}
+
+type assembler struct {
+	name     string
+	filename string
+	program  *Program
+	line     int
+
+	fn       *Function
+	pos      token.Position
+	labels   map[string]int
+	fixups   []jumpFixup
+	funcRefs []funcRef
+	fixLines map[int]int // строка листинга для каждого исправляемого перехода
+}
+
+func (a *assembler) fail(format string, args ...interface{}) {
+	panic(asmError{line: a.line, msg: fmt.Sprintf(format, args...)})
+}
+
+func (a *assembler) run(in io.Reader) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			asmErr, ok := r.(asmError)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("%s:%d: %s", a.name, asmErr.line, asmErr.msg)
+		}
+	}()
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		a.line++
+		a.parseLine(splitFields(a, scanner.Text()))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if a.fn != nil {
+		a.fail("function %s is not closed with end", a.fn.Name)
+	}
+	a.resolveFunctions()
+	return nil
+}
+
+// splitFields делит строку на поля, строки в кавычках остаются одним полем. Комментарий отбрасывается
+func splitFields(a *assembler, line string) []string {
+	var fields []string
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" || line[0] == ';' {
+			return fields
+		}
+		if line[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				a.fail("unterminated string")
+			}
+			fields = append(fields, quoted)
+			line = line[len(quoted):]
+			continue
+		}
+		end := strings.IndexAny(line, " \t;")
+		if end < 0 {
+			end = len(line)
+		}
+		fields = append(fields, line[:end])
+		line = line[end:]
+	}
+}
+
+func (a *assembler) parseLine(fields []string) {
+	if len(fields) == 0 {
+		return
+	}
+	switch fields[0] {
+	case "file":
+		if len(fields) != 2 {
+			a.fail("file expects a quoted name")
+		}
+		a.filename = a.unquote(fields[1])
+		return
+	case "function":
+		a.beginFunction(fields[1:])
+		return
+	case "end":
+		a.endFunction()
+		return
+	}
+
+	if a.fn == nil {
+		a.fail("%s outside of a function", fields[0])
+	}
+	if fields[0] == "const" {
+		a.parseConst(fields[1:])
+		return
+	}
+	if label := fields[0]; strings.HasSuffix(label, ":") && len(fields) == 1 {
+		a.defineLabel(strings.TrimSuffix(label, ":"))
+		return
+	}
+
+	offset := len(a.fn.Chunk.Code)
+	if isNumber(fields[0]) {
+		if n, _ := strconv.Atoi(fields[0]); n != offset {
+			a.fail("offset %s does not match the instruction offset %04d", fields[0], offset)
+		}
+		fields = fields[1:]
+	}
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "[") {
+		a.parsePosition(fields[0])
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		a.fail("missing instruction")
+	}
+	a.parseInstruction(fields[0], fields[1:])
+}
+
+func (a *assembler) beginFunction(args []string) {
+	if a.fn != nil {
+		a.fail("function %s is not closed with end", a.fn.Name)
+	}
+	if len(args) == 3 {
+		if n, err := strconv.Atoi(args[0]); err != nil || n != len(a.program.Functions) {
+			a.fail("function number %s does not match its position %d", args[0], len(a.program.Functions))
+		}
+		args = args[1:]
+	}
+	if len(args) != 2 {
+		a.fail("function expects a name and an arity")
+	}
+	arity, err := strconv.Atoi(args[1])
+	if err != nil || arity < 0 {
+		a.fail("invalid arity %s", args[1])
+	}
+	a.fn = &Function{Name: args[0], Arity: arity}
+	a.program.Functions = append(a.program.Functions, a.fn)
+	a.pos = token.Position{}
+	a.labels = make(map[string]int)
+	a.fixups = nil
+	a.fixLines = make(map[int]int)
+}
+
+func (a *assembler) endFunction() {
+	if a.fn == nil {
+		a.fail("end without function")
+	}
+	for _, f := range a.fixups {
+		target, ok := a.labels[f.label]
+		if !ok {
+			a.line = a.fixLines[f.at]
+			a.fail("label %s not found", f.label)
+		}
+		copy(a.fn.Chunk.Code[f.at:], appendOperand(nil, offsetWidth, target-f.next))
+	}
+	a.fn = nil
+}
+
+func (a *assembler) defineLabel(label string) {
+	if _, ok := a.labels[label]; ok || !isIdentifier(label) {
+		a.fail("invalid or duplicate label %s", label)
+	}
+	a.labels[label] = len(a.fn.Chunk.Code)
+}
+
+func (a *assembler) parseConst(args []string) {
+	if len(args) == 2 {
+		if args[0] != fmt.Sprintf("#%d", len(a.fn.Chunk.Constants)) {
+			a.fail("constant %s does not match its position #%d", args[0], len(a.fn.Chunk.Constants))
+		}
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		a.fail("const expects a value")
+	}
+	// повторяющиеся константы допустимы, поэтому они добавляются без поиска в пуле
+	a.fn.Chunk.Constants = append(a.fn.Chunk.Constants, a.literal(args[0]))
+	a.fn.Chunk.constants = nil
+}
+
+func (a *assembler) parsePosition(field string) {
+	var line, column int
+	if _, err := fmt.Sscanf(field, "[%d:%d]", &line, &column); err != nil {
+		a.fail("invalid position %s", field)
+	}
+	a.pos = token.Position{}
+	if line > 0 {
+		a.pos = token.Position{Filename: a.filename, Line: line, Column: column}
+	}
+}
+
+func (a *assembler) parseInstruction(name string, args []string) {
+	op, ok := opcodeByName(name)
+	if !ok || !op.Valid() {
+		a.fail("unknown instruction %s", name)
+	}
+	widths := OperandWidths(op)
+	if len(args) != len(widths) {
+		a.fail("%s expects %d operands, got %d", op, len(widths), len(args))
+	}
+
+	chunk := &a.fn.Chunk
+	offset := len(chunk.Code)
+	next := offset + InstructionSize(op)
+	chunk.addLine(offset, a.pos)
+	chunk.Code = append(chunk.Code, byte(op))
+	for i, width := range widths {
+		arg := args[i]
+		var value int
+		switch {
+		case width == offsetWidth:
+			if isIdentifier(arg) {
+				a.fixups = append(a.fixups, jumpFixup{at: len(chunk.Code), next: next, label: arg})
+				a.fixLines[len(chunk.Code)] = a.line
+			} else {
+				value = a.number(arg) - next
+			}
+		case op == FUNC && isIdentifier(arg):
+			a.funcRefs = append(a.funcRefs, funcRef{fn: a.fn, at: len(chunk.Code), name: arg, line: a.line})
+		case op == FUNC:
+			value = a.number(arg)
+		case i == 0 && width == indexWidth && op != NEW_ARRAY && op != NEW_MAP:
+			value = a.constantOperand(op, arg)
+		case i == 1 && arg == "global":
+			value = GlobalDistance
+		default:
+			value = a.number(arg)
+		}
+		if width != offsetWidth && (value < 0 || value >= 1<<(8*width)) {
+			a.fail("operand %s of %s does not fit in %d bytes", arg, op, width)
+		}
+		chunk.Code = appendOperand(chunk.Code, width, value)
+	}
+}
+
+// constantOperand возвращает номер константы: #N, значение для PUSH_CONST или имя для остальных инструкций
+func (a *assembler) constantOperand(op Opcode, arg string) int {
+	chunk := &a.fn.Chunk
+	if strings.HasPrefix(arg, "#") {
+		n := a.number(arg[1:])
+		if n >= len(chunk.Constants) {
+			a.fail("constant #%d is not defined", n)
+		}
+		return n
+	}
+	if op == PUSH_CONST {
+		return chunk.AddConstant(a.literal(arg))
+	}
+	if strings.HasPrefix(arg, `"`) {
+		return chunk.AddConstant(StringValue(a.unquote(arg)))
+	}
+	return chunk.AddConstant(StringValue(arg))
+}
+
+func (a *assembler) resolveFunctions() {
+	for _, ref := range a.funcRefs {
+		index := -1
+		for i, fn := range a.program.Functions {
+			if fn.Name == ref.name {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			a.line = ref.line
+			a.fail("function %s not found", ref.name)
+		}
+		copy(ref.fn.Chunk.Code[ref.at:], appendOperand(nil, indexWidth, index))
+	}
+}
+
+func (a *assembler) literal(arg string) Constant {
+	if strings.HasPrefix(arg, `"`) {
+		return StringValue(a.unquote(arg))
+	}
+	value, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		a.fail("invalid constant %s", arg)
+	}
+	return NumberValue(value)
+}
+
+func (a *assembler) number(arg string) int {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		a.fail("invalid number %s", arg)
+	}
+	return n
+}
+
+func (a *assembler) unquote(arg string) string {
+	s, err := strconv.Unquote(arg)
+	if err != nil {
+		a.fail("invalid string %s", arg)
+	}
+	return s
+}
+
+func opcodeByName(name string) (Opcode, bool) {
+	for op := Opcode(0); op < opcodeCount; op++ {
+		if opcodes[op].name == name {
+			return op, true
+		}
+	}
+	return 0, false
+}
+
+func isNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isIdentifier(s string) bool {
+	if s == "" || (s[0] >= '0' && s[0] <= '9') || s[0] == '-' || s[0] == '#' || s[0] == '"' {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+package bytecode_gen
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Dor1ma/Strawberry/lexer"
+	"github.com/Dor1ma/Strawberry/parser"
+)
+
+func compile(t *testing.T, input string) *Program {
+	statements, err := parser.New(lexer.NewFile("test.berry", input)).Parse()
+	if err != nil {
+		t.Fatalf("parse error: %s", err)
+	}
+	cg := CodeGenerator{}
+	if err := cg.GenerateProgram(statements); err != nil {
+		t.Fatalf("generate error: %s", err)
+	}
+	program, err := cg.Program()
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	return program
+}
+
+func TestDisassembleRoundTrip(t *testing.T) {
+	tests := []string{
+		`print "hello world";`,
+		`var a = 1.5; a = a * 2e3; print a;`,
+		`fun f(x) { if (x < 2) return x; return f(x - 1) + f(x - 2); } print f(10);`,
+		`var i = 0; while (i < 3) { i = i + 1; if (i == 2) continue; print i; }`,
+		`class A { init(x) { this.x = x; } get() { return this.x; } }
+		 class B < A { get() { return super.get() + 1; } }
+		 print B(1).get();`,
+		`try { throw "x"; } catch (e) { print e; } finally { print "done"; }`,
+		`var m = {"a; b": [1, 2]}; print m["a; b"];`,
+	}
+
+	for i, input := range tests {
+		program := compile(t, input)
+		var listing bytes.Buffer
+		if err := Disassemble(&listing, program); err != nil {
+			t.Fatalf("test [%d] failed. disassemble error: %s", i, err)
+		}
+		assembled, err := Assemble("test.bca", strings.NewReader(listing.String()))
+		if err != nil {
+			t.Fatalf("test [%d] failed. assemble error: %s\n%s", i, err, listing.String())
+		}
+		var again bytes.Buffer
+		Disassemble(&again, assembled)
+		if again.String() != listing.String() {
+			t.Fatalf("test [%d] failed. listing changed after assembling:\n%s\nwant:\n%s", i, again.String(), listing.String())
+		}
+		for j, fn := range program.Functions {
+			if !bytes.Equal(fn.Chunk.Code, assembled.Functions[j].Chunk.Code) {
+				t.Fatalf("test [%d] failed. code of %s differs", i, fn.Name)
+			}
+		}
+	}
+}
+
+func TestAssembleLabels(t *testing.T) {
+	input := `
+function <script> 0
+loop:
+  PUSH_VAR i global
+  PUSH_CONST 3
+  LESS_THAN
+  JUMP_IF_FALSE done
+  FUNC f
+  CALL_FUNCTION 0
+  POP
+  JUMP loop
+done:
+end
+
+function f 0
+  NIL
+  RETURN
+end`
+	program, err := Assemble("test.bca", strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("assemble error: %s", err)
+	}
+	code := program.Functions[0].Chunk.Code
+	// JUMP_IF_FALSE перепрыгивает FUNC, CALL_FUNCTION, POP и JUMP
+	if got := ReadOperand(code, 10, offsetWidth); got != 11 {
+		t.Fatalf("JUMP_IF_FALSE offset is %d, want 11", got)
+	}
+	if got := ReadOperand(code, len(code)-4, offsetWidth); got != -len(code) {
+		t.Fatalf("JUMP offset is %d, want %d", got, -len(code))
+	}
+	if got := ReadOperand(code, 15, indexWidth); got != 1 {
+		t.Fatalf("FUNC refers to function %d, want 1", got)
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"function f 0\n  JUMP nowhere\nend", "test.bca:2: label nowhere not found"},
+		{"function f 0\n  FOO\nend", "test.bca:2: unknown instruction FOO"},
+		{"function f 0\n  PUSH_CONST\nend", "test.bca:2: PUSH_CONST expects 1 operands, got 0"},
+		{"function f 0\n  0005 POP\nend", "test.bca:2: offset 0005 does not match the instruction offset 0000"},
+		{"function f 0\n  FUNC g\nend", "test.bca:2: function g not found"},
+		{"  POP", "test.bca:1: POP outside of a function"},
+		{"function f 0\n  PUSH_CONST #3\nend", "test.bca:2: constant #3 is not defined"},
+		{"function f 0\n  JUMP 0001\nend", "bytecode: function f: jump to 1 is not an instruction"},
+		{"function f 0\n  ADD\n  PRINT\nend", "bytecode: function f: ADD at 0 needs 2 values on the stack, but there are 0"},
+		{"function f 0\n  TRUE\n  JUMP_IF_FALSE end\n  NIL\nend:\n  PRINT\nend", "bytecode: function f: stack depth at 7 is 0 or 1 depending on the path"},
+	}
+
+	for i, test := range tests {
+		_, err := Assemble("test.bca", strings.NewReader(test.input))
+		if err == nil || err.Error() != test.expected {
+			t.Fatalf("test [%d] failed. expected error %q, got %v", i, test.expected, err)
+		}
+	}
+}
+
+func TestReadRejectsCorruptedFile(t *testing.T) {
+	var buf bytes.Buffer
+	if err := compile(t, `print 1;`).Write(&buf); err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+	data := buf.Bytes()
+
+	if _, err := Read(bytes.NewReader(data)); err != nil {
+		t.Fatalf("read error: %s", err)
+	}
+
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)/2] ^= 0xFF
+	if _, err := Read(bytes.NewReader(corrupted)); err != ErrCorrupted {
+		t.Fatalf("expected ErrCorrupted, got %v", err)
+	}
+
+	version := append([]byte(nil), data...)
+	version[5] = FormatVersion + 1
+	if _, err := Read(bytes.NewReader(version)); err == nil || err.Error() != "bytecode: unsupported format version 2, expected 1" {
+		t.Fatalf("expected version error, got %v", err)
+	}
+
+	if _, err := Read(strings.NewReader("print 1;")); err != ErrNotCompiled {
+		t.Fatalf("expected ErrNotCompiled, got %v", err)
+	}
+}
@@ -3,98 +3,125 @@ package bytecode_gen
 import (
 	"fmt"
 	"github.com/Dor1ma/Strawberry/ast"
+	"github.com/Dor1ma/Strawberry/errors"
+	"github.com/Dor1ma/Strawberry/resolver"
 	"github.com/Dor1ma/Strawberry/token"
+	"github.com/Dor1ma/Strawberry/valuer"
 	"strconv"
 )
 
-const (
-	NEG = "NEG" // Унарный минус
-	NOT = "NOT" // Логическое отрицание
-	ADD = "ADD" // Сложение
-	SUB = "SUB" // Вычитание
-	MUL = "MUL" // Умножение
-	DIV = "DIV" // Деление
-	AND = "AND" // Деление
-	OR  = "OR"  // Деление
-
-	LESS_THAN          = "LESS_THAN"          // Меньше
-	GREATER_THAN       = "GREATER_THAN"       // Больше
-	LESS_EQUAL_THAN    = "LESS_EQUAL_THAN"    // Меньше или равно
-	GREATER_EQUAL_THAN = "GREATER_EQUAL_THAN" // Больше или равно
-	EQUAL              = "EQUAL"              // Равенство
-	NOT_EQUAL          = "NOT_EQUAL"          // Неравенство
-
-	JUMP          = "JUMP"          // Безусловный переход
-	JUMP_IF_FALSE = "JUMP_IF_FALSE" // Переход, если условие ложно
-	CALL_FUNCTION = "CALL_FUNCTION" // Вызов функции
-	RETURN        = "RETURN"        // Возврат из функции
-
-	PUSH_CONST = "PUSH_CONST" // Поместить константу в стек
-	PUSH_VAR   = "PUSH_VAR"   // Поместить значение переменной в стек
-	STORE_VAR  = "STORE_VAR"  // Сохранить значение в переменной
-	NEW_ARRAY  = "NEW_ARRAY"  // Создать новый массив
-	ARRAY_GET  = "ARRAY_GET"  // Получить значение из массива
-	ARRAY_SET  = "ARRAY_SET"  // Установить значение в массиве
-
-	GET_PROPERTY = "GET_PROPERTY" // Получить свойство объекта
-	SET_PROPERTY = "SET_PROPERTY" // Установить свойство объекта
-
-	PRINT    = "PRINT" // Вывод значения
-	SUPER    = "SUPER" // Обращение к суперклассу
-	THIS     = "THIS"  // Ссылка на текущий объект
-	FUNC     = "FUNC"  // Объявление функции
-	END_FUNC = "END_FUNC"
-
-	LABEL            = "label"        // Метка для перехода
-	FALSE_LABEL      = "false_label_" // Метка для перехода при false
-	LOOP_START_LABEL = "loop_start_"  // Метка старта цикла
-	LOOP_END_LABEL   = "loop_end_"    // Метка конца цикла
-	END_LABEL        = "end_label_"   // Метка конца
-
-	SCOPE_START = "scope_start"
-	SCOPE_END   = "scope_end"
-
-	NULL = "NULL"
-)
-
-var isFixedLoopAnalysationEnabled = false
-
+// Bytecode - инструкция до кодирования: переходы ссылаются на метки, функции вложены между FUNC и END_FUNC
 type Bytecode struct {
-	Opcode string
-	Arg    string
+	Opcode Opcode
+	Arg    string         // имя или метка перехода
+	N      int            // число аргументов, элементов или параметров, расстояние до окружения переменной
+	Const  Constant       // значение PUSH_CONST
+	Pos    token.Position // позиция в исходнике, из которой сгенерирована инструкция
+}
+
+func (bc Bytecode) String() string {
+	switch bc.Opcode {
+	case PUSH_CONST:
+		return fmt.Sprintf("%s %s", bc.Opcode, bc.Const.Format())
+	case PUSH_VAR, STORE_VAR, SUPER, CALL_METHOD, FUNC:
+		return fmt.Sprintf("%s %s %d", bc.Opcode, bc.Arg, bc.N)
+	case CALL_FUNCTION, NEW_ARRAY, NEW_MAP:
+		return fmt.Sprintf("%s %d", bc.Opcode, bc.N)
+	}
+	if bc.Arg != "" {
+		return fmt.Sprintf("%s %s", bc.Opcode, bc.Arg)
+	}
+	return bc.Opcode.String()
 }
 
 type CodeGenerator struct {
 	Bytecodes []Bytecode
+
+	pos    token.Position // позиция узла, для которого сейчас генерируется код
+	loops  []loopLabels   // метки объемлющих циклов, последний - самый внутренний
+	tries  []tryBlock     // блоки try, внутри которых генерируется код, последний - самый внутренний
+	scopes int            // число открытых блоков внутри текущей функции
+
+	initializer bool // генерируется тело init: функция возвращает this
+
+	unrollLoops bool         // разворачивать циклы for с известным числом итераций
+	forInit     *ast.VarStmt // объявление счетчика цикла for, который генерируется следующим
+}
+
+// maxUnrolledIterations - циклы с большим числом итераций не разворачиваются, чтобы не раздувать код
+const maxUnrolledIterations = 32
+
+// tryBlock - блок try с установленным обработчиком. break, continue и return, покидающие его,
+// должны снять обработчик и выполнить finally
+type tryBlock struct {
+	finally *ast.BlockStmt
+	loops   int // число объемлющих циклов на входе в блок
+	scopes  int // число открытых блоков, в которых выполняется finally
+}
+
+// loopLabels - метки, на которые переходят break и continue
+type loopLabels struct {
+	next   string
+	end    string
+	scopes int // число открытых блоков вокруг цикла, до него break и continue закрывают блоки
+}
+
+func (cg *CodeGenerator) emit(opcode Opcode, arg string) {
+	cg.emitN(opcode, arg, 0)
+}
+
+func (cg *CodeGenerator) emitN(opcode Opcode, arg string, n int) {
+	cg.Bytecodes = append(cg.Bytecodes, Bytecode{Opcode: opcode, Arg: arg, N: n, Pos: cg.pos})
 }
 
-func (cg *CodeGenerator) emit(opcode, arg string) {
-	cg.Bytecodes = append(cg.Bytecodes, Bytecode{Opcode: opcode, Arg: arg})
+// at запоминает позицию узла для следующих инструкций и возвращает функцию восстановления
+func (cg *CodeGenerator) at(node ast.Node) func() {
+	previous := cg.pos
+	if pos := node.Pos(); pos.IsValid() {
+		cg.pos = pos
+	}
+	return func() {
+		cg.pos = previous
+	}
 }
 
-func (cg *CodeGenerator) GenerateProgram(statements []ast.Statement) {
+// GenerateProgram разрешает переменные и генерирует код программы. PUSH_VAR и STORE_VAR получают
+// расстояние до окружения переменной, вычисленное resolver; ошибки resolver возвращаются как *errors.RuntimeError
+func (cg *CodeGenerator) GenerateProgram(statements []ast.Statement) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			runErr, ok := r.(errors.RuntimeError)
+			if !ok {
+				panic(r)
+			}
+			err = &runErr
+		}
+	}()
+
+	res := resolver.New()
+	for _, stmt := range statements {
+		res.Resolve(stmt)
+	}
 	for _, stmt := range statements {
 		cg.GenerateStatement(stmt)
 	}
+	return nil
 }
 
 func (cg *CodeGenerator) PrintBytecode() {
 	for _, bc := range cg.Bytecodes {
-		fmt.Printf("%s %s\n", bc.Opcode, bc.Arg)
+		fmt.Println(bc)
 	}
 }
 
-func (cg *CodeGenerator) GetBytecodes() []string {
-	var result []string
-
-	for _, bc := range cg.Bytecodes {
-		result = append(result, fmt.Sprintf("%s %s\n", bc.Opcode, bc.Arg))
-	}
-
-	return result
+// Program кодирует сгенерированный код в программу для VM
+func (cg *CodeGenerator) Program() (*Program, error) {
+	return Encode(cg.Bytecodes)
 }
 
 func (cg *CodeGenerator) GenerateExpression(expr ast.Expression) {
+	defer cg.at(expr)()
+
 	switch e := expr.(type) {
 	case *ast.Literal:
 		cg.GenerateLiteral(e)
@@ -104,6 +131,8 @@ func (cg *CodeGenerator) GenerateExpression(expr ast.Expression) {
 		cg.GenerateBinaryExpr(e)
 	case *ast.CallExpr:
 		cg.GenerateCallExpr(e)
+	case *ast.FunctionExpr:
+		cg.GenerateFunctionExpr(e)
 	case *ast.AssignExpr:
 		cg.GenerateAssignExpr(e)
 	case *ast.UnaryExpr:
@@ -116,6 +145,8 @@ func (cg *CodeGenerator) GenerateExpression(expr ast.Expression) {
 		cg.GenerateArrayExpr(e)
 	case *ast.ArrayIndex:
 		cg.GenerateArrayIndex(e)
+	case *ast.MapExpr:
+		cg.GenerateMapExpr(e)
 	case *ast.GetExpr:
 		cg.GenerateGetExpr(e)
 	case *ast.SetExpr:
@@ -128,33 +159,40 @@ func (cg *CodeGenerator) GenerateExpression(expr ast.Expression) {
 }
 
 func (cg *CodeGenerator) GenerateLiteral(lit *ast.Literal) {
-	var value string
 	switch lit.Token {
 	case token.Number:
-		value = lit.Value
+		value, err := strconv.ParseFloat(lit.Value, 64)
+		if err != nil {
+			panic(err)
+		}
+		cg.emitConst(NumberValue(value))
 	case token.String:
-		value = lit.Value
+		cg.emitConst(StringValue(lit.Value))
 	case token.Nil:
-		value = NULL
+		cg.emit(NIL, "")
 	case token.True:
-		value = "true"
+		cg.emit(TRUE, "")
 	case token.False:
-		value = "false"
+		cg.emit(FALSE, "")
 	default:
 		panic("unhandled token for literal")
 	}
-	cg.emit(PUSH_CONST, value)
+}
+
+func (cg *CodeGenerator) emitConst(value Constant) {
+	cg.Bytecodes = append(cg.Bytecodes, Bytecode{Opcode: PUSH_CONST, Const: value, Pos: cg.pos})
 }
 
 func (cg *CodeGenerator) GenerateVariable(varExpr *ast.VariableExpr) {
-	cg.emit(PUSH_VAR, varExpr.Name)
+	// расстояние до окружения переменной, -1 для глобальной
+	cg.emitN(PUSH_VAR, varExpr.Name, varExpr.Distance)
 }
 
 func (cg *CodeGenerator) GenerateBinaryExpr(binExpr *ast.BinaryExpr) {
 	cg.GenerateExpression(binExpr.Left)
 	cg.GenerateExpression(binExpr.Right)
 
-	var opcode string
+	var opcode Opcode
 	switch binExpr.Operator {
 	case token.Plus:
 		opcode = ADD
@@ -179,34 +217,60 @@ func (cg *CodeGenerator) GenerateBinaryExpr(binExpr *ast.BinaryExpr) {
 	default:
 		panic("unhandled token for binary operation")
 	}
+	if opcode == DIV {
+		// деление на 0, как и в интерпретаторе, сообщается в позиции делителя
+		defer cg.at(binExpr.Right)()
+	}
 	cg.emit(opcode, "")
 }
 
 func (cg *CodeGenerator) GenerateCallExpr(call *ast.CallExpr) {
+	if get, ok := call.Callee.(*ast.GetExpr); ok {
+		cg.GenerateMethodCall(get, call.Arguments)
+		return
+	}
+
+	// функция - любое значение в стеке под аргументами
+	cg.GenerateExpression(call.Callee)
+
 	for _, arg := range call.Arguments {
 		cg.GenerateExpression(arg)
 	}
 
-	cg.emit(PUSH_CONST, strconv.Itoa(len(call.Arguments)))
-	cg.emit(CALL_FUNCTION, call.Callee.String())
+	cg.emitN(CALL_FUNCTION, "", len(call.Arguments))
+}
+
+// GenerateMethodCall кладет в стек объект и аргументы, затем вызывает метод
+func (cg *CodeGenerator) GenerateMethodCall(get *ast.GetExpr, arguments []ast.Expression) {
+	cg.GenerateExpression(get.Object)
+
+	for _, arg := range arguments {
+		cg.GenerateExpression(arg)
+	}
+
+	cg.emitN(CALL_METHOD, get.Name, len(arguments))
 }
 
 func (cg *CodeGenerator) GenerateLeftExpr(left ast.LeftExpr) {
 	switch l := left.(type) {
 	case *ast.VariableExpr:
-		cg.emit(STORE_VAR, l.Name)
+		cg.emitN(STORE_VAR, l.Name, l.Distance)
 
 	case *ast.ArrayIndex:
 		cg.GenerateExpression(l.Array)
 		cg.GenerateExpression(l.Index)
+		// неверный индекс или ключ, как и в интерпретаторе, сообщается в позиции индекса
+		defer cg.at(l.Index)()
 		cg.emit(ARRAY_SET, "")
 	default:
 		panic(fmt.Sprintf("unsupported left-side expression: %T", l))
 	}
 }
 
+// GenerateAssignExpr оставляет присвоенное значение в стеке: присваивание - выражение
 func (cg *CodeGenerator) GenerateAssignExpr(assign *ast.AssignExpr) {
 	cg.GenerateExpression(assign.Value)
+	cg.emit(DUP, "")
 	cg.GenerateLeftExpr(assign.Left)
 }
 
@@ -231,14 +295,25 @@ func (cg *CodeGenerator) GenerateIfStmt(ifStmt *ast.IfStmt) {
 }
 
 func (cg *CodeGenerator) GenerateWhileStmt(whileStmt *ast.WhileStmt) {
-	constantIterations := cg.analyzeFixedLoop(whileStmt)
+	init := cg.forInit
+	cg.forInit = nil
+	constantIterations := -1
+	if cg.unrollLoops && init != nil {
+		constantIterations = analyzeFixedLoop(init, whileStmt)
+	}
 
-	if isFixedLoopAnalysationEnabled && constantIterations >= 0 {
+	// тело с break или continue разворачивать нельзя: им нужны метки цикла
+	if constantIterations >= 0 && !hasLoopControl(whileStmt.Body) {
 		for i := 0; i < constantIterations; i++ {
 			cg.GenerateStatement(whileStmt.Body)
+			if whileStmt.Increment != nil {
+				cg.GenerateExpression(whileStmt.Increment)
+				cg.emit(POP, "")
+			}
 		}
 	} else {
 		loopStartLabel := fmt.Sprintf("%s%d", LOOP_START_LABEL, len(cg.Bytecodes))
+		loopNextLabel := fmt.Sprintf("%s%d", LOOP_NEXT_LABEL, len(cg.Bytecodes))
 		loopEndLabel := fmt.Sprintf("%s%d", LOOP_END_LABEL, len(cg.Bytecodes))
 
 		cg.emit(LABEL, loopStartLabel)
@@ -246,25 +321,202 @@ func (cg *CodeGenerator) GenerateWhileStmt(whileStmt *ast.WhileStmt) {
 
 		cg.emit(JUMP_IF_FALSE, loopEndLabel)
 
+		cg.loops = append(cg.loops, loopLabels{next: loopNextLabel, end: loopEndLabel, scopes: cg.scopes})
 		cg.GenerateStatement(whileStmt.Body)
+		cg.loops = cg.loops[:len(cg.loops)-1]
+
+		cg.emit(LABEL, loopNextLabel)
+		if whileStmt.Increment != nil {
+			cg.GenerateExpression(whileStmt.Increment)
+			cg.emit(POP, "")
+		}
 
 		cg.emit(JUMP, loopStartLabel)
 		cg.emit(LABEL, loopEndLabel)
 	}
 }
 
+func (cg *CodeGenerator) GenerateBreakStmt(stmt *ast.BreakStmt) {
+	if len(cg.loops) == 0 {
+		panic("break outside of a loop")
+	}
+	loop := cg.loops[len(cg.loops)-1]
+	cg.endScopes(cg.leaveTries(cg.loopTries()) - loop.scopes)
+	cg.emit(JUMP, loop.end)
+}
+
+func (cg *CodeGenerator) GenerateContinueStmt(stmt *ast.ContinueStmt) {
+	if len(cg.loops) == 0 {
+		panic("continue outside of a loop")
+	}
+	loop := cg.loops[len(cg.loops)-1]
+	cg.endScopes(cg.leaveTries(cg.loopTries()) - loop.scopes)
+	cg.emit(JUMP, loop.next)
+}
+
+// endScopes закрывает n блоков при выходе из них переходом
+func (cg *CodeGenerator) endScopes(n int) {
+	for i := 0; i < n; i++ {
+		cg.emit(SCOPE_END, "")
+	}
+}
+
+// loopTries возвращает число блоков try, открытых вне самого внутреннего цикла
+func (cg *CodeGenerator) loopTries() int {
+	for i, try := range cg.tries {
+		if try.loops >= len(cg.loops) {
+			return i
+		}
+	}
+	return len(cg.tries)
+}
+
+// leaveTries снимает обработчики блоков try глубже depth и выполняет их finally, начиная с внутреннего.
+// Перед каждым finally закрываются блоки до уровня оператора try. Возвращает число открытых блоков после выхода
+func (cg *CodeGenerator) leaveTries(depth int) int {
+	tries, scopes := cg.tries, cg.scopes
+	defer func() {
+		cg.tries, cg.scopes = tries, scopes
+	}()
+	level := cg.scopes
+	for i := len(tries) - 1; i >= depth; i-- {
+		cg.endScopes(level - tries[i].scopes)
+		level = tries[i].scopes
+		cg.tries, cg.scopes = tries[:i], level
+		cg.emit(TRY_END, "")
+		if tries[i].finally != nil {
+			cg.GenerateBlockStmt(tries[i].finally)
+		}
+	}
+	return level
+}
+
+// GenerateTryStmt генерирует try/catch/finally. TRY_START устанавливает обработчик: при исключении
+// VM возвращает стек и вызовы к состоянию на момент TRY_START, кладет в стек исключение и переходит на метку
+func (cg *CodeGenerator) GenerateTryStmt(stmt *ast.TryStmt) {
+	id := len(cg.Bytecodes)
+	catchLabel := fmt.Sprintf("%s%d", CATCH_LABEL, id)
+	finallyLabel := fmt.Sprintf("%s%d", FINALLY_LABEL, id)
+
+	level := cg.scopes
+	cg.emit(TRY_START, catchLabel)
+	cg.generateProtected(stmt.Body, stmt.FinallyBody, level)
+	cg.emit(TRY_END, "")
+	cg.emit(JUMP, finallyLabel)
+
+	cg.emit(LABEL, catchLabel)
+	switch {
+	case stmt.CatchBody == nil:
+		cg.generateRethrow(stmt.FinallyBody)
+	case stmt.FinallyBody == nil:
+		cg.generateCatch(stmt, nil, level)
+	default:
+		// исключение из catch тоже должно пройти через finally
+		rethrowLabel := fmt.Sprintf("%s%d", RETHROW_LABEL, id)
+		cg.emit(TRY_START, rethrowLabel)
+		cg.generateCatch(stmt, stmt.FinallyBody, level)
+		cg.emit(TRY_END, "")
+		cg.emit(JUMP, finallyLabel)
+
+		cg.emit(LABEL, rethrowLabel)
+		cg.generateRethrow(stmt.FinallyBody)
+	}
+
+	cg.emit(LABEL, finallyLabel)
+	if stmt.FinallyBody != nil {
+		cg.GenerateBlockStmt(stmt.FinallyBody)
+	}
+}
+
+// generateProtected генерирует блок под обработчиком. finally выполняется на уровне level блоков
+func (cg *CodeGenerator) generateProtected(block, finally *ast.BlockStmt, level int) {
+	cg.tries = append(cg.tries, tryBlock{finally: finally, loops: len(cg.loops), scopes: level})
+	cg.GenerateBlockStmt(block)
+	cg.tries = cg.tries[:len(cg.tries)-1]
+}
+
+// generateCatch объявляет переменную исключения в своем окружении, как resolver, и генерирует тело catch
+func (cg *CodeGenerator) generateCatch(stmt *ast.TryStmt, finally *ast.BlockStmt, level int) {
+	cg.emit(SCOPE_START, "")
+	cg.scopes++
+	cg.emit(DEFINE_VAR, stmt.CatchName.Name)
+	if finally != nil {
+		cg.generateProtected(stmt.CatchBody, finally, level)
+	} else {
+		cg.GenerateBlockStmt(stmt.CatchBody)
+	}
+	cg.scopes--
+	cg.emit(SCOPE_END, "")
+}
+
+// generateRethrow выполняет finally и бросает дальше исключение, которое лежит на вершине стека
+func (cg *CodeGenerator) generateRethrow(finally *ast.BlockStmt) {
+	cg.GenerateBlockStmt(finally)
+	cg.emit(THROW, "")
+}
+
+func (cg *CodeGenerator) GenerateThrowStmt(stmt *ast.ThrowStmt) {
+	cg.GenerateExpression(stmt.Value)
+	cg.emit(THROW, "")
+}
+
+// hasLoopControl проверяет, есть ли в теле цикла break или continue, относящиеся к этому циклу
+func hasLoopControl(stmt ast.Statement) bool {
+	switch s := stmt.(type) {
+	case *ast.BreakStmt, *ast.ContinueStmt:
+		return true
+	case *ast.BlockStmt:
+		for _, statement := range s.Statements {
+			if hasLoopControl(statement) {
+				return true
+			}
+		}
+	case *ast.IfStmt:
+		return hasLoopControl(s.ThenBranch) || (s.ElseBranch != nil && hasLoopControl(s.ElseBranch))
+	case *ast.TryStmt:
+		return hasLoopControl(s.Body) || (s.CatchBody != nil && hasLoopControl(s.CatchBody)) ||
+			(s.FinallyBody != nil && hasLoopControl(s.FinallyBody))
+	}
+	return false
+}
+
 func (cg *CodeGenerator) GenerateFunctionStmt(funcStmt *ast.FunctionStmt) {
-	cg.emit(FUNC, funcStmt.Name)
+	cg.generateFunction(funcStmt.Name, funcStmt.Params, funcStmt.Body, false)
+	cg.emit(DEFINE_VAR, funcStmt.Name)
+}
+
+func (cg *CodeGenerator) GenerateFunctionExpr(funcExpr *ast.FunctionExpr) {
+	cg.generateFunction(valuer.LambdaName, funcExpr.Params, funcExpr.Body, false)
+}
 
-	for _, arg := range funcStmt.Params {
-		cg.emit(STORE_VAR, arg.Name)
+// generateFunction генерирует тело функции между FUNC и END_FUNC. FUNC создает замыкание
+// с текущим окружением и кладет его в стек. Аргументы FUNC: имя и число параметров
+func (cg *CodeGenerator) generateFunction(name string, params []*ast.Identifier, body []ast.Statement, initializer bool) {
+	cg.emitN(FUNC, name, len(params))
+
+	// break, continue и return не выходят за границу функции
+	enclosingLoops, enclosingTries, enclosingScopes := cg.loops, cg.tries, cg.scopes
+	enclosingInitializer := cg.initializer
+	cg.loops, cg.tries, cg.scopes = nil, nil, 0
+	cg.initializer = initializer
+	defer func() {
+		cg.loops, cg.tries, cg.scopes = enclosingLoops, enclosingTries, enclosingScopes
+		cg.initializer = enclosingInitializer
+	}()
+
+	for _, arg := range params {
+		cg.emit(DEFINE_VAR, arg.Name)
 	}
 
-	for _, stmt := range funcStmt.Body {
+	for _, stmt := range body {
 		cg.GenerateStatement(stmt)
 	}
+	if initializer {
+		cg.emit(THIS, "")
+		cg.emit(RETURN, "")
+	}
 
-	cg.emit(END_FUNC, funcStmt.Name)
+	cg.emit(END_FUNC, name)
 }
 
 func (cg *CodeGenerator) GeneratePrintStmt(printStmt *ast.PrintStmt) {
@@ -274,11 +526,14 @@ func (cg *CodeGenerator) GeneratePrintStmt(printStmt *ast.PrintStmt) {
 }
 
 func (cg *CodeGenerator) GenerateStatement(stmt ast.Statement) {
+	defer cg.at(stmt)()
+
 	switch s := stmt.(type) {
 	case *ast.ExprStmt:
 		cg.GenerateExpression(s.Expression)
+		cg.emit(POP, "")
 	case *ast.VarStmt:
-		cg.GenerateAssignExpr(&ast.AssignExpr{Left: &ast.VariableExpr{Name: s.Name.Name}, Value: s.Initializer})
+		cg.GenerateVarStmt(s)
 	case *ast.IfStmt:
 		cg.GenerateIfStmt(s)
 	case *ast.WhileStmt:
@@ -291,48 +546,87 @@ func (cg *CodeGenerator) GenerateStatement(stmt ast.Statement) {
 		cg.GenerateBlockStmt(s)
 	case *ast.ReturnStmt:
 		cg.GenerateReturnStmt(s)
-	/*case *ast.ClassStmt:
-	cg.GenerateClassStmt(s)*/
+	case *ast.BreakStmt:
+		cg.GenerateBreakStmt(s)
+	case *ast.ContinueStmt:
+		cg.GenerateContinueStmt(s)
+	case *ast.TryStmt:
+		cg.GenerateTryStmt(s)
+	case *ast.ThrowStmt:
+		cg.GenerateThrowStmt(s)
+	case *ast.ClassStmt:
+		cg.GenerateClassStmt(s)
 	default:
 		panic(fmt.Sprintf("unknown ast type to generate bytecode statement: %T", s))
 	}
 }
 
+func (cg *CodeGenerator) GenerateVarStmt(stmt *ast.VarStmt) {
+	if stmt.Initializer != nil {
+		cg.GenerateExpression(stmt.Initializer)
+	} else {
+		cg.emit(NIL, "")
+	}
+	cg.emit(DEFINE_VAR, stmt.Name.Name)
+}
+
+// GenerateBlockStmt открывает для блока новое окружение: так же блоки видит resolver
 func (cg *CodeGenerator) GenerateBlockStmt(stmt *ast.BlockStmt) {
+	cg.emit(SCOPE_START, "")
+	cg.scopes++
 
-	for _, statement := range stmt.Statements {
+	for i, statement := range stmt.Statements {
+		// цикл for - блок из объявления счетчика и while с шагом
+		if _, ok := statement.(*ast.WhileStmt); ok && i == 1 && len(stmt.Statements) == 2 {
+			cg.forInit, _ = stmt.Statements[0].(*ast.VarStmt)
+		}
 		cg.GenerateStatement(statement)
+		cg.forInit = nil
 	}
 
+	cg.scopes--
 	cg.emit(SCOPE_END, "")
 }
 
 func (cg *CodeGenerator) GenerateReturnStmt(stmt *ast.ReturnStmt) {
 	if stmt.Value != nil {
 		cg.GenerateExpression(stmt.Value)
-		cg.emit(RETURN, "")
+	} else if cg.initializer {
+		cg.emit(THIS, "")
 	} else {
-		cg.emit(PUSH_CONST, NULL)
+		cg.emit(NIL, "")
 	}
+	// значение остается на вершине стека, пока выполняются finally
+	cg.leaveTries(0)
+	cg.emit(RETURN, "")
 }
 
-/*
+// GenerateClassStmt собирает класс в стеке: CLASS создает его, METHOD добавляет замыкания методов.
+// Родительский класс определяется как super в отдельном окружении, которое захватывают методы
 func (cg *CodeGenerator) GenerateClassStmt(stmt *ast.ClassStmt) {
-	cg.Emit(OpClass, cg.AddConstant(stmt.Name))
-	if stmt.SuperClass.Name != "" {
-		cg.GenerateExpression(&stmt.SuperClass)
-		cg.Emit(OpInherit)
+	cg.emit(CLASS, stmt.Name)
+	if stmt.SuperClass != nil {
+		restore := cg.at(stmt.SuperClass)
+		cg.GenerateVariable(stmt.SuperClass)
+		cg.emit(INHERIT, "")
+		restore()
+		cg.emit(SCOPE_START, "")
+		cg.emit(DEFINE_VAR, "super")
 	}
 	for _, method := range stmt.Methods {
-		cg.GenerateFunctionStmt(method)
-		cg.Emit(OpMethod, cg.AddConstant(method.Name))
+		cg.generateFunction(method.Name, method.Params, method.Body, method.IsInitializer)
+		cg.emit(METHOD, method.Name)
+	}
+	if stmt.SuperClass != nil {
+		cg.emit(SCOPE_END, "")
 	}
-}*/
+	cg.emit(DEFINE_VAR, stmt.Name)
+}
 
 func (cg *CodeGenerator) GenerateUnaryExpr(unary *ast.UnaryExpr) {
 	cg.GenerateExpression(unary.Right)
 
-	var opcode string
+	var opcode Opcode
 
 	switch unary.Operator {
 	case token.Minus:
@@ -345,20 +639,29 @@ func (cg *CodeGenerator) GenerateUnaryExpr(unary *ast.UnaryExpr) {
 	cg.emit(opcode, "")
 }
 
+// GenerateLogicalExpr вычисляет правый операнд, только если результат не ясен по левому.
+// Результат - значение последнего вычисленного операнда, как в интерпретаторе
 func (cg *CodeGenerator) GenerateLogicalExpr(logical *ast.LogicalExpr) {
 	cg.GenerateExpression(logical.Left)
-	cg.GenerateExpression(logical.Right)
+	cg.emit(DUP, "")
 
-	var opcode string
+	endLabel := fmt.Sprintf("%s%d", END_LABEL, len(cg.Bytecodes))
 	switch logical.Operator {
 	case token.And:
-		opcode = AND
+		// ложный левый операнд остается в стеке результатом
+		cg.emit(JUMP_IF_FALSE, endLabel)
 	case token.Or:
-		opcode = OR
+		// истинный левый операнд остается в стеке результатом
+		falseLabel := fmt.Sprintf("%s%d", FALSE_LABEL, len(cg.Bytecodes))
+		cg.emit(JUMP_IF_FALSE, falseLabel)
+		cg.emit(JUMP, endLabel)
+		cg.emit(LABEL, falseLabel)
 	default:
 		panic("unhandled token for logical expression")
 	}
-	cg.emit(opcode, "")
+	cg.emit(POP, "")
+	cg.GenerateExpression(logical.Right)
+	cg.emit(LABEL, endLabel)
 }
 
 func (cg *CodeGenerator) GenerateGroupingExpr(grouping *ast.GroupingExpr) {
@@ -370,13 +673,31 @@ func (cg *CodeGenerator) GenerateArrayExpr(array *ast.ArrayExpr) {
 		cg.GenerateExpression(element)
 	}
 
-	cg.emit(NEW_ARRAY, fmt.Sprintf("%d", len(array.Elements)))
+	cg.emitN(NEW_ARRAY, "", len(array.Elements))
+}
+
+func (cg *CodeGenerator) GenerateMapExpr(mapExpr *ast.MapExpr) {
+	for i, key := range mapExpr.Keys {
+		cg.GenerateExpression(key)
+		cg.emitMapKey(key)
+		cg.GenerateExpression(mapExpr.Values[i])
+	}
+
+	cg.emitN(NEW_MAP, "", len(mapExpr.Keys))
+}
+
+// emitMapKey проверяет ключ сразу после его вычисления, как и интерпретатор, и в позиции ключа
+func (cg *CodeGenerator) emitMapKey(key ast.Expression) {
+	defer cg.at(key)()
+	cg.emit(MAP_KEY, "")
 }
 
 func (cg *CodeGenerator) GenerateArrayIndex(arrayIndex *ast.ArrayIndex) {
 	cg.GenerateExpression(arrayIndex.Array)
 	cg.GenerateExpression(arrayIndex.Index)
 
+	// неверный индекс или ключ, как и в интерпретаторе, сообщается в позиции индекса
+	defer cg.at(arrayIndex.Index)()
 	cg.emit(ARRAY_GET, "")
 }
 
@@ -395,12 +716,10 @@ func (cg *CodeGenerator) GenerateSetExpr(set *ast.SetExpr) {
 }
 
 func (cg *CodeGenerator) GenerateSuperExpr(super *ast.SuperExpr) {
-	panic("npt implemented bytecode gen for super")
-	cg.emit(SUPER, "")
+	cg.emitN(SUPER, super.Method, super.Distance)
 }
 
 func (cg *CodeGenerator) GenerateThisExpr(this *ast.ThisExpr) {
-	panic("not implemented bytecode gen for this")
 	cg.emit(THIS, "")
 }
 
@@ -409,7 +728,7 @@ func (cg *CodeGenerator) EliminateDeadCode() {
 	optimizedBytecodes := []Bytecode{}
 
 	for _, bc := range cg.Bytecodes {
-		if bc.Opcode == JUMP || bc.Opcode == JUMP_IF_FALSE {
+		if bc.Opcode == JUMP || bc.Opcode == JUMP_IF_FALSE || bc.Opcode == TRY_START {
 			usedLabels[bc.Arg] = true
 		} else if bc.Opcode == LABEL {
 			usedLabels[bc.Arg] = usedLabels[bc.Arg]
@@ -426,23 +745,72 @@ func (cg *CodeGenerator) EliminateDeadCode() {
 	cg.Bytecodes = optimizedBytecodes
 }
 
-func (cg *CodeGenerator) analyzeFixedLoop(whileStmt *ast.WhileStmt) int {
-	var cond, ok = whileStmt.Condition.(*ast.BinaryExpr)
-	if ok {
-		if cond.Operator == token.Less || cond.Operator == token.LessThanOrEqual {
-			if _, ok := cond.Left.(*ast.VariableExpr); ok {
-				if right, ok := cond.Right.(*ast.Literal); ok {
-					iterations, err := strconv.Atoi(right.Value)
-					if err == nil {
-						return iterations
-					}
-				}
-			}
+// analyzeFixedLoop возвращает число итераций цикла for (var i = A; i < B; i = i + 1), где A и B -
+// числа, а тело не присваивает счетчику, или -1, если число итераций заранее не известно
+func analyzeFixedLoop(init *ast.VarStmt, whileStmt *ast.WhileStmt) int {
+	name := init.Name.Name
+	start, ok := numberLiteral(init.Initializer)
+	if !ok {
+		return -1
+	}
+	cond, ok := whileStmt.Condition.(*ast.BinaryExpr)
+	if !ok || (cond.Operator != token.Less && cond.Operator != token.LessThanOrEqual) || !isVariable(cond.Left, name) {
+		return -1
+	}
+	end, ok := numberLiteral(cond.Right)
+	if !ok {
+		return -1
+	}
+	step, ok := whileStmt.Increment.(*ast.AssignExpr)
+	if !ok || !isVariable(step.Left, name) {
+		return -1
+	}
+	sum, ok := step.Value.(*ast.BinaryExpr)
+	if !ok || sum.Operator != token.Plus || !isVariable(sum.Left, name) {
+		return -1
+	}
+	if one, ok := numberLiteral(sum.Right); !ok || one != 1 {
+		return -1
+	}
+	if assigns(whileStmt.Body, name) {
+		return -1
+	}
+
+	iterations := 0
+	for i := start; i < end || (cond.Operator == token.LessThanOrEqual && i == end); i++ {
+		if iterations++; iterations > maxUnrolledIterations {
+			return -1
 		}
 	}
-	return -1
+	return iterations
+}
+
+func numberLiteral(expr ast.Expression) (float64, bool) {
+	lit, ok := expr.(*ast.Literal)
+	if !ok || lit.Token != token.Number {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(lit.Value, 64)
+	return value, err == nil
+}
+
+func isVariable(expr ast.Node, name string) bool {
+	v, ok := expr.(*ast.VariableExpr)
+	return ok && v.Name == name
+}
+
+// assigns сообщает, есть ли в stmt присваивание переменной name, в том числе во вложенных функциях
+func assigns(stmt ast.Statement, name string) bool {
+	found := false
+	ast.Inspect(stmt, func(node ast.Node) bool {
+		if assign, ok := node.(*ast.AssignExpr); ok && isVariable(assign.Left, name) {
+			found = true
+		}
+		return !found
+	})
+	return found
 }
 
 func (cg *CodeGenerator) EnableLoopEnrolling() {
-	isFixedLoopAnalysationEnabled = true
+	cg.unrollLoops = true
 }
@@ -0,0 +1,43 @@
+package bytecode_gen
+
+import (
+	"testing"
+
+	"github.com/Dor1ma/Strawberry/lexer"
+	"github.com/Dor1ma/Strawberry/parser"
+)
+
+func TestUnrollLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		unrolled bool
+	}{
+		{`for (var i = 0; i < 3; i = i + 1) print i;`, true},
+		{`for (var i = 1; i <= 3; i = i + 1) { print i; }`, true},
+		{`var k = 0; while (k < 3) { print k; k = k + 1; }`, false},
+		{`for (var i = 0; i < 3; i = i + 2) print i;`, false},
+		{`var n = 0; for (var i = n; i < 3; i = i + 1) print i;`, false},
+		{`for (var i = 0; i < 6; i = i + 1) { i = i + 1; }`, false},
+		{`for (var i = 0; i < 3; i = i + 1) { fun f() { i = 5; } f(); }`, false},
+		{`for (var i = 0; i < 1000; i = i + 1) print i;`, false},
+		{`for (var i = 0; i < 3; i = i + 1) { if (i == 1) continue; print i; }`, false},
+	}
+	for i, test := range tests {
+		statements, err := parser.New(lexer.New(test.input)).Parse()
+		if err != nil {
+			t.Fatalf("test [%d]: parse error: %s", i, err)
+		}
+		cg := CodeGenerator{}
+		cg.EnableLoopEnrolling()
+		if err := cg.GenerateProgram(statements); err != nil {
+			t.Fatalf("test [%d]: generate error: %s", i, err)
+		}
+		jumps := false
+		for _, bc := range cg.Bytecodes {
+			jumps = jumps || bc.Opcode == JUMP
+		}
+		if jumps == test.unrolled {
+			t.Errorf("test [%d]: expected unrolled=%t for %s", i, test.unrolled, test.input)
+		}
+	}
+}
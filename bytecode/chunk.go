@@ -0,0 +1,98 @@
+package bytecode_gen
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/Dor1ma/Strawberry/token"
+)
+
+type ConstantType byte
+
+const (
+	NumberConstant ConstantType = iota + 1
+	StringConstant
+)
+
+// Constant - число или строка из пула констант. Имена переменных и свойств тоже хранятся как строки
+type Constant struct {
+	Type   ConstantType
+	Number float64
+	String string
+}
+
+func NumberValue(value float64) Constant {
+	return Constant{Type: NumberConstant, Number: value}
+}
+
+func StringValue(value string) Constant {
+	return Constant{Type: StringConstant, String: value}
+}
+
+func (c Constant) Format() string {
+	if c.Type == NumberConstant {
+		return strconv.FormatFloat(c.Number, 'f', -1, 64)
+	}
+	return strconv.Quote(c.String)
+}
+
+// Line связывает начало инструкции с позицией в исходнике. Позиция действует до следующей записи
+type Line struct {
+	Offset int
+	Pos    token.Position
+}
+
+// Chunk - закодированный код одной функции со своим пулом констант и таблицей строк
+type Chunk struct {
+	Code      []byte
+	Constants []Constant
+	Lines     []Line // по возрастанию Offset
+
+	constants map[Constant]int
+}
+
+// AddConstant добавляет константу в пул, если ее там еще нет, и возвращает ее номер
+func (chunk *Chunk) AddConstant(c Constant) int {
+	if chunk.constants == nil {
+		chunk.constants = make(map[Constant]int, len(chunk.Constants))
+		for i, existing := range chunk.Constants {
+			chunk.constants[existing] = i
+		}
+	}
+	if i, ok := chunk.constants[c]; ok {
+		return i
+	}
+	chunk.Constants = append(chunk.Constants, c)
+	chunk.constants[c] = len(chunk.Constants) - 1
+	return len(chunk.Constants) - 1
+}
+
+// Position возвращает позицию в исходнике для инструкции, начинающейся с offset
+func (chunk *Chunk) Position(offset int) token.Position {
+	i := sort.Search(len(chunk.Lines), func(i int) bool {
+		return chunk.Lines[i].Offset > offset
+	})
+	if i == 0 {
+		return token.Position{}
+	}
+	return chunk.Lines[i-1].Pos
+}
+
+func (chunk *Chunk) addLine(offset int, pos token.Position) {
+	if n := len(chunk.Lines); n > 0 && chunk.Lines[n-1].Pos == pos {
+		return
+	}
+	chunk.Lines = append(chunk.Lines, Line{Offset: offset, Pos: pos})
+}
+
+// Function - функция программы. Тело функции заканчивается возвратом nil, если в нем нет return
+type Function struct {
+	Name  string
+	Arity int
+	Chunk Chunk
+}
+
+// Program - таблица функций; нулевая функция - код верхнего уровня скрипта
+type Program struct {
+	Functions []*Function
+}
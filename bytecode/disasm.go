@@ -0,0 +1,114 @@
+package bytecode_gen
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Disassemble печатает листинг программы: для каждой функции пул констант и инструкции
+// со смещениями, адресами переходов и позициями в исходнике. Позиция печатается, только когда
+// меняется, так же, как ее хранит таблица строк. Листинг читается обратно функцией Assemble
+func Disassemble(out io.Writer, program *Program) error {
+	w := bufio.NewWriter(out)
+
+	if file := program.file(); file != "" {
+		fmt.Fprintf(w, "file %q\n\n", file)
+	}
+	for i, fn := range program.Functions {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		disassembleFunction(w, program, i, fn)
+	}
+	return w.Flush()
+}
+
+// file возвращает имя файла, из которого скомпилирована программа, или "", если файлов несколько
+func (program *Program) file() string {
+	name := ""
+	files, _ := program.files()
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		if name != "" {
+			return ""
+		}
+		name = file
+	}
+	return name
+}
+
+func disassembleFunction(w io.Writer, program *Program, index int, fn *Function) {
+	chunk := &fn.Chunk
+	fmt.Fprintf(w, "function %d %s %d\n", index, fn.Name, fn.Arity)
+	for i, c := range chunk.Constants {
+		fmt.Fprintf(w, "  const #%d %s\n", i, c.Format())
+	}
+
+	line := 0
+	for offset := 0; offset < len(chunk.Code); {
+		op := Opcode(chunk.Code[offset])
+		if !op.Valid() {
+			fmt.Fprintf(w, "  %04d  ; unknown opcode %d\n", offset, chunk.Code[offset])
+			offset++
+			continue
+		}
+
+		position := ""
+		if line < len(chunk.Lines) && chunk.Lines[line].Offset == offset {
+			position = fmt.Sprintf("[%d:%d]", chunk.Lines[line].Pos.Line, chunk.Lines[line].Pos.Column)
+			line++
+		}
+
+		operands, comment := instructionText(program, chunk, op, offset)
+		text := op.String()
+		if len(operands) > 0 {
+			text += " " + strings.Join(operands, " ")
+		}
+		if comment != "" {
+			fmt.Fprintf(w, "  %04d %-9s %-28s ; %s\n", offset, position, text, comment)
+		} else {
+			fmt.Fprintf(w, "  %04d %-9s %s\n", offset, position, text)
+		}
+		offset += InstructionSize(op)
+	}
+	fmt.Fprintln(w, "end")
+}
+
+// instructionText возвращает операнды инструкции и комментарий с их значениями
+func instructionText(program *Program, chunk *Chunk, op Opcode, offset int) ([]string, string) {
+	var operands, comments []string
+	at := offset + 1
+	for i, width := range OperandWidths(op) {
+		value := ReadOperand(chunk.Code, at, width)
+		at += width
+		switch {
+		case width == offsetWidth:
+			// переход печатается адресом, смещение отсчитывается от следующей инструкции
+			operands = append(operands, fmt.Sprintf("%04d", offset+InstructionSize(op)+value))
+		case op == FUNC:
+			operands = append(operands, fmt.Sprint(value))
+			if value < len(program.Functions) {
+				comments = append(comments, program.Functions[value].Name)
+			}
+		case i == 0 && width == indexWidth && op != NEW_ARRAY && op != NEW_MAP:
+			operands = append(operands, fmt.Sprintf("#%d", value))
+			if value < len(chunk.Constants) {
+				c := chunk.Constants[value]
+				if op == PUSH_CONST {
+					comments = append(comments, c.Format())
+				} else {
+					comments = append(comments, c.String)
+				}
+			}
+		case i == 1 && (op == PUSH_VAR || op == STORE_VAR || op == SUPER) && value == GlobalDistance:
+			operands = append(operands, "global")
+		default:
+			operands = append(operands, fmt.Sprint(value))
+		}
+	}
+	return operands, strings.Join(comments, " ")
+}
@@ -0,0 +1,156 @@
+package bytecode_gen
+
+import (
+	"fmt"
+
+	"github.com/Dor1ma/Strawberry/errors"
+	"github.com/Dor1ma/Strawberry/token"
+)
+
+// encodeError - ошибка кодирования, которой encoder выходит из вложенных функций
+type encodeError struct {
+	msg string
+}
+
+// jumpFixup - операнд перехода, который заполняется, когда известны адреса всех меток функции
+type jumpFixup struct {
+	at    int // начало операнда
+	next  int // начало следующей инструкции, от него отсчитывается смещение
+	label string
+}
+
+type encoder struct {
+	program *Program
+}
+
+// Encode кодирует вывод CodeGenerator. Тела между FUNC и END_FUNC становятся отдельными функциями
+// программы, а FUNC получает номер функции; метки заменяются смещениями переходов
+func Encode(bytecodes []Bytecode) (program *Program, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			encErr, ok := r.(encodeError)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("bytecode: %s", encErr.msg)
+		}
+	}()
+
+	e := &encoder{program: &Program{}}
+	e.function(errors.ScriptFrame, 0, bytecodes, nil)
+	return e.program, nil
+}
+
+func (e *encoder) fail(format string, args ...interface{}) {
+	panic(encodeError{msg: fmt.Sprintf(format, args...)})
+}
+
+// function кодирует тело функции и возвращает ее номер. end - END_FUNC функции,
+// для кода верхнего уровня он nil, и неявный возврат не добавляется
+func (e *encoder) function(name string, arity int, code []Bytecode, end *Bytecode) int {
+	index := len(e.program.Functions)
+	fn := &Function{Name: name, Arity: arity}
+	e.program.Functions = append(e.program.Functions, fn)
+	chunk := &fn.Chunk
+
+	labels := make(map[string]int)
+	var fixups []jumpFixup
+	for i := 0; i < len(code); i++ {
+		bc := code[i]
+		switch bc.Opcode {
+		case LABEL:
+			labels[bc.Arg] = len(chunk.Code)
+		case END_FUNC:
+			e.fail("END_FUNC without FUNC in %s", name)
+		case FUNC:
+			j := matchingEnd(code, i)
+			if j < 0 {
+				e.fail("END_FUNC not found for function %s", bc.Arg)
+			}
+			nested := e.function(bc.Arg, bc.N, code[i+1:j], &code[j])
+			e.emit(chunk, bc.Pos, FUNC, nested)
+			i = j
+		case JUMP, JUMP_IF_FALSE, TRY_START:
+			e.emit(chunk, bc.Pos, bc.Opcode, 0)
+			fixups = append(fixups, jumpFixup{at: len(chunk.Code) - offsetWidth, next: len(chunk.Code), label: bc.Arg})
+		default:
+			e.emit(chunk, bc.Pos, bc.Opcode, e.operands(chunk, bc)...)
+		}
+	}
+	if end != nil {
+		e.emit(chunk, end.Pos, NIL)
+		e.emit(chunk, end.Pos, RETURN)
+	}
+
+	for _, f := range fixups {
+		target, ok := labels[f.label]
+		if !ok {
+			e.fail("label %s not found in %s", f.label, name)
+		}
+		copy(chunk.Code[f.at:], appendOperand(nil, offsetWidth, target-f.next))
+	}
+	return index
+}
+
+// matchingEnd возвращает индекс END_FUNC, закрывающего FUNC с индексом start, или -1
+func matchingEnd(code []Bytecode, start int) int {
+	depth := 0
+	for i := start; i < len(code); i++ {
+		switch code[i].Opcode {
+		case FUNC:
+			depth++
+		case END_FUNC:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// operands переводит аргументы инструкции в операнды: имена и значения попадают в пул констант
+func (e *encoder) operands(chunk *Chunk, bc Bytecode) []int {
+	switch bc.Opcode {
+	case PUSH_CONST:
+		return []int{e.constant(chunk, bc.Const)}
+	case PUSH_VAR, STORE_VAR, SUPER:
+		distance := bc.N
+		if distance < 0 {
+			distance = GlobalDistance
+		} else if distance >= GlobalDistance {
+			e.fail("variable %s is too deep", bc.Arg)
+		}
+		return []int{e.constant(chunk, StringValue(bc.Arg)), distance}
+	case DEFINE_VAR, GET_PROPERTY, SET_PROPERTY, CLASS, METHOD:
+		return []int{e.constant(chunk, StringValue(bc.Arg))}
+	case CALL_METHOD:
+		return []int{e.constant(chunk, StringValue(bc.Arg)), bc.N}
+	case CALL_FUNCTION, NEW_ARRAY, NEW_MAP:
+		return []int{bc.N}
+	}
+	return nil
+}
+
+func (e *encoder) constant(chunk *Chunk, c Constant) int {
+	index := chunk.AddConstant(c)
+	if index > 0xFFFF {
+		e.fail("too many constants in one function")
+	}
+	return index
+}
+
+func (e *encoder) emit(chunk *Chunk, pos token.Position, op Opcode, operands ...int) {
+	widths := OperandWidths(op)
+	if len(widths) != len(operands) {
+		e.fail("%s expects %d operands, got %d", op, len(widths), len(operands))
+	}
+	chunk.addLine(len(chunk.Code), pos)
+	chunk.Code = append(chunk.Code, byte(op))
+	for i, width := range widths {
+		if width != offsetWidth && (operands[i] < 0 || operands[i] >= 1<<(8*width)) {
+			e.fail("operand %d of %s does not fit in %d bytes", operands[i], op, width)
+		}
+		chunk.Code = appendOperand(chunk.Code, width, operands[i])
+	}
+}
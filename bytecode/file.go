@@ -0,0 +1,423 @@
+package bytecode_gen
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+
+	"github.com/Dor1ma/Strawberry/token"
+)
+
+// Файл .berryc:
+//
+//	magic    "BRYC"
+//	version  uint16
+//	files    число имен файлов и сами имена, на них ссылается таблица строк
+//	funcs    число функций, для каждой: имя, арность, пул констант, код и таблица строк
+//	checksum uint32, CRC-32 всего, что записано до него
+//
+// Целые числа внутри секций записываются как uvarint, строки - длиной и байтами.
+const (
+	FileMagic     = "BRYC"
+	FormatVersion = 1
+	FileExtension = ".berryc"
+)
+
+var (
+	ErrNotCompiled = errors.New("bytecode: not a compiled Strawberry file")
+	ErrCorrupted   = errors.New("bytecode: file is corrupted (checksum mismatch)")
+	ErrTruncated   = errors.New("bytecode: file is truncated")
+)
+
+// VersionError - файл записан другой версией формата
+type VersionError struct {
+	Version int
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("bytecode: unsupported format version %d, expected %d", e.Version, FormatVersion)
+}
+
+type fileWriter struct {
+	buf     bytes.Buffer
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func (w *fileWriter) uint(value int) {
+	n := binary.PutUvarint(w.scratch[:], uint64(value))
+	w.buf.Write(w.scratch[:n])
+}
+
+func (w *fileWriter) string(value string) {
+	w.uint(len(value))
+	w.buf.WriteString(value)
+}
+
+// Write записывает программу в формате .berryc
+func (program *Program) Write(out io.Writer) error {
+	w := &fileWriter{}
+	w.buf.WriteString(FileMagic)
+	binary.Write(&w.buf, binary.BigEndian, uint16(FormatVersion))
+
+	files, fileIndex := program.files()
+	w.uint(len(files))
+	for _, name := range files {
+		w.string(name)
+	}
+
+	w.uint(len(program.Functions))
+	for _, fn := range program.Functions {
+		w.string(fn.Name)
+		w.uint(fn.Arity)
+
+		w.uint(len(fn.Chunk.Constants))
+		for _, c := range fn.Chunk.Constants {
+			w.buf.WriteByte(byte(c.Type))
+			if c.Type == NumberConstant {
+				binary.Write(&w.buf, binary.BigEndian, math.Float64bits(c.Number))
+			} else {
+				w.string(c.String)
+			}
+		}
+
+		w.uint(len(fn.Chunk.Code))
+		w.buf.Write(fn.Chunk.Code)
+
+		w.uint(len(fn.Chunk.Lines))
+		for _, line := range fn.Chunk.Lines {
+			w.uint(line.Offset)
+			w.uint(fileIndex[line.Pos.Filename])
+			w.uint(line.Pos.Offset)
+			w.uint(line.Pos.Line)
+			w.uint(line.Pos.Column)
+		}
+	}
+
+	binary.Write(&w.buf, binary.BigEndian, crc32.ChecksumIEEE(w.buf.Bytes()))
+	_, err := out.Write(w.buf.Bytes())
+	return err
+}
+
+// files собирает имена файлов из таблиц строк, чтобы не повторять их в каждой записи
+func (program *Program) files() ([]string, map[string]int) {
+	var files []string
+	index := make(map[string]int)
+	for _, fn := range program.Functions {
+		for _, line := range fn.Chunk.Lines {
+			if _, ok := index[line.Pos.Filename]; !ok {
+				index[line.Pos.Filename] = len(files)
+				files = append(files, line.Pos.Filename)
+			}
+		}
+	}
+	return files, index
+}
+
+type fileReader struct {
+	data []byte
+	pos  int
+}
+
+// readError прерывает разбор файла, Read возвращает ее как ошибку
+type readError struct {
+	err error
+}
+
+func (r *fileReader) fail(err error) {
+	panic(readError{err})
+}
+
+func (r *fileReader) bytes(n int) []byte {
+	if n < 0 || n > len(r.data)-r.pos {
+		r.fail(ErrTruncated)
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *fileReader) uint() int {
+	value, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 || value > math.MaxInt32 {
+		r.fail(ErrTruncated)
+	}
+	r.pos += n
+	return int(value)
+}
+
+// count читает число элементов. Каждый элемент занимает хотя бы байт, поэтому число
+// больше остатка файла означает испорченный файл, и память под него не выделяется
+func (r *fileReader) count() int {
+	n := r.uint()
+	if n > len(r.data)-r.pos {
+		r.fail(ErrTruncated)
+	}
+	return n
+}
+
+func (r *fileReader) string() string {
+	return string(r.bytes(r.uint()))
+}
+
+// Read читает программу в формате .berryc. Файл с неверной контрольной суммой,
+// другой версией формата или неверным кодом не принимается
+func Read(in io.Reader) (program *Program, err error) {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(FileMagic) || string(data[:len(FileMagic)]) != FileMagic {
+		return nil, ErrNotCompiled
+	}
+	if len(data) < len(FileMagic)+2+4 {
+		return nil, ErrTruncated
+	}
+	if version := int(binary.BigEndian.Uint16(data[len(FileMagic):])); version != FormatVersion {
+		return nil, &VersionError{Version: version}
+	}
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, ErrCorrupted
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			readErr, ok := r.(readError)
+			if !ok {
+				panic(r)
+			}
+			program, err = nil, readErr.err
+		}
+	}()
+
+	r := &fileReader{data: body, pos: len(FileMagic) + 2}
+	files := make([]string, r.count())
+	for i := range files {
+		files[i] = r.string()
+	}
+
+	program = &Program{}
+	count := r.count()
+	for i := 0; i < count; i++ {
+		fn := &Function{Name: r.string(), Arity: r.uint()}
+
+		fn.Chunk.Constants = make([]Constant, r.count())
+		for j := range fn.Chunk.Constants {
+			c := Constant{Type: ConstantType(r.bytes(1)[0])}
+			switch c.Type {
+			case NumberConstant:
+				c.Number = math.Float64frombits(binary.BigEndian.Uint64(r.bytes(8)))
+			case StringConstant:
+				c.String = r.string()
+			default:
+				r.fail(fmt.Errorf("bytecode: unknown constant type %d in %s", c.Type, fn.Name))
+			}
+			fn.Chunk.Constants[j] = c
+		}
+
+		fn.Chunk.Code = append([]byte(nil), r.bytes(r.uint())...)
+
+		fn.Chunk.Lines = make([]Line, r.count())
+		for j := range fn.Chunk.Lines {
+			line := Line{Offset: r.uint()}
+			file := r.uint()
+			if file >= len(files) {
+				r.fail(fmt.Errorf("bytecode: unknown file %d in line table of %s", file, fn.Name))
+			}
+			line.Pos = token.Position{Filename: files[file], Offset: r.uint(), Line: r.uint(), Column: r.uint()}
+			fn.Chunk.Lines[j] = line
+		}
+		program.Functions = append(program.Functions, fn)
+	}
+	if r.pos != len(body) {
+		return nil, fmt.Errorf("bytecode: %d unexpected bytes after function table", len(body)-r.pos)
+	}
+
+	if err := program.Validate(); err != nil {
+		return nil, err
+	}
+	return program, nil
+}
+
+// Validate проверяет, что VM может исполнить программу: инструкции и операнды целые,
+// номера констант и функций существуют, переходы ведут на начало инструкции,
+// а стеку хватает значений для каждой инструкции
+func (program *Program) Validate() error {
+	if len(program.Functions) == 0 {
+		return errors.New("bytecode: program has no functions")
+	}
+	for i, fn := range program.Functions {
+		if err := program.validateFunction(fn, i == 0); err != nil {
+			return fmt.Errorf("bytecode: function %s: %v", fn.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateFunction проверяет одну функцию; top - функция верхнего уровня, с которой VM начинает
+func (program *Program) validateFunction(fn *Function, top bool) error {
+	code := fn.Chunk.Code
+	starts := make(map[int]bool)
+	var jumps []int
+	for offset := 0; offset < len(code); {
+		starts[offset] = true
+		op := Opcode(code[offset])
+		if !op.Valid() {
+			return fmt.Errorf("unknown opcode %d at %d", code[offset], offset)
+		}
+		size := InstructionSize(op)
+		if offset+size > len(code) {
+			return fmt.Errorf("%s at %d is truncated", op, offset)
+		}
+
+		operand := offset + 1
+		for i, width := range OperandWidths(op) {
+			value := ReadOperand(code, operand, width)
+			operand += width
+			switch {
+			case width == offsetWidth:
+				jumps = append(jumps, offset+size+value)
+			case op == FUNC:
+				if value >= len(program.Functions) {
+					return fmt.Errorf("FUNC at %d refers to missing function %d", offset, value)
+				}
+				if value == 0 {
+					return fmt.Errorf("FUNC at %d refers to the top-level function", offset)
+				}
+			case op == PUSH_CONST:
+				if value >= len(fn.Chunk.Constants) {
+					return fmt.Errorf("PUSH_CONST at %d refers to missing constant %d", offset, value)
+				}
+			case i == 0 && width == indexWidth && op != NEW_ARRAY && op != NEW_MAP:
+				// первый операнд остальных инструкций - имя
+				if value >= len(fn.Chunk.Constants) || fn.Chunk.Constants[value].Type != StringConstant {
+					return fmt.Errorf("%s at %d refers to missing name %d", op, offset, value)
+				}
+			}
+		}
+		offset += size
+	}
+	for _, target := range jumps {
+		if target != len(code) && !starts[target] {
+			return fmt.Errorf("jump to %d is not an instruction", target)
+		}
+	}
+	return validateStack(fn, top)
+}
+
+// validateStack проходит по всем достижимым инструкциям и проверяет, что ни одна не снимает
+// со стека больше значений, чем в нем есть, и что на каждую инструкцию все пути приходят
+// с одной глубиной стека. Функция начинается со стека из аргументов
+func validateStack(fn *Function, top bool) error {
+	code := fn.Chunk.Code
+	depths := map[int]int{0: fn.Arity}
+	work := []int{0}
+	// reach запоминает глубину стека перед инструкцией offset
+	reach := func(offset, depth int) error {
+		if offset == len(code) {
+			return nil
+		}
+		if known, ok := depths[offset]; ok {
+			if known != depth {
+				return fmt.Errorf("stack depth at %d is %d or %d depending on the path", offset, known, depth)
+			}
+			return nil
+		}
+		depths[offset] = depth
+		work = append(work, offset)
+		return nil
+	}
+	for len(work) > 0 {
+		offset := work[len(work)-1]
+		work = work[:len(work)-1]
+		if offset == len(code) {
+			continue
+		}
+
+		op := Opcode(code[offset])
+		var operands [2]int
+		operand := offset + 1
+		for i, width := range OperandWidths(op) {
+			operands[i] = ReadOperand(code, operand, width)
+			operand += width
+		}
+		next := offset + InstructionSize(op)
+
+		pop, push := stackEffect(op, operands)
+		if top && op == RETURN {
+			// на верхнем уровне возвращаться некуда: VM не трогает стек и идет дальше
+			pop, push = 0, 0
+		}
+		depth := depths[offset]
+		if depth < pop {
+			return fmt.Errorf("%s at %d needs %d values on the stack, but there are %d", op, offset, pop, depth)
+		}
+		depth += push - pop
+
+		var err error
+		switch op {
+		case JUMP:
+			err = reach(next+operands[0], depth)
+		case JUMP_IF_FALSE:
+			if err = reach(next+operands[0], depth); err == nil {
+				err = reach(next, depth)
+			}
+		case TRY_START:
+			// обработчик получает стек, каким он был при установке, и брошенное значение
+			if err = reach(next+operands[0], depth+1); err == nil {
+				err = reach(next, depth)
+			}
+		case RETURN:
+			// после возврата из функции эта ветвь не исполняется
+			if top {
+				err = reach(next, depth)
+			}
+		case THROW:
+			// дальше эта ветвь не исполняется
+		default:
+			err = reach(next, depth)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stackEffect возвращает, сколько значений инструкция снимает со стека и сколько кладет обратно.
+// Инструкции, которые только смотрят на значения под вершиной, снимают их и кладут снова
+func stackEffect(op Opcode, operands [2]int) (pop, push int) {
+	switch op {
+	case NEG, NOT, GET_PROPERTY, MAP_KEY:
+		return 1, 1
+	case ADD, SUB, MUL, DIV, AND, OR, LESS_THAN, GREATER_THAN, LESS_EQUAL_THAN, GREATER_EQUAL_THAN,
+		EQUAL, NOT_EQUAL, ARRAY_GET, SET_PROPERTY:
+		return 2, 1
+	case JUMP_IF_FALSE, RETURN, THROW, STORE_VAR, DEFINE_VAR, POP, PRINT:
+		return 1, 0
+	case CALL_FUNCTION:
+		return operands[0] + 1, 1
+	case CALL_METHOD:
+		return operands[1] + 1, 1
+	case PUSH_CONST, NIL, TRUE, FALSE, PUSH_VAR, SUPER, THIS, FUNC, CLASS:
+		return 0, 1
+	case DUP:
+		return 1, 2
+	case NEW_ARRAY:
+		return operands[0], 1
+	case NEW_MAP:
+		return 2 * operands[0], 1
+	case ARRAY_SET:
+		return 3, 0
+	case INHERIT:
+		return 2, 2
+	case METHOD:
+		return 2, 1
+	}
+	// JUMP, TRY_START, TRY_END, SCOPE_START, SCOPE_END
+	return 0, 0
+}
@@ -0,0 +1,131 @@
+package bytecode_gen
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func writeProgram(t *testing.T, program *Program) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := program.Write(&buf); err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+	return buf.Bytes()
+}
+
+// withChecksum заменяет контрольную сумму, чтобы Read дошел до разбора содержимого
+func withChecksum(body []byte) []byte {
+	data := append([]byte(nil), body...)
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(body))
+}
+
+func TestWriteReadRoundTrip(t *testing.T) {
+	tests := []string{
+		`print "hello" + 1.5;`,
+		`fun f(a, b) { var s = "x"; return a + b + s; } print f(1, 2);`,
+		`class A { init(x) { this.x = x; } } class B < A { get() { return this.x; } } print B(1).get();`,
+		`try { throw {"k": [1, 2]}; } catch (e) { print e; } finally { print nil and true; }`,
+	}
+	for i, input := range tests {
+		program := compile(t, input)
+		read, err := Read(bytes.NewReader(writeProgram(t, program)))
+		if err != nil {
+			t.Fatalf("test [%d]: read error: %s", i, err)
+		}
+		if len(read.Functions) != len(program.Functions) {
+			t.Fatalf("test [%d]: expected %d functions. got %d", i, len(program.Functions), len(read.Functions))
+		}
+		for j, fn := range program.Functions {
+			got := read.Functions[j]
+			if got.Name != fn.Name || got.Arity != fn.Arity ||
+				!bytes.Equal(got.Chunk.Code, fn.Chunk.Code) ||
+				!reflect.DeepEqual(got.Chunk.Constants, fn.Chunk.Constants) ||
+				!reflect.DeepEqual(got.Chunk.Lines, fn.Chunk.Lines) {
+				t.Errorf("test [%d]: function %s changed after writing and reading", i, fn.Name)
+			}
+		}
+	}
+}
+
+func TestReadVersionError(t *testing.T) {
+	data := writeProgram(t, compile(t, `print 1;`))
+	binary.BigEndian.PutUint16(data[len(FileMagic):], FormatVersion+1)
+	_, err := Read(bytes.NewReader(data))
+	var versionErr *VersionError
+	if !errors.As(err, &versionErr) || versionErr.Version != FormatVersion+1 {
+		t.Fatalf("expected VersionError, got %v", err)
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	data := writeProgram(t, compile(t, `fun f(x) { return x * 2; } print f("ab");`))
+	body := data[:len(data)-4]
+
+	if _, err := Read(bytes.NewReader(data[:len(data)-1])); err != ErrCorrupted {
+		t.Fatalf("expected ErrCorrupted, got %v", err)
+	}
+	if _, err := Read(bytes.NewReader(data[:len(FileMagic)+1])); err != ErrTruncated {
+		t.Fatalf("expected ErrTruncated, got %v", err)
+	}
+	// обрезанное содержимое с верной контрольной суммой не должно проходить проверку
+	for n := len(FileMagic) + 2; n < len(body); n++ {
+		if _, err := Read(bytes.NewReader(withChecksum(body[:n]))); err == nil {
+			t.Fatalf("file cut to %d bytes is accepted", n)
+		}
+	}
+	if _, err := Read(bytes.NewReader(withChecksum(body[:len(FileMagic)+2]))); err != ErrTruncated {
+		t.Fatalf("expected ErrTruncated, got %v", err)
+	}
+}
+
+func TestReadHugeCount(t *testing.T) {
+	// huge - файл, в котором после полей fields идет число 2^31-1
+	huge := func(fields ...byte) []byte {
+		body := binary.BigEndian.AppendUint16([]byte(FileMagic), FormatVersion)
+		return withChecksum(binary.AppendUvarint(append(body, fields...), math.MaxInt32))
+	}
+	tests := [][]byte{
+		huge(),
+		huge(0),
+		// одна функция без имени и аргументов, у которой 2^31-1 констант
+		huge(0, 1, 0, 0),
+		huge(0, 1, 0, 0, 0, 0),
+	}
+	for i, data := range tests {
+		if _, err := Read(bytes.NewReader(data)); err != ErrTruncated {
+			t.Errorf("test [%d]: expected ErrTruncated, got %v", i, err)
+		}
+	}
+}
+
+func TestReadRejectsUnbalancedStack(t *testing.T) {
+	program := &Program{Functions: []*Function{{Name: "<script>", Chunk: Chunk{Code: []byte{byte(ADD), byte(PRINT)}}}}}
+	_, err := Read(bytes.NewReader(writeProgram(t, program)))
+	if err == nil || err.Error() != "bytecode: function <script>: ADD at 0 needs 2 values on the stack, but there are 0" {
+		t.Fatalf("expected stack error, got %v", err)
+	}
+}
+
+func TestReadRejectsTopLevelReturn(t *testing.T) {
+	tests := []struct {
+		code     []byte
+		expected string
+	}{
+		// print nil; где NIL заменен на RETURN: на верхнем уровне RETURN ничего не снимает со стека
+		{[]byte{byte(RETURN), byte(PRINT)}, "bytecode: function <script>: PRINT at 1 needs 1 values on the stack, but there are 0"},
+		{[]byte{byte(FUNC), 0, 0, byte(POP)}, "bytecode: function <script>: FUNC at 0 refers to the top-level function"},
+	}
+	for i, test := range tests {
+		program := &Program{Functions: []*Function{{Name: "<script>", Chunk: Chunk{Code: test.code}}}}
+		_, err := Read(bytes.NewReader(writeProgram(t, program)))
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("test [%d]: expected %q, got %v", i, test.expected, err)
+		}
+	}
+}
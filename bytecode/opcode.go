@@ -0,0 +1,197 @@
+package bytecode_gen
+
+// Opcode - код инструкции. В закодированной функции инструкция занимает один байт кода
+// и операнды фиксированной ширины, см. OperandWidths
+type Opcode byte
+
+const (
+	NEG Opcode = iota // Унарный минус
+	NOT               // Логическое отрицание
+	ADD               // Сложение
+	SUB               // Вычитание
+	MUL               // Умножение
+	DIV               // Деление
+	AND               // Логическое и
+	OR                // Логическое или
+
+	LESS_THAN          // Меньше
+	GREATER_THAN       // Больше
+	LESS_EQUAL_THAN    // Меньше или равно
+	GREATER_EQUAL_THAN // Больше или равно
+	EQUAL              // Равенство
+	NOT_EQUAL          // Неравенство
+
+	JUMP          // Безусловный переход
+	JUMP_IF_FALSE // Переход, если условие ложно
+	CALL_FUNCTION // Вызов функции
+	CALL_METHOD   // Вызов метода объекта
+	RETURN        // Возврат из функции
+	TRY_START     // Установить обработчик исключений
+	TRY_END       // Снять обработчик исключений
+	THROW         // Бросить исключение
+
+	PUSH_CONST // Поместить константу в стек
+	NIL        // Поместить nil в стек
+	TRUE       // Поместить true в стек
+	FALSE      // Поместить false в стек
+	PUSH_VAR   // Поместить значение переменной в стек
+	STORE_VAR  // Сохранить значение в переменной
+	DEFINE_VAR // Объявить переменную в текущем окружении
+	POP        // Снять значение со стека
+	DUP        // Продублировать вершину стека
+	NEW_ARRAY  // Создать новый массив
+	ARRAY_GET  // Получить значение из массива
+	ARRAY_SET  // Установить значение в массиве
+	NEW_MAP    // Создать новый словарь
+
+	GET_PROPERTY // Получить свойство объекта
+	SET_PROPERTY // Установить свойство объекта
+
+	PRINT   // Вывод значения
+	SUPER   // Обращение к суперклассу
+	THIS    // Ссылка на текущий объект
+	FUNC    // Объявление функции
+	CLASS   // Создать класс
+	INHERIT // Задать классу родительский класс
+	METHOD  // Добавить метод в класс
+
+	SCOPE_START // Открыть окружение блока
+	SCOPE_END   // Закрыть окружение блока
+
+	MAP_KEY // Проверить, что вершина стека может быть ключом словаря
+
+	// Инструкции ниже есть только в выводе CodeGenerator, при кодировании они исчезают
+	END_FUNC // Конец тела функции
+	LABEL    // Метка для перехода
+
+	opcodeCount
+)
+
+const (
+	FALSE_LABEL      = "false_label_" // Метка для перехода при false
+	LOOP_START_LABEL = "loop_start_"  // Метка старта цикла
+	LOOP_END_LABEL   = "loop_end_"    // Метка конца цикла
+	LOOP_NEXT_LABEL  = "loop_next_"   // Метка шага цикла, на нее переходит continue
+	END_LABEL        = "end_label_"   // Метка конца
+	CATCH_LABEL      = "catch_"       // Метка обработчика исключения
+	RETHROW_LABEL    = "rethrow_"     // Метка finally, после которого исключение бросается дальше
+	FINALLY_LABEL    = "finally_"     // Метка блока finally
+)
+
+// Ширины операндов в байтах. Однобайтовые и двухбайтовые операнды беззнаковые,
+// четырехбайтовые - знаковые смещения переходов
+const (
+	countWidth  = 1 // число аргументов вызова
+	indexWidth  = 2 // номер константы, функции, число элементов или расстояние до окружения
+	offsetWidth = 4 // смещение перехода от начала следующей инструкции
+)
+
+// GlobalDistance - расстояние в PUSH_VAR и STORE_VAR для глобальной переменной
+const GlobalDistance = 0xFFFF
+
+var opcodes = [opcodeCount]struct {
+	name     string
+	operands []int
+}{
+	NEG:                {"NEG", nil},
+	NOT:                {"NOT", nil},
+	ADD:                {"ADD", nil},
+	SUB:                {"SUB", nil},
+	MUL:                {"MUL", nil},
+	DIV:                {"DIV", nil},
+	AND:                {"AND", nil},
+	OR:                 {"OR", nil},
+	LESS_THAN:          {"LESS_THAN", nil},
+	GREATER_THAN:       {"GREATER_THAN", nil},
+	LESS_EQUAL_THAN:    {"LESS_EQUAL_THAN", nil},
+	GREATER_EQUAL_THAN: {"GREATER_EQUAL_THAN", nil},
+	EQUAL:              {"EQUAL", nil},
+	NOT_EQUAL:          {"NOT_EQUAL", nil},
+	JUMP:               {"JUMP", []int{offsetWidth}},
+	JUMP_IF_FALSE:      {"JUMP_IF_FALSE", []int{offsetWidth}},
+	CALL_FUNCTION:      {"CALL_FUNCTION", []int{countWidth}},
+	CALL_METHOD:        {"CALL_METHOD", []int{indexWidth, countWidth}},
+	RETURN:             {"RETURN", nil},
+	TRY_START:          {"TRY_START", []int{offsetWidth}},
+	TRY_END:            {"TRY_END", nil},
+	THROW:              {"THROW", nil},
+	PUSH_CONST:         {"PUSH_CONST", []int{indexWidth}},
+	NIL:                {"NIL", nil},
+	TRUE:               {"TRUE", nil},
+	FALSE:              {"FALSE", nil},
+	PUSH_VAR:           {"PUSH_VAR", []int{indexWidth, indexWidth}},
+	STORE_VAR:          {"STORE_VAR", []int{indexWidth, indexWidth}},
+	DEFINE_VAR:         {"DEFINE_VAR", []int{indexWidth}},
+	POP:                {"POP", nil},
+	DUP:                {"DUP", nil},
+	NEW_ARRAY:          {"NEW_ARRAY", []int{indexWidth}},
+	ARRAY_GET:          {"ARRAY_GET", nil},
+	ARRAY_SET:          {"ARRAY_SET", nil},
+	NEW_MAP:            {"NEW_MAP", []int{indexWidth}},
+	GET_PROPERTY:       {"GET_PROPERTY", []int{indexWidth}},
+	SET_PROPERTY:       {"SET_PROPERTY", []int{indexWidth}},
+	PRINT:              {"PRINT", nil},
+	SUPER:              {"SUPER", []int{indexWidth, indexWidth}},
+	THIS:               {"THIS", nil},
+	FUNC:               {"FUNC", []int{indexWidth}},
+	CLASS:              {"CLASS", []int{indexWidth}},
+	INHERIT:            {"INHERIT", nil},
+	METHOD:             {"METHOD", []int{indexWidth}},
+	SCOPE_START:        {"SCOPE_START", nil},
+	SCOPE_END:          {"SCOPE_END", nil},
+	MAP_KEY:            {"MAP_KEY", nil},
+	END_FUNC:           {"END_FUNC", nil},
+	LABEL:              {"LABEL", nil},
+}
+
+func (op Opcode) String() string {
+	if op < opcodeCount {
+		return opcodes[op].name
+	}
+	return "UNKNOWN"
+}
+
+// Valid сообщает, может ли код встретиться в закодированной функции
+func (op Opcode) Valid() bool {
+	return op < END_FUNC
+}
+
+// OperandWidths возвращает ширины операндов инструкции в порядке их следования
+func OperandWidths(op Opcode) []int {
+	return opcodes[op].operands
+}
+
+// InstructionSize возвращает размер инструкции вместе с операндами
+func InstructionSize(op Opcode) int {
+	size := 1
+	for _, width := range opcodes[op].operands {
+		size += width
+	}
+	return size
+}
+
+// ReadOperand читает из кода операнд ширины width, начинающийся с offset
+func ReadOperand(code []byte, offset, width int) int {
+	switch width {
+	case countWidth:
+		return int(code[offset])
+	case indexWidth:
+		return int(code[offset])<<8 | int(code[offset+1])
+	case offsetWidth:
+		return int(int32(uint32(code[offset])<<24 | uint32(code[offset+1])<<16 | uint32(code[offset+2])<<8 | uint32(code[offset+3])))
+	}
+	panic("unknown operand width")
+}
+
+func appendOperand(code []byte, width, value int) []byte {
+	switch width {
+	case countWidth:
+		return append(code, byte(value))
+	case indexWidth:
+		return append(code, byte(value>>8), byte(value))
+	case offsetWidth:
+		v := uint32(int32(value))
+		return append(code, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+	panic("unknown operand width")
+}
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/Dor1ma/Strawberry/ast"
+	bytecodegen "github.com/Dor1ma/Strawberry/bytecode"
+	"github.com/Dor1ma/Strawberry/cmd/strawberry/repl"
+	"github.com/Dor1ma/Strawberry/dap"
+	"github.com/Dor1ma/Strawberry/debugger"
+	"github.com/Dor1ma/Strawberry/errors"
+	"github.com/Dor1ma/Strawberry/format"
+	"github.com/Dor1ma/Strawberry/interpreter"
+	"github.com/Dor1ma/Strawberry/lexer"
+	"github.com/Dor1ma/Strawberry/lsp"
+	"github.com/Dor1ma/Strawberry/parser"
+	"github.com/Dor1ma/Strawberry/resolver"
+	"github.com/Dor1ma/Strawberry/testrunner"
+	virtm "github.com/Dor1ma/Strawberry/vm"
+)
+
+// stdinName - имя стандартного ввода в позициях ошибок
+const stdinName = "<stdin>"
+
+// exitError - ошибка команды вместе с кодом выхода
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func fail(code int, err error) error {
+	return &exitError{code: code, err: err}
+}
+
+// report печатает ошибку команды в stderr и возвращает код выхода
+func report(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	code := exitRuntime
+	if exitErr, ok := err.(*exitError); ok {
+		code, err = exitErr.code, exitErr.err
+	}
+	if err == nil {
+		// код выхода без сообщения: все уже напечатано
+		return code
+	}
+	switch list := err.(type) {
+	case parser.ErrorList:
+		parser.PrintError(os.Stderr, list)
+	case resolver.ErrorList:
+		for _, e := range list {
+			errors.PrintError(os.Stderr, e)
+		}
+	default:
+		errors.PrintError(os.Stderr, err)
+	}
+	return code
+}
+
+// usageError печатает сообщение о неверных аргументах и справку команды
+func usageError(fs *flag.FlagSet, format string, args ...interface{}) int {
+	fmt.Fprintf(fs.Output(), "strawberry %s: %s\n", fs.Name(), fmt.Sprintf(format, args...))
+	fs.Usage()
+	return exitUsage
+}
+
+// source - входной файл команды: исходный текст или скомпилированная программа
+type source struct {
+	name string
+	data []byte
+}
+
+// readSource читает файл; "" и "-" означают стандартный ввод
+func readSource(path string) (*source, error) {
+	if path == "" || path == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fail(exitIO, err)
+		}
+		return &source{name: stdinName, data: data}, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fail(exitIO, err)
+	}
+	return &source{name: path, data: data}, nil
+}
+
+// compiled сообщает, что файл - программа .berryc. Проверяется содержимое, а не расширение,
+// чтобы скомпилированную программу можно было передать и через стандартный ввод
+func (src *source) compiled() bool {
+	return bytes.HasPrefix(src.data, []byte(bytecodegen.FileMagic))
+}
+
+// parse разбирает исходный текст и проверяет его resolver'ом
+func (src *source) parse() ([]ast.Statement, error) {
+	statements, err := parser.New(lexer.NewFile(src.name, string(src.data))).Parse()
+	if err != nil {
+		return nil, fail(exitCompile, err)
+	}
+	if err := resolver.Check(statements); err != nil {
+		return nil, fail(exitCompile, err)
+	}
+	return statements, nil
+}
+
+// program возвращает байт-код файла: читает .berryc или компилирует исходный текст
+func (src *source) program(opts *optimizations) (*bytecodegen.Program, error) {
+	if src.compiled() {
+		program, err := bytecodegen.Read(bytes.NewReader(src.data))
+		if err != nil {
+			return nil, fail(exitCompile, fmt.Errorf("%s: %v", src.name, err))
+		}
+		return program, nil
+	}
+	statements, err := src.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	generator := bytecodegen.CodeGenerator{}
+	if opts.unrollLoops {
+		generator.EnableLoopEnrolling()
+	}
+	if err := generator.GenerateProgram(statements); err != nil {
+		return nil, fail(exitCompile, err)
+	}
+	if opts.deadCode {
+		generator.EliminateDeadCode()
+	}
+	program, err := generator.Program()
+	if err != nil {
+		return nil, fail(exitCompile, err)
+	}
+	return program, nil
+}
+
+// optimizations - флаги оптимизаций байт-кода, общие для run, build и disasm
+type optimizations struct {
+	unrollLoops bool
+	deadCode    bool
+	tailCalls   bool
+}
+
+func (opts *optimizations) register(fs *flag.FlagSet) {
+	fs.BoolVar(&opts.unrollLoops, "unroll-loops", true, "unroll for loops with literal bounds whose counter the body does not assign")
+	fs.BoolVar(&opts.deadCode, "dce", true, "eliminate dead code")
+	fs.BoolVar(&opts.tailCalls, "tail-calls", true, "reuse the frame for recursive tail calls (VM only)")
+}
+
+// fileArg возвращает единственный необязательный позиционный аргумент
+func fileArg(fs *flag.FlagSet) (string, bool) {
+	switch fs.NArg() {
+	case 0:
+		return "", true
+	case 1:
+		return fs.Arg(0), true
+	}
+	return "", false
+}
+
+func runCommand(args []string) int {
+	fs := newFlagSet("run")
+	engine := fs.String("engine", "interp", "execution engine: interp (tree-walking interpreter) or vm (bytecode)")
+	opts := &optimizations{}
+	opts.register(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	path, ok := fileArg(fs)
+	if !ok {
+		return usageError(fs, "expected a single file")
+	}
+	engineSet := false
+	fs.Visit(func(f *flag.Flag) {
+		engineSet = engineSet || f.Name == "engine"
+	})
+
+	src, err := readSource(path)
+	if err != nil {
+		return report(err)
+	}
+	if src.compiled() {
+		// .berryc исполняет только VM
+		if engineSet && *engine != "vm" {
+			return usageError(fs, "%s is a compiled program, it can only run with -engine=vm", src.name)
+		}
+		*engine = "vm"
+	}
+
+	switch *engine {
+	case "interp":
+		statements, err := src.parse()
+		if err != nil {
+			return report(err)
+		}
+		return report(interpreter.New(interpreter.Options{}).Interpret(statements))
+	case "vm":
+		program, err := src.program(opts)
+		if err != nil {
+			return report(err)
+		}
+		vm := virtm.NewVirtualMachine(program)
+		if opts.tailCalls {
+			vm.EnableTailRecursionOptimization()
+		}
+		return report(vm.Run())
+	}
+	return usageError(fs, "unknown engine %q, expected interp or vm", *engine)
+}
+
+func buildCommand(args []string) int {
+	fs := newFlagSet("build")
+	output := fs.String("o", "", "output file; by default the input name with the "+bytecodegen.FileExtension+" extension, \"-\" for standard output")
+	opts := &optimizations{}
+	opts.register(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	path, ok := fileArg(fs)
+	if !ok {
+		return usageError(fs, "expected a single file")
+	}
+	if *output == "" {
+		if path == "" || path == "-" {
+			return usageError(fs, "-o is required when reading standard input")
+		}
+		*output = strings.TrimSuffix(path, filepath.Ext(path)) + bytecodegen.FileExtension
+	}
+
+	src, err := readSource(path)
+	if err != nil {
+		return report(err)
+	}
+	if src.compiled() {
+		return report(fail(exitUsage, fmt.Errorf("%s is already compiled", src.name)))
+	}
+	program, err := src.program(opts)
+	if err != nil {
+		return report(err)
+	}
+
+	var out bytes.Buffer
+	if err := program.Write(&out); err != nil {
+		return report(fail(exitIO, err))
+	}
+	if *output == "-" {
+		_, err = os.Stdout.Write(out.Bytes())
+	} else {
+		err = os.WriteFile(*output, out.Bytes(), 0644)
+	}
+	if err != nil {
+		return report(fail(exitIO, err))
+	}
+	return exitOK
+}
+
+func disasmCommand(args []string) int {
+	fs := newFlagSet("disasm")
+	opts := &optimizations{}
+	opts.register(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	path, ok := fileArg(fs)
+	if !ok {
+		return usageError(fs, "expected a single file")
+	}
+
+	src, err := readSource(path)
+	if err != nil {
+		return report(err)
+	}
+	program, err := src.program(opts)
+	if err != nil {
+		return report(err)
+	}
+	if err := bytecodegen.Disassemble(os.Stdout, program); err != nil {
+		return report(fail(exitIO, err))
+	}
+	return exitOK
+}
+
+// checkCommand проверяет все файлы и печатает все ошибки, код выхода - худший из кодов файлов
+func checkCommand(args []string) int {
+	fs := newFlagSet("check")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+
+	code := exitOK
+	for _, path := range paths {
+		src, err := readSource(path)
+		if err == nil {
+			if src.compiled() {
+				_, err = src.program(nil)
+			} else {
+				_, err = src.parse()
+			}
+		}
+		if c := report(err); c > code {
+			code = c
+		}
+	}
+	return code
+}
+
+func fmtCommand(args []string) int {
+	fs := newFlagSet("fmt")
+	write := fs.Bool("w", false, "write the result to the files instead of standard output")
+	check := fs.Bool("check", false, "list files that are not formatted and exit with status 1 if there are any")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() == 0 || fs.NArg() == 1 && fs.Arg(0) == "-" {
+		if *write {
+			return usageError(fs, "-w cannot be used with standard input")
+		}
+		return report(formatFile("-", false, *check))
+	}
+
+	files, err := berryFiles(fs.Args())
+	if err != nil {
+		return report(err)
+	}
+	code := exitOK
+	for _, file := range files {
+		if c := report(formatFile(file, *write, *check)); c > code {
+			code = c
+		}
+	}
+	return code
+}
+
+// formatFile форматирует файл: печатает результат, перезаписывает файл или только проверяет его
+func formatFile(path string, write, check bool) error {
+	src, err := readSource(path)
+	if err != nil {
+		return err
+	}
+	formatted, err := format.Source(src.name, src.data)
+	if err != nil {
+		return fail(exitCompile, err)
+	}
+	switch {
+	case check:
+		if !bytes.Equal(formatted, src.data) {
+			fmt.Fprintln(os.Stdout, src.name)
+			return fail(exitUnformatted, nil)
+		}
+	case write:
+		if !bytes.Equal(formatted, src.data) {
+			if err := os.WriteFile(path, formatted, 0644); err != nil {
+				return fail(exitIO, err)
+			}
+		}
+	default:
+		if _, err := os.Stdout.Write(formatted); err != nil {
+			return fail(exitIO, err)
+		}
+	}
+	return nil
+}
+
+// berryFiles раскрывает аргументы в список файлов: каталоги обходятся рекурсивно
+// и дают все файлы .berry, "dir/..." - то же, что "dir"
+func berryFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		path = strings.TrimSuffix(path, "...")
+		if path == "" {
+			path = "."
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fail(exitIO, err)
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(file string, entry os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() && filepath.Ext(file) == ".berry" {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fail(exitIO, err)
+		}
+	}
+	return files, nil
+}
+
+// testCommand выполняет тесты из файлов *_test.berry. Каталоги testdata при обходе пропускаются,
+// как в go test
+func testCommand(args []string) int {
+	fs := newFlagSet("test")
+	run := fs.String("run", "", "run only tests whose names match the regular expression")
+	verbose := fs.Bool("v", false, "report every test, not only failed ones")
+	junit := fs.String("junit", "", "also write the results as JUnit XML to the file")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	opts := testrunner.Options{Verbose: *verbose}
+	if *run != "" {
+		re, err := regexp.Compile(*run)
+		if err != nil {
+			return usageError(fs, "invalid -run: %s", err)
+		}
+		opts.Run = re
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"./..."}
+	}
+
+	var files []string
+	for _, path := range paths {
+		found, err := berryFiles([]string{path})
+		if err != nil {
+			return report(err)
+		}
+		for _, file := range found {
+			if testrunner.IsTestFile(file) && (file == path || !inTestdata(file)) {
+				files = append(files, file)
+			}
+		}
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "strawberry test: no test files")
+		return exitOK
+	}
+
+	results := testrunner.Run(files, opts)
+	if *junit != "" {
+		var buf bytes.Buffer
+		if err := testrunner.WriteJUnit(&buf, results); err != nil {
+			return report(fail(exitIO, err))
+		}
+		if err := os.WriteFile(*junit, buf.Bytes(), 0644); err != nil {
+			return report(fail(exitIO, err))
+		}
+	}
+	for _, result := range results {
+		if result.Err != nil || result.Failed() > 0 {
+			return exitRuntime
+		}
+	}
+	return exitOK
+}
+
+func inTestdata(path string) bool {
+	for _, dir := range strings.Split(filepath.ToSlash(filepath.Dir(path)), "/") {
+		if dir == "testdata" {
+			return true
+		}
+	}
+	return false
+}
+
+func replCommand(args []string) int {
+	fs := newFlagSet("repl")
+	history := fs.String("history", defaultHistoryFile(), "file that keeps the input history between sessions; empty to disable")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	fmt.Fprintln(os.Stdout, "Strawberry.")
+	fmt.Fprintln(os.Stdout, "Type :help for help, \"exit\" to exit.")
+	repl.StartWithOptions(os.Stdin, os.Stdout, repl.Options{HistoryFile: *history})
+	return exitOK
+}
+
+// debugCommand запускает скрипт под отладчиком; команды отладчика читаются из stdin
+func debugCommand(args []string) int {
+	fs := newFlagSet("debug")
+	engine := fs.String("engine", "interp", "execution engine: interp (tree-walking interpreter) or vm (bytecode)")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	path, ok := fileArg(fs)
+	if !ok || path == "" || path == "-" {
+		return usageError(fs, "expected a single script file")
+	}
+	if *engine != "interp" && *engine != "vm" {
+		return usageError(fs, "unknown engine %q", *engine)
+	}
+	src, err := readSource(path)
+	if err != nil {
+		return report(err)
+	}
+	if src.compiled() {
+		return usageError(fs, "%s is a compiled program, debug needs the script", src.name)
+	}
+	d, err := debugger.New(src.name, src.data, debugger.Options{Engine: *engine})
+	if err != nil {
+		return report(fail(exitCompile, err))
+	}
+	fmt.Fprintf(os.Stdout, "Debugging %s. Type help for help.\n", src.name)
+	if err := debugger.NewTerminal(d, os.Stdout).Run(os.Stdin); err != nil {
+		return report(fail(exitIO, err))
+	}
+	return exitOK
+}
+
+// lspCommand запускает языковой сервер; редактор общается с ним через stdin и stdout
+func lspCommand(args []string) int {
+	fs := newFlagSet("lsp")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "strawberry lsp: %s\n", err)
+		return exitRuntime
+	}
+	return exitOK
+}
+
+// dapCommand запускает адаптер отладки; редактор общается с ним через stdin и stdout
+func dapCommand(args []string) int {
+	fs := newFlagSet("dap")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	if err := dap.NewServer(os.Stdin, os.Stdout).Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "strawberry dap: %s\n", err)
+		return exitRuntime
+	}
+	return exitOK
+}
+
+// defaultHistoryFile - ~/.strawberry_history или "", если домашний каталог неизвестен
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".strawberry_history")
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckReportsAllErrors(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "bad.berry")
+	src := "break;\nthis.x;\n{ var a = 1; var a = 2; }\nprint \"ok\";\n"
+	if err := os.WriteFile(script, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(self, "check", script)
+	cmd.Env = append(os.Environ(), mainEnv+"=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Run()
+
+	expected := script + ":1:1: Cannot use 'break' outside of a loop.\n" +
+		script + ":2:1: Cannot use 'this' outside of a class.\n" +
+		script + ":3:18: variable name \"a\" has been already delcared in this scope.\n"
+	if stderr.String() != expected || cmd.ProcessState.ExitCode() != exitCompile {
+		t.Fatalf("unexpected result (exit status %d):\n%s\nwant:\n%s", cmd.ProcessState.ExitCode(), stderr.String(), expected)
+	}
+}
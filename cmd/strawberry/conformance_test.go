@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Проверка соответствия движков: каждый скрипт из example/ и tasks/ выполняется командой
+// strawberry run с -engine=interp и -engine=vm, и результат сравнивается с файлами рядом
+// со скриптом. В .out лежит ожидаемый stdout. В .err - stderr и последней строкой
+// "exit status N"; если .err нет, скрипт должен завершиться с кодом 0 и пустым stderr.
+// go test -run Conformance -update перезаписывает эти файлы по выводу интерпретатора;
+// VM после этого все равно сравнивается с ними.
+
+var update = flag.Bool("update", false, "rewrite the .out and .err files of the conformance scripts from the interpreter output")
+
+// mainEnv - переменная окружения, с которой тестовый бинарник работает как strawberry
+const mainEnv = "STRAWBERRY_TEST_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(mainEnv) == "1" {
+		os.Exit(run(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+var engines = []string{"interp", "vm"}
+
+// outcome - то, что видно снаружи после выполнения скрипта
+type outcome struct {
+	stdout string
+	stderr string
+	code   int
+}
+
+// errFile - содержимое .err для результата; "" - файл не нужен
+func (o outcome) errFile() string {
+	if o.stderr == "" && o.code == 0 {
+		return ""
+	}
+	return o.stderr + "exit status " + strconv.Itoa(o.code) + "\n"
+}
+
+func TestConformance(t *testing.T) {
+	root := filepath.Join("..", "..")
+	var scripts []string
+	for _, dir := range []string{"example", "tasks"} {
+		found, err := filepath.Glob(filepath.Join(root, dir, "*.berry"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		scripts = append(scripts, found...)
+	}
+	if len(scripts) == 0 {
+		t.Fatal("no scripts found")
+	}
+
+	for _, script := range scripts {
+		rel, _ := filepath.Rel(root, script)
+		t.Run(filepath.ToSlash(rel), func(t *testing.T) {
+			base, name := strings.TrimSuffix(script, ".berry"), strings.TrimSuffix(filepath.ToSlash(rel), ".berry")
+			if *update {
+				writeGolden(t, base, execute(t, root, engines[0], rel))
+			}
+			want := readGolden(t, base)
+			for _, engine := range engines {
+				got := execute(t, root, engine, rel)
+				if got.stdout != want.stdout {
+					t.Errorf("%s: stdout differs from %s.out\n%s", engine, name, lineDiff(want.stdout, got.stdout))
+				}
+				if got.errFile() != want.errFile() {
+					t.Errorf("%s: stderr or exit status differs from %s.err\n%s", engine, name, lineDiff(want.errFile(), got.errFile()))
+				}
+			}
+		})
+	}
+}
+
+// execute запускает strawberry run в корне репозитория, чтобы пути в ошибках были относительными
+func execute(t *testing.T, root, engine, script string) outcome {
+	t.Helper()
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(self, "run", "-engine="+engine, filepath.ToSlash(script))
+	cmd.Dir = root
+	cmd.Env = append(os.Environ(), mainEnv+"=1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatalf("%s: %s", engine, err)
+	}
+	return outcome{stdout: stdout.String(), stderr: stderr.String(), code: cmd.ProcessState.ExitCode()}
+}
+
+func readGolden(t *testing.T, base string) outcome {
+	t.Helper()
+	out, err := os.ReadFile(base + ".out")
+	if err != nil {
+		t.Fatalf("%s (run with -update to create it)", err)
+	}
+	want := outcome{stdout: string(out)}
+	data, err := os.ReadFile(base + ".err")
+	if errors.Is(err, os.ErrNotExist) {
+		return want
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := strings.TrimSuffix(string(data), "\n")
+	i := strings.LastIndex(text, "\n") + 1
+	code, err := strconv.Atoi(strings.TrimPrefix(text[i:], "exit status "))
+	if err != nil || !strings.HasPrefix(text[i:], "exit status ") {
+		t.Fatalf("%s.err: the last line must be \"exit status N\"", base)
+	}
+	want.stderr, want.code = text[:i], code
+	return want
+}
+
+func writeGolden(t *testing.T, base string, o outcome) {
+	t.Helper()
+	if err := os.WriteFile(base+".out", []byte(o.stdout), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if o.errFile() == "" {
+		if err := os.Remove(base + ".err"); err != nil && !errors.Is(err, os.ErrNotExist) {
+			t.Fatal(err)
+		}
+		return
+	}
+	if err := os.WriteFile(base+".err", []byte(o.errFile()), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// lineDiff показывает различие построчно: "-" - ожидалось, "+" - получено.
+// Совпадающие начало и конец пропускаются
+func lineDiff(want, got string) string {
+	w, g := strings.Split(want, "\n"), strings.Split(got, "\n")
+	prefix := 0
+	for prefix < len(w) && prefix < len(g) && w[prefix] == g[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(w)-prefix && suffix < len(g)-prefix && w[len(w)-1-suffix] == g[len(g)-1-suffix] {
+		suffix++
+	}
+	var sb strings.Builder
+	for _, line := range w[prefix : len(w)-suffix] {
+		sb.WriteString("- " + line + "\n")
+	}
+	for _, line := range g[prefix : len(g)-suffix] {
+		sb.WriteString("+ " + line + "\n")
+	}
+	return sb.String()
+}
@@ -1,47 +1,136 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	bytecodegen "github.com/Dor1ma/Strawberry/bytecode"
-	"github.com/Dor1ma/Strawberry/cmd/strawberry/repl"
-	"github.com/Dor1ma/Strawberry/lexer"
-	"github.com/Dor1ma/Strawberry/parser"
-	virtm "github.com/Dor1ma/Strawberry/vm"
-	"io/ioutil"
+	"io"
 	"os"
+	"strings"
 )
 
-func main() {
-	if len(os.Args) >= 2 {
-		name := ".\\tasks\\001-bubble-sort.berry"
-		//name := ".\\tasks\\002-factorial.berry"
-		//name := ".\\tasks\\003-era.berry"
-
-		b, err := ioutil.ReadFile(name)
-		if err != nil {
-			panic(err)
-		}
-		l := lexer.New(string(b))
-		p := parser.New(l)
-		if statements, err := p.Parse(); err == nil && len(statements) != 0 {
-			generator := bytecodegen.CodeGenerator{}
+// коды выхода
+const (
+	exitOK      = 0
+	exitRuntime = 1 // ошибка во время выполнения скрипта
+	exitUsage   = 2 // неизвестная команда, неверные флаги или аргументы
+	exitCompile = 3 // синтаксическая ошибка, ошибка resolver или неверный .berryc
+	exitIO      = 4 // файл не удалось прочитать или записать
 
-			generator.EnableLoopEnrolling()
+	exitUnformatted = 1 // fmt -check: есть неотформатированные файлы
+)
 
-			generator.GenerateProgram(statements)
+// command - подкоманда strawberry. run получает аргументы после имени команды и возвращает код выхода
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"run", "[flags] [file]", "run a script (.berry) or a compiled program (.berryc)", runCommand},
+		{"build", "[flags] [-o out.berryc] [file]", "compile a script to bytecode", buildCommand},
+		{"disasm", "[flags] [file]", "print the bytecode listing of a script or a compiled program", disasmCommand},
+		{"check", "[files]", "report syntax and resolve errors without running", checkCommand},
+		{"fmt", "[-w | -check] [files or directories]", "format scripts", fmtCommand},
+		{"test", "[-run regexp] [-v] [-junit out.xml] [files or directories]", "run test_* functions from *_test.berry files", testCommand},
+		{"repl", "", "start an interactive session", replCommand},
+		{"debug", "[-engine=interp|vm] file", "run a script under the interactive debugger", debugCommand},
+		{"lsp", "", "start a language server on stdin and stdout", lspCommand},
+		{"dap", "", "start a debug adapter on stdin and stdout", dapCommand},
+		{"help", "[command]", "show help for a command", helpCommand},
+	}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) == 0 {
+		return replCommand(nil)
+	}
+	switch args[0] {
+	case "-h", "-help", "--help":
+		usage(os.Stdout)
+		return exitOK
+	}
+	if cmd := findCommand(args[0]); cmd != nil {
+		return cmd.run(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "strawberry: unknown command %q\n\n", args[0])
+	usage(os.Stderr)
+	return exitUsage
+}
 
-			generator.EliminateDeadCode()
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
 
-			vm := virtm.NewVirtualMachine(generator.GetBytecodes())
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: strawberry <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Without a command strawberry starts the REPL. A file named \"-\" or no file")
+	fmt.Fprintln(w, "means standard input. Run \"strawberry help <command>\" for the command flags.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Exit codes: 0 success, 1 runtime error, 2 usage error,")
+	fmt.Fprintln(w, "            3 compile error, 4 input/output error;")
+	fmt.Fprintln(w, "            \"fmt -check\" exits with 1 if files are not formatted.")
+}
 
-			vm.EnableTailRecursionOptimization()
+func helpCommand(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stdout)
+		return exitOK
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "strawberry help: unknown command %q\n", args[0])
+		return exitUsage
+	}
+	// справку печатает сама команда, как на флаг -h
+	return cmd.run([]string{"-h"})
+}
 
-			vm.Run()
+// newFlagSet создает набор флагов команды name; ошибки разбора обрабатывает parseFlags
+func newFlagSet(name string) *flag.FlagSet {
+	cmd := findCommand(name)
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		w := fs.Output()
+		fmt.Fprintf(w, "Usage: strawberry %s %s\n\n", cmd.name, cmd.args)
+		fmt.Fprintf(w, "%s%s.\n", strings.ToUpper(cmd.summary[:1]), cmd.summary[1:])
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintln(w, "\nFlags:")
+			fs.PrintDefaults()
 		}
-		return
 	}
+	return fs
+}
 
-	fmt.Fprintln(os.Stdout, "Strawberry.")
-	fmt.Fprintln(os.Stdout, "Type \"exit\" to exit.")
-	repl.Start(os.Stdin, os.Stdout)
+// parseFlags разбирает флаги; ok == false, если команду выполнять не нужно, тогда code - код выхода
+func parseFlags(fs *flag.FlagSet, args []string) (code int, ok bool) {
+	switch err := fs.Parse(args); err {
+	case nil:
+		return exitOK, true
+	case flag.ErrHelp:
+		return exitOK, false
+	default:
+		return exitUsage, false
+	}
 }
@@ -0,0 +1,89 @@
+package repl
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// historySize - сколько последних записей истории читается из файла
+const historySize = 1000
+
+// History - введенные команды. Каждая запись - одна строка файла, переводы строк
+// многострочного ввода записываются как \n, обратная косая черта - как \\
+type History struct {
+	file    string
+	entries []string
+}
+
+// LoadHistory читает историю из файла. Отсутствующий файл - пустая история;
+// пустое имя файла - история только на время сессии
+func LoadHistory(file string) (*History, error) {
+	h := &History{file: file}
+	if file == "" {
+		return h, nil
+	}
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			h.entries = append(h.entries, unescapeEntry(line))
+		}
+	}
+	if len(h.entries) > historySize {
+		h.entries = h.entries[len(h.entries)-historySize:]
+	}
+	return h, scanner.Err()
+}
+
+// Add добавляет запись и дописывает ее в файл. Повтор предыдущей записи не сохраняется
+func (h *History) Add(entry string) error {
+	if entry == "" || (len(h.entries) > 0 && h.entries[len(h.entries)-1] == entry) {
+		return nil
+	}
+	h.entries = append(h.entries, entry)
+	if h.file == "" {
+		return nil
+	}
+	f, err := os.OpenFile(h.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(escapeEntry(entry) + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Entries возвращает записи, самая старая - первая
+func (h *History) Entries() []string {
+	return h.entries
+}
+
+func escapeEntry(entry string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(entry)
+}
+
+func unescapeEntry(line string) string {
+	var b strings.Builder
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' && i+1 < len(line) {
+			i++
+			if line[i] == 'n' {
+				b.WriteByte('\n')
+				continue
+			}
+		}
+		b.WriteByte(line[i])
+	}
+	return b.String()
+}
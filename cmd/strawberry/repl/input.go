@@ -0,0 +1,58 @@
+package repl
+
+import (
+	"github.com/Dor1ma/Strawberry/lexer"
+	"github.com/Dor1ma/Strawberry/token"
+)
+
+// continuation - токены, после которых ввод не может закончиться
+var continuation = map[token.Token]bool{
+	token.Comma:              true,
+	token.Colon:              true,
+	token.Dot:                true,
+	token.Minus:              true,
+	token.Plus:               true,
+	token.Slash:              true,
+	token.Star:               true,
+	token.Not:                true,
+	token.NotEqual:           true,
+	token.Equal:              true,
+	token.EqualEqual:         true,
+	token.Arrow:              true,
+	token.Greater:            true,
+	token.GreaterThanOrEqual: true,
+	token.Less:               true,
+	token.LessThanOrEqual:    true,
+	token.And:                true,
+	token.Or:                 true,
+	token.Else:               true,
+}
+
+// incomplete сообщает, что ввод не закончен: открыта скобка, строка или блочный комментарий,
+// или ввод кончается оператором. Тогда REPL показывает приглашение продолжения
+func incomplete(input string) bool {
+	l := lexer.New(input)
+	unterminated := false
+	l.SetErrorHandler(func(pos token.Position, msg string) {
+		if msg == "unterminated string" || msg == "unterminated comment" {
+			unterminated = true
+		}
+	})
+
+	depth := 0
+	last := token.Illegal
+	for {
+		tok, _, _ := l.NextToken()
+		if tok == token.EOF {
+			break
+		}
+		switch tok {
+		case token.LeftParen, token.LeftBrace, token.LeftBracket:
+			depth++
+		case token.RightParen, token.RightBrace, token.RightBracket:
+			depth--
+		}
+		last = tok
+	}
+	return unterminated || depth > 0 || continuation[last]
+}
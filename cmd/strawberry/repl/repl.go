@@ -3,39 +3,262 @@ package repl
 import (
 	"bufio"
 	"fmt"
+	"github.com/Dor1ma/Strawberry/ast"
+	bytecodegen "github.com/Dor1ma/Strawberry/bytecode"
+	"github.com/Dor1ma/Strawberry/errors"
 	"github.com/Dor1ma/Strawberry/interpreter"
 	"github.com/Dor1ma/Strawberry/lexer"
 	"github.com/Dor1ma/Strawberry/parser"
+	"github.com/Dor1ma/Strawberry/token"
+	"github.com/Dor1ma/Strawberry/valuer"
 	"io"
+	"os"
 	"strings"
 )
 
-const prompt = ">> "
+const (
+	prompt             = ">> "
+	continuationPrompt = ".. "
+)
+
+const help = `Enter statements to run them; the value of the last expression is printed.
+Input continues on the next line while brackets are open or a line ends with an operator.
+An empty line runs unfinished input as is.
+
+Commands:
+  :help             show this help
+  :load <file>      run a file in the current session
+  :reset            forget all variables
+  :env              list global variables
+  :ast <code>       print the syntax tree of code or an expression
+  :bytecode <code>  print the bytecode of code or an expression
+  :history          show the input history
+  :quit             leave the REPL (same as exit)
+`
+
+// Options - настройки REPL
+type Options struct {
+	// HistoryFile - файл, в котором история ввода хранится между сессиями; "" - не сохранять
+	HistoryFile string
+}
 
 // Start creates a REPL for Strawberry.
 func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	interpreter.SetEvalEnv("repl")
+	StartWithOptions(in, out, Options{})
+}
+
+// StartWithOptions - то же, что Start, с настройками
+func StartWithOptions(in io.Reader, out io.Writer, opts Options) {
+	history, err := LoadHistory(opts.HistoryFile)
+	if err != nil {
+		fmt.Fprintf(out, "history: %s\n", err)
+		history, _ = LoadHistory("")
+	}
+	r := &repl{out: out, history: history}
+	r.reset()
+	r.loop(bufio.NewScanner(in))
+}
+
+type repl struct {
+	out     io.Writer
+	interp  *interpreter.Interpreter
+	history *History
+}
+
+func (r *repl) reset() {
+	r.interp = interpreter.New(interpreter.Options{Stdout: r.out, REPL: true})
+}
+
+func (r *repl) loop(scanner *bufio.Scanner) {
+	var input []string
 	for {
-		fmt.Fprintf(out, prompt)
-		scanned := scanner.Scan()
-		if !scanned {
+		if len(input) == 0 {
+			fmt.Fprint(r.out, prompt)
+		} else {
+			fmt.Fprint(r.out, continuationPrompt)
+		}
+		if !scanner.Scan() {
+			if len(input) != 0 {
+				fmt.Fprintln(r.out)
+				r.submit(strings.Join(input, "\n"))
+			}
 			return
 		}
+		line := scanner.Text()
 
-		line := strings.TrimSpace(scanner.Text())
-		if len(line) == 0 {
+		if len(input) == 0 {
+			trimmed := strings.TrimSpace(line)
+			if trimmed == "" {
+				continue
+			}
+			if trimmed == "exit" || strings.HasPrefix(trimmed, ":") {
+				r.addHistory(trimmed)
+				if !r.command(trimmed) {
+					fmt.Fprintln(r.out, "bye.")
+					return
+				}
+				continue
+			}
+		}
+
+		input = append(input, line)
+		src := strings.Join(input, "\n")
+		// пустая строка отправляет незаконченный ввод, чтобы из продолжения можно было выйти
+		if strings.TrimSpace(line) != "" && incomplete(src) {
 			continue
 		}
-		if line == "exit" {
-			fmt.Fprintln(out, "bye.")
-			return
+		input = nil
+		r.submit(src)
+	}
+}
+
+// submit сохраняет ввод в истории и выполняет его
+func (r *repl) submit(src string) {
+	r.addHistory(strings.TrimSpace(src))
+	r.run(src)
+}
+
+func (r *repl) addHistory(entry string) {
+	if err := r.history.Add(entry); err != nil {
+		fmt.Fprintf(r.out, "history: %s\n", err)
+	}
+}
+
+func (r *repl) run(src string) {
+	statements, err := parse(src)
+	if err != nil {
+		parser.PrintError(r.out, err)
+		return
+	}
+	if len(statements) != 0 {
+		if err := r.interp.Interpret(statements); err != nil {
+			errors.PrintError(r.out, err)
+		}
+	}
+}
+
+// parse разбирает ввод. Выражение можно ввести без ';': если без нее ввод не разбирается,
+// он разбирается еще раз с ';' в конце
+func parse(src string) ([]ast.Statement, error) {
+	statements, err := parser.New(lexer.New(src)).Parse()
+	if err != nil && !strings.HasSuffix(strings.TrimSpace(src), ";") {
+		if withSemicolon, err2 := parser.New(lexer.New(src + ";")).Parse(); err2 == nil {
+			return withSemicolon, nil
 		}
-		l := lexer.New(line)
-		p := parser.New(l)
-		statements, err := p.Parse()
-		if err == nil && len(statements) != 0 {
-			interpreter.Interpret(statements)
+	}
+	return statements, err
+}
+
+// command выполняет команду REPL; false означает выход
+func (r *repl) command(line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case "exit", ":quit", ":q":
+		return false
+	case ":help":
+		fmt.Fprint(r.out, help)
+	case ":load":
+		r.load(arg)
+	case ":reset":
+		r.reset()
+		fmt.Fprintln(r.out, "Session reset.")
+	case ":env":
+		r.env()
+	case ":ast":
+		r.ast(arg)
+	case ":bytecode":
+		r.bytecode(arg)
+	case ":history":
+		for i, entry := range r.history.Entries() {
+			fmt.Fprintf(r.out, "%4d  %s\n", i+1, strings.ReplaceAll(entry, "\n", "\n      "))
+		}
+	default:
+		fmt.Fprintf(r.out, "Unknown command %s. Type :help for help.\n", name)
+	}
+	return true
+}
+
+func (r *repl) load(file string) {
+	if file == "" {
+		fmt.Fprintln(r.out, "Usage: :load <file>")
+		return
+	}
+	src, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(r.out, err)
+		return
+	}
+	if err := r.interp.RunFile(file, string(src)); err != nil {
+		if list, ok := err.(parser.ErrorList); ok {
+			parser.PrintError(r.out, list)
+		} else {
+			errors.PrintError(r.out, err)
+		}
+	}
+}
+
+// env печатает глобальные переменные сессии; встроенные функции не показываются
+func (r *repl) env() {
+	for _, name := range r.interp.Globals() {
+		v, _ := r.interp.GetGlobal(name)
+		if _, ok := v.(*valuer.NativeFunction); ok {
+			continue
 		}
+		fmt.Fprintf(r.out, "%s: %s = %s\n", name, v.Type(), v)
+	}
+}
+
+// parseCode разбирает аргумент :ast и :bytecode как выражение, а если не получилось - как операторы
+func (r *repl) parseCode(code string) ([]ast.Statement, ast.Expression, bool) {
+	if code == "" {
+		fmt.Fprintln(r.out, "Expected code or an expression.")
+		return nil, nil, false
+	}
+	expr, exprErr := parser.ParseExpr(code)
+	if exprErr == nil {
+		return nil, expr, true
+	}
+	statements, err := parser.New(lexer.New(code)).Parse()
+	if err == nil {
+		return statements, nil, true
+	}
+	parser.PrintError(r.out, err)
+	return nil, nil, false
+}
+
+func (r *repl) ast(code string) {
+	statements, expr, ok := r.parseCode(code)
+	if !ok {
+		return
+	}
+	if expr != nil {
+		fmt.Fprintln(r.out, expr)
+		return
+	}
+	for _, stmt := range statements {
+		fmt.Fprintln(r.out, stmt)
+	}
+}
+
+func (r *repl) bytecode(code string) {
+	statements, expr, ok := r.parseCode(code)
+	if !ok {
+		return
+	}
+	if expr != nil {
+		statements = []ast.Statement{&ast.ExprStmt{Span: token.Span{From: expr.Pos(), To: expr.End()}, Expression: expr}}
+	}
+
+	generator := bytecodegen.CodeGenerator{}
+	if err := generator.GenerateProgram(statements); err != nil {
+		errors.PrintError(r.out, err)
+		return
+	}
+	program, err := generator.Program()
+	if err != nil {
+		fmt.Fprintln(r.out, err)
+		return
 	}
+	bytecodegen.Disassemble(r.out, program)
 }
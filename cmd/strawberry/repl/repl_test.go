@@ -0,0 +1,98 @@
+package repl
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIncomplete(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"print 1;", false},
+		{"fun f(a) {", true},
+		{"fun f(a) {\n  return a;\n}", false},
+		{"var a = [1,", true},
+		{"print (1 +", true},
+		{"var a = 1 +", true},
+		{"a.b.", true},
+		{"if (a) print 1; else", true},
+		{`var s = "abc`, true},
+		{"/* comment", true},
+		{"print 1; // {", false},
+		{"}", false},
+	}
+
+	for i, test := range tests {
+		if got := incomplete(test.input); got != test.expected {
+			t.Fatalf("test [%d] failed. incomplete(%q) = %t, want %t", i, test.input, got, test.expected)
+		}
+	}
+}
+
+func TestSession(t *testing.T) {
+	input := `fun add(a, b) {
+  return a +
+    b;
+}
+add(1, 2)
+print 1 +;
+throw "boom";
+:env
+:ast 1 + 2 * 3
+:reset
+:env
+:quit
+print "unreachable";
+`
+	var out bytes.Buffer
+	StartWithOptions(strings.NewReader(input), &out, Options{})
+
+	expected := []string{
+		">> .. .. .. >> \x1b[1;30mnumber\x1b[0m 3",
+		">> 1:10: Expect expression.",
+		">> Traceback (most recent call last):",
+		"  1:1 in <script>",
+		"1:1: boom",
+		">> add: function = <fn add>",
+		">> (1 + (2 * 3))",
+		">> Session reset.",
+		">> >> bye.",
+		"",
+	}
+	if got := out.String(); got != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected output:\n%s", got)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+	h, err := LoadHistory(file)
+	if err != nil {
+		t.Fatalf("load error: %s", err)
+	}
+	entries := []string{"print 1;", "fun f() {\n  print \"a\\n\";\n}", "print 1;", "print 1;"}
+	for _, entry := range entries {
+		if err := h.Add(entry); err != nil {
+			t.Fatalf("add error: %s", err)
+		}
+	}
+
+	h, err = LoadHistory(file)
+	if err != nil {
+		t.Fatalf("load error: %s", err)
+	}
+	expected := []string{"print 1;", "fun f() {\n  print \"a\\n\";\n}", "print 1;"}
+	got := h.Entries()
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d entries, got %q", len(expected), got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("entry [%d] is %q, want %q", i, got[i], expected[i])
+		}
+	}
+}
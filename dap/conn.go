@@ -0,0 +1,66 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// outgoing - ответ или событие; conn нумерует их перед отправкой
+type outgoing interface {
+	header() *protocolMessage
+}
+
+// conn читает и пишет сообщения с заголовком Content-Length, как требует DAP
+type conn struct {
+	r   *textproto.Reader
+	mu  sync.Mutex // сообщения пишутся целиком и по порядку номеров
+	w   io.Writer
+	seq int
+}
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{r: textproto.NewReader(bufio.NewReader(r)), w: w}
+}
+
+// read читает следующее сообщение в v
+func (c *conn) read(v interface{}) error {
+	header, err := c.r.ReadMIMEHeader()
+	if err != nil {
+		return err
+	}
+	length, err := strconv.Atoi(strings.TrimSpace(header.Get("Content-Length")))
+	if err != nil || length < 0 {
+		return fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r.R, body); err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+func (c *conn) write(msg outgoing) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	msg.header().Seq = c.seq
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = c.w.Write(body)
+	return err
+}
+
+func (c *conn) event(name string, body interface{}) error {
+	return c.write(&event{protocolMessage: protocolMessage{Type: "event"}, Event: name, Body: body})
+}
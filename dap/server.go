@@ -0,0 +1,423 @@
+// Package dap реализует адаптер отладки Strawberry по протоколу Debug Adapter Protocol.
+// Адаптер отлаживает одну программу с помощью пакета debugger: редактор запускает ее
+// запросом launch, ставит точки останова и управляет выполнением. Поток в программе один,
+// его номер - 1.
+package dap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Dor1ma/Strawberry/debugger"
+	"github.com/Dor1ma/Strawberry/errors"
+)
+
+const threadID = 1
+
+// Server - адаптер отладки, работающий с одним клиентом
+type Server struct {
+	conn *conn
+
+	d       *debugger.Debugger
+	program string // путь программы из launch
+
+	mu       sync.Mutex
+	running  bool          // программа выполняется, ее состояние смотреть нельзя
+	exited   bool          // программа завершилась или прервана
+	closing  bool          // сессия завершается, об остановках не сообщаем
+	done     chan struct{} // закрывается, когда программа остановилась или завершилась
+	vars     [][]debugger.Variable
+	after    func() // запустить программу после отправки ответа
+	shutdown bool
+}
+
+// handler обрабатывает запрос и возвращает тело ответа
+type handler func(s *Server, args json.RawMessage) (interface{}, error)
+
+var handlers = map[string]handler{
+	"initialize":        (*Server).initialize,
+	"launch":            (*Server).launch,
+	"setBreakpoints":    (*Server).setBreakpoints,
+	"configurationDone": (*Server).configurationDone,
+	"threads":           (*Server).threads,
+	"stackTrace":        (*Server).stackTrace,
+	"scopes":            (*Server).scopes,
+	"variables":         (*Server).variables,
+	"continue":          (*Server).continueRequest,
+	"next":              (*Server).next,
+	"stepIn":            (*Server).stepIn,
+	"stepOut":           (*Server).stepOut,
+	"pause":             (*Server).pause,
+	"evaluate":          (*Server).evaluate,
+	"disconnect":        (*Server).disconnect,
+}
+
+// конструктор; адаптер читает запросы из in и пишет ответы и события в out
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{conn: newConn(in, out)}
+}
+
+// Run обрабатывает запросы, пока клиент не пришлет disconnect или не закроет поток.
+// Незавершенная программа при выходе прерывается
+func (s *Server) Run() error {
+	defer s.stop()
+	for {
+		var req request
+		err := s.conn.read(&req)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if req.Type != "request" {
+			continue
+		}
+		if err := s.handle(&req); err != nil {
+			return err
+		}
+		if s.shutdown {
+			return nil
+		}
+	}
+}
+
+func (s *Server) handle(req *request) error {
+	resp := &response{
+		protocolMessage: protocolMessage{Type: "response"},
+		RequestSeq:      req.Seq,
+		Command:         req.Command,
+	}
+	var err error
+	if h, ok := handlers[req.Command]; ok {
+		resp.Body, err = h(s, req.Arguments)
+	} else {
+		err = fmt.Errorf("unknown command %q", req.Command)
+	}
+	if err != nil {
+		resp.Body, resp.Message = nil, err.Error()
+	} else {
+		resp.Success = true
+	}
+	if err := s.conn.write(resp); err != nil {
+		return err
+	}
+	if after := s.after; after != nil {
+		s.after = nil
+		after()
+	}
+	return nil
+}
+
+func (s *Server) initialize(args json.RawMessage) (interface{}, error) {
+	return Capabilities{
+		SupportsConfigurationDoneRequest: true,
+		SupportsConditionalBreakpoints:   true,
+		SupportsEvaluateForHovers:        true,
+	}, nil
+}
+
+// launch готовит программу к отладке. Точки останова клиент ставит после события initialized,
+// а выполнение начинается по configurationDone
+func (s *Server) launch(args json.RawMessage) (interface{}, error) {
+	var a LaunchArguments
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	if s.d != nil {
+		return nil, fmt.Errorf("a program is already launched")
+	}
+	if a.Program == "" {
+		return nil, fmt.Errorf("program is required")
+	}
+	src, err := os.ReadFile(a.Program)
+	if err != nil {
+		return nil, err
+	}
+	d, err := debugger.New(a.Program, src, debugger.Options{
+		Engine:      a.Engine,
+		Stdout:      &output{s.conn, "stdout"},
+		Stderr:      &output{s.conn, "stderr"},
+		Stdin:       strings.NewReader(""), // stdin занят протоколом
+		StopOnEntry: a.StopOnEntry,
+	})
+	if err != nil {
+		var buf bytes.Buffer
+		errors.PrintError(&buf, err)
+		return nil, fmt.Errorf("%s", strings.TrimSpace(buf.String()))
+	}
+	s.d, s.program = d, a.Program
+	s.after = func() { s.conn.event("initialized", nil) }
+	return nil, nil
+}
+
+func (s *Server) setBreakpoints(args json.RawMessage) (interface{}, error) {
+	var a SetBreakpointsArguments
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	if s.d == nil {
+		return nil, fmt.Errorf("no program launched")
+	}
+	body := SetBreakpointsResponseBody{Breakpoints: make([]Breakpoint, 0, len(a.Breakpoints))}
+	if filepath.Clean(a.Source.Path) != filepath.Clean(s.program) {
+		for _, sbp := range a.Breakpoints {
+			body.Breakpoints = append(body.Breakpoints,
+				Breakpoint{Line: sbp.Line, Message: "not the launched program"})
+		}
+		return body, nil
+	}
+	// запрос задает все точки останова файла заново
+	s.d.ClearBreakpoints()
+	for _, sbp := range a.Breakpoints {
+		bp, err := s.d.SetBreakpoint(sbp.Line, sbp.Condition)
+		if err != nil {
+			body.Breakpoints = append(body.Breakpoints, Breakpoint{Line: sbp.Line, Message: err.Error()})
+			continue
+		}
+		body.Breakpoints = append(body.Breakpoints,
+			Breakpoint{ID: bp.ID, Verified: true, Source: s.source(), Line: bp.Line})
+	}
+	return body, nil
+}
+
+func (s *Server) configurationDone(args json.RawMessage) (interface{}, error) {
+	if s.d == nil {
+		return nil, fmt.Errorf("no program launched")
+	}
+	return nil, s.resume(s.d.Start)
+}
+
+func (s *Server) threads(args json.RawMessage) (interface{}, error) {
+	return ThreadsResponseBody{Threads: []Thread{{ID: threadID, Name: "main"}}}, nil
+}
+
+func (s *Server) stackTrace(args json.RawMessage) (interface{}, error) {
+	if err := s.paused(); err != nil {
+		return nil, err
+	}
+	frames := s.d.Stack()
+	body := StackTraceResponseBody{StackFrames: make([]StackFrame, len(frames)), TotalFrames: len(frames)}
+	for i, frame := range frames {
+		body.StackFrames[i] = StackFrame{
+			ID:     i,
+			Name:   frame.Function,
+			Source: s.source(),
+			Line:   frame.Pos.Line,
+			Column: frame.Pos.Column,
+		}
+	}
+	return body, nil
+}
+
+// scopes возвращает окружения кадра. Отладчик видит окружения только текущего кадра,
+// у вызывающих кадров доступно лишь глобальное
+func (s *Server) scopes(args json.RawMessage) (interface{}, error) {
+	var a ScopesArguments
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	if err := s.paused(); err != nil {
+		return nil, err
+	}
+	scopes := s.d.Scopes()
+	if a.FrameID != 0 && len(scopes) > 0 {
+		scopes = scopes[len(scopes)-1:]
+	}
+	body := ScopesResponseBody{Scopes: make([]Scope, 0, len(scopes))}
+	for _, scope := range scopes {
+		s.vars = append(s.vars, scope.Variables)
+		body.Scopes = append(body.Scopes, Scope{
+			Name:               strings.ToUpper(scope.Name[:1]) + scope.Name[1:],
+			VariablesReference: len(s.vars),
+		})
+	}
+	return body, nil
+}
+
+func (s *Server) variables(args json.RawMessage) (interface{}, error) {
+	var a VariablesArguments
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	if err := s.paused(); err != nil {
+		return nil, err
+	}
+	if a.VariablesReference < 1 || a.VariablesReference > len(s.vars) {
+		return nil, fmt.Errorf("unknown variables reference %d", a.VariablesReference)
+	}
+	vars := s.vars[a.VariablesReference-1]
+	body := VariablesResponseBody{Variables: make([]Variable, len(vars))}
+	for i, v := range vars {
+		body.Variables[i] = Variable{Name: v.Name, Value: v.Value, Type: v.Type}
+	}
+	return body, nil
+}
+
+func (s *Server) continueRequest(args json.RawMessage) (interface{}, error) {
+	if err := s.paused(); err != nil {
+		return nil, err
+	}
+	return ContinueResponseBody{AllThreadsContinued: true}, s.resume(s.d.Continue)
+}
+
+func (s *Server) next(args json.RawMessage) (interface{}, error) {
+	if err := s.paused(); err != nil {
+		return nil, err
+	}
+	return nil, s.resume(s.d.StepOver)
+}
+
+func (s *Server) stepIn(args json.RawMessage) (interface{}, error) {
+	if err := s.paused(); err != nil {
+		return nil, err
+	}
+	return nil, s.resume(s.d.StepIn)
+}
+
+func (s *Server) stepOut(args json.RawMessage) (interface{}, error) {
+	if err := s.paused(); err != nil {
+		return nil, err
+	}
+	return nil, s.resume(s.d.StepOut)
+}
+
+// pause останавливает выполняющуюся программу; об остановке придет событие stopped
+func (s *Server) pause(args json.RawMessage) (interface{}, error) {
+	s.mu.Lock()
+	running := s.running
+	s.mu.Unlock()
+	if running {
+		s.d.Interrupt()
+	}
+	return nil, nil
+}
+
+// evaluate вычисляет выражение в текущем кадре, какой бы кадр ни указал клиент
+func (s *Server) evaluate(args json.RawMessage) (interface{}, error) {
+	var a EvaluateArguments
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	if err := s.paused(); err != nil {
+		return nil, err
+	}
+	v, err := s.d.Eval(a.Expression)
+	if err != nil {
+		return nil, err
+	}
+	return EvaluateResponseBody{Result: v.Value, Type: v.Type}, nil
+}
+
+func (s *Server) disconnect(args json.RawMessage) (interface{}, error) {
+	s.stop()
+	s.shutdown = true
+	return nil, nil
+}
+
+// resume продолжает программу методом proceed после отправки ответа. Об остановке
+// или завершении сообщается событием
+func (s *Server) resume(proceed func() debugger.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return fmt.Errorf("program is already running")
+	}
+	if s.exited {
+		return fmt.Errorf("program is not running")
+	}
+	s.running, s.vars = true, nil
+	done := make(chan struct{})
+	s.done = done
+	s.after = func() {
+		go func() {
+			defer close(done)
+			s.report(proceed())
+		}()
+	}
+	return nil
+}
+
+// report сообщает клиенту, чем закончилось продолжение программы
+func (s *Server) report(ev debugger.Event) {
+	s.mu.Lock()
+	s.running = false
+	s.exited = ev.Reason == debugger.StopExited
+	closing := s.closing
+	s.mu.Unlock()
+	if closing {
+		return
+	}
+
+	if ev.Reason != debugger.StopExited {
+		body := StoppedEventBody{Reason: string(ev.Reason), ThreadID: threadID, AllThreadsStopped: true}
+		if ev.Breakpoint != nil {
+			body.HitBreakpointIDs = []int{ev.Breakpoint.ID}
+		}
+		s.conn.event("stopped", body)
+		return
+	}
+	code := 0
+	if ev.Err != nil {
+		var buf bytes.Buffer
+		errors.PrintError(&buf, ev.Err)
+		s.conn.event("output", OutputEventBody{Category: "stderr", Output: buf.String()})
+		code = 1
+	}
+	s.conn.event("exited", ExitedEventBody{ExitCode: code})
+	s.conn.event("terminated", nil)
+}
+
+// stop прерывает программу, если она еще не завершилась
+func (s *Server) stop() {
+	if s.d == nil {
+		return
+	}
+	s.mu.Lock()
+	s.closing = true
+	running, done := s.running, s.done
+	s.mu.Unlock()
+	if running {
+		s.d.Interrupt()
+		<-done
+	}
+	s.d.Stop()
+	s.mu.Lock()
+	s.exited = true
+	s.mu.Unlock()
+}
+
+func (s *Server) paused() error {
+	if s.d == nil {
+		return fmt.Errorf("no program launched")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running || s.exited || !s.d.Paused() {
+		return fmt.Errorf("program is not paused")
+	}
+	return nil
+}
+
+func (s *Server) source() *Source {
+	return &Source{Name: filepath.Base(s.program), Path: s.program}
+}
+
+// output пересылает вывод программы клиенту событиями output
+type output struct {
+	conn     *conn
+	category string
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	if err := o.conn.event("output", OutputEventBody{Category: o.category, Output: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,95 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Сессии в testdata/*.session записаны построчно: "-> " - запрос клиента, "<- " - сообщение,
+// которое адаптер должен прислать следующим. Сообщения сравниваются как JSON, без учета
+// порядка полей. Пустые строки и строки с # пропускаются
+func TestSessions(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.session")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no sessions found: %v", err)
+	}
+	for _, file := range files {
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".session"), func(t *testing.T) {
+			replay(t, file)
+		})
+	}
+}
+
+func replay(t *testing.T, file string) {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := NewServer(serverIn, serverOut).Run()
+		serverOut.Close()
+		done <- err
+	}()
+	c := newConn(clientIn, clientOut)
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "-> "):
+			body := strings.TrimPrefix(line, "-> ")
+			if _, err := fmt.Fprintf(clientOut, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+				t.Fatalf("%s:%d: %s", file, n, err)
+			}
+		case strings.HasPrefix(line, "<- "):
+			var actual json.RawMessage
+			if err := c.read(&actual); err != nil {
+				t.Fatalf("%s:%d: %s", file, n, err)
+			}
+			if !sameJSON(t, []byte(strings.TrimPrefix(line, "<- ")), actual) {
+				t.Fatalf("%s:%d: unexpected message\nexpected: %s\nactual:   %s",
+					file, n, strings.TrimPrefix(line, "<- "), actual)
+			}
+		default:
+			t.Fatalf("%s:%d: unexpected line %q", file, n, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	clientOut.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("server: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+	}
+}
+
+func sameJSON(t *testing.T, expected, actual []byte) bool {
+	var e, a interface{}
+	if err := json.Unmarshal(expected, &e); err != nil {
+		t.Fatalf("invalid expected message: %s", err)
+	}
+	if err := json.Unmarshal(actual, &a); err != nil {
+		t.Fatalf("invalid message: %s", err)
+	}
+	return reflect.DeepEqual(e, a)
+}
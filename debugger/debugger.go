@@ -0,0 +1,438 @@
+// Package debugger - отладчик скриптов Strawberry. Он подключается к интерпретатору или VM через
+// их Hook, останавливает программу на точках останова и по шагам, показывает стек вызовов и
+// окружения и вычисляет выражения в остановленном кадре.
+//
+// Программа выполняется в отдельной горутине. Методы, которые ее продолжают (Start, Continue,
+// StepIn, StepOver, StepOut), ждут следующей остановки или завершения и возвращают Event.
+// Stack, Scopes и Eval можно вызывать, только пока программа стоит. Методы Debugger, кроме
+// Interrupt и методов точек останова, нельзя вызывать из нескольких горутин одновременно.
+package debugger
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Dor1ma/Strawberry/ast"
+	"github.com/Dor1ma/Strawberry/errors"
+	"github.com/Dor1ma/Strawberry/lexer"
+	"github.com/Dor1ma/Strawberry/parser"
+	"github.com/Dor1ma/Strawberry/resolver"
+	"github.com/Dor1ma/Strawberry/token"
+)
+
+// Options - настройки отладчика
+type Options struct {
+	Engine string // "interp" (по умолчанию) или "vm"
+
+	// потоки программы; nil - стандартные потоки процесса
+	Stdout io.Writer
+	Stderr io.Writer
+	Stdin  io.Reader
+
+	StopOnEntry bool // остановиться перед первым оператором
+}
+
+// Reason - причина остановки
+type Reason string
+
+const (
+	StopEntry      Reason = "entry"
+	StopBreakpoint Reason = "breakpoint"
+	StopStep       Reason = "step"
+	StopPause      Reason = "pause"  // по Interrupt
+	StopExited     Reason = "exited" // программа завершилась, Err - ее ошибка или nil
+)
+
+// Event - остановка программы или ее завершение
+type Event struct {
+	Reason     Reason
+	Pos        token.Position
+	Breakpoint *Breakpoint // точка останова, на которой остановилась программа
+	Err        error
+}
+
+// Breakpoint - точка останова на строке, возможно с условием
+type Breakpoint struct {
+	ID        int
+	Line      int    // строка оператора, на котором стоит точка
+	Condition string // выражение; пустое - останавливаться всегда
+	Hits      int    // сколько раз программа на ней остановилась
+
+	condition ast.Expression
+}
+
+// Variable - переменная окружения или результат вычисления
+type Variable struct {
+	Name  string
+	Type  string
+	Value string
+}
+
+// Scope - одно окружение из цепочки, от текущего к глобальному
+type Scope struct {
+	Name      string // "local", "enclosing" или "global"
+	Variables []Variable
+}
+
+// step - что делать после продолжения
+type step int
+
+const (
+	stepContinue step = iota
+	stepIn
+	stepOver
+	stepOut
+	stepAbort
+)
+
+// aborted - паника, которой Hook прерывает программу по Stop
+type aborted struct{}
+
+// engine - движок, который исполняет программу под отладчиком
+type engine interface {
+	run() error
+	stack() []errors.Frame // внешний кадр первым, последний - текущая позиция
+	scopes() []Scope
+	eval(expr ast.Expression) (v Variable, truthy bool, err error)
+}
+
+// Debugger управляет одной программой
+type Debugger struct {
+	engine engine
+	source []string
+	lines  []int // строки, на которых начинаются операторы, по возрастанию
+
+	mu          sync.Mutex          // точки останова можно менять, пока программа выполняется
+	breakpoints map[int]*Breakpoint // по строке
+	nextID      int
+
+	started bool
+	paused  bool
+	exited  *Event
+
+	mode  step
+	depth int  // глубина вызовов, на которой программу продолжили
+	entry bool // следующая остановка - первая, по StopOnEntry
+
+	interrupt atomic.Bool // остановиться перед следующим оператором
+
+	events chan Event
+	resume chan step
+}
+
+// New разбирает скрипт и готовит его к отладке. Синтаксические ошибки возвращаются как
+// parser.ErrorList, ошибки resolver - как resolver.ErrorList, ошибки компиляции - как *errors.RuntimeError
+func New(filename string, src []byte, opts Options) (*Debugger, error) {
+	statements, err := parser.New(lexer.NewFile(filename, string(src))).Parse()
+	if err != nil {
+		return nil, err
+	}
+	if err := resolver.Check(statements); err != nil {
+		return nil, err
+	}
+
+	d := &Debugger{
+		source:      strings.Split(string(src), "\n"),
+		lines:       statementLines(statements),
+		breakpoints: make(map[int]*Breakpoint),
+		events:      make(chan Event),
+		resume:      make(chan step),
+	}
+	if opts.StopOnEntry {
+		d.mode, d.entry = stepIn, true
+	}
+	switch opts.Engine {
+	case "", "interp":
+		d.engine = newInterpEngine(d, statements, opts)
+	case "vm":
+		d.engine, err = newVMEngine(d, statements, opts)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown engine %q", opts.Engine)
+	}
+	return d, nil
+}
+
+// statementLines собирает строки, на которых можно остановиться
+func statementLines(statements []ast.Statement) []int {
+	seen := make(map[int]bool)
+	for _, stmt := range statements {
+		ast.Inspect(stmt, func(node ast.Node) bool {
+			if _, ok := node.(ast.Statement); ok {
+				if _, block := node.(*ast.BlockStmt); !block {
+					seen[node.Pos().Line] = true
+				}
+			}
+			return true
+		})
+	}
+	lines := make([]int, 0, len(seen))
+	for line := range seen {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// Source возвращает строку исходника с номером line (с 1) или "", если такой нет
+func (d *Debugger) Source(line int) string {
+	if line < 1 || line > len(d.source) {
+		return ""
+	}
+	return strings.TrimRight(d.source[line-1], "\r")
+}
+
+// SetBreakpoint ставит точку останова на первый оператор, который начинается на строке line
+// или после нее. condition - выражение; пустое - останавливаться всегда
+func (d *Debugger) SetBreakpoint(line int, condition string) (*Breakpoint, error) {
+	i := sort.SearchInts(d.lines, line)
+	if i == len(d.lines) {
+		return nil, fmt.Errorf("no statement at or after line %d", line)
+	}
+	bp := &Breakpoint{Line: d.lines[i], Condition: condition}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if condition != "" {
+		expr, err := parser.ParseExpr(condition)
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition: %s", err)
+		}
+		bp.condition = expr
+	}
+	if old, ok := d.breakpoints[bp.Line]; ok {
+		bp.ID = old.ID
+	} else {
+		d.nextID++
+		bp.ID = d.nextID
+	}
+	d.breakpoints[bp.Line] = bp
+	return bp, nil
+}
+
+// ClearBreakpoint удаляет точку останова по номеру. Возвращает false, если ее нет
+func (d *Debugger) ClearBreakpoint(id int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for line, bp := range d.breakpoints {
+		if bp.ID == id {
+			delete(d.breakpoints, line)
+			return true
+		}
+	}
+	return false
+}
+
+// ClearBreakpoints удаляет все точки останова
+func (d *Debugger) ClearBreakpoints() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.breakpoints = make(map[int]*Breakpoint)
+}
+
+// Breakpoints возвращает точки останова по возрастанию строк
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	result := make([]*Breakpoint, 0, len(d.breakpoints))
+	for _, bp := range d.breakpoints {
+		result = append(result, bp)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Line < result[j].Line })
+	return result
+}
+
+// Start запускает программу и ждет первой остановки
+func (d *Debugger) Start() Event {
+	if d.started {
+		return d.Continue()
+	}
+	d.started = true
+	go func() {
+		err := d.run()
+		d.events <- Event{Reason: StopExited, Err: err}
+	}()
+	return d.wait()
+}
+
+func (d *Debugger) run() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(aborted); !ok {
+				panic(r)
+			}
+			err = nil
+		}
+	}()
+	return d.engine.run()
+}
+
+// Continue продолжает программу до точки останова или до конца
+func (d *Debugger) Continue() Event {
+	return d.proceed(stepContinue)
+}
+
+// StepIn выполняет программу до следующего оператора, заходя в вызовы
+func (d *Debugger) StepIn() Event {
+	return d.proceed(stepIn)
+}
+
+// StepOver выполняет программу до следующего оператора текущей функции
+func (d *Debugger) StepOver() Event {
+	return d.proceed(stepOver)
+}
+
+// StepOut выполняет программу до возврата из текущей функции
+func (d *Debugger) StepOut() Event {
+	return d.proceed(stepOut)
+}
+
+// Stop прерывает программу. После Stop программа считается завершенной без ошибки
+func (d *Debugger) Stop() Event {
+	if !d.started {
+		d.started = true
+		d.exited = &Event{Reason: StopExited}
+	}
+	return d.proceed(stepAbort)
+}
+
+func (d *Debugger) proceed(mode step) Event {
+	if !d.started {
+		d.mode = mode
+		return d.Start()
+	}
+	if d.exited != nil {
+		return *d.exited
+	}
+	d.paused = false
+	d.resume <- mode
+	return d.wait()
+}
+
+func (d *Debugger) wait() Event {
+	event := <-d.events
+	if event.Reason == StopExited {
+		d.exited = &event
+	} else {
+		d.paused = true
+	}
+	return event
+}
+
+// Interrupt просит остановить выполняющуюся программу перед следующим оператором; продолжающий
+// ее метод вернет Event с причиной StopPause. В отличие от остальных методов Interrupt можно
+// вызывать из любой горутины
+func (d *Debugger) Interrupt() {
+	d.interrupt.Store(true)
+}
+
+// Paused сообщает, что программа стоит и можно смотреть ее состояние
+func (d *Debugger) Paused() bool {
+	return d.paused
+}
+
+// Stack возвращает кадры стека вызовов остановленной программы, текущий кадр первым
+func (d *Debugger) Stack() []errors.Frame {
+	if !d.paused {
+		return nil
+	}
+	frames := d.engine.stack()
+	for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+		frames[i], frames[j] = frames[j], frames[i]
+	}
+	return frames
+}
+
+// Scopes возвращает окружения текущего кадра, от внутреннего к глобальному
+func (d *Debugger) Scopes() []Scope {
+	if !d.paused {
+		return nil
+	}
+	return d.engine.scopes()
+}
+
+// Eval вычисляет выражение в текущем кадре остановленной программы
+func (d *Debugger) Eval(src string) (Variable, error) {
+	if !d.paused {
+		return Variable{}, fmt.Errorf("program is not paused")
+	}
+	expr, err := parser.ParseExpr(src)
+	if err != nil {
+		return Variable{}, err
+	}
+	v, _, err := d.engine.eval(expr)
+	v.Name = src
+	return v, err
+}
+
+// statement вызывается движком перед каждым оператором; depth - число активных вызовов
+func (d *Debugger) statement(pos token.Position, depth int) {
+	if reason, bp := d.check(pos, depth); reason != "" {
+		d.pause(Event{Reason: reason, Pos: pos, Breakpoint: bp}, depth)
+	}
+}
+
+// returned вызывается движком после возврата из функции в место вызова pos. Шаг, который
+// вышел из функции, останавливается в вызывающей, даже если в ней не осталось операторов
+func (d *Debugger) returned(pos token.Position, depth int) {
+	if d.mode != stepContinue && depth < d.depth {
+		d.pause(Event{Reason: StopStep, Pos: pos}, depth)
+	}
+}
+
+// pause сообщает об остановке и ждет, как продолжить программу
+func (d *Debugger) pause(event Event, depth int) {
+	d.entry = false
+	d.events <- event
+	mode := <-d.resume
+	if mode == stepAbort {
+		panic(aborted{})
+	}
+	d.mode, d.depth = mode, depth
+}
+
+// check решает, нужно ли остановиться перед оператором в позиции pos
+func (d *Debugger) check(pos token.Position, depth int) (Reason, *Breakpoint) {
+	if d.interrupt.CompareAndSwap(true, false) {
+		return StopPause, nil
+	}
+	d.mu.Lock()
+	bp, ok := d.breakpoints[pos.Line]
+	d.mu.Unlock()
+	if ok && d.hit(bp) {
+		d.mu.Lock()
+		bp.Hits++
+		d.mu.Unlock()
+		return StopBreakpoint, bp
+	}
+	switch d.mode {
+	case stepIn:
+		if d.entry {
+			return StopEntry, nil
+		}
+		return StopStep, nil
+	case stepOver:
+		if depth <= d.depth {
+			return StopStep, nil
+		}
+	case stepOut:
+		if depth < d.depth {
+			return StopStep, nil
+		}
+	}
+	return "", nil
+}
+
+// hit вычисляет условие точки останова. Ошибка в условии тоже останавливает программу
+func (d *Debugger) hit(bp *Breakpoint) bool {
+	if bp.condition == nil {
+		return true
+	}
+	_, truthy, err := d.engine.eval(bp.condition)
+	return err != nil || truthy
+}
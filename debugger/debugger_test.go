@@ -0,0 +1,212 @@
+package debugger
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const script = `fun add(a, b) {
+    var sum = a + b;
+    return sum;
+}
+var total = 0;
+for (var i = 0; i < 3; i = i + 1) {
+    total = add(total, i);
+}
+print total;
+`
+
+func newDebugger(t *testing.T, engine string, stopOnEntry bool, out *bytes.Buffer) *Debugger {
+	d, err := New("test.berry", []byte(script), Options{Engine: engine, Stdout: out, StopOnEntry: stopOnEntry})
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	return d
+}
+
+func expectStop(t *testing.T, engine string, event Event, reason Reason, line int) {
+	t.Helper()
+	if event.Reason != reason || event.Pos.Line != line {
+		t.Fatalf("%s: expected %s at line %d, got %s at %s (err %v)", engine, reason, line, event.Reason, event.Pos, event.Err)
+	}
+}
+
+func TestBreakpoints(t *testing.T) {
+	for _, engine := range []string{"interp", "vm"} {
+		var out bytes.Buffer
+		d := newDebugger(t, engine, false, &out)
+		bp, err := d.SetBreakpoint(2, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		event := d.Start()
+		for i := 0; i < 3; i++ {
+			expectStop(t, engine, event, StopBreakpoint, 2)
+			if event.Breakpoint != bp {
+				t.Fatalf("%s: unexpected breakpoint %+v", engine, event.Breakpoint)
+			}
+
+			stack := d.Stack()
+			if len(stack) != 2 || stack[0].Function != "add" || stack[0].Pos.Line != 2 ||
+				stack[1].Function != "<script>" || stack[1].Pos.Line != 7 {
+				t.Fatalf("%s: unexpected stack %v", engine, stack)
+			}
+			scopes := d.Scopes()
+			if len(scopes) < 2 || scopes[0].Name != "local" || scopes[len(scopes)-1].Name != "global" {
+				t.Fatalf("%s: unexpected scopes %+v", engine, scopes)
+			}
+			if !hasVariable(scopes[0], "b", strconv.Itoa(i)) {
+				t.Fatalf("%s: expected b = %d in %+v", engine, i, scopes[0])
+			}
+			if !hasVariable(scopes[len(scopes)-1], "total", "") {
+				t.Fatalf("%s: expected global total in %+v", engine, scopes[len(scopes)-1])
+			}
+
+			v, err := d.Eval("a + b * 10")
+			if err != nil {
+				t.Fatalf("%s: eval: %s", engine, err)
+			}
+			if v.Type != "number" {
+				t.Fatalf("%s: unexpected type %q", engine, v.Type)
+			}
+			event = d.Continue()
+		}
+		if event.Reason != StopExited || event.Err != nil {
+			t.Fatalf("%s: expected normal exit, got %+v", engine, event)
+		}
+		if bp.Hits != 3 {
+			t.Fatalf("%s: expected 3 hits, got %d", engine, bp.Hits)
+		}
+		if out.String() != "3\n" {
+			t.Fatalf("unexpected output %q", out.String())
+		}
+	}
+}
+
+func TestConditionalBreakpoint(t *testing.T) {
+	for _, engine := range []string{"interp", "vm"} {
+		d := newDebugger(t, engine, false, &bytes.Buffer{})
+		// строка 4 - конец функции: точка переезжает на следующий оператор
+		bp, err := d.SetBreakpoint(4, "1 > 2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bp.Line != 5 {
+			t.Fatalf("expected breakpoint to move to line 5, got %d", bp.Line)
+		}
+		if _, err := d.SetBreakpoint(7, "i == 2"); err != nil {
+			t.Fatal(err)
+		}
+
+		event := d.Start()
+		expectStop(t, engine, event, StopBreakpoint, 7)
+		v, err := d.Eval("total")
+		if err != nil || v.Value != "1" {
+			t.Fatalf("%s: expected total = 1, got %+v, %v", engine, v, err)
+		}
+		if event := d.Continue(); event.Reason != StopExited {
+			t.Fatalf("%s: expected exit, got %+v", engine, event)
+		}
+	}
+}
+
+func TestStepping(t *testing.T) {
+	for _, engine := range []string{"interp", "vm"} {
+		d := newDebugger(t, engine, true, &bytes.Buffer{})
+		expectStop(t, engine, d.Start(), StopEntry, 1)
+		expectStop(t, engine, d.StepOver(), StopStep, 5)
+		expectStop(t, engine, d.StepOver(), StopStep, 6)
+		event := d.StepOver()
+		for event.Pos.Line == 6 {
+			event = d.StepOver()
+		}
+		expectStop(t, engine, event, StopStep, 7)
+		expectStop(t, engine, d.StepIn(), StopStep, 2)
+		if len(d.Stack()) != 2 {
+			t.Fatalf("%s: expected to be inside add, got %v", engine, d.Stack())
+		}
+		expectStop(t, engine, d.StepOver(), StopStep, 3)
+		event = d.StepOut()
+		if event.Reason != StopStep || len(d.Stack()) != 1 {
+			t.Fatalf("%s: expected to step out of add, got %+v in %v", engine, event, d.Stack())
+		}
+		v, err := d.Eval("total")
+		if err != nil || v.Value != "0" {
+			t.Fatalf("%s: expected total = 0 after the first call, got %+v, %v", engine, v, err)
+		}
+		if event := d.Stop(); event.Reason != StopExited || event.Err != nil {
+			t.Fatalf("%s: expected stop to end the program, got %+v", engine, event)
+		}
+	}
+}
+
+func TestEvalError(t *testing.T) {
+	d := newDebugger(t, "interp", true, &bytes.Buffer{})
+	d.Start()
+	if _, err := d.Eval("missing + 1"); err == nil {
+		t.Fatal("expected an error for an undefined variable")
+	}
+	if _, err := d.Eval("1 +"); err == nil {
+		t.Fatal("expected a syntax error")
+	}
+	// после ошибки программа продолжается как обычно
+	if event := d.Continue(); event.Reason != StopExited || event.Err != nil {
+		t.Fatalf("expected normal exit, got %+v", event)
+	}
+}
+
+func TestRuntimeError(t *testing.T) {
+	d, err := New("err.berry", []byte("var a = 1;\nprint a + nil;\n"), Options{Stdout: &bytes.Buffer{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := d.Start()
+	if event.Reason != StopExited || event.Err == nil || !strings.Contains(event.Err.Error(), "err.berry:2") {
+		t.Fatalf("expected runtime error at line 2, got %+v", event)
+	}
+}
+
+func TestTerminal(t *testing.T) {
+	var out, programOut bytes.Buffer
+	d := newDebugger(t, "interp", false, &programOut)
+	input := strings.Join([]string{
+		"break 2 if b == 1",
+		"run",
+		"bt",
+		"print a + b",
+		"vars",
+		"",
+		"c",
+		"quit",
+	}, "\n")
+	if err := NewTerminal(d, &out).Run(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"breakpoint 1 at line 2\n",
+		"breakpoint 1, test.berry:2:5\n>   2 |     var sum = a + b;\n",
+		"#0 test.berry:2:5 in add\n#1 test.berry:7:13 in <script>\n",
+		"1 (number)\n",
+		"local:\n  a = 0 (number)\n  b = 1 (number)\n",
+		"program exited\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("expected %q in output:\n%s", expected, out.String())
+		}
+	}
+	if programOut.String() != "3\n" {
+		t.Fatalf("unexpected program output %q", programOut.String())
+	}
+}
+
+func hasVariable(scope Scope, name, value string) bool {
+	for _, v := range scope.Variables {
+		if v.Name == name && (value == "" || v.Value == value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package debugger
+
+import (
+	"sort"
+
+	"github.com/Dor1ma/Strawberry/ast"
+	bytecodegen "github.com/Dor1ma/Strawberry/bytecode"
+	"github.com/Dor1ma/Strawberry/errors"
+	"github.com/Dor1ma/Strawberry/interpreter"
+	"github.com/Dor1ma/Strawberry/token"
+	"github.com/Dor1ma/Strawberry/valuer"
+	virtm "github.com/Dor1ma/Strawberry/vm"
+)
+
+// interpEngine выполняет программу интерпретатором
+type interpEngine struct {
+	d          *Debugger
+	interp     *interpreter.Interpreter
+	statements []ast.Statement
+	pos        token.Position // где стоит программа
+}
+
+func newInterpEngine(d *Debugger, statements []ast.Statement, opts Options) *interpEngine {
+	e := &interpEngine{
+		interp: interpreter.New(interpreter.Options{
+			Stdout: opts.Stdout,
+			Stderr: opts.Stderr,
+			Stdin:  opts.Stdin,
+		}),
+		statements: statements,
+		d:          d,
+	}
+	e.interp.SetHook(e)
+	return e
+}
+
+func (e *interpEngine) Statement(stmt ast.Statement) {
+	e.pos = stmt.Pos()
+	e.d.statement(e.pos, len(e.interp.CallStack()))
+}
+
+func (e *interpEngine) Return(pos token.Position) {
+	e.pos = pos
+	e.d.returned(pos, len(e.interp.CallStack()))
+}
+
+func (e *interpEngine) run() error {
+	return e.interp.Interpret(e.statements)
+}
+
+func (e *interpEngine) stack() []errors.Frame {
+	return errors.BuildFrames(e.interp.CallStack(), e.pos)
+}
+
+func (e *interpEngine) scopes() []Scope {
+	var scopes []Scope
+	for env := e.interp.Env(); env != nil; env = env.Enclosing {
+		scope := Scope{Name: scopeName(len(scopes), e.interp.IsGlobal(env))}
+		for _, name := range sortedNames(env.Values) {
+			v := env.Values[name]
+			if _, native := v.(*valuer.NativeFunction); native {
+				continue
+			}
+			scope.Variables = append(scope.Variables, interpVariable(name, v))
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes
+}
+
+func (e *interpEngine) eval(expr ast.Expression) (Variable, bool, error) {
+	v, err := e.interp.EvalFrame(expr)
+	if err != nil {
+		return Variable{}, false, err
+	}
+	return interpVariable("", v), interpreter.Truthy(v), nil
+}
+
+func interpVariable(name string, v valuer.Valuer) Variable {
+	if v == nil {
+		return Variable{Name: name, Type: "nil", Value: "nil"}
+	}
+	return Variable{Name: name, Type: v.Type().String(), Value: v.String()}
+}
+
+// vmEngine выполняет программу, скомпилированную в байт-код
+type vmEngine struct {
+	d   *Debugger
+	vm  *virtm.VirtualMachine
+	pos token.Position // где стоит программа
+}
+
+func newVMEngine(d *Debugger, statements []ast.Statement, opts Options) (*vmEngine, error) {
+	cg := &bytecodegen.CodeGenerator{}
+	if err := cg.GenerateProgram(statements); err != nil {
+		return nil, err
+	}
+	program, err := cg.Program()
+	if err != nil {
+		return nil, err
+	}
+	e := &vmEngine{d: d, vm: virtm.NewVirtualMachine(program)}
+	if opts.Stdout != nil {
+		e.vm.SetOutput(opts.Stdout)
+	}
+	if opts.Stdin != nil {
+		e.vm.SetInput(opts.Stdin)
+	}
+	e.vm.SetHook(e)
+	return e, nil
+}
+
+func (e *vmEngine) Statement(pos token.Position) {
+	e.pos = pos
+	e.d.statement(pos, len(e.vm.CallStack()))
+}
+
+func (e *vmEngine) Return(pos token.Position) {
+	e.pos = pos
+	e.d.returned(pos, len(e.vm.CallStack()))
+}
+
+func (e *vmEngine) run() error {
+	return e.vm.Run()
+}
+
+func (e *vmEngine) stack() []errors.Frame {
+	return errors.BuildFrames(e.vm.CallStack(), e.pos)
+}
+
+func (e *vmEngine) scopes() []Scope {
+	var scopes []Scope
+	for env := e.vm.Env(); env != nil; env = env.Enclosing() {
+		scope := Scope{Name: scopeName(len(scopes), env.Enclosing() == nil)}
+		for _, name := range env.Names() {
+			v, _ := env.Lookup(name)
+			scope.Variables = append(scope.Variables, e.variable(name, v))
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes
+}
+
+func (e *vmEngine) eval(expr ast.Expression) (Variable, bool, error) {
+	v, err := e.vm.Eval(expr)
+	if err != nil {
+		return Variable{}, false, err
+	}
+	return e.variable("", v), virtm.Truthy(v), nil
+}
+
+func (e *vmEngine) variable(name string, v virtm.StackValue) Variable {
+	return Variable{Name: name, Type: string(v.ValueType), Value: e.vm.Display(v)}
+}
+
+func scopeName(i int, global bool) string {
+	switch {
+	case global:
+		return "global"
+	case i == 0:
+		return "local"
+	}
+	return "enclosing"
+}
+
+func sortedNames(values map[string]valuer.Valuer) []string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
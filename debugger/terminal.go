@@ -0,0 +1,224 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Dor1ma/Strawberry/errors"
+)
+
+const terminalHelp = `Commands:
+  break LINE [if COND]  set a breakpoint (b)
+  delete [ID]           remove a breakpoint or all of them (d)
+  breakpoints           list breakpoints (bl)
+  run, continue         start or continue the program (r, c)
+  step                  step into calls (s)
+  next                  step over calls (n)
+  finish                step out of the current function (f)
+  stack                 print the call stack (bt)
+  vars                  print variables of the current frame (v)
+  print EXPR            evaluate an expression in the current frame (p)
+  list                  show source around the current line (l)
+  quit                  stop the program and exit (q)
+An empty line repeats the previous command.`
+
+// Terminal - текстовый интерфейс отладчика в духе gdb
+type Terminal struct {
+	d   *Debugger
+	out io.Writer
+	pos int // строка, на которой стоит программа, 0 - не запущена
+}
+
+// NewTerminal создает интерфейс, который пишет в out
+func NewTerminal(d *Debugger, out io.Writer) *Terminal {
+	return &Terminal{d: d, out: out}
+}
+
+// Run читает команды из in, пока не встретит quit или конец ввода.
+// Незавершенная программа при выходе прерывается
+func (t *Terminal) Run(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	last := ""
+	for {
+		fmt.Fprint(t.out, "(debug) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(t.out)
+			break
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			line = last
+		}
+		if line == "" {
+			continue
+		}
+		last = line
+		if !t.Execute(line) {
+			break
+		}
+	}
+	t.d.Stop()
+	return scanner.Err()
+}
+
+// Execute выполняет одну команду. Возвращает false для quit
+func (t *Terminal) Execute(line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case "break", "b":
+		t.setBreakpoint(arg)
+	case "delete", "d":
+		t.deleteBreakpoint(arg)
+	case "breakpoints", "bl":
+		for _, bp := range t.d.Breakpoints() {
+			fmt.Fprintf(t.out, "%d: line %d", bp.ID, bp.Line)
+			if bp.Condition != "" {
+				fmt.Fprintf(t.out, " if %s", bp.Condition)
+			}
+			fmt.Fprintf(t.out, " (hits %d)\n", bp.Hits)
+		}
+	case "run", "r", "continue", "c":
+		t.show(t.d.Continue())
+	case "step", "s":
+		t.show(t.d.StepIn())
+	case "next", "n":
+		t.show(t.d.StepOver())
+	case "finish", "f":
+		t.show(t.d.StepOut())
+	case "stack", "bt":
+		t.stack()
+	case "vars", "v":
+		t.vars()
+	case "print", "p":
+		t.print(arg)
+	case "list", "l":
+		t.list()
+	case "quit", "q":
+		return false
+	case "help", "h":
+		fmt.Fprintln(t.out, terminalHelp)
+	default:
+		fmt.Fprintf(t.out, "unknown command %q, type help for help\n", name)
+	}
+	return true
+}
+
+func (t *Terminal) setBreakpoint(arg string) {
+	lineArg, condition, _ := strings.Cut(arg, " if ")
+	line, err := strconv.Atoi(strings.TrimSpace(lineArg))
+	if err != nil {
+		fmt.Fprintln(t.out, "usage: break LINE [if COND]")
+		return
+	}
+	bp, err := t.d.SetBreakpoint(line, strings.TrimSpace(condition))
+	if err != nil {
+		fmt.Fprintln(t.out, err)
+		return
+	}
+	fmt.Fprintf(t.out, "breakpoint %d at line %d\n", bp.ID, bp.Line)
+}
+
+func (t *Terminal) deleteBreakpoint(arg string) {
+	if arg == "" {
+		t.d.ClearBreakpoints()
+		return
+	}
+	id, err := strconv.Atoi(arg)
+	if err != nil || !t.d.ClearBreakpoint(id) {
+		fmt.Fprintf(t.out, "no breakpoint %s\n", arg)
+	}
+}
+
+// show печатает, где остановилась программа
+func (t *Terminal) show(event Event) {
+	if event.Reason == StopExited {
+		if t.pos < 0 {
+			fmt.Fprintln(t.out, "the program is not running")
+			return
+		}
+		t.pos = -1
+		if event.Err != nil {
+			errors.PrintError(t.out, event.Err)
+		}
+		fmt.Fprintln(t.out, "program exited")
+		return
+	}
+	t.pos = event.Pos.Line
+	if event.Breakpoint != nil {
+		fmt.Fprintf(t.out, "breakpoint %d, ", event.Breakpoint.ID)
+	}
+	fmt.Fprintf(t.out, "%s\n", event.Pos)
+	t.source(t.pos)
+}
+
+func (t *Terminal) source(line int) {
+	marker := " "
+	if line == t.pos {
+		marker = ">"
+	}
+	fmt.Fprintf(t.out, "%s%4d | %s\n", marker, line, t.d.Source(line))
+}
+
+func (t *Terminal) stack() {
+	if !t.paused() {
+		return
+	}
+	for i, frame := range t.d.Stack() {
+		fmt.Fprintf(t.out, "#%d %s\n", i, frame)
+	}
+}
+
+func (t *Terminal) vars() {
+	if !t.paused() {
+		return
+	}
+	for _, scope := range t.d.Scopes() {
+		if len(scope.Variables) == 0 {
+			continue
+		}
+		fmt.Fprintf(t.out, "%s:\n", scope.Name)
+		for _, v := range scope.Variables {
+			fmt.Fprintf(t.out, "  %s = %s (%s)\n", v.Name, v.Value, v.Type)
+		}
+	}
+}
+
+func (t *Terminal) print(arg string) {
+	if arg == "" {
+		fmt.Fprintln(t.out, "usage: print EXPR")
+		return
+	}
+	if !t.paused() {
+		return
+	}
+	v, err := t.d.Eval(arg)
+	if err != nil {
+		fmt.Fprintln(t.out, err)
+		return
+	}
+	fmt.Fprintf(t.out, "%s (%s)\n", v.Value, v.Type)
+}
+
+func (t *Terminal) list() {
+	center := t.pos
+	if center <= 0 {
+		center = 1
+	}
+	for line := center - 5; line <= center+5; line++ {
+		if line >= 1 && line <= len(t.d.source) {
+			t.source(line)
+		}
+	}
+}
+
+func (t *Terminal) paused() bool {
+	if !t.d.Paused() {
+		fmt.Fprintln(t.out, "the program is not paused")
+		return false
+	}
+	return true
+}
@@ -7,13 +7,40 @@ import (
 type RuntimeError struct {
 	s     string
 	token token.Token
+	pos   token.Position
+
+	frames []Frame     // стек вызовов, заполняется движком при перехвате ошибки
+	value  interface{} // значение из throw, nil для встроенных ошибок
 }
 
 func (r *RuntimeError) Error() string {
+	if r.pos.IsValid() {
+		return r.pos.String() + ": " + r.s
+	}
+	return r.s
+}
+
+// Pos возвращает позицию в исходном тексте, где произошла ошибка
+func (r *RuntimeError) Pos() token.Position {
+	return r.pos
+}
+
+// Msg возвращает текст ошибки без позиции
+func (r *RuntimeError) Msg() string {
 	return r.s
 }
 
+// Thrown возвращает значение, переданное в throw, или nil для встроенной ошибки
+func (r *RuntimeError) Thrown() interface{} {
+	return r.value
+}
+
+// Throw бросает значение пользователя; s - текст, который увидят, если исключение не перехватят
+func Throw(pos token.Position, value interface{}, s string) {
+	panic(RuntimeError{token: token.Throw, s: s, pos: pos, value: value})
+}
+
 // Класс для возврата ошибок в рантайме
-func Error(token token.Token, s string) {
-	panic(RuntimeError{token: token, s: s})
+func Error(pos token.Position, tok token.Token, s string) {
+	panic(RuntimeError{token: tok, s: s, pos: pos})
 }
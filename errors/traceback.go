@@ -0,0 +1,76 @@
+package errors
+
+import (
+	"fmt"
+	"github.com/Dor1ma/Strawberry/token"
+	"io"
+	"strings"
+)
+
+// ScriptFrame - имя кадра для кода верхнего уровня
+const ScriptFrame = "<script>"
+
+// Frame - кадр стека вызовов в момент ошибки
+type Frame struct {
+	Function string         // имя функции, ScriptFrame для верхнего уровня
+	Pos      token.Position // какое место функции выполнялось
+}
+
+func (f Frame) String() string {
+	return f.Pos.String() + " in " + f.Function
+}
+
+// CallFrame - активный вызов функции, который движок кладет в свой стек при вызове
+type CallFrame struct {
+	Function string         // имя вызванной функции
+	Call     token.Position // позиция вызова
+}
+
+// BuildFrames собирает кадры трассировки из стека вызовов (внешний вызов первым)
+// и позиции, где произошла ошибка
+func BuildFrames(calls []CallFrame, pos token.Position) []Frame {
+	frames := make([]Frame, 0, len(calls)+1)
+	function := ScriptFrame
+	for _, call := range calls {
+		frames = append(frames, Frame{Function: function, Pos: call.Call})
+		function = call.Function
+	}
+	return append(frames, Frame{Function: function, Pos: pos})
+}
+
+// WithFrames возвращает копию ошибки с трассировкой стека. Уже заполненная трассировка не меняется.
+func (r RuntimeError) WithFrames(frames []Frame) RuntimeError {
+	if r.frames == nil {
+		r.frames = frames
+	}
+	return r
+}
+
+// Frames возвращает стек вызовов в момент ошибки, самый последний вызов - в конце
+func (r *RuntimeError) Frames() []Frame {
+	return r.frames
+}
+
+// Traceback форматирует ошибку вместе со стеком вызовов в стиле Python
+func (r *RuntimeError) Traceback() string {
+	var sb strings.Builder
+	if len(r.frames) > 0 {
+		sb.WriteString("Traceback (most recent call last):\n")
+		for _, frame := range r.frames {
+			sb.WriteString("  ")
+			sb.WriteString(frame.String())
+			sb.WriteString("\n")
+		}
+	}
+	sb.WriteString(r.Error())
+	return sb.String()
+}
+
+// PrintError печатает ошибку в w; для ошибки времени выполнения - вместе со стеком вызовов
+func PrintError(w io.Writer, err error) {
+	if runErr, ok := err.(*RuntimeError); ok {
+		fmt.Fprintln(w, runErr.Traceback())
+	} else if err != nil {
+		fmt.Fprintln(w, err)
+	}
+}
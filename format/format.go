@@ -0,0 +1,83 @@
+// Package format печатает программы на Strawberry в едином стиле: отступ - 4 пробела,
+// открывающая фигурная скобка на строке оператора, пробелы вокруг бинарных операторов,
+// не больше одной пустой строки подряд. Комментарии сохраняются
+package format
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/Dor1ma/Strawberry/ast"
+	"github.com/Dor1ma/Strawberry/lexer"
+	"github.com/Dor1ma/Strawberry/parser"
+	"github.com/Dor1ma/Strawberry/token"
+)
+
+// Source форматирует исходный текст. Текст с синтаксическими ошибками не форматируется,
+// возвращается parser.ErrorList. Результат Source не меняется при повторном форматировании
+func Source(filename string, src []byte) ([]byte, error) {
+	statements, err := parser.New(lexer.NewFile(filename, string(src))).Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &printer{src: src, comments: scanComments(filename, src)}
+	p.statements(statements, token.Position{Offset: len(src)})
+	out := bytes.TrimLeft(p.buf.Bytes(), "\n")
+	if len(out) == 0 {
+		return out, nil
+	}
+	return append(bytes.TrimRight(out, "\n"), '\n'), nil
+}
+
+// Node печатает узел без комментариев. Строки печатаются заново по их значению,
+// поэтому исходная запись экранирования не сохраняется
+func Node(w io.Writer, node ast.Node) error {
+	p := &printer{}
+	switch n := node.(type) {
+	case ast.Statement:
+		p.stmt(n)
+	case ast.Expression:
+		p.expr(n)
+	}
+	_, err := w.Write(p.buf.Bytes())
+	return err
+}
+
+// IsFormatted сообщает, что текст уже отформатирован
+func IsFormatted(filename string, src []byte) (bool, error) {
+	formatted, err := Source(filename, src)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(formatted, src), nil
+}
+
+// comment - комментарий исходного текста
+type comment struct {
+	span token.Span
+	text string
+}
+
+// line сообщает, что комментарий однострочный (//), после него код на той же строке продолжить нельзя
+func (c comment) line() bool {
+	return len(c.text) >= 2 && c.text[:2] == "//"
+}
+
+func scanComments(filename string, src []byte) []comment {
+	l := lexer.NewFile(filename, string(src))
+	l.SetMode(lexer.ScanComments)
+	l.SetErrorHandler(func(token.Position, string) {})
+
+	var comments []comment
+	for {
+		tok, _, span := l.NextToken()
+		if tok == token.EOF {
+			return comments
+		}
+		if tok == token.Comment {
+			text := string(bytes.TrimRight(src[span.From.Offset:span.To.Offset], " \t\r\n"))
+			comments = append(comments, comment{span: span, text: text})
+		}
+	}
+}
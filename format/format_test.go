@@ -0,0 +1,156 @@
+package format
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Dor1ma/Strawberry/lexer"
+	"github.com/Dor1ma/Strawberry/parser"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"var  a=1+2*3 ;", "var a = 1 + 2 * 3;\n"},
+		{"print !true and (false or nil);", "print !true and (false or nil);\n"},
+		{"a.b = c = -3; print f(1,2)[0].x;", "a.b = c = -3;\nprint f(1, 2)[0].x;\n"},
+		{"fun f(x,y){return x;}", "fun f(x, y) {\n    return x;\n}\n"},
+		{"fun f(){}", "fun f() {}\n"},
+		{"if(a)print 1;else print 2;", "if (a) print 1;\nelse print 2;\n"},
+		{"if(a){print 1;}else if(b){print 2;}else{print 3;}",
+			"if (a) {\n    print 1;\n} else if (b) {\n    print 2;\n} else {\n    print 3;\n}\n"},
+		{"while(i<3){i=i+1;}", "while (i < 3) {\n    i = i + 1;\n}\n"},
+		{"for(var i=0;i<3;i=i+1)print i;", "for (var i = 0; i < 3; i = i + 1) print i;\n"},
+		{"for(;;){break;}", "for (;;) {\n    break;\n}\n"},
+		{"for(i=0;i<3;){continue;}", "for (i = 0; i < 3;) {\n    continue;\n}\n"},
+		{"class B<A{init(x){this.x=x;} get(){return super.get();}}",
+			"class B < A {\n    init(x) {\n        this.x = x;\n    }\n    get() {\n        return super.get();\n    }\n}\n"},
+		{"try{throw \"x\\\"y\";}catch(e){print e;}finally{}",
+			"try {\n    throw \"x\\\"y\";\n} catch (e) {\n    print e;\n} finally {}\n"},
+		{"var g=fun(a){return a;}; var h=(a,b)=>a*b;",
+			"var g = fun (a) {\n    return a;\n};\nvar h = (a, b) => a * b;\n"},
+		{"var m={\"a\":1,\"b\":[1,2]};", "var m = {\"a\": 1, \"b\": [1, 2]};\n"},
+		{"var m = {\"a\": 1,\n\"b\": 2};", "var m = {\n    \"a\": 1,\n    \"b\": 2\n};\n"},
+		{"print 1;\n\n\n\nprint 2;", "print 1;\n\nprint 2;\n"},
+		{"", ""},
+	}
+
+	for i, test := range tests {
+		got, err := Source("test.berry", []byte(test.input))
+		if err != nil {
+			t.Fatalf("test [%d] failed. format error: %s", i, err)
+		}
+		if string(got) != test.expected {
+			t.Fatalf("test [%d] failed. expected:\n%s\ngot:\n%s", i, test.expected, got)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// header
+
+
+var a = 1;   // trailing
+var m = {"a": 1,
+  "b": 2, // second
+  // before c
+  "c": 3
+};
+fun f() {
+  // only comment
+}
+class C {
+  init() {} /* after init */
+
+  /* doc */
+  get() { return 1; }
+}
+if (x) { // on brace
+  print 1;
+}
+/* nested /* comment */ */
+`
+	expected := `// header
+
+var a = 1; // trailing
+var m = {
+    "a": 1,
+    "b": 2, // second
+    // before c
+    "c": 3
+};
+fun f() {
+    // only comment
+}
+class C {
+    init() {} /* after init */
+
+    /* doc */
+    get() {
+        return 1;
+    }
+}
+if (x) {
+    // on brace
+    print 1;
+}
+/* nested /* comment */ */
+`
+	got, err := Source("test.berry", []byte(input))
+	if err != nil {
+		t.Fatalf("format error: %s", err)
+	}
+	if string(got) != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	files, _ := filepath.Glob("../example/*.berry")
+	tasks, _ := filepath.Glob("../tasks/*.berry")
+	files = append(files, tasks...)
+	if len(files) == 0 {
+		t.Fatal("no scripts found")
+	}
+
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		once, err := Source(file, src)
+		if err != nil {
+			t.Fatalf("%s: format error: %s", file, err)
+		}
+		if ok, err := IsFormatted(file, once); err != nil || !ok {
+			twice, _ := Source(file, once)
+			t.Fatalf("%s: formatting is not idempotent:\n%s\nthen:\n%s", file, once, twice)
+		}
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	_, err := Source("test.berry", []byte("print 1 +;"))
+	if _, ok := err.(parser.ErrorList); !ok {
+		t.Fatalf("expected parser.ErrorList, got %v", err)
+	}
+}
+
+func TestNode(t *testing.T) {
+	statements, err := parser.New(lexer.New(`if (a) { print "x\"y"; } else print nil;`)).Parse()
+	if err != nil {
+		t.Fatalf("parse error: %s", err)
+	}
+	var buf bytes.Buffer
+	if err := Node(&buf, statements[0]); err != nil {
+		t.Fatalf("format error: %s", err)
+	}
+	expected := "if (a) {\n    print \"x\\\"y\";\n} else print nil;"
+	if buf.String() != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
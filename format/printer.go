@@ -0,0 +1,499 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/Dor1ma/Strawberry/ast"
+	"github.com/Dor1ma/Strawberry/token"
+)
+
+const indentation = "    "
+
+type printer struct {
+	src      []byte // исходный текст; nil, если печатается узел без исходника
+	buf      bytes.Buffer
+	indent   int
+	newLine  bool // следующая запись начинается с отступа
+	comments []comment
+	next     int  // первый еще не напечатанный комментарий
+	line     int  // строка исходника, на которой закончился последний напечатанный элемент
+	first    bool // следующий элемент - первый в блоке, пустая строка перед ним не нужна
+}
+
+func (p *printer) write(s string) {
+	if p.newLine {
+		p.buf.WriteString(strings.Repeat(indentation, p.indent))
+		p.newLine = false
+	}
+	p.buf.WriteString(s)
+}
+
+func (p *printer) newline() {
+	p.buf.WriteByte('\n')
+	p.newLine = true
+}
+
+// separate оставляет одну пустую строку перед элементом, если в исходнике перед ним была хотя бы одна
+func (p *printer) separate(line int) {
+	if !p.first && line > p.line+1 {
+		p.newline()
+	}
+	p.first = false
+}
+
+// leading печатает комментарии, которые начинаются до pos, каждый на своей строке
+func (p *printer) leading(pos token.Position) {
+	for p.next < len(p.comments) && p.comments[p.next].span.From.Offset < pos.Offset {
+		c := p.comments[p.next]
+		p.next++
+		p.separate(c.span.From.Line)
+		p.write(c.text)
+		p.newline()
+		if c.span.To.Line > p.line {
+			p.line = c.span.To.Line
+		}
+	}
+}
+
+// trailing печатает после оператора комментарии, которые остались внутри него или идут за ним
+// на той же строке. Код после однострочного комментария продолжить нельзя, поэтому следующий
+// комментарий переносится на новую строку
+func (p *printer) trailing(end token.Position) {
+	afterLine := false
+	for p.next < len(p.comments) {
+		c := p.comments[p.next]
+		if c.span.From.Offset >= end.Offset && c.span.From.Line != end.Line {
+			break
+		}
+		p.next++
+		if afterLine {
+			p.newline()
+		} else {
+			p.write(" ")
+		}
+		p.write(c.text)
+		afterLine = c.line()
+		if c.span.To.Line > end.Line {
+			end.Line = c.span.To.Line
+		}
+	}
+	if end.Line > p.line {
+		p.line = end.Line
+	}
+}
+
+// hasComments сообщает, что до end остались ненапечатанные комментарии
+func (p *printer) hasComments(end token.Position) bool {
+	return p.src != nil && p.next < len(p.comments) && p.comments[p.next].span.From.Offset < end.Offset
+}
+
+// statements печатает операторы блока, каждый на своей строке, и комментарии до end
+func (p *printer) statements(statements []ast.Statement, end token.Position) {
+	p.first = true
+	for _, stmt := range statements {
+		if p.src != nil {
+			p.leading(stmt.Pos())
+			p.separate(stmt.Pos().Line)
+		}
+		p.stmt(stmt)
+		if p.src != nil {
+			p.trailing(stmt.End())
+		}
+		p.newline()
+	}
+	if p.src != nil {
+		p.leading(end)
+	}
+	p.first = false
+}
+
+// block печатает тело в фигурных скобках. Открывающая скобка - первая после from,
+// end - позиция за закрывающей скобкой
+func (p *printer) block(from token.Position, statements []ast.Statement, end token.Position) {
+	if len(statements) == 0 && !p.hasComments(end) {
+		p.write("{}")
+		return
+	}
+	p.open(from)
+	p.statements(statements, end)
+	p.indent--
+	p.write("}")
+}
+
+// open печатает открывающую скобку блока, первую в исходнике после from. Пустые строки
+// в блоке считаются от строки со скобкой, а не от комментария перед ней
+func (p *printer) open(from token.Position) {
+	p.write("{")
+	p.newline()
+	p.indent++
+	if p.src == nil {
+		return
+	}
+	if i := bytes.IndexByte(p.src[from.Offset:], '{'); i >= 0 {
+		if line := from.Line + bytes.Count(p.src[from.Offset:from.Offset+i], []byte("\n")); line > p.line {
+			p.line = line
+		}
+	}
+}
+
+// body печатает тело if, while и for: блок или оператор на той же строке
+func (p *printer) body(stmt ast.Statement) {
+	if block, ok := stmt.(*ast.BlockStmt); ok && !p.isFor(block) {
+		p.block(block.Pos(), block.Statements, block.End())
+		return
+	}
+	p.stmt(stmt)
+}
+
+// isFor сообщает, что узел - цикл for, который парсер развернул в while
+func (p *printer) isFor(node ast.Node) bool {
+	if p.src == nil || node.Pos().Offset+3 > len(p.src) {
+		return false
+	}
+	rest := p.src[node.Pos().Offset:]
+	if !bytes.HasPrefix(rest, []byte("for")) {
+		return false
+	}
+	return len(rest) == 3 || !isIdentChar(rune(rest[3]))
+}
+
+func isIdentChar(ch rune) bool {
+	return unicode.IsLetter(ch) || unicode.IsDigit(ch) || ch == '_'
+}
+
+func (p *printer) stmt(stmt ast.Statement) {
+	switch s := stmt.(type) {
+	case *ast.BlockStmt:
+		if loop, ok := p.forLoop(s); ok {
+			p.forStmt(s.Statements[0], loop)
+			return
+		}
+		p.block(s.Pos(), s.Statements, s.End())
+	case *ast.BreakStmt:
+		p.write("break;")
+	case *ast.ContinueStmt:
+		p.write("continue;")
+	case *ast.ClassStmt:
+		p.classStmt(s)
+	case *ast.ExprStmt:
+		p.expr(s.Expression)
+		p.write(";")
+	case *ast.FunctionStmt:
+		p.write("fun ")
+		p.function(s.Pos(), s.Name, s.Params, s.Body, s.End())
+	case *ast.IfStmt:
+		p.ifStmt(s)
+	case *ast.PrintStmt:
+		p.write("print ")
+		p.expr(s.Expression)
+		p.write(";")
+	case *ast.ReturnStmt:
+		if s.Value == nil {
+			p.write("return;")
+			return
+		}
+		p.write("return ")
+		p.expr(s.Value)
+		p.write(";")
+	case *ast.ThrowStmt:
+		p.write("throw ")
+		p.expr(s.Value)
+		p.write(";")
+	case *ast.TryStmt:
+		p.write("try ")
+		p.block(s.Body.Pos(), s.Body.Statements, s.Body.End())
+		if s.CatchBody != nil {
+			p.write(" catch (" + s.CatchName.Name + ") ")
+			p.block(s.CatchBody.Pos(), s.CatchBody.Statements, s.CatchBody.End())
+		}
+		if s.FinallyBody != nil {
+			p.write(" finally ")
+			p.block(s.FinallyBody.Pos(), s.FinallyBody.Statements, s.FinallyBody.End())
+		}
+	case *ast.VarStmt:
+		p.write("var " + s.Name.Name)
+		if s.Initializer != nil {
+			p.write(" = ")
+			p.expr(s.Initializer)
+		}
+		p.write(";")
+	case *ast.WhileStmt:
+		if p.isFor(s) {
+			p.forStmt(nil, s)
+			return
+		}
+		p.write("while (")
+		p.expr(s.Condition)
+		p.write(") ")
+		p.body(s.Body)
+	default:
+		panic(fmt.Sprintf("format: unexpected statement %T", stmt))
+	}
+}
+
+// forLoop распознает блок, в который парсер разворачивает for с инициализатором
+func (p *printer) forLoop(block *ast.BlockStmt) (*ast.WhileStmt, bool) {
+	if !p.isFor(block) || len(block.Statements) != 2 {
+		return nil, false
+	}
+	loop, ok := block.Statements[1].(*ast.WhileStmt)
+	return loop, ok
+}
+
+func (p *printer) forStmt(initializer ast.Statement, loop *ast.WhileStmt) {
+	p.write("for (")
+	if initializer != nil {
+		p.stmt(initializer)
+	} else {
+		p.write(";")
+	}
+	// условие, которого не было в исходнике, парсер заменяет на true с позицией всего цикла
+	if lit, ok := loop.Condition.(*ast.Literal); !ok || lit.Span != loop.Span {
+		p.write(" ")
+		p.expr(loop.Condition)
+	}
+	p.write(";")
+	if loop.Increment != nil {
+		p.write(" ")
+		p.expr(loop.Increment)
+	}
+	p.write(") ")
+	p.body(loop.Body)
+}
+
+func (p *printer) ifStmt(s *ast.IfStmt) {
+	p.write("if (")
+	p.expr(s.Condition)
+	p.write(") ")
+	p.body(s.ThenBranch)
+	if s.ElseBranch == nil {
+		return
+	}
+	if block, ok := s.ThenBranch.(*ast.BlockStmt); ok && !p.isFor(block) {
+		p.write(" else ")
+	} else {
+		p.newline()
+		p.write("else ")
+	}
+	if elseIf, ok := s.ElseBranch.(*ast.IfStmt); ok {
+		p.ifStmt(elseIf)
+		return
+	}
+	p.body(s.ElseBranch)
+}
+
+func (p *printer) classStmt(s *ast.ClassStmt) {
+	p.write("class " + s.Name)
+	if s.SuperClass != nil {
+		p.write(" < " + s.SuperClass.Name)
+	}
+	p.write(" ")
+	if len(s.Methods) == 0 && !p.hasComments(s.End()) {
+		p.write("{}")
+		return
+	}
+	p.open(s.Pos())
+	p.first = true
+	for _, method := range s.Methods {
+		if p.src != nil {
+			p.leading(method.Pos())
+			p.separate(method.Pos().Line)
+		}
+		p.function(method.Pos(), method.Name, method.Params, method.Body, method.End())
+		if p.src != nil {
+			p.trailing(method.End())
+		}
+		p.newline()
+	}
+	if p.src != nil {
+		p.leading(s.End())
+	}
+	p.first = false
+	p.indent--
+	p.write("}")
+}
+
+// function печатает имя, параметры и тело функции или метода, которая начинается в from
+func (p *printer) function(from token.Position, name string, params []*ast.Identifier, body []ast.Statement, end token.Position) {
+	p.write(name + "(")
+	p.params(params)
+	p.write(") ")
+	p.block(from, body, end)
+}
+
+func (p *printer) params(params []*ast.Identifier) {
+	for i, param := range params {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.write(param.Name)
+	}
+}
+
+func (p *printer) expr(expr ast.Expression) {
+	switch e := expr.(type) {
+	case *ast.Literal:
+		p.literal(e)
+	case *ast.VariableExpr:
+		p.write(e.Name)
+	case *ast.ThisExpr:
+		p.write("this")
+	case *ast.SuperExpr:
+		p.write("super." + e.Method)
+	case *ast.AssignExpr:
+		p.expr(e.Left)
+		p.write(" = ")
+		p.expr(e.Value)
+	case *ast.SetExpr:
+		p.expr(e.Object)
+		p.write("." + e.Name + " = ")
+		p.expr(e.Value)
+	case *ast.BinaryExpr:
+		p.expr(e.Left)
+		p.write(" " + e.Operator.String() + " ")
+		p.expr(e.Right)
+	case *ast.LogicalExpr:
+		p.expr(e.Left)
+		p.write(" " + e.Operator.String() + " ")
+		p.expr(e.Right)
+	case *ast.UnaryExpr:
+		p.write(e.Operator.String())
+		p.expr(e.Right)
+	case *ast.GroupingExpr:
+		p.write("(")
+		p.expr(e.Expression)
+		p.write(")")
+	case *ast.CallExpr:
+		p.expr(e.Callee)
+		p.write("(")
+		p.list(e.Arguments)
+		p.write(")")
+	case *ast.GetExpr:
+		p.expr(e.Object)
+		p.write("." + e.Name)
+	case *ast.ArrayIndex:
+		p.expr(e.Array)
+		p.write("[")
+		p.expr(e.Index)
+		p.write("]")
+	case *ast.ArrayExpr:
+		spans := make([]token.Span, len(e.Elements))
+		for i, elem := range e.Elements {
+			spans[i] = token.Span{From: elem.Pos(), To: elem.End()}
+		}
+		p.elements("[", "]", e.Span, spans, func(i int) {
+			p.expr(e.Elements[i])
+		})
+	case *ast.MapExpr:
+		spans := make([]token.Span, len(e.Keys))
+		for i := range e.Keys {
+			spans[i] = token.Span{From: e.Keys[i].Pos(), To: e.Values[i].End()}
+		}
+		p.elements("{", "}", e.Span, spans, func(i int) {
+			p.expr(e.Keys[i])
+			p.write(": ")
+			p.expr(e.Values[i])
+		})
+	case *ast.FunctionExpr:
+		if e.Arrow {
+			p.write("(")
+			p.params(e.Params)
+			p.write(") => ")
+			p.expr(e.Body[0].(*ast.ReturnStmt).Value)
+			return
+		}
+		p.write("fun ")
+		p.function(e.Pos(), "", e.Params, e.Body, e.End())
+	default:
+		panic(fmt.Sprintf("format: unexpected expression %T", expr))
+	}
+}
+
+func (p *printer) list(exprs []ast.Expression) {
+	for i, expr := range exprs {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.expr(expr)
+	}
+}
+
+// elements печатает элементы массива или словаря; spans - участки исходника, занятые элементами.
+// Литерал, который в исходнике занимает несколько строк, печатается по элементу на строке
+// вместе с комментариями между элементами
+func (p *printer) elements(open, close string, span token.Span, spans []token.Span, element func(i int)) {
+	p.write(open)
+	if len(spans) == 0 {
+		p.write(close)
+		return
+	}
+	if p.src == nil || span.To.Line == span.From.Line {
+		for i := range spans {
+			if i > 0 {
+				p.write(", ")
+			}
+			element(i)
+		}
+		p.write(close)
+		return
+	}
+
+	p.newline()
+	p.indent++
+	p.first = true
+	for i, elem := range spans {
+		p.leading(elem.From)
+		p.separate(elem.From.Line)
+		element(i)
+		if i < len(spans)-1 {
+			p.write(",")
+		}
+		p.trailing(elem.To)
+		p.newline()
+	}
+	p.leading(span.To)
+	p.first = false
+	p.indent--
+	p.write(close)
+}
+
+func (p *printer) literal(lit *ast.Literal) {
+	switch lit.Token {
+	case token.Nil:
+		p.write("nil")
+	case token.True:
+		p.write("true")
+	case token.False:
+		p.write("false")
+	case token.Number:
+		p.write(lit.Value)
+	case token.String:
+		if p.src != nil {
+			// запись строки в исходнике сохраняется вместе с экранированием
+			p.write(string(p.src[lit.Pos().Offset:lit.End().Offset]))
+			return
+		}
+		p.write(quote(lit.Value))
+	}
+}
+
+// quote записывает строку так, чтобы лексер прочитал ее обратно
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, ch := range s {
+		switch {
+		case ch == '"':
+			b.WriteString(`\"`)
+		case ch == '\\' || !unicode.IsPrint(ch) && ch != '\n' && ch != '\t':
+			fmt.Fprintf(&b, `\u%04x`, ch)
+		default:
+			b.WriteRune(ch)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
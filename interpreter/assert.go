@@ -0,0 +1,161 @@
+package interpreter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Dor1ma/Strawberry/valuer"
+)
+
+// Встроенные функции проверок для тестов на Strawberry. Неудачная проверка - обычная ошибка
+// времени выполнения в месте вызова: ее можно поймать в catch, а strawberry test печатает ее
+// вместе с позицией. Последний необязательный аргумент - пояснение к проверке.
+
+func (interp *Interpreter) defineAsserts() {
+	interp.DefineNative("assert", valuer.VariadicArity, nativeAssert)
+	interp.DefineNative("assertEqual", valuer.VariadicArity, nativeAssertEqual)
+	interp.DefineNative("assertNotEqual", valuer.VariadicArity, nativeAssertNotEqual)
+}
+
+// nativeAssert: assert(условие, [пояснение])
+func nativeAssert(args []valuer.Valuer) (valuer.Valuer, error) {
+	if err := assertArgs("assert", args, 1); err != nil {
+		return nil, err
+	}
+	if !isTruthy(args[0]) {
+		return nil, fmt.Errorf("%s", assertHeader("assertion failed", args[1:]))
+	}
+	return Nil, nil
+}
+
+// nativeAssertEqual: assertEqual(полученное, ожидаемое, [пояснение]). Массивы и словари
+// сравниваются по содержимому
+func nativeAssertEqual(args []valuer.Valuer) (valuer.Valuer, error) {
+	if err := assertArgs("assertEqual", args, 2); err != nil {
+		return nil, err
+	}
+	got, want := args[0], args[1]
+	if deepEqual(got, want) {
+		return Nil, nil
+	}
+	header := assertHeader("assertEqual failed", args[2:])
+	g, gok := got.(*valuer.String)
+	w, wok := want.(*valuer.String)
+	if gok && wok && (strings.Contains(g.Value, "\n") || strings.Contains(w.Value, "\n")) {
+		diff := lineDiff(strings.Split(w.Value, "\n"), strings.Split(g.Value, "\n"))
+		return nil, fmt.Errorf("%s (-want +got):\n%s", header, strings.Join(diff, "\n"))
+	}
+	return nil, fmt.Errorf("%s: got %s, want %s", header, repr(got), repr(want))
+}
+
+// nativeAssertNotEqual: assertNotEqual(полученное, неожиданное, [пояснение])
+func nativeAssertNotEqual(args []valuer.Valuer) (valuer.Valuer, error) {
+	if err := assertArgs("assertNotEqual", args, 2); err != nil {
+		return nil, err
+	}
+	if deepEqual(args[0], args[1]) {
+		return nil, fmt.Errorf("%s: both are %s", assertHeader("assertNotEqual failed", args[2:]), repr(args[0]))
+	}
+	return Nil, nil
+}
+
+func assertArgs(name string, args []valuer.Valuer, n int) error {
+	if len(args) != n && len(args) != n+1 {
+		return fmt.Errorf("%s() expects %d or %d arguments, got %d.", name, n, n+1, len(args))
+	}
+	return nil
+}
+
+func assertHeader(header string, message []valuer.Valuer) string {
+	if len(message) == 0 {
+		return header
+	}
+	if s, ok := message[0].(*valuer.String); ok {
+		return header + ": " + s.Value
+	}
+	return header + ": " + message[0].String()
+}
+
+// deepEqual сравнивает значения одного типа; массивы и словари - поэлементно,
+// экземпляры, классы и функции - по ссылке
+func deepEqual(a, b valuer.Valuer) bool {
+	if a.Type() != b.Type() {
+		return false
+	}
+	switch x := a.(type) {
+	case *valuer.Number, *valuer.String, *valuer.Nil:
+		return isEqual(a, b)
+	case *valuer.Boolean:
+		return x.Value == b.(*valuer.Boolean).Value
+	case *valuer.Array:
+		y := b.(*valuer.Array)
+		if len(x.Elements) != len(y.Elements) {
+			return false
+		}
+		for i := range x.Elements {
+			if !deepEqual(x.Elements[i], y.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	case *valuer.Map:
+		y := b.(*valuer.Map)
+		if x.Len() != y.Len() {
+			return false
+		}
+		for _, key := range x.Keys() {
+			k, _ := valuer.NewMapKey(key)
+			xv, _ := x.Get(k)
+			yv, ok := y.Get(k)
+			if !ok || !deepEqual(xv, yv) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// repr показывает значение в сообщении проверки; строки - в кавычках, чтобы были видны пробелы
+func repr(v valuer.Valuer) string {
+	if s, ok := v.(*valuer.String); ok {
+		return strconv.Quote(s.Value)
+	}
+	return v.String()
+}
+
+// lineDiff строит построчную разницу по наибольшей общей подпоследовательности:
+// "-" - строка только в want, "+" - только в got
+func lineDiff(want, got []string) []string {
+	// lcs[i][j] - длина общей подпоследовательности want[i:] и got[j:]
+	lcs := make([][]int, len(want)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(got)+1)
+	}
+	for i := len(want) - 1; i >= 0; i-- {
+		for j := len(got) - 1; j >= 0; j-- {
+			if want[i] == got[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var diff []string
+	i, j := 0, 0
+	for i < len(want) || j < len(got) {
+		switch {
+		case i < len(want) && j < len(got) && want[i] == got[j]:
+			diff = append(diff, "    "+want[i])
+			i, j = i+1, j+1
+		case j == len(got) || i < len(want) && lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, "  - "+want[i])
+			i++
+		default:
+			diff = append(diff, "  + "+got[j])
+			j++
+		}
+	}
+	return diff
+}
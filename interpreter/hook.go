@@ -0,0 +1,92 @@
+package interpreter
+
+import (
+	"github.com/Dor1ma/Strawberry/ast"
+	"github.com/Dor1ma/Strawberry/errors"
+	"github.com/Dor1ma/Strawberry/resolver"
+	"github.com/Dor1ma/Strawberry/token"
+	"github.com/Dor1ma/Strawberry/valuer"
+)
+
+// Hook получает управление во время выполнения программы. Пока его метод не вернул управление,
+// программа стоит: можно смотреть окружения, стек вызовов и вычислять выражения через EvalFrame.
+// Так к интерпретатору подключается отладчик
+type Hook interface {
+	// Statement вызывается перед выполнением каждого оператора, кроме блоков
+	Statement(stmt ast.Statement)
+	// Return вызывается после возврата из функции; pos - место вызова
+	Return(pos token.Position)
+}
+
+// SetHook задает Hook; nil отключает его
+func (interp *Interpreter) SetHook(hook Hook) {
+	interp.hook = hook
+}
+
+// Env возвращает текущее окружение. Цепочка Enclosing заканчивается глобальным окружением
+func (interp *Interpreter) Env() *valuer.Environment {
+	return interp.env
+}
+
+// IsGlobal сообщает, что env - глобальное окружение интерпретатора
+func (interp *Interpreter) IsGlobal(env *valuer.Environment) bool {
+	return env == interp.globals
+}
+
+// CallStack возвращает копию стека активных вызовов, внешний вызов первым
+func (interp *Interpreter) CallStack() []errors.CallFrame {
+	return append([]errors.CallFrame(nil), interp.calls...)
+}
+
+// EvalFrame вычисляет выражение в текущем окружении остановленной программы. В отличие от Eval
+// ошибка не сбрасывает окружение и стек вызовов, поэтому программу можно выполнять дальше
+func (interp *Interpreter) EvalFrame(expr ast.Expression) (v valuer.Valuer, err error) {
+	env, calls, hook := interp.env, len(interp.calls), interp.hook
+	interp.hook = nil
+	defer func() {
+		if r := recover(); r != nil {
+			runErr, ok := r.(errors.RuntimeError)
+			if !ok {
+				panic(r)
+			}
+			err = &runErr
+		}
+		interp.env, interp.calls, interp.hook = env, interp.calls[:calls], hook
+	}()
+	interp.bindNames(expr)
+	return interp.eval(expr), nil
+}
+
+// bindNames задает расстояния до переменных выражения по цепочке окружений: resolver не знает,
+// в каком месте программы она остановлена. Функции внутри выражения разрешаются как на верхнем уровне
+func (interp *Interpreter) bindNames(expr ast.Expression) {
+	ast.Inspect(expr, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.VariableExpr:
+			n.Distance = interp.distance(n.Name)
+		case *ast.SuperExpr:
+			n.Distance = interp.distance("super")
+		case *ast.FunctionExpr:
+			resolver.New().Resolve(n)
+			return false
+		}
+		return true
+	})
+}
+
+// distance возвращает расстояние до окружения, где определено имя, или -1 для глобальных
+func (interp *Interpreter) distance(name string) int {
+	d := 0
+	for env := interp.env; env != nil && env != interp.globals; env = env.Enclosing {
+		if _, ok := env.Values[name]; ok {
+			return d
+		}
+		d++
+	}
+	return -1
+}
+
+// Truthy сообщает, считается ли значение истинным в условии
+func Truthy(v valuer.Valuer) bool {
+	return isTruthy(v)
+}
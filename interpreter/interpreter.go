@@ -1,13 +1,18 @@
 package interpreter
 
 import (
+	"bufio"
 	"fmt"
 	"github.com/Dor1ma/Strawberry/ast"
 	"github.com/Dor1ma/Strawberry/errors"
+	"github.com/Dor1ma/Strawberry/lexer"
+	"github.com/Dor1ma/Strawberry/parser"
 	"github.com/Dor1ma/Strawberry/resolver"
 	"github.com/Dor1ma/Strawberry/token"
 	"github.com/Dor1ma/Strawberry/valuer"
+	"io"
 	"os"
+	"sort"
 	"strconv"
 )
 
@@ -15,113 +20,228 @@ var (
 	True  = &valuer.Boolean{Value: true}
 	False = &valuer.Boolean{Value: false}
 	Nil   = &valuer.Nil{}
+
+	Break    = &valuer.Break{}
+	Continue = &valuer.Continue{}
 )
 
-// potential value is empty or "repl".
-var evalEnv string
+// Options - настройки интерпретатора. Нулевое значение использует стандартные потоки процесса.
+type Options struct {
+	Stdout io.Writer
+	Stderr io.Writer
+	Stdin  io.Reader
 
-var (
-	env     *valuer.Environment
-	globals *valuer.Environment
-)
+	// REPL включает печать значения последнего выражения после Interpret
+	REPL bool
+}
 
-func init() {
-	initEnv()
+// Interpreter - независимый экземпляр интерпретатора со своими глобальными переменными,
+// состоянием резолвера и потоками ввода-вывода. Разные экземпляры можно использовать
+// из разных горутин, один экземпляр - только из одной.
+type Interpreter struct {
+	env      *valuer.Environment
+	globals  *valuer.Environment
+	resolver *resolver.Resolver
+	calls    []errors.CallFrame // активные вызовы функций, внешний - первый
+	hook     Hook
+
+	stdout io.Writer
+	stderr io.Writer
+	stdin  *bufio.Reader
+
+	repl bool
 }
 
-func initEnv() {
-	globals = valuer.NewEnv()
-	env = globals
+// конструктор
+func New(opts Options) *Interpreter {
+	interp := &Interpreter{
+		globals:  valuer.NewEnv(),
+		resolver: resolver.New(),
+		stdout:   opts.Stdout,
+		stderr:   opts.Stderr,
+		repl:     opts.REPL,
+	}
+	if interp.stdout == nil {
+		interp.stdout = os.Stdout
+	}
+	if interp.stderr == nil {
+		interp.stderr = os.Stderr
+	}
+	if opts.Stdin == nil {
+		opts.Stdin = os.Stdin
+	}
+	interp.stdin = bufio.NewReader(opts.Stdin)
+	interp.env = interp.globals
+	interp.defineNatives()
+	return interp
 }
 
-func Interpret(statements []ast.Statement) {
-	defer func() {
-		if r := recover(); r != nil {
-			if err, ok := r.(errors.RuntimeError); ok {
-				fmt.Fprintln(os.Stderr, err.Error())
-			} else {
-				panic(r)
-			}
-		}
-	}()
+// Run разбирает и выполняет исходный текст. Возвращает синтаксические ошибки (parser.ErrorList)
+// или ошибку времени выполнения (*errors.RuntimeError).
+func (interp *Interpreter) Run(src string) error {
+	return interp.RunFile("", src)
+}
+
+// RunFile - то же, что Run, но позиции в ошибках содержат имя файла
+func (interp *Interpreter) RunFile(filename, src string) error {
+	statements, err := parser.New(lexer.NewFile(filename, src)).Parse()
+	if err != nil {
+		return err
+	}
+	return interp.Interpret(statements)
+}
+
+// Interpret выполняет уже разобранные операторы
+func (interp *Interpreter) Interpret(statements []ast.Statement) (err error) {
+	defer interp.recoverRuntimeError(&err)
 	for _, stmt := range statements {
-		resolver.Resolve(stmt)
+		interp.resolver.Resolve(stmt)
 	}
 	var v valuer.Valuer
 	for _, stmt := range statements {
-		val := Eval(stmt)
+		val := interp.eval(stmt)
 		if val != nil {
 			if val.Type() == valuer.ReturnType {
-				fmt.Fprintf(os.Stderr, "Unexpected return statement %v\n", val)
+				fmt.Fprintf(interp.stderr, "Unexpected return statement %v\n", val)
 			} else {
 				v = val
 			}
 		}
 	}
-	if v != nil && evalEnv == "repl" {
-		fmt.Printf("%s %s\n", black(v.Type().String()), v)
+	if v != nil && interp.repl {
+		fmt.Fprintf(interp.stdout, "%s %s\n", black(v.Type().String()), v)
 	}
+	return nil
 }
 
-func Eval(node ast.Node) valuer.Valuer {
+// Eval разрешает и вычисляет один узел в текущем окружении
+func (interp *Interpreter) Eval(node ast.Node) (v valuer.Valuer, err error) {
+	defer interp.recoverRuntimeError(&err)
+	interp.resolver.Resolve(node)
+	return interp.eval(node), nil
+}
+
+// SetGlobal определяет глобальную переменную
+func (interp *Interpreter) SetGlobal(name string, v valuer.Valuer) {
+	interp.globals.Define(name, v)
+}
+
+// GetGlobal возвращает значение глобальной переменной
+func (interp *Interpreter) GetGlobal(name string) (valuer.Valuer, bool) {
+	return interp.globals.Get(name)
+}
+
+// Globals возвращает имена глобальных переменных, включая встроенные функции, по алфавиту
+func (interp *Interpreter) Globals() []string {
+	names := make([]string, 0, len(interp.globals.Values))
+	for name := range interp.globals.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// recoverRuntimeError превращает панику с errors.RuntimeError в ошибку со стеком вызовов.
+// Окружение сбрасывается на глобальное, чтобы после ошибки экземпляр можно было использовать дальше.
+func (interp *Interpreter) recoverRuntimeError(err *error) {
+	if r := recover(); r != nil {
+		runErr, ok := r.(errors.RuntimeError)
+		if !ok {
+			panic(r)
+		}
+		runErr = runErr.WithFrames(errors.BuildFrames(interp.calls, runErr.Pos()))
+		interp.env = interp.globals
+		interp.calls = nil
+		*err = &runErr
+	}
+}
+
+func (interp *Interpreter) eval(node ast.Node) valuer.Valuer {
+	if interp.hook != nil {
+		if stmt, ok := node.(ast.Statement); ok {
+			if _, block := stmt.(*ast.BlockStmt); !block {
+				interp.hook.Statement(stmt)
+			}
+		}
+	}
 	switch n := node.(type) {
 	default:
 		panic(fmt.Sprintf("unknown ast type %#v.", n))
 	case *ast.Literal:
-		return evalLiteral(n)
+		return interp.evalLiteral(n)
 	case *ast.BinaryExpr:
-		return evalBinaryExpr(n)
+		return interp.evalBinaryExpr(n)
 	case *ast.UnaryExpr:
-		return evalUnaryExpr(n)
+		return interp.evalUnaryExpr(n)
 	case *ast.GroupingExpr:
-		return Eval(n.Expression)
+		return interp.eval(n.Expression)
 	case *ast.ArrayExpr:
-		return evalArrayExpr(n)
+		return interp.evalArrayExpr(n)
 	case *ast.ArrayIndex:
-		return evalArrayIndex(n)
+		return interp.evalArrayIndex(n)
+	case *ast.MapExpr:
+		return interp.evalMapExpr(n)
 	case *ast.VariableExpr:
-		return evalVariableExpr(n)
+		return interp.evalVariableExpr(n)
 	case *ast.AssignExpr:
 		if arrayIndex, ok := n.Left.(*ast.ArrayIndex); ok {
-			return evalArrayIndexAssign(arrayIndex, Eval(n.Value))
+			return interp.evalArrayIndexAssign(arrayIndex, interp.eval(n.Value))
 		}
-		return evalAssignExpr(n)
+		return interp.evalAssignExpr(n)
 	case *ast.LogicalExpr:
-		return evalLogicalExpr(n)
+		return interp.evalLogicalExpr(n)
 	case *ast.CallExpr:
-		return evalCallExpr(n)
+		return interp.evalCallExpr(n)
+	case *ast.FunctionExpr:
+		return &valuer.Function{
+			Name:    valuer.LambdaName,
+			Params:  n.Params,
+			Body:    n.Body,
+			Closure: interp.env,
+		}
 	case *ast.GetExpr:
-		return evalGetExpr(n)
+		return interp.evalGetExpr(n)
 	case *ast.SetExpr:
-		return evalSetExpr(n)
+		return interp.evalSetExpr(n)
 	case *ast.ThisExpr:
-		return evalThisExpr(n)
+		return interp.evalThisExpr(n)
+	case *ast.SuperExpr:
+		return interp.evalSuperExpr(n)
 	case *ast.VarStmt:
-		evalVarStmt(n)
+		interp.evalVarStmt(n)
 		return nil
 	case *ast.FunctionStmt:
-		evalFunctionStmt(n)
+		interp.evalFunctionStmt(n)
 		return nil
 	case *ast.PrintStmt:
-		evalPrintStmt(n)
+		interp.evalPrintStmt(n)
 		return nil
 	case *ast.BlockStmt:
-		return evalBlockStmt(n)
+		return interp.evalBlockStmt(n)
 	case *ast.ExprStmt:
-		return evalExprStmt(n)
+		return interp.evalExprStmt(n)
 	case *ast.IfStmt:
-		return evalIfStmt(n)
+		return interp.evalIfStmt(n)
 	case *ast.WhileStmt:
-		return evalWhileStmt(n)
+		return interp.evalWhileStmt(n)
 	case *ast.ReturnStmt:
-		return evalReturnStmt(n)
+		return interp.evalReturnStmt(n)
+	case *ast.BreakStmt:
+		return Break
+	case *ast.ContinueStmt:
+		return Continue
 	case *ast.ClassStmt:
-		evalClassStmt(n)
+		interp.evalClassStmt(n)
+		return nil
+	case *ast.TryStmt:
+		return interp.evalTryStmt(n)
+	case *ast.ThrowStmt:
+		interp.evalThrowStmt(n)
 		return nil
 	}
 }
 
-func evalLiteral(lit *ast.Literal) valuer.Valuer {
+func (interp *Interpreter) evalLiteral(lit *ast.Literal) valuer.Valuer {
 	switch lit.Token {
 	case token.True:
 		return True
@@ -142,47 +262,79 @@ func evalLiteral(lit *ast.Literal) valuer.Valuer {
 	panic("unexpected literal.")
 }
 
-func evalArrayExpr(expr *ast.ArrayExpr) valuer.Valuer {
+func (interp *Interpreter) evalArrayExpr(expr *ast.ArrayExpr) valuer.Valuer {
 	elements := make([]valuer.Valuer, len(expr.Elements))
 	for i, element := range expr.Elements {
-		elements[i] = Eval(element)
+		elements[i] = interp.eval(element)
 	}
 	return &valuer.Array{Elements: elements}
 }
 
-func evalArrayIndex(expr *ast.ArrayIndex) valuer.Valuer {
-	array, index := getTargetAndIndex(expr)
+func (interp *Interpreter) evalArrayIndex(expr *ast.ArrayIndex) valuer.Valuer {
+	target := interp.eval(expr.Array)
+	if m, ok := target.(*valuer.Map); ok {
+		// отсутствующий ключ дает nil
+		if v, ok := m.Get(mapKey(expr.Index, interp.eval(expr.Index))); ok {
+			return v
+		}
+		return Nil
+	}
+
+	array, index := interp.getTargetAndIndex(expr, target)
 
 	return array.Elements[int(index.Value)]
 }
 
-func evalArrayIndexAssign(expr *ast.ArrayIndex, value valuer.Valuer) valuer.Valuer {
-	array, index := getTargetAndIndex(expr)
+func (interp *Interpreter) evalArrayIndexAssign(expr *ast.ArrayIndex, value valuer.Valuer) valuer.Valuer {
+	target := interp.eval(expr.Array)
+	if m, ok := target.(*valuer.Map); ok {
+		m.Set(mapKey(expr.Index, interp.eval(expr.Index)), value)
+		return value
+	}
+
+	array, index := interp.getTargetAndIndex(expr, target)
 
 	array.Elements[int(index.Value)] = value
 	return value
 }
 
-func getTargetAndIndex(expr *ast.ArrayIndex) (*valuer.Array, *valuer.Number) {
-	target := Eval(expr.Array)
-	index := Eval(expr.Index)
+func (interp *Interpreter) getTargetAndIndex(expr *ast.ArrayIndex, target valuer.Valuer) (*valuer.Array, *valuer.Number) {
+	index := interp.eval(expr.Index)
 
 	array, ok := target.(*valuer.Array)
 	if !ok {
-		errors.Error(token.LeftBracket, "Only arrays can be indexed.")
+		errors.Error(expr.Pos(), token.LeftBracket, "Only arrays and maps can be indexed.")
 	}
 
 	idx, ok := index.(*valuer.Number)
 	if !ok || idx.Value < 0 || int(idx.Value) >= len(array.Elements) {
-		errors.Error(token.LeftBracket, "Index out of bounds.")
+		errors.Error(expr.Index.Pos(), token.LeftBracket, "Index out of bounds.")
 	}
 
 	return array, idx
 }
 
-func evalBinaryExpr(expr *ast.BinaryExpr) valuer.Valuer {
-	left := Eval(expr.Left)
-	right := Eval(expr.Right)
+func (interp *Interpreter) evalMapExpr(expr *ast.MapExpr) valuer.Valuer {
+	m := valuer.NewMap()
+	for i, key := range expr.Keys {
+		k := mapKey(key, interp.eval(key))
+		m.Set(k, interp.eval(expr.Values[i]))
+	}
+	return m
+}
+
+func mapKey(expr ast.Expression, v valuer.Valuer) valuer.MapKey {
+	key, ok := valuer.NewMapKey(v)
+	if !ok {
+		errors.Error(expr.Pos(), token.LeftBracket, "Map keys must be strings, numbers or booleans.")
+	}
+	return key
+}
+
+func (interp *Interpreter) evalBinaryExpr(expr *ast.BinaryExpr) valuer.Valuer {
+	left := interp.eval(expr.Left)
+	right := interp.eval(expr.Right)
+	pos := expr.Pos()
 
 	switch op := expr.Operator; op {
 	case token.EqualEqual:
@@ -192,36 +344,36 @@ func evalBinaryExpr(expr *ast.BinaryExpr) valuer.Valuer {
 		t := !isEqual(left, right)
 		return toBooleanValuer(t)
 	case token.Greater:
-		a, b := checkNumberOperands(op, left, right)
+		a, b := checkNumberOperands(pos, op, left, right)
 		t := a > b
 		return toBooleanValuer(t)
 	case token.GreaterThanOrEqual:
-		a, b := checkNumberOperands(op, left, right)
+		a, b := checkNumberOperands(pos, op, left, right)
 		t := a >= b
 		return toBooleanValuer(t)
 	case token.Less:
-		a, b := checkNumberOperands(op, left, right)
+		a, b := checkNumberOperands(pos, op, left, right)
 		t := a < b
 		return toBooleanValuer(t)
 	case token.LessThanOrEqual:
-		a, b := checkNumberOperands(op, left, right)
+		a, b := checkNumberOperands(pos, op, left, right)
 		t := a <= b
 		return toBooleanValuer(t)
 	case token.Minus:
-		a, b := checkNumberOperands(op, left, right)
+		a, b := checkNumberOperands(pos, op, left, right)
 		v := a - b
 		return &valuer.Number{Value: v}
 	case token.Plus:
-		return doPlusOperation(left, right)
+		return doPlusOperation(pos, left, right)
 	case token.Slash:
-		a, b := checkNumberOperands(op, left, right)
+		a, b := checkNumberOperands(pos, op, left, right)
 		if b == float64(0) {
-			errors.Error(op, "Divisor can't be 0.")
+			errors.Error(expr.Right.Pos(), op, "Divisor can't be 0.")
 		}
 		v := a / b
 		return &valuer.Number{Value: v}
 	case token.Star:
-		a, b := checkNumberOperands(op, left, right)
+		a, b := checkNumberOperands(pos, op, left, right)
 		v := a * b
 		return &valuer.Number{Value: v}
 	}
@@ -229,55 +381,55 @@ func evalBinaryExpr(expr *ast.BinaryExpr) valuer.Valuer {
 	panic("unexpected binary expression.")
 }
 
-func evalUnaryExpr(expr *ast.UnaryExpr) valuer.Valuer {
-	right := Eval(expr.Right)
+func (interp *Interpreter) evalUnaryExpr(expr *ast.UnaryExpr) valuer.Valuer {
+	right := interp.eval(expr.Right)
 	switch op := expr.Operator; op {
 	case token.Not:
 		t := !isTruthy(right)
 		return toBooleanValuer(t)
 	case token.Minus:
-		v := checkNumberOperand(op, right)
+		v := checkNumberOperand(expr.Pos(), op, right)
 		return &valuer.Number{Value: -v}
 	}
 
 	panic("unexpected unary expression.")
 }
 
-func evalVariableExpr(expr *ast.VariableExpr) valuer.Valuer {
+func (interp *Interpreter) evalVariableExpr(expr *ast.VariableExpr) valuer.Valuer {
 	if expr.Distance >= 0 {
-		if v, ok := env.GetAt(expr.Distance, expr.Name); ok {
+		if v, ok := interp.env.GetAt(expr.Distance, expr.Name); ok {
 			return v
 		}
 	} else {
-		if v, ok := globals.Get(expr.Name); ok {
+		if v, ok := interp.globals.Get(expr.Name); ok {
 			return v
 		}
 	}
 
-	errors.Error(token.Identifier, fmt.Sprintf("Undefined variable %s.", expr.Name))
+	errors.Error(expr.Pos(), token.Identifier, fmt.Sprintf("Undefined variable %s.", expr.Name))
 	return nil
 }
 
-func evalAssignExpr(expr *ast.AssignExpr) valuer.Valuer {
-	v := Eval(expr.Value)
+func (interp *Interpreter) evalAssignExpr(expr *ast.AssignExpr) valuer.Valuer {
+	v := interp.eval(expr.Value)
 	left := expr.Left.(*ast.VariableExpr)
 	name, distance := left.Name, left.Distance
 	if distance >= 0 {
-		if ok := env.AssignAt(distance, name, v); ok {
+		if ok := interp.env.AssignAt(distance, name, v); ok {
 			return v
 		}
 	} else {
-		if ok := globals.Assign(name, v); ok {
+		if ok := interp.globals.Assign(name, v); ok {
 			return v
 		}
 	}
-	errors.Error(token.Equal, fmt.Sprintf("Undefined variable %s.", expr.Left))
+	errors.Error(expr.Pos(), token.Equal, fmt.Sprintf("Undefined variable %s.", expr.Left))
 
 	return nil
 }
 
-func evalLogicalExpr(expr *ast.LogicalExpr) valuer.Valuer {
-	left := Eval(expr.Left)
+func (interp *Interpreter) evalLogicalExpr(expr *ast.LogicalExpr) valuer.Valuer {
+	left := interp.eval(expr.Left)
 	switch expr.Operator {
 	default:
 		panic(fmt.Sprintf("unknown operator %s", expr.Operator))
@@ -290,53 +442,83 @@ func evalLogicalExpr(expr *ast.LogicalExpr) valuer.Valuer {
 			return left
 		}
 	}
-	return Eval(expr.Right)
+	return interp.eval(expr.Right)
 }
 
-func evalCallExpr(expr *ast.CallExpr) valuer.Valuer {
-	callee := Eval(expr.Callee)
+func (interp *Interpreter) evalCallExpr(expr *ast.CallExpr) valuer.Valuer {
+	callee := interp.eval(expr.Callee)
 	callableValue, ok := callee.(valuer.Callable)
 	if !ok {
-		errors.Error(token.LeftParen, "Can only call functions and classes.")
-		return nil
-	}
-	if l, l1 := callableValue.Arity(), len(expr.Arguments); l != l1 {
-		errors.Error(token.LeftParen, fmt.Sprintf("Expected %d arguments but got %d", l, l1))
+		errors.Error(expr.Pos(), token.LeftParen, "Can only call functions and classes.")
 		return nil
 	}
+	checkArity(callableValue, len(expr.Arguments), expr.Pos())
 
 	switch n := callee.(type) {
 	default:
 		panic("invaid type")
 	case *valuer.Function:
-		return callFunction(n, expr.Arguments)
+		return interp.callFunction(n, expr.Arguments, expr.Pos())
 	case *valuer.ClassValue:
-		return constructInstance(n, expr.Arguments)
+		return interp.constructInstance(n, expr.Arguments, expr.Pos())
+	case *valuer.NativeFunction:
+		return interp.callNative(n, expr.Arguments, expr.Pos())
+	}
+}
+
+// checkArity проверяет число аргументов одинаково для функций, классов и встроенных функций
+func checkArity(callee valuer.Callable, count int, pos token.Position) {
+	arity := callee.Arity()
+	if arity == valuer.VariadicArity || arity == count {
+		return
 	}
+	errors.Error(pos, token.LeftParen, fmt.Sprintf("Expected %d arguments but got %d", arity, count))
 }
 
-func constructInstance(c *valuer.ClassValue, arguments []ast.Expression) *valuer.Instance {
+func (interp *Interpreter) callNative(fn *valuer.NativeFunction, arguments []ast.Expression, pos token.Position) valuer.Valuer {
+	args := make([]valuer.Valuer, len(arguments))
+	for i, arg := range arguments {
+		args[i] = interp.eval(arg)
+	}
+	v, err := fn.Fn(args)
+	if err != nil {
+		errors.Error(pos, token.LeftParen, err.Error())
+		return nil
+	}
+	if v == nil {
+		return Nil
+	}
+	return v
+}
+
+func (interp *Interpreter) constructInstance(c *valuer.ClassValue, arguments []ast.Expression, pos token.Position) *valuer.Instance {
 	instance := &valuer.Instance{Klass: c}
 	initializer := c.FindMethod("init")
 	if initializer != nil {
-		callFunction(initializer.Bind(instance), arguments)
+		interp.callFunction(initializer.Bind(instance), arguments, pos)
 	}
 	return instance
 }
 
-func callFunction(function *valuer.Function, arguments []ast.Expression) valuer.Valuer {
+func (interp *Interpreter) callFunction(function *valuer.Function, arguments []ast.Expression, pos token.Position) valuer.Valuer {
 	environment := function.Closure
 	environment = valuer.NewEnclosing(function.Closure)
 	for i, param := range function.Params {
-		environment.Define(param.Name, Eval(arguments[i]))
+		environment.Define(param.Name, interp.eval(arguments[i]))
+	}
+	// при ошибке кадр не снимается: стек нужен для трассировки, его сбросит recoverRuntimeError
+	interp.calls = append(interp.calls, errors.CallFrame{Function: function.Name, Call: pos})
+	v := interp.executeBlock(function.Body, environment)
+	interp.calls = interp.calls[:len(interp.calls)-1]
+	if interp.hook != nil {
+		interp.hook.Return(pos)
 	}
-	v := executeBlock(function.Body, environment)
 	if function.IsInitializer {
 		// lookup this in function.Closure
 		if v, ok := function.Closure.GetAt(0, "this"); ok {
 			return v
 		}
-		errors.Error(token.Fun, "Cann't get this in currrent enviroment.")
+		errors.Error(pos, token.Fun, "Cann't get this in currrent enviroment.")
 		return nil
 	}
 	if returnValue, ok := v.(*valuer.ReturnValue); ok {
@@ -345,74 +527,110 @@ func callFunction(function *valuer.Function, arguments []ast.Expression) valuer.
 	return v
 }
 
-func evalGetExpr(expr *ast.GetExpr) valuer.Valuer {
-	object := Eval(expr.Object)
+func (interp *Interpreter) evalGetExpr(expr *ast.GetExpr) valuer.Valuer {
+	object := interp.eval(expr.Object)
+	if m, ok := object.(*valuer.Map); ok {
+		if method := mapMethod(m, expr.Name); method != nil {
+			return method
+		}
+		errors.Error(expr.Pos(), token.Identifier, fmt.Sprintf("Undefined map method %s.", expr.Name))
+		return nil
+	}
+	if e, ok := object.(*valuer.Error); ok {
+		if v, ok := e.Property(expr.Name); ok {
+			return v
+		}
+		errors.Error(expr.Pos(), token.Identifier, fmt.Sprintf("Undefined propterty %s.", expr.Name))
+		return nil
+	}
 	instance, ok := object.(*valuer.Instance)
 	if !ok {
-		errors.Error(token.Identifier, "Only instances have properties.")
+		errors.Error(expr.Pos(), token.Identifier, "Only instances have properties.")
 		return nil
 	}
 	if v, ok := instance.Get(expr.Name); ok {
 		return v
 	}
-	errors.Error(token.Identifier, fmt.Sprintf("Undefined propterty %s.", expr.Name))
+	errors.Error(expr.Pos(), token.Identifier, fmt.Sprintf("Undefined propterty %s.", expr.Name))
 	return nil
 }
 
-func evalSetExpr(expr *ast.SetExpr) valuer.Valuer {
-	object := Eval(expr.Object)
+func (interp *Interpreter) evalSetExpr(expr *ast.SetExpr) valuer.Valuer {
+	object := interp.eval(expr.Object)
 	instance, ok := object.(*valuer.Instance)
 	if !ok {
-		errors.Error(token.Identifier, "Only instances have properties.")
+		errors.Error(expr.Pos(), token.Identifier, "Only instances have properties.")
 		return nil
 	}
-	v := Eval(expr.Value)
+	v := interp.eval(expr.Value)
 	instance.Set(expr.Name, v)
 	return v
 }
 
-func evalThisExpr(expr *ast.ThisExpr) valuer.Valuer {
-	if v, ok := env.Get("this"); ok {
+func (interp *Interpreter) evalThisExpr(expr *ast.ThisExpr) valuer.Valuer {
+	if v, ok := interp.env.Get("this"); ok {
 		return v
 	}
-	errors.Error(token.This, "Cannot use 'this' outside of a class.")
+	errors.Error(expr.Pos(), token.This, "Cannot use 'this' outside of a class.")
 	return nil
 }
 
-func evalExprStmt(stmt *ast.ExprStmt) valuer.Valuer {
-	return Eval(stmt.Expression)
+func (interp *Interpreter) evalSuperExpr(expr *ast.SuperExpr) valuer.Valuer {
+	v, ok := interp.env.GetAt(expr.Distance, "super")
+	superClass, isClass := v.(*valuer.ClassValue)
+	if !ok || !isClass {
+		errors.Error(expr.Pos(), token.Super, "Cannot use 'super' outside of a class.")
+		return nil
+	}
+	// this всегда определен в окружении, вложенном в окружение с super
+	object, ok := interp.env.GetAt(expr.Distance-1, "this")
+	instance, isInstance := object.(*valuer.Instance)
+	if !ok || !isInstance {
+		errors.Error(expr.Pos(), token.This, "Cannot use 'super' outside of a method.")
+		return nil
+	}
+	method := superClass.FindMethod(expr.Method)
+	if method == nil {
+		errors.Error(expr.Pos(), token.Super, fmt.Sprintf("Undefined property %s.", expr.Method))
+		return nil
+	}
+	return method.Bind(instance)
 }
 
-func evalVarStmt(stmt *ast.VarStmt) {
+func (interp *Interpreter) evalExprStmt(stmt *ast.ExprStmt) valuer.Valuer {
+	return interp.eval(stmt.Expression)
+}
+
+func (interp *Interpreter) evalVarStmt(stmt *ast.VarStmt) {
 	name := stmt.Name.Name
 	var v valuer.Valuer
 	if stmt.Initializer != nil {
-		v = Eval(stmt.Initializer)
+		v = interp.eval(stmt.Initializer)
 	} else {
 		v = Nil
 	}
-	env.Define(name, v)
+	interp.env.Define(name, v)
 }
 
-func evalPrintStmt(stmt *ast.PrintStmt) {
-	v := Eval(stmt.Expression)
-	fmt.Println(v)
+func (interp *Interpreter) evalPrintStmt(stmt *ast.PrintStmt) {
+	v := interp.eval(stmt.Expression)
+	fmt.Fprintln(interp.stdout, v)
 }
 
-func evalBlockStmt(block *ast.BlockStmt) valuer.Valuer {
-	return executeBlock(block.Statements, valuer.NewEnclosing(env))
+func (interp *Interpreter) evalBlockStmt(block *ast.BlockStmt) valuer.Valuer {
+	return interp.executeBlock(block.Statements, valuer.NewEnclosing(interp.env))
 }
 
-func executeBlock(statements []ast.Statement, environment *valuer.Environment) valuer.Valuer {
-	previous := env
-	env = environment
+func (interp *Interpreter) executeBlock(statements []ast.Statement, environment *valuer.Environment) valuer.Valuer {
+	previous := interp.env
+	interp.env = environment
 	defer func() {
-		env = previous
+		interp.env = previous
 	}()
 	for _, stmt := range statements {
-		result := Eval(stmt)
+		result := interp.eval(stmt)
 		if result != nil {
-			if rt := result.Type(); rt == valuer.ReturnType {
+			if rt := result.Type(); rt == valuer.ReturnType || rt == valuer.BreakType || rt == valuer.ContinueType {
 				return result
 			}
 		}
@@ -420,85 +638,184 @@ func executeBlock(statements []ast.Statement, environment *valuer.Environment) v
 	return Nil
 }
 
-func evalIfStmt(stmt *ast.IfStmt) valuer.Valuer {
-	condition := Eval(stmt.Condition)
+func (interp *Interpreter) evalIfStmt(stmt *ast.IfStmt) valuer.Valuer {
+	condition := interp.eval(stmt.Condition)
 	if isTruthy(condition) {
-		return Eval(stmt.ThenBranch)
+		return interp.eval(stmt.ThenBranch)
 	} else if stmt.ElseBranch != nil {
-		return Eval(stmt.ElseBranch)
+		return interp.eval(stmt.ElseBranch)
 	}
 	return Nil
 }
 
-func evalWhileStmt(stmt *ast.WhileStmt) valuer.Valuer {
-	for isTruthy(Eval(stmt.Condition)) {
-		result := Eval(stmt.Body)
+func (interp *Interpreter) evalWhileStmt(stmt *ast.WhileStmt) valuer.Valuer {
+	for isTruthy(interp.eval(stmt.Condition)) {
+		result := interp.eval(stmt.Body)
 		if result != nil {
 			if rt := result.Type(); rt == valuer.ReturnType {
 				return result
+			} else if rt == valuer.BreakType {
+				break
 			}
 		}
+		if stmt.Increment != nil {
+			interp.eval(stmt.Increment)
+		}
 	}
 	return Nil
 }
 
-func evalFunctionStmt(stmt *ast.FunctionStmt) {
+func (interp *Interpreter) evalFunctionStmt(stmt *ast.FunctionStmt) {
 	fn := &valuer.Function{
 		Name:    stmt.Name,
 		Params:  stmt.Params,
 		Body:    stmt.Body,
-		Closure: env,
+		Closure: interp.env,
 	}
-	env.Define(stmt.Name, fn)
+	interp.env.Define(stmt.Name, fn)
 }
 
-func evalReturnStmt(stmt *ast.ReturnStmt) valuer.Valuer {
+func (interp *Interpreter) evalReturnStmt(stmt *ast.ReturnStmt) valuer.Valuer {
 	var v valuer.Valuer = Nil
 	if stmt.Value != nil {
-		v = Eval(stmt.Value)
+		v = interp.eval(stmt.Value)
 	}
 	return &valuer.ReturnValue{
 		Value: v,
 	}
 }
 
-func evalClassStmt(stmt *ast.ClassStmt) {
+func (interp *Interpreter) evalTryStmt(stmt *ast.TryStmt) valuer.Valuer {
+	result, thrown := interp.protect(stmt.Body)
+	if thrown != nil && stmt.CatchBody != nil {
+		catchEnv := valuer.NewEnclosing(interp.env)
+		catchEnv.Define(stmt.CatchName.Name, thrownValue(thrown))
+		previous := interp.env
+		interp.env = catchEnv
+		result, thrown = interp.protect(stmt.CatchBody)
+		interp.env = previous
+	}
+	if stmt.FinallyBody != nil {
+		// return, break и continue из finally отменяют исключение и результат try
+		if fin := interp.evalBlockStmt(stmt.FinallyBody); isControl(fin) {
+			return fin
+		}
+	}
+	if thrown != nil {
+		panic(*thrown)
+	}
+	return result
+}
+
+// protect выполняет блок и перехватывает ошибку времени выполнения. Стек вызовов и окружение
+// возвращаются к состоянию на входе в блок, трассировка сохраняется в ошибке
+func (interp *Interpreter) protect(block *ast.BlockStmt) (result valuer.Valuer, thrown *errors.RuntimeError) {
+	env, depth := interp.env, len(interp.calls)
+	defer func() {
+		if r := recover(); r != nil {
+			runErr, ok := r.(errors.RuntimeError)
+			if !ok {
+				panic(r)
+			}
+			runErr = runErr.WithFrames(errors.BuildFrames(interp.calls, runErr.Pos()))
+			interp.env = env
+			interp.calls = interp.calls[:depth]
+			result, thrown = Nil, &runErr
+		}
+	}()
+	return interp.evalBlockStmt(block), nil
+}
+
+// thrownValue возвращает значение, которое получит catch: значение из throw
+// или ошибку с message и trace для встроенной ошибки
+func thrownValue(runErr *errors.RuntimeError) valuer.Valuer {
+	frames := runErr.Frames()
+	trace := make([]string, len(frames))
+	for i, frame := range frames {
+		trace[i] = frame.String()
+	}
+	switch v := runErr.Thrown().(type) {
+	case nil:
+		return &valuer.Error{Message: runErr.Msg(), Trace: trace}
+	case *valuer.Error:
+		if v.Trace == nil {
+			v.Trace = trace
+		}
+		return v
+	case valuer.Valuer:
+		return v
+	}
+	panic("invalid thrown value")
+}
+
+func (interp *Interpreter) evalThrowStmt(stmt *ast.ThrowStmt) {
+	v := interp.eval(stmt.Value)
+	errors.Throw(stmt.Pos(), v, v.String())
+}
+
+func isControl(v valuer.Valuer) bool {
+	if v == nil {
+		return false
+	}
+	rt := v.Type()
+	return rt == valuer.ReturnType || rt == valuer.BreakType || rt == valuer.ContinueType
+}
+
+func (interp *Interpreter) evalClassStmt(stmt *ast.ClassStmt) {
+	var superClass *valuer.ClassValue
+	if stmt.SuperClass != nil {
+		class, ok := interp.evalVariableExpr(stmt.SuperClass).(*valuer.ClassValue)
+		if !ok {
+			errors.Error(stmt.SuperClass.Pos(), token.Class, "Superclass must be a class.")
+			return
+		}
+		superClass = class
+	}
+
+	enclosing := interp.env
+	if superClass != nil {
+		interp.env = valuer.NewEnclosing(interp.env)
+		interp.env.Define("super", superClass)
+	}
+
 	methods := make(map[string]*valuer.Function, len(stmt.Methods))
 	for _, method := range stmt.Methods {
 		fn := &valuer.Function{
 			Name:          method.Name,
 			Params:        method.Params,
 			Body:          method.Body,
-			Closure:       env,
+			Closure:       interp.env,
 			IsInitializer: method.IsInitializer,
 		}
 		methods[method.Name] = fn
 	}
 	cl := &valuer.ClassValue{
-		Name:    stmt.Name,
-		Methods: methods,
+		Name:       stmt.Name,
+		SuperClass: superClass,
+		Methods:    methods,
 	}
-	env.Define(stmt.Name, cl)
+	interp.env = enclosing
+	interp.env.Define(stmt.Name, cl)
 }
 
-func checkNumberOperand(operator token.Token, right valuer.Valuer) float64 {
+func checkNumberOperand(pos token.Position, operator token.Token, right valuer.Valuer) float64 {
 	a, ok := right.(*valuer.Number)
 	if !ok {
-		errors.Error(operator, "Operand must be a number.")
+		errors.Error(pos, operator, "Operand must be a number.")
 	}
 	return a.Value
 }
 
-func checkNumberOperands(operator token.Token, left, right valuer.Valuer) (float64, float64) {
+func checkNumberOperands(pos token.Position, operator token.Token, left, right valuer.Valuer) (float64, float64) {
 	a, ok := left.(*valuer.Number)
 	b, ok1 := right.(*valuer.Number)
 	if !(ok && ok1) {
-		errors.Error(operator, "Operands must be numbers.")
+		errors.Error(pos, operator, "Operands must be numbers.")
 	}
 	return a.Value, b.Value
 }
 
-func doPlusOperation(left, right valuer.Valuer) valuer.Valuer {
+func doPlusOperation(pos token.Position, left, right valuer.Valuer) valuer.Valuer {
 	switch l := left.(type) {
 	case *valuer.Number, *valuer.String:
 		switch r := right.(type) {
@@ -530,7 +847,7 @@ func doPlusOperation(left, right valuer.Valuer) valuer.Valuer {
 		}
 	}
 
-	errors.Error(token.Plus, "Operands must be numbers or strings.")
+	errors.Error(pos, token.Plus, "Operands must be numbers or strings.")
 	return nil
 }
 
@@ -585,8 +902,3 @@ func toBooleanValuer(t bool) *valuer.Boolean {
 func black(s string) string {
 	return "\033[1;30m" + s + "\033[0m"
 }
-
-// SetEvalEnv specify eval env of Interpreter.
-func SetEvalEnv(envConfig string) {
-	evalEnv = envConfig
-}
@@ -1,11 +1,14 @@
 package interpreter
 
 import (
+	"bytes"
+	"fmt"
+	"github.com/Dor1ma/Strawberry/ast"
 	"github.com/Dor1ma/Strawberry/errors"
+	"github.com/Dor1ma/Strawberry/lexer"
 	"github.com/Dor1ma/Strawberry/parser"
 	"github.com/Dor1ma/Strawberry/valuer"
 	"io/ioutil"
-	"os"
 	"strings"
 	"testing"
 )
@@ -163,6 +166,235 @@ func TestEvalForStmt(t *testing.T) {
 	testEvalPrintStmt(t, input, expected)
 }
 
+func TestEvalBreakContinue(t *testing.T) {
+	input := `for (var a = 0; a < 10; a = a + 1) {
+		if (a == 1) continue;
+		if (a == 4) {
+			break;
+		}
+		print a;
+	}
+	var b = 0;
+	while (true) {
+		b = b + 1;
+		if (b < 3) {
+			continue;
+		}
+		for (;;) {
+			break;
+		}
+		print b;
+		if (b >= 4) break;
+	}
+	fun f() {
+		while (true) {
+			return "f";
+		}
+	}
+	print f();`
+	expected := []string{"0", "2", "3", "3", "4", "f"}
+	testEvalPrintStmt(t, input, expected)
+}
+
+func TestLoopControlError(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"break;", "run.berry:1:1: Cannot use 'break' outside of a loop."},
+		{"if (true) {\n  continue;\n}", "run.berry:2:3: Cannot use 'continue' outside of a loop."},
+		{"while (true) {\n  fun f() { break; }\n}", "run.berry:2:13: Cannot use 'break' outside of a loop."},
+	}
+
+	for i, test := range tests {
+		p := parser.New(lexer.NewFile("run.berry", test.input))
+		stmts, err := p.Parse()
+		if err != nil {
+			t.Fatalf("test [%d] failed. error: %s", i, err.Error())
+		}
+		err = evalStmts(stmts)
+		if err == nil {
+			t.Fatalf("test [%d] failed. expected error", i)
+		}
+		if err.Error() != test.expected {
+			t.Errorf("test [%d] expected error is %q. got %q", i, test.expected, err.Error())
+		}
+	}
+}
+
+func TestEvalMap(t *testing.T) {
+	input := `var m = {"a": 1, 2: "two", true: "yes"};
+	print m["a"];
+	print m[2];
+	print m[true];
+	print m[1 + 1];
+	print m["missing"];
+	m["b"] = m["a"] + 1;
+	m["a"] = 0;
+	print m;
+	print m.len();
+	print m.has("b");
+	print m.delete("b");
+	print m.delete("b");
+	print m.has("b");
+	var keys = m.keys();
+	for (var i = 0; i < m.len(); i = i + 1) {
+		print keys[i];
+	}
+	fun f() {
+		var local = {};
+		local["x"] = 1;
+		return local["x"];
+	}
+	print f();`
+	expected := []string{
+		"1", "two", "yes", "two", "nil",
+		"{a: 0, 2: two, true: yes, b: 2}",
+		"4", "true", "true", "false", "false",
+		"a", "2", "true",
+		"1",
+	}
+	testEvalPrintStmt(t, input, expected)
+}
+
+func TestMapError(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"var m = {[1]: 2};", "run.berry:1:10: Map keys must be strings, numbers or booleans."},
+		{"var m = {};\nm[nil] = 1;", "run.berry:2:3: Map keys must be strings, numbers or booleans."},
+		{"var m = {};\nm.size();", "run.berry:2:1: Undefined map method size."},
+		{"var a = 1;\nprint a[0];", "run.berry:2:7: Only arrays and maps can be indexed."},
+	}
+
+	for i, test := range tests {
+		p := parser.New(lexer.NewFile("run.berry", test.input))
+		stmts, err := p.Parse()
+		if err != nil {
+			t.Fatalf("test [%d] failed. error: %s", i, err.Error())
+		}
+		err = evalStmts(stmts)
+		if err == nil {
+			t.Fatalf("test [%d] failed. expected error", i)
+		}
+		if err.Error() != test.expected {
+			t.Errorf("test [%d] expected error is %q. got %q", i, test.expected, err.Error())
+		}
+	}
+}
+
+func TestEvalNatives(t *testing.T) {
+	input := `var a = [1, 2];
+	print len(a);
+	print push(a, 3);
+	print a;
+	print pop(a);
+	print len(a);
+	print len("ягода");
+	print len({"a": 1});
+	print str(12) + "3";
+	print num("1.5") + 1;
+	print num(true);
+	print type(1);
+	print type("s");
+	print type(nil);
+	print type([]);
+	print type({});
+	print type(len);
+	print clock() > 0;
+	print len;
+	class A {}
+	print type(A);
+	print type(A());`
+	expected := []string{
+		"2", "3", "[1, 2, 3]", "3", "2", "5", "1",
+		"123", "2.5", "1",
+		"number", "string", "nil", "array", "map", "function",
+		"true", "<native fn len>", "class", "instance",
+	}
+	testEvalPrintStmt(t, input, expected)
+}
+
+func TestRegisterNative(t *testing.T) {
+	RegisterNative("twice", 1, func(args []valuer.Valuer) (valuer.Valuer, error) {
+		n, ok := args[0].(*valuer.Number)
+		if !ok {
+			return nil, fmt.Errorf("twice() expects a number.")
+		}
+		return &valuer.Number{Value: n.Value * 2}, nil
+	})
+	defer delete(natives, "twice")
+	testEvalPrintStmt(t, "print twice(21);", []string{"42"})
+}
+
+func TestNativeError(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"print len(1);", "run.berry:1:7: len() expects a string, array or map, got number."},
+		{"print len(1, 2);", "run.berry:1:7: Expected 1 arguments but got 2"},
+		{"var a = [];\npop(a);", "run.berry:2:1: pop() from empty array."},
+		{"print num(\"x\");", "run.berry:1:7: num() cannot convert \"x\" to a number."},
+		{"fun f(a) {}\nf();", "run.berry:2:1: Expected 1 arguments but got 0"},
+	}
+
+	for i, test := range tests {
+		p := parser.New(lexer.NewFile("run.berry", test.input))
+		stmts, err := p.Parse()
+		if err != nil {
+			t.Fatalf("test [%d] failed. error: %s", i, err.Error())
+		}
+		err = evalStmts(stmts)
+		if err == nil {
+			t.Fatalf("test [%d] failed. expected error", i)
+		}
+		if err.Error() != test.expected {
+			t.Errorf("test [%d] expected error is %q. got %q", i, test.expected, err.Error())
+		}
+	}
+}
+
+func TestAsserts(t *testing.T) {
+	testEvalPrintStmt(t, `assert(1 < 2);
+	assertEqual([1, {"a": [2]}], [1, {"a": [2]}]);
+	assertNotEqual(1, "1");
+	assertNotEqual(true, 1);
+	try {
+		assert(false, "boom");
+	} catch (e) {
+		print e.message;
+	}`, []string{"assertion failed: boom"})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"assert(nil);", "run.berry:1:1: assertion failed"},
+		{"assert();", "run.berry:1:1: assert() expects 1 or 2 arguments, got 0."},
+		{"assertEqual(1 + 1, 3);", "run.berry:1:1: assertEqual failed: got 2, want 3"},
+		{`assertEqual("a ", "a", "trailing space");`, `run.berry:1:1: assertEqual failed: trailing space: got "a ", want "a"`},
+		{"assertEqual([1, 2], [1, 3]);", "run.berry:1:1: assertEqual failed: got [1, 2], want [1, 3]"},
+		{"assertEqual({\"a\": 1}, {\"b\": 1});", "run.berry:1:1: assertEqual failed: got {a: 1}, want {b: 1}"},
+		{"assertEqual(\"a\u000ab\u000ac\", \"a\u000ac\u000ad\");", "run.berry:1:1: assertEqual failed (-want +got):\n    a\n  + b\n    c\n  - d"},
+		{"var a = [];\nassertNotEqual(a, []);", "run.berry:2:1: assertNotEqual failed: both are []"},
+	}
+	for i, test := range tests {
+		stmts, err := parser.New(lexer.NewFile("run.berry", test.input)).Parse()
+		if err != nil {
+			t.Fatalf("test [%d] failed. error: %s", i, err.Error())
+		}
+		err = evalStmts(stmts)
+		if err == nil {
+			t.Fatalf("test [%d] failed. expected error", i)
+		}
+		if err.Error() != test.expected {
+			t.Errorf("test [%d] expected error is %q. got %q", i, test.expected, err.Error())
+		}
+	}
+}
+
 func TestEvalFunctionDeclaration(t *testing.T) {
 	input := `var a = 0;
 	var b = 1;
@@ -179,6 +411,29 @@ func TestEvalFunctionDeclaration(t *testing.T) {
 	testEvalPrintStmt(t, input, expected)
 }
 
+func TestEvalFunctionExpr(t *testing.T) {
+	input := `var add = fun (a, b) { return a + b; };
+	print add(1, 2);
+	var mul = (a, b) => a * b;
+	print mul(3, 4);
+	fun apply(f, x) { return f(x); }
+	print apply((x) => x + 1, 10);
+	print ((x) => x * 2)(21);
+	fun makeCounter() {
+		var count = 0;
+		return () => count = count + 1;
+	}
+	var counter = makeCounter();
+	counter();
+	print counter();
+	var adder = (a) => (b) => a + b;
+	print adder(2)(3);
+	print add;
+	print type(mul);`
+	expected := []string{"3", "12", "11", "42", "2", "5", "<fn lambda>", "function"}
+	testEvalPrintStmt(t, input, expected)
+}
+
 func TestReturnStatement(t *testing.T) {
 	input := `var a = 1;
 	fun f() {
@@ -291,6 +546,74 @@ func TestEvalThisAndInit(t *testing.T) {
 	testEvalPrintStmt(t, input, expected)
 }
 
+func TestEvalInheritance(t *testing.T) {
+	input := `class A {
+		init(x) {
+			this.x = x;
+		}
+		fn() {
+			return "a.fn " + this.x;
+		}
+		name() {
+			return "A";
+		}
+	}
+	class B < A {
+		init(x) {
+			super.init(x + 1);
+		}
+		fn() {
+			return "b.fn " + super.fn();
+		}
+	}
+	class C < B {
+		name() {
+			return "C < " + super.name();
+		}
+	}
+	var b = B(1);
+	print b.fn();
+	print b.name();
+	var c = C(10);
+	print c.fn();
+	print c.name();`
+	expected := []string{
+		"b.fn a.fn 2",  // print b.fn();
+		"A",            // print b.name();
+		"b.fn a.fn 11", // print c.fn();
+		"C < A",        // print c.name();
+	}
+	testEvalPrintStmt(t, input, expected)
+}
+
+func TestInheritanceError(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"var A = 1;\nclass B < A {}", "run.berry:2:11: Superclass must be a class."},
+		{"class A < A {}", "run.berry:1:11: A class cannot inherit from itself."},
+		{"print super.x;", "run.berry:1:7: Cannot use 'super' outside of a class."},
+		{"class A {\n  f() { super.f(); }\n}", "run.berry:2:9: Cannot use 'super' in a class with no superclass."},
+		{"class A {}\nclass B < A {\n  f() { super.g(); }\n}\nB().f();", "run.berry:3:9: Undefined property g."},
+	}
+
+	for i, test := range tests {
+		p := parser.New(lexer.NewFile("run.berry", test.input))
+		stmts, err := p.Parse()
+		if err != nil {
+			t.Fatalf("test [%d] failed. error: %s", i, err.Error())
+		}
+		err = evalStmts(stmts)
+		if err == nil {
+			t.Fatalf("test [%d] failed. expected error", i)
+		}
+		if err.Error() != test.expected {
+			t.Errorf("test [%d] expected error is %q. got %q", i, test.expected, err.Error())
+		}
+	}
+}
+
 func TestResolveError(t *testing.T) {
 	tests := []struct {
 		input string
@@ -310,15 +633,9 @@ func TestResolveError(t *testing.T) {
 		if err != nil {
 			t.Fatalf("test [%d] failed. error: %s", i, err.Error())
 		}
-		initEnv()
-		defer func(i int, s string) {
-			if r := recover(); r != nil {
-				if _, ok := r.(errors.RuntimeError); !ok {
-					t.Fatalf("test [%d] failed. %s", i, s)
-				}
-			}
-		}(i, test.msg)
-		Interpret(stmts)
+		if err := evalStmts(stmts); err == nil {
+			t.Fatalf("test [%d] failed. %s", i, test.msg)
+		}
 	}
 
 }
@@ -328,17 +645,7 @@ func evalExprFromInput(input string) (v valuer.Valuer, err error) {
 	if err != nil {
 		panic(err)
 	}
-	defer func() {
-		if r := recover(); r != nil {
-			if runErr, ok := r.(errors.RuntimeError); ok {
-				err = &runErr
-				expr = nil
-			} else {
-				panic(r)
-			}
-		}
-	}()
-	return Eval(expr), nil
+	return New(Options{}).Eval(expr)
 }
 
 func testNumberValuer(t *testing.T, val valuer.Valuer, expected float64) bool {
@@ -385,12 +692,12 @@ func testEvalPrintStmt(t *testing.T, input string, expected []string) {
 	if err != nil {
 		t.Fatalf("parse failed. error: %s", err.Error())
 	}
-	// reset environment before interpereting.
-	initEnv()
-	s := captureStdout(func() {
-		Interpret(stmts)
-	})
-	out := splitByLine(s)
+	var stdout bytes.Buffer
+	// ошибка времени выполнения не прерывает проверку: сравнивается только то, что успело напечататься
+	if err := New(Options{Stdout: &stdout}).Interpret(stmts); err != nil {
+		t.Logf("runtime error: %s", err.Error())
+	}
+	out := splitByLine(stdout.String())
 	if len(out) != len(expected) {
 		t.Errorf("should get %d outputs. got %d", len(expected), len(out))
 		return
@@ -403,32 +710,270 @@ func testEvalPrintStmt(t *testing.T, input string, expected []string) {
 	}
 }
 
-// https://stackoverflow.com/a/47281683
-func captureStdout(fn func()) string {
-	rescueStdout := os.Stdout
-	r, w, err := os.Pipe()
-	if err != nil {
-		panic(err)
-	}
-	os.Stdout = w
+func splitByLine(s string) []string {
+	s = strings.TrimSpace(s)
+	return strings.Split(s, "\n")
+}
 
-	fn()
+func TestRuntimeErrorPosition(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"var a = 1;\nprint a / 0;", "run.berry:2:11: Divisor can't be 0."},
+		{"print 1;\n  print -\"x\";", "run.berry:2:9: Operand must be a number."},
+		{"print b;", "run.berry:1:7: Undefined variable b."},
+	}
 
-	ch := make(chan string)
-	go func() {
-		b, err := ioutil.ReadAll(r)
+	for i, test := range tests {
+		p := parser.New(lexer.NewFile("run.berry", test.input))
+		stmts, err := p.Parse()
 		if err != nil {
-			panic(err)
+			t.Fatalf("test [%d] failed. error: %s", i, err.Error())
+		}
+		err = evalStmts(stmts)
+		if err == nil {
+			t.Fatalf("test [%d] failed. expected runtime error", i)
 		}
-		ch <- string(b)
-	}()
-	w.Close()
-	os.Stdout = rescueStdout
-	s := <-ch
-	return s
+		if err.Error() != test.expected {
+			t.Errorf("test [%d] expected error is %q. got %q", i, test.expected, err.Error())
+		}
+	}
 }
 
-func splitByLine(s string) []string {
-	s = strings.TrimSpace(s)
-	return strings.Split(s, "\n")
+func evalStmts(stmts []ast.Statement) error {
+	return New(Options{Stdout: ioutil.Discard}).Interpret(stmts)
+}
+
+func TestInterpreterInstances(t *testing.T) {
+	var out1, out2 bytes.Buffer
+	in1 := New(Options{Stdout: &out1})
+	in2 := New(Options{Stdout: &out2})
+
+	if err := in1.Run("var a = 1; print a;"); err != nil {
+		t.Fatalf("run failed. error: %s", err.Error())
+	}
+	if _, ok := in2.GetGlobal("a"); ok {
+		t.Fatalf("global a leaked into another interpreter")
+	}
+	if err := in2.Run("print a;"); err == nil {
+		t.Fatalf("expected undefined variable error")
+	}
+
+	in2.SetGlobal("a", &valuer.Number{Value: 2})
+	if err := in2.Run("a = a + 1; print a;"); err != nil {
+		t.Fatalf("run failed. error: %s", err.Error())
+	}
+	v, ok := in2.GetGlobal("a")
+	if !ok || !testNumberValuer(t, v, 3) {
+		t.Fatalf("expected a = 3 in second interpreter. got %v", v)
+	}
+	if out1.String() != "1\n" || out2.String() != "3\n" {
+		t.Fatalf("unexpected outputs %q and %q", out1.String(), out2.String())
+	}
+}
+
+func TestInterpreterRunErrors(t *testing.T) {
+	var stdout bytes.Buffer
+	interp := New(Options{Stdout: &stdout})
+
+	err := interp.Run("var = 1;")
+	if _, ok := err.(parser.ErrorList); !ok {
+		t.Fatalf("expected parser.ErrorList. got %T (%v)", err, err)
+	}
+
+	err = interp.RunFile("run.berry", "fun f() {\n  return 1 / 0;\n}\nf();")
+	if err == nil || err.Error() != "run.berry:2:14: Divisor can't be 0." {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// после ошибки внутри функции экземпляр продолжает работать в глобальном окружении
+	if err := interp.Run("var b = 2; print b;"); err != nil {
+		t.Fatalf("run failed. error: %s", err.Error())
+	}
+	if stdout.String() != "2\n" {
+		t.Fatalf("expected output is %q. got %q", "2\n", stdout.String())
+	}
+}
+
+func TestInterpreterConcurrent(t *testing.T) {
+	input := `var sum = 0;
+	for (var i = 0; i < 100; i = i + 1) {
+		sum = sum + i;
+	}
+	print sum;`
+	done := make(chan string)
+	for n := 0; n < 8; n++ {
+		go func() {
+			var stdout bytes.Buffer
+			if err := New(Options{Stdout: &stdout}).Run(input); err != nil {
+				done <- err.Error()
+				return
+			}
+			done <- stdout.String()
+		}()
+	}
+	for n := 0; n < 8; n++ {
+		if out := <-done; out != "4950\n" {
+			t.Errorf("expected output is %q. got %q", "4950\n", out)
+		}
+	}
+}
+
+func TestNativeInput(t *testing.T) {
+	var stdout bytes.Buffer
+	interp := New(Options{Stdout: &stdout, Stdin: strings.NewReader("berry\n")})
+	if err := interp.Run(`var s = input("name: "); print s; print input();`); err != nil {
+		t.Fatalf("run failed. error: %s", err.Error())
+	}
+	expected := "name: berry\nnil\n"
+	if stdout.String() != expected {
+		t.Fatalf("expected output is %q. got %q", expected, stdout.String())
+	}
+}
+
+func TestRuntimeErrorTraceback(t *testing.T) {
+	input := `fun inner(x) {
+    return x / 0;
+}
+fun outer(x) {
+    return inner(x) + 1;
+}
+print outer(3);`
+	err := New(Options{Stdout: ioutil.Discard}).RunFile("trace.berry", input)
+	runErr, ok := err.(*errors.RuntimeError)
+	if !ok {
+		t.Fatalf("expected *errors.RuntimeError. got %T (%v)", err, err)
+	}
+
+	expectedFrames := []errors.Frame{
+		{Function: errors.ScriptFrame},
+		{Function: "outer"},
+		{Function: "inner"},
+	}
+	positions := []string{"trace.berry:7:7", "trace.berry:5:12", "trace.berry:2:16"}
+	frames := runErr.Frames()
+	if len(frames) != len(expectedFrames) {
+		t.Fatalf("should get %d frames. got %d: %v", len(expectedFrames), len(frames), frames)
+	}
+	for i, frame := range frames {
+		if frame.Function != expectedFrames[i].Function || frame.Pos.String() != positions[i] {
+			t.Errorf("frame [%d]: expected %s in %s. got %s", i, positions[i], expectedFrames[i].Function, frame)
+		}
+	}
+
+	expected := `Traceback (most recent call last):
+  trace.berry:7:7 in <script>
+  trace.berry:5:12 in outer
+  trace.berry:2:16 in inner
+trace.berry:2:16: Divisor can't be 0.`
+	if runErr.Traceback() != expected {
+		t.Errorf("expected traceback is\n%s\ngot\n%s", expected, runErr.Traceback())
+	}
+
+	// ошибка на верхнем уровне содержит один кадр
+	err = New(Options{}).RunFile("trace.berry", "print -nil;")
+	if frames := err.(*errors.RuntimeError).Frames(); len(frames) != 1 || frames[0].Function != errors.ScriptFrame {
+		t.Errorf("expected single script frame. got %v", frames)
+	}
+}
+
+func TestEvalTryCatch(t *testing.T) {
+	input := `fun div(a, b) { return a / b; }
+	try {
+		div(1, 0);
+		print "unreachable";
+	} catch (e) {
+		print e.message;
+		print type(e);
+		print len(e.trace);
+	} finally {
+		print "finally";
+	}
+	try { throw "boom"; } catch (e) { print e + "!"; }
+	try {
+		try { throw 42; } finally { print "inner"; }
+	} catch (e) {
+		print e * 2;
+	}
+	try {
+		try { nope; } catch (e) { throw e; }
+	} catch (e) {
+		print e.message;
+	}
+	fun f() {
+		for (var i = 0; i < 5; i = i + 1) {
+			try {
+				if (i == 1) continue;
+				if (i == 2) return i;
+			} finally {
+				print "f" + str(i);
+			}
+		}
+	}
+	print f();
+	fun g() {
+		try { return "try"; } finally { return "finally"; }
+	}
+	print g();
+	var e = "outer";
+	try { throw 1; } catch (e) { }
+	print e;`
+	expected := []string{
+		"Divisor can't be 0.", "error", "2", "finally",
+		"boom!",
+		"inner", "84",
+		"Undefined variable nope.",
+		"f0", "f1", "f2", "2",
+		"finally",
+		"outer",
+	}
+	testEvalPrintStmt(t, input, expected)
+}
+
+func TestUncaughtThrow(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`throw "boom";`, "run.berry:1:1: boom"},
+		{"fun f() {\n  throw 1;\n}\ntry { f(); } finally { print 1; }", "run.berry:2:3: 1"},
+		{"try { 1 / 0; } catch (e) {\n  throw e;\n}", "run.berry:2:3: Divisor can't be 0."},
+	}
+
+	for i, test := range tests {
+		err := New(Options{Stdout: ioutil.Discard}).RunFile("run.berry", test.input)
+		if err == nil {
+			t.Fatalf("test [%d] failed. expected error", i)
+		}
+		if err.Error() != test.expected {
+			t.Errorf("test [%d] expected error is %q. got %q", i, test.expected, err.Error())
+		}
+	}
+}
+
+func TestCaughtErrorTrace(t *testing.T) {
+	input := `fun inner() {
+    return 1 / 0;
+}
+var trace;
+try {
+    inner();
+} catch (e) {
+    trace = e.trace;
+}`
+	interp := New(Options{Stdout: ioutil.Discard})
+	if err := interp.RunFile("trace.berry", input); err != nil {
+		t.Fatalf("run failed. error: %s", err.Error())
+	}
+	trace, _ := interp.GetGlobal("trace")
+	expected := "[trace.berry:6:5 in <script>, trace.berry:2:16 in inner]"
+	if trace.String() != expected {
+		t.Errorf("expected trace is %q. got %q", expected, trace.String())
+	}
+	// после перехвата стек вызовов пуст: следующая ошибка содержит один кадр
+	err := interp.Run("print -nil;")
+	if frames := err.(*errors.RuntimeError).Frames(); len(frames) != 1 {
+		t.Errorf("expected single frame. got %v", frames)
+	}
 }
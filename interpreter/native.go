@@ -0,0 +1,172 @@
+package interpreter
+
+import (
+	"fmt"
+	"github.com/Dor1ma/Strawberry/valuer"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// natives - функции, зарегистрированные через RegisterNative. Они определяются
+// в глобальном окружении каждого нового интерпретатора.
+var (
+	nativesMu sync.RWMutex
+	natives   = map[string]*valuer.NativeFunction{}
+)
+
+// RegisterNative делает Go-функцию доступной из скриптов как глобальную переменную name
+// во всех интерпретаторах, созданных после вызова.
+// arity - число аргументов или valuer.VariadicArity, если fn проверяет их сама.
+func RegisterNative(name string, arity int, fn valuer.NativeFn) {
+	nativesMu.Lock()
+	defer nativesMu.Unlock()
+	natives[name] = &valuer.NativeFunction{Name: name, NumParams: arity, Fn: fn}
+}
+
+// DefineNative определяет встроенную функцию только в этом интерпретаторе
+func (interp *Interpreter) DefineNative(name string, arity int, fn valuer.NativeFn) {
+	interp.globals.Define(name, &valuer.NativeFunction{Name: name, NumParams: arity, Fn: fn})
+}
+
+func (interp *Interpreter) defineNatives() {
+	interp.DefineNative("len", 1, nativeLen)
+	interp.DefineNative("clock", 0, nativeClock)
+	interp.DefineNative("str", 1, nativeStr)
+	interp.DefineNative("num", 1, nativeNum)
+	interp.DefineNative("type", 1, nativeType)
+	interp.DefineNative("input", valuer.VariadicArity, interp.nativeInput)
+	interp.DefineNative("push", 2, nativePush)
+	interp.DefineNative("pop", 1, nativePop)
+	interp.defineAsserts()
+
+	nativesMu.RLock()
+	defer nativesMu.RUnlock()
+	for name, native := range natives {
+		interp.globals.Define(name, native)
+	}
+}
+
+func nativeLen(args []valuer.Valuer) (valuer.Valuer, error) {
+	switch v := args[0].(type) {
+	case *valuer.String:
+		return &valuer.Number{Value: float64(utf8.RuneCountInString(v.Value))}, nil
+	case *valuer.Array:
+		return &valuer.Number{Value: float64(len(v.Elements))}, nil
+	case *valuer.Map:
+		return &valuer.Number{Value: float64(v.Len())}, nil
+	}
+	return nil, fmt.Errorf("len() expects a string, array or map, got %s.", args[0].Type())
+}
+
+func nativeClock(args []valuer.Valuer) (valuer.Valuer, error) {
+	return &valuer.Number{Value: float64(time.Now().UnixNano()) / float64(time.Second)}, nil
+}
+
+func nativeStr(args []valuer.Valuer) (valuer.Valuer, error) {
+	if s, ok := args[0].(*valuer.String); ok {
+		return s, nil
+	}
+	return &valuer.String{Value: args[0].String()}, nil
+}
+
+func nativeNum(args []valuer.Valuer) (valuer.Valuer, error) {
+	switch v := args[0].(type) {
+	case *valuer.Number:
+		return v, nil
+	case *valuer.Boolean:
+		if v.Value {
+			return &valuer.Number{Value: 1}, nil
+		}
+		return &valuer.Number{Value: 0}, nil
+	case *valuer.String:
+		n, err := strconv.ParseFloat(strings.TrimSpace(v.Value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("num() cannot convert %q to a number.", v.Value)
+		}
+		return &valuer.Number{Value: n}, nil
+	}
+	return nil, fmt.Errorf("num() cannot convert %s to a number.", args[0].Type())
+}
+
+func nativeType(args []valuer.Valuer) (valuer.Valuer, error) {
+	return &valuer.String{Value: args[0].Type().String()}, nil
+}
+
+// nativeInput печатает необязательное приглашение и читает строку из stdin. В конце ввода возвращает nil.
+func (interp *Interpreter) nativeInput(args []valuer.Valuer) (valuer.Valuer, error) {
+	if len(args) > 1 {
+		return nil, fmt.Errorf("Expected at most 1 arguments but got %d", len(args))
+	}
+	if len(args) == 1 {
+		fmt.Fprint(interp.stdout, args[0])
+	}
+	line, err := interp.stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return Nil, nil
+	}
+	return &valuer.String{Value: strings.TrimRight(line, "\r\n")}, nil
+}
+
+// nativePush добавляет элемент в конец массива и возвращает новую длину
+func nativePush(args []valuer.Valuer) (valuer.Valuer, error) {
+	array, ok := args[0].(*valuer.Array)
+	if !ok {
+		return nil, fmt.Errorf("push() expects an array, got %s.", args[0].Type())
+	}
+	array.Elements = append(array.Elements, args[1])
+	return &valuer.Number{Value: float64(len(array.Elements))}, nil
+}
+
+// nativePop удаляет и возвращает последний элемент массива
+func nativePop(args []valuer.Valuer) (valuer.Valuer, error) {
+	array, ok := args[0].(*valuer.Array)
+	if !ok {
+		return nil, fmt.Errorf("pop() expects an array, got %s.", args[0].Type())
+	}
+	if len(array.Elements) == 0 {
+		return nil, fmt.Errorf("pop() from empty array.")
+	}
+	last := array.Elements[len(array.Elements)-1]
+	array.Elements = array.Elements[:len(array.Elements)-1]
+	return last, nil
+}
+
+// mapMethod возвращает встроенный метод словаря, привязанный к m, или nil, если метода нет
+func mapMethod(m *valuer.Map, name string) *valuer.NativeFunction {
+	arity, ok := valuer.MapMethodArity[name]
+	if !ok {
+		return nil
+	}
+	method := &valuer.NativeFunction{Name: "map." + name, NumParams: arity}
+	switch name {
+	case "keys":
+		method.Fn = func(args []valuer.Valuer) (valuer.Valuer, error) {
+			return &valuer.Array{Elements: m.Keys()}, nil
+		}
+	case "len":
+		method.Fn = func(args []valuer.Valuer) (valuer.Valuer, error) {
+			return &valuer.Number{Value: float64(m.Len())}, nil
+		}
+	case "has":
+		method.Fn = func(args []valuer.Valuer) (valuer.Valuer, error) {
+			key, ok := valuer.NewMapKey(args[0])
+			if !ok {
+				return False, nil
+			}
+			_, ok = m.Get(key)
+			return toBooleanValuer(ok), nil
+		}
+	case "delete":
+		method.Fn = func(args []valuer.Valuer) (valuer.Valuer, error) {
+			key, ok := valuer.NewMapKey(args[0])
+			if !ok {
+				return False, nil
+			}
+			return toBooleanValuer(m.Delete(key)), nil
+		}
+	}
+	return method
+}
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"text/scanner"
 	"unicode"
+	"unicode/utf8"
 )
 
 var eof = rune(-1)
@@ -21,12 +22,32 @@ var (
 
 	// ошибки для чисел
 	errLessPower = errors.New("power is required")
+
+	// ошибки для комментариев
+	errUnterminatedComment = errors.New("unterminated comment")
+
+	// символ, с которого не начинается ни один токен
+	errUnexpectedChar = errors.New("unexpected character")
+)
+
+// Mode - набор флагов, управляющих работой лексера
+type Mode uint
+
+const (
+	// ScanComments - возвращать комментарии как токены token.Comment вместо того, чтобы их пропускать
+	ScanComments Mode = 1 << iota
 )
 
+// ErrorHandler получает лексические ошибки вместе с их позицией
+type ErrorHandler func(pos token.Position, msg string)
+
 // Lexer - делает лексический анализ текста и разбивает его на токены
 type Lexer struct {
 	s        *scanner.Scanner
 	char     rune
+	pos      token.Position // позиция символа char
+	mode     Mode
+	err      ErrorHandler
 	tokenBuf *strings.Builder
 }
 
@@ -34,6 +55,11 @@ func (l *Lexer) consume() {
 	if l.isAtEnd() {
 		return
 	}
+	l.advance()
+	l.read()
+}
+
+func (l *Lexer) read() {
 	char := l.s.Next()
 	if char == scanner.EOF {
 		l.char = eof
@@ -42,6 +68,21 @@ func (l *Lexer) consume() {
 	l.char = char
 }
 
+// advance сдвигает позицию за текущий символ
+func (l *Lexer) advance() {
+	size := utf8.RuneLen(l.char)
+	if size < 0 {
+		size = 1
+	}
+	l.pos.Offset += size
+	if l.char == '\n' {
+		l.pos.Line++
+		l.pos.Column = 1
+	} else {
+		l.pos.Column++
+	}
+}
+
 func (l *Lexer) peek() rune {
 	ch := l.s.Peek()
 	return ch
@@ -67,7 +108,15 @@ func (l *Lexer) match(ch rune) bool {
 }
 
 func (l *Lexer) error(msg string) {
-	fmt.Fprintf(os.Stderr, "%s %s\n", l.Pos().String(), msg)
+	l.errorAt(l.pos, msg)
+}
+
+func (l *Lexer) errorAt(pos token.Position, msg string) {
+	if l.err != nil {
+		l.err(pos, msg)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s: %s\n", pos, msg)
 }
 
 func (l *Lexer) readIdentifier() string {
@@ -165,10 +214,70 @@ func (l *Lexer) readNumber() (string, error) {
 	return l.tokenBuf.String(), nil
 }
 
-// NextToken читает и возвращает токены или литералы
-func (l *Lexer) NextToken() (tok token.Token, literal string) {
-	l.skip()
+// readLineComment читает комментарий вида // до конца строки
+func (l *Lexer) readLineComment() string {
+	l.tokenBuf.Reset()
+	for l.char != '\n' && !l.isAtEnd() {
+		l.tokenBuf.WriteRune(l.char)
+		l.consume()
+	}
+	return l.tokenBuf.String()
+}
 
+// readBlockComment читает комментарий вида /* ... */, который может содержать вложенные комментарии
+func (l *Lexer) readBlockComment() (string, error) {
+	start := l.pos
+	l.tokenBuf.Reset()
+	depth := 0
+	for {
+		if l.isAtEnd() {
+			l.errorAt(start, errUnterminatedComment.Error())
+			return "", errUnterminatedComment
+		}
+		if l.char == '/' && l.peek() == '*' {
+			depth++
+		} else if l.char == '*' && l.peek() == '/' {
+			depth--
+		} else {
+			l.tokenBuf.WriteRune(l.char)
+			l.consume()
+			continue
+		}
+		l.tokenBuf.WriteRune(l.char)
+		l.consume()
+		l.tokenBuf.WriteRune(l.char)
+		l.consume()
+		if depth == 0 {
+			return l.tokenBuf.String(), nil
+		}
+	}
+}
+
+// NextToken читает и возвращает токены или литералы вместе с их положением в тексте.
+// Комментарии пропускаются, если не включен режим ScanComments.
+func (l *Lexer) NextToken() (tok token.Token, literal string, span token.Span) {
+	for {
+		l.skip()
+		span.From = l.pos
+		tok, literal = l.scan()
+		span.To = l.pos
+		if tok != token.Comment || l.mode&ScanComments != 0 {
+			return
+		}
+	}
+}
+
+// SetMode задает режим работы лексера
+func (l *Lexer) SetMode(mode Mode) {
+	l.mode = mode
+}
+
+// SetErrorHandler задает обработчик ошибок. По умолчанию ошибки печатаются в stderr.
+func (l *Lexer) SetErrorHandler(handler ErrorHandler) {
+	l.err = handler
+}
+
+func (l *Lexer) scan() (tok token.Token, literal string) {
 	switch l.char {
 	case '(':
 		tok = token.LeftParen
@@ -191,6 +300,9 @@ func (l *Lexer) NextToken() (tok token.Token, literal string) {
 	case ',':
 		tok = token.Comma
 		literal = ","
+	case ':':
+		tok = token.Colon
+		literal = ":"
 	case '.':
 		tok = token.Dot
 		literal = "."
@@ -204,6 +316,16 @@ func (l *Lexer) NextToken() (tok token.Token, literal string) {
 		tok = token.Semicolon
 		literal = ";"
 	case '/':
+		switch l.peek() {
+		case '/':
+			return token.Comment, l.readLineComment()
+		case '*':
+			comment, err := l.readBlockComment()
+			if err != nil {
+				return token.Illegal, ""
+			}
+			return token.Comment, comment
+		}
 		tok = token.Slash
 		literal = "/"
 	case '*':
@@ -219,10 +341,17 @@ func (l *Lexer) NextToken() (tok token.Token, literal string) {
 		}
 		return
 	case '=':
-		if l.match('=') {
+		l.consume()
+		switch l.char {
+		case '=':
+			l.consume()
 			tok = token.EqualEqual
 			literal = "=="
-		} else {
+		case '>':
+			l.consume()
+			tok = token.Arrow
+			literal = "=>"
+		default:
 			tok = token.Equal
 			literal = "="
 		}
@@ -271,6 +400,7 @@ func (l *Lexer) NextToken() (tok token.Token, literal string) {
 			return
 		}
 
+		l.error(fmt.Sprintf("%s %q", errUnexpectedChar, l.char))
 		tok = token.Illegal
 		literal = ""
 	}
@@ -279,8 +409,9 @@ func (l *Lexer) NextToken() (tok token.Token, literal string) {
 	return
 }
 
-func (l *Lexer) Pos() scanner.Position {
-	return l.s.Pos()
+// Pos возвращает позицию текущего символа
+func (l *Lexer) Pos() token.Position {
+	return l.pos
 }
 
 func charCode2Rune(code string) rune {
@@ -295,12 +426,19 @@ func isAlphaNumeric(ch rune) bool { return unicode.IsLetter(ch) || unicode.IsNum
 
 // конструктор
 func New(input string) *Lexer {
+	return NewFile("", input)
+}
+
+// NewFile создает лексер, позиции которого ссылаются на файл filename
+func NewFile(filename, input string) *Lexer {
 	s := &scanner.Scanner{}
 	s.Init(strings.NewReader(input))
+	s.Filename = filename
 	l := &Lexer{
 		s:        s,
+		pos:      token.Position{Filename: filename, Line: 1, Column: 1},
 		tokenBuf: &strings.Builder{},
 	}
-	l.consume()
+	l.read()
 	return l
 }
@@ -12,7 +12,8 @@ func TestReadSimpleToken(t *testing.T) {
 / * !
 = == !=
 > >=
-< <=`
+< <=
+: =>`
 	l := New(input)
 	tests := []struct {
 		expectTok     token.Token
@@ -40,10 +41,12 @@ func TestReadSimpleToken(t *testing.T) {
 		{token.GreaterThanOrEqual, ">="},
 		{token.Less, "<"},
 		{token.LessThanOrEqual, "<="},
+		{token.Colon, ":"},
+		{token.Arrow, "=>"},
 	}
 
 	for i, test := range tests {
-		tok, literal := l.NextToken()
+		tok, literal, _ := l.NextToken()
 		if test.expectTok != tok {
 			t.Fatalf("test [%d]: expected token is %s. got %s", i, test.expectTok, tok)
 		}
@@ -53,7 +56,7 @@ func TestReadSimpleToken(t *testing.T) {
 		}
 	}
 
-	tok, _ := l.NextToken()
+	tok, _, _ := l.NextToken()
 	if token.EOF != tok {
 		t.Fatalf("expected token is EOF. got %s", tok)
 	}
@@ -64,13 +67,13 @@ func TestReadString(t *testing.T) {
 		"",
 		"abc xyz",
 		"字符串",
-		"lox语言",
+		"strawberry语言",
 	}
 	input := `"" "abc xyz" "\u5b57符串" "strawberry\u8Bed言"`
 
 	l := New(input)
 	for _, expected := range tests {
-		tok, literal := l.NextToken()
+		tok, literal, _ := l.NextToken()
 
 		if tok != token.String {
 			t.Fatalf("expected token is string. got %s", tok)
@@ -92,7 +95,7 @@ func TestReadInvalidString(t *testing.T) {
 
 	for i, test := range tests {
 		l := New(test)
-		tok, literal := l.NextToken()
+		tok, literal, _ := l.NextToken()
 
 		if tok != token.Illegal {
 			t.Fatalf("test [%d]: expected token is illegal. got %s", i, tok)
@@ -110,7 +113,8 @@ abc 		xyz 		a123 		A_123			X_x_
 and 		class 	else 		false 		fun
 for 		if 			nil 		or 				print
 return 	super 	this 		true			var
-while
+while 	break 	continue
+try 		catch 	finally 	throw
 `
 	tests := []struct {
 		expectTok     token.Token
@@ -141,11 +145,18 @@ while
 		{token.Var, "var"},
 
 		{token.While, "while"},
+		{token.Break, "break"},
+		{token.Continue, "continue"},
+
+		{token.Try, "try"},
+		{token.Catch, "catch"},
+		{token.Finally, "finally"},
+		{token.Throw, "throw"},
 	}
 	l := New(input)
 
 	for i, test := range tests {
-		tok, literal := l.NextToken()
+		tok, literal, _ := l.NextToken()
 
 		if tok != test.expectTok {
 			t.Fatalf("test [%d]: expected token is %s. got %s", i, test.expectTok, tok)
@@ -184,7 +195,7 @@ func TestReadNumber(t *testing.T) {
 	}
 
 	for i, test := range tests {
-		tok, literal := l.NextToken()
+		tok, literal, _ := l.NextToken()
 		if tok != token.Number {
 			t.Fatalf("test [%d]: expected token is number. got %s", i, tok)
 		}
@@ -205,7 +216,132 @@ func TestReadInvalidNumber(t *testing.T) {
 
 	for i, test := range tests {
 		l := New(test)
-		tok, literal := l.NextToken()
+		tok, literal, _ := l.NextToken()
+
+		if tok != token.Illegal {
+			t.Fatalf("test [%d]: expected token is illegal. got %s", i, tok)
+		}
+
+		if literal != "" {
+			t.Fatalf("test [%d]: expected literal is empty string. got %q", i, literal)
+		}
+	}
+}
+
+func TestTokenPosition(t *testing.T) {
+	input := "var abc = 12;\n  print \"ю\" + abc;"
+	tests := []struct {
+		expectTok token.Token
+		from      string
+		to        string
+		offset    int
+	}{
+		{token.Var, "test.berry:1:1", "test.berry:1:4", 0},
+		{token.Identifier, "test.berry:1:5", "test.berry:1:8", 4},
+		{token.Equal, "test.berry:1:9", "test.berry:1:10", 8},
+		{token.Number, "test.berry:1:11", "test.berry:1:13", 10},
+		{token.Semicolon, "test.berry:1:13", "test.berry:1:14", 12},
+		{token.Print, "test.berry:2:3", "test.berry:2:8", 16},
+		{token.String, "test.berry:2:9", "test.berry:2:12", 22},
+		{token.Plus, "test.berry:2:13", "test.berry:2:14", 27},
+		{token.Identifier, "test.berry:2:15", "test.berry:2:18", 29},
+		{token.Semicolon, "test.berry:2:18", "test.berry:2:19", 32},
+		{token.EOF, "test.berry:2:19", "test.berry:2:19", 33},
+	}
+
+	l := NewFile("test.berry", input)
+	for i, test := range tests {
+		tok, _, span := l.NextToken()
+		if tok != test.expectTok {
+			t.Fatalf("test [%d]: expected token is %s. got %s", i, test.expectTok, tok)
+		}
+		if span.From.String() != test.from || span.To.String() != test.to {
+			t.Fatalf("test [%d]: expected span is %s-%s. got %s-%s", i, test.from, test.to, span.From, span.To)
+		}
+		if span.From.Offset != test.offset {
+			t.Fatalf("test [%d]: expected offset is %d. got %d", i, test.offset, span.From.Offset)
+		}
+	}
+}
+
+func TestSkipComments(t *testing.T) {
+	input := `// comment at start
+var a = 1; // trailing comment
+/* block
+   comment */ print a / 2;
+/* outer /* nested */ still comment */ a;`
+	tests := []struct {
+		expectTok     token.Token
+		expectLiteral string
+	}{
+		{token.Var, "var"},
+		{token.Identifier, "a"},
+		{token.Equal, "="},
+		{token.Number, "1"},
+		{token.Semicolon, ";"},
+		{token.Print, "print"},
+		{token.Identifier, "a"},
+		{token.Slash, "/"},
+		{token.Number, "2"},
+		{token.Semicolon, ";"},
+		{token.Identifier, "a"},
+		{token.Semicolon, ";"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, test := range tests {
+		tok, literal, _ := l.NextToken()
+		if tok != test.expectTok {
+			t.Fatalf("test [%d]: expected token is %s. got %s", i, test.expectTok, tok)
+		}
+		if literal != test.expectLiteral {
+			t.Fatalf("test [%d]: expected literal is %q. got %q", i, test.expectLiteral, literal)
+		}
+	}
+}
+
+func TestScanComments(t *testing.T) {
+	input := "a; // line\n/* a /* b */ c */\n// last"
+	tests := []struct {
+		expectTok     token.Token
+		expectLiteral string
+		from          string
+	}{
+		{token.Identifier, "a", "1:1"},
+		{token.Semicolon, ";", "1:2"},
+		{token.Comment, "// line", "1:4"},
+		{token.Comment, "/* a /* b */ c */", "2:1"},
+		{token.Comment, "// last", "3:1"},
+		{token.EOF, "", "3:8"},
+	}
+
+	l := New(input)
+	l.SetMode(ScanComments)
+	for i, test := range tests {
+		tok, literal, span := l.NextToken()
+		if tok != test.expectTok {
+			t.Fatalf("test [%d]: expected token is %s. got %s", i, test.expectTok, tok)
+		}
+		if literal != test.expectLiteral {
+			t.Fatalf("test [%d]: expected literal is %q. got %q", i, test.expectLiteral, literal)
+		}
+		if span.From.String() != test.from {
+			t.Fatalf("test [%d]: expected position is %s. got %s", i, test.from, span.From)
+		}
+	}
+}
+
+func TestReadUnterminatedComment(t *testing.T) {
+	tests := []string{
+		"/* abc",
+		"/* a /* b */",
+		"/*/",
+	}
+
+	for i, test := range tests {
+		l := New(test)
+		tok, literal, span := l.NextToken()
 
 		if tok != token.Illegal {
 			t.Fatalf("test [%d]: expected token is illegal. got %s", i, tok)
@@ -214,5 +350,9 @@ func TestReadInvalidNumber(t *testing.T) {
 		if literal != "" {
 			t.Fatalf("test [%d]: expected literal is empty string. got %q", i, literal)
 		}
+
+		if span.From.String() != "1:1" {
+			t.Fatalf("test [%d]: expected position is 1:1. got %s", i, span.From)
+		}
 	}
 }
@@ -0,0 +1,344 @@
+package lsp
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/Dor1ma/Strawberry/ast"
+	"github.com/Dor1ma/Strawberry/errors"
+	"github.com/Dor1ma/Strawberry/lexer"
+	"github.com/Dor1ma/Strawberry/parser"
+	"github.com/Dor1ma/Strawberry/resolver"
+	"github.com/Dor1ma/Strawberry/token"
+)
+
+// reference - использование или объявление имени в документе
+type reference struct {
+	span token.Span
+	sym  *resolver.Symbol
+}
+
+// document - открытый в редакторе файл и результат его анализа
+type document struct {
+	uri   string
+	text  string
+	lines []int // смещения начала строк в байтах
+
+	statements  []ast.Statement
+	diagnostics []Diagnostic
+	symbols     []*resolver.Symbol
+	globals     map[string]*resolver.Symbol
+	refs        []reference
+	details     map[int]string // описание объявления по смещению его имени
+}
+
+func newDocument(uri, text string) *document {
+	doc := &document{
+		uri:     uri,
+		text:    text,
+		lines:   []int{0},
+		globals: make(map[string]*resolver.Symbol),
+		details: make(map[int]string),
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			doc.lines = append(doc.lines, i+1)
+		}
+	}
+	doc.analyze()
+	return doc
+}
+
+// analyze разбирает текст, разрешает имена и собирает диагностики и символы
+func (doc *document) analyze() {
+	statements, err := parser.New(lexer.NewFile(doc.uri, doc.text)).Parse()
+	doc.statements = statements
+	if list, ok := err.(parser.ErrorList); ok {
+		for _, e := range list {
+			doc.addDiagnostic(e.Pos, e.Msg)
+		}
+	}
+
+	// Resolver останавливается на первой ошибке, поэтому каждый оператор верхнего уровня
+	// разрешается отдельно: так видны ошибки во всем файле, а не только первая
+	var uses []reference
+	for _, stmt := range statements {
+		res := resolver.New()
+		res.SetListener(listener{doc: doc, uses: &uses})
+		if err := resolve(res, stmt); err != nil {
+			doc.addDiagnostic(err.Pos(), err.Msg())
+		}
+	}
+	// глобальное имя можно использовать до объявления, поэтому связываем их в конце
+	for _, use := range uses {
+		if use.sym == nil {
+			use.sym = doc.globals[doc.text[use.span.From.Offset:use.span.To.Offset]]
+		}
+		doc.refs = append(doc.refs, use)
+	}
+	sort.SliceStable(doc.refs, func(i, j int) bool {
+		return doc.refs[i].span.From.Offset < doc.refs[j].span.From.Offset
+	})
+
+	for _, stmt := range statements {
+		ast.Inspect(stmt, doc.describe)
+	}
+}
+
+func resolve(res *resolver.Resolver, stmt ast.Statement) (err *errors.RuntimeError) {
+	defer func() {
+		if r := recover(); r != nil {
+			runErr, ok := r.(errors.RuntimeError)
+			if !ok {
+				panic(r)
+			}
+			err = &runErr
+		}
+	}()
+	res.Resolve(stmt)
+	return nil
+}
+
+// listener собирает объявления и использования имен одного оператора
+type listener struct {
+	doc  *document
+	uses *[]reference
+}
+
+func (l listener) Declare(sym *resolver.Symbol) {
+	l.doc.symbols = append(l.doc.symbols, sym)
+	if sym.Global() {
+		if _, ok := l.doc.globals[sym.Name]; !ok {
+			l.doc.globals[sym.Name] = sym
+		}
+	}
+	l.doc.refs = append(l.doc.refs, reference{span: sym.Span, sym: sym})
+}
+
+func (l listener) Use(name string, span token.Span, sym *resolver.Symbol) {
+	*l.uses = append(*l.uses, reference{span: span, sym: sym})
+}
+
+// describe запоминает описания объявлений для подсказок
+func (doc *document) describe(node ast.Node) bool {
+	switch n := node.(type) {
+	case *ast.FunctionStmt:
+		doc.details[n.NameSpan.From.Offset] = "fun " + n.Name + "(" + params(n.Params) + ")"
+		for _, param := range n.Params {
+			doc.details[param.Pos().Offset] = "parameter " + param.Name
+		}
+	case *ast.FunctionExpr:
+		for _, param := range n.Params {
+			doc.details[param.Pos().Offset] = "parameter " + param.Name
+		}
+	case *ast.ClassStmt:
+		detail := "class " + n.Name
+		if n.SuperClass != nil {
+			detail += " < " + n.SuperClass.Name
+		}
+		doc.details[n.NameSpan.From.Offset] = detail
+	case *ast.VarStmt:
+		doc.details[n.Name.Pos().Offset] = "var " + n.Name.Name
+	case *ast.TryStmt:
+		if n.CatchName != nil {
+			doc.details[n.CatchName.Pos().Offset] = "var " + n.CatchName.Name
+		}
+	}
+	return true
+}
+
+func params(idents []*ast.Identifier) string {
+	names := make([]string, len(idents))
+	for i, ident := range idents {
+		names[i] = ident.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+func (doc *document) addDiagnostic(pos token.Position, msg string) {
+	doc.diagnostics = append(doc.diagnostics, Diagnostic{
+		Range:    doc.wordRange(pos),
+		Severity: SeverityError,
+		Source:   "strawberry",
+		Message:  msg,
+	})
+}
+
+// wordRange возвращает участок идентификатора, который начинается в pos, или один символ
+func (doc *document) wordRange(pos token.Position) Range {
+	from := pos.Offset
+	if from > len(doc.text) {
+		from = len(doc.text)
+	}
+	to := from
+	for to < len(doc.text) && isIdentChar(doc.text[to]) {
+		to++
+	}
+	if to == from && to < len(doc.text) && doc.text[to] != '\n' {
+		_, size := utf8.DecodeRuneInString(doc.text[to:])
+		to += size
+	}
+	return Range{Start: doc.position(from), End: doc.position(to)}
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// position переводит смещение в байтах в позицию LSP
+func (doc *document) position(offset int) Position {
+	line := sort.Search(len(doc.lines), func(i int) bool { return doc.lines[i] > offset }) - 1
+	return Position{Line: line, Character: utf16Len(doc.text[doc.lines[line]:offset])}
+}
+
+// offset переводит позицию LSP в смещение в байтах
+func (doc *document) offset(pos Position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(doc.lines) {
+		return len(doc.text)
+	}
+	offset := doc.lines[pos.Line]
+	for units := 0; units < pos.Character && offset < len(doc.text) && doc.text[offset] != '\n'; {
+		r, size := utf8.DecodeRuneInString(doc.text[offset:])
+		units += len(utf16.Encode([]rune{r}))
+		offset += size
+	}
+	return offset
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += len(utf16.Encode([]rune{r}))
+	}
+	return n
+}
+
+func (doc *document) spanRange(span token.Span) Range {
+	return Range{Start: doc.position(span.From.Offset), End: doc.position(span.To.Offset)}
+}
+
+// symbolAt возвращает объявление имени под курсором
+func (doc *document) symbolAt(pos Position) (*resolver.Symbol, token.Span) {
+	offset := doc.offset(pos)
+	for _, ref := range doc.refs {
+		if ref.sym != nil && ref.span.From.Offset <= offset && offset <= ref.span.To.Offset {
+			return ref.sym, ref.span
+		}
+	}
+	return nil, token.Span{}
+}
+
+// references возвращает все места, где встречается объявление sym
+func (doc *document) references(sym *resolver.Symbol, includeDeclaration bool) []Location {
+	locations := []Location{}
+	for _, ref := range doc.refs {
+		if ref.sym != sym || !includeDeclaration && ref.span == sym.Span {
+			continue
+		}
+		locations = append(locations, Location{URI: doc.uri, Range: doc.spanRange(ref.span)})
+	}
+	return locations
+}
+
+func (doc *document) detail(sym *resolver.Symbol) string {
+	if detail, ok := doc.details[sym.Span.From.Offset]; ok {
+		return detail
+	}
+	return sym.Name
+}
+
+// outline возвращает функции и классы документа с вложенными в них объявлениями
+func (doc *document) outline() []DocumentSymbol {
+	return doc.symbolsIn(doc.statements)
+}
+
+func (doc *document) symbolsIn(statements []ast.Statement) []DocumentSymbol {
+	result := []DocumentSymbol{}
+	visit := func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.FunctionStmt:
+			result = append(result, doc.function(n, SymbolKindFunction))
+			return false
+		case *ast.ClassStmt:
+			class := DocumentSymbol{
+				Name:           n.Name,
+				Detail:         doc.details[n.NameSpan.From.Offset],
+				Kind:           SymbolKindClass,
+				Range:          doc.spanRange(n.Span),
+				SelectionRange: doc.spanRange(n.NameSpan),
+			}
+			for _, method := range n.Methods {
+				class.Children = append(class.Children, doc.function(method, SymbolKindMethod))
+			}
+			result = append(result, class)
+			return false
+		}
+		return true
+	}
+	for _, stmt := range statements {
+		ast.Inspect(stmt, visit)
+	}
+	return result
+}
+
+func (doc *document) function(fn *ast.FunctionStmt, kind int) DocumentSymbol {
+	return DocumentSymbol{
+		Name:           fn.Name,
+		Detail:         "(" + params(fn.Params) + ")",
+		Kind:           kind,
+		Range:          doc.spanRange(fn.Span),
+		SelectionRange: doc.spanRange(fn.NameSpan),
+		Children:       doc.symbolsIn(fn.Body),
+	}
+}
+
+// completion возвращает ключевые слова, встроенные функции и имена, видимые в позиции pos
+func (doc *document) completion(pos Position, natives []string) []CompletionItem {
+	offset := doc.offset(pos)
+	seen := make(map[string]bool)
+	items := []CompletionItem{}
+	add := func(item CompletionItem) {
+		if !seen[item.Label] {
+			seen[item.Label] = true
+			items = append(items, item)
+		}
+	}
+
+	// сначала ближайшие локальные имена, они перекрывают внешние
+	for i := len(doc.symbols) - 1; i >= 0; i-- {
+		sym := doc.symbols[i]
+		if sym.Global() || sym.Span.From.Offset >= offset {
+			continue
+		}
+		if sym.Scope.From.Offset <= offset && offset <= sym.Scope.To.Offset {
+			add(CompletionItem{Label: sym.Name, Kind: completionKind(sym.Kind), Detail: doc.detail(sym)})
+		}
+	}
+	for _, sym := range doc.symbols {
+		if sym.Global() {
+			add(CompletionItem{Label: sym.Name, Kind: completionKind(sym.Kind), Detail: doc.detail(sym)})
+		}
+	}
+	for _, name := range natives {
+		add(CompletionItem{Label: name, Kind: CompletionKindFunction, Detail: "built-in"})
+	}
+	for _, keyword := range token.Keywords() {
+		add(CompletionItem{Label: keyword, Kind: CompletionKindKeyword})
+	}
+	return items
+}
+
+func completionKind(kind resolver.SymbolKind) int {
+	switch kind {
+	case resolver.FunctionSymbol:
+		return CompletionKindFunction
+	case resolver.ClassSymbol:
+		return CompletionKindClass
+	}
+	return CompletionKindVariable
+}
@@ -0,0 +1,269 @@
+// Package lsp реализует языковой сервер Strawberry по протоколу Language Server Protocol.
+// Сервер держит открытые документы в памяти и заново анализирует документ при каждом изменении.
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/Dor1ma/Strawberry/interpreter"
+)
+
+// Server - языковой сервер, работающий с одним клиентом
+type Server struct {
+	conn        *conn
+	initialized bool
+	shutdown    bool
+	natives     []string // встроенные функции для автодополнения
+
+	mu   sync.Mutex
+	docs map[string]*document
+}
+
+// handler обрабатывает запрос или уведомление; результат уведомления не отправляется
+type handler func(s *Server, params json.RawMessage) (interface{}, error)
+
+var handlers = map[string]handler{
+	"initialize":                  (*Server).initialize,
+	"initialized":                 noop,
+	"shutdown":                    (*Server).shutdownRequest,
+	"textDocument/didOpen":        (*Server).didOpen,
+	"textDocument/didChange":      (*Server).didChange,
+	"textDocument/didClose":       (*Server).didClose,
+	"textDocument/definition":     (*Server).definition,
+	"textDocument/references":     (*Server).references,
+	"textDocument/documentSymbol": (*Server).documentSymbol,
+	"textDocument/completion":     (*Server).completion,
+	"textDocument/hover":          (*Server).hover,
+}
+
+// конструктор; сервер читает сообщения из in и пишет ответы в out
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		conn:    newConn(in, out),
+		natives: interpreter.New(interpreter.Options{Stdout: io.Discard, Stderr: io.Discard}).Globals(),
+		docs:    make(map[string]*document),
+	}
+}
+
+// Run обрабатывает сообщения, пока клиент не пришлет exit или не закроет поток.
+// Возвращает nil, если перед exit был shutdown
+func (s *Server) Run() error {
+	for {
+		msg, err := s.conn.read()
+		if err == io.EOF {
+			return nil
+		}
+		if respErr, ok := err.(*responseError); ok {
+			// id запроса не прочитать, по спецификации в ответе будет null
+			id := json.RawMessage("null")
+			if err := s.conn.write(&message{ID: &id, Error: respErr}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exit without shutdown")
+			}
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(msg *message) error {
+	if msg.Method == "" {
+		return nil // ответ клиента на запрос сервера, сервер их не отправляет
+	}
+	h, ok := handlers[msg.Method]
+	var result interface{}
+	var err error
+	switch {
+	case !ok:
+		err = &responseError{Code: codeMethodNotFound, Message: "method not found: " + msg.Method}
+	case !s.initialized && msg.Method != "initialize":
+		err = &responseError{Code: codeNotInitialized, Message: "server not initialized"}
+	default:
+		result, err = h(s, msg.Params)
+	}
+	if msg.ID == nil {
+		return nil
+	}
+
+	resp := &message{ID: msg.ID}
+	if err != nil {
+		respErr, ok := err.(*responseError)
+		if !ok {
+			respErr = &responseError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		resp.Error = respErr
+	} else if resp.Result, err = json.Marshal(result); err != nil {
+		return err
+	}
+	return s.conn.write(resp)
+}
+
+func noop(s *Server, params json.RawMessage) (interface{}, error) {
+	return nil, nil
+}
+
+func (s *Server) initialize(params json.RawMessage) (interface{}, error) {
+	s.initialized = true
+	var result InitializeResult
+	result.ServerInfo.Name = "strawberry"
+	result.Capabilities = ServerCapabilities{
+		TextDocumentSync:       TextDocumentSyncFull,
+		DefinitionProvider:     true,
+		ReferencesProvider:     true,
+		DocumentSymbolProvider: true,
+		HoverProvider:          true,
+	}
+	return result, nil
+}
+
+func (s *Server) shutdownRequest(params json.RawMessage) (interface{}, error) {
+	s.shutdown = true
+	return nil, nil
+}
+
+func (s *Server) didOpen(params json.RawMessage) (interface{}, error) {
+	var p DidOpenTextDocumentParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	return nil, s.update(p.TextDocument.URI, p.TextDocument.Text)
+}
+
+func (s *Server) didChange(params json.RawMessage) (interface{}, error) {
+	var p DidChangeTextDocumentParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	if len(p.ContentChanges) == 0 {
+		return nil, nil
+	}
+	// синхронизация полная: последнее изменение содержит весь текст
+	return nil, s.update(p.TextDocument.URI, p.ContentChanges[len(p.ContentChanges)-1].Text)
+}
+
+func (s *Server) didClose(params json.RawMessage) (interface{}, error) {
+	var p DidCloseTextDocumentParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	delete(s.docs, p.TextDocument.URI)
+	s.mu.Unlock()
+	// закрытый документ больше не должен показывать ошибки
+	return nil, s.conn.notify("textDocument/publishDiagnostics",
+		PublishDiagnosticsParams{URI: p.TextDocument.URI, Diagnostics: []Diagnostic{}})
+}
+
+// update анализирует новый текст документа и публикует его диагностики
+func (s *Server) update(uri, text string) error {
+	doc := newDocument(uri, text)
+	s.mu.Lock()
+	s.docs[uri] = doc
+	s.mu.Unlock()
+
+	diagnostics := doc.diagnostics
+	if diagnostics == nil {
+		diagnostics = []Diagnostic{}
+	}
+	return s.conn.notify("textDocument/publishDiagnostics",
+		PublishDiagnosticsParams{URI: uri, Diagnostics: diagnostics})
+}
+
+func (s *Server) document(uri string) (*document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	doc, ok := s.docs[uri]
+	if !ok {
+		return nil, &responseError{Code: codeInvalidParams, Message: "unknown document: " + uri}
+	}
+	return doc, nil
+}
+
+func (s *Server) definition(params json.RawMessage) (interface{}, error) {
+	var p TextDocumentPositionParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	sym, _ := doc.symbolAt(p.Position)
+	if sym == nil {
+		return nil, nil
+	}
+	return Location{URI: doc.uri, Range: doc.spanRange(sym.Span)}, nil
+}
+
+func (s *Server) references(params json.RawMessage) (interface{}, error) {
+	var p ReferenceParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	sym, _ := doc.symbolAt(p.Position)
+	if sym == nil {
+		return []Location{}, nil
+	}
+	return doc.references(sym, p.Context.IncludeDeclaration), nil
+}
+
+func (s *Server) documentSymbol(params json.RawMessage) (interface{}, error) {
+	var p DocumentSymbolParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	return doc.outline(), nil
+}
+
+func (s *Server) completion(params json.RawMessage) (interface{}, error) {
+	var p TextDocumentPositionParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	return doc.completion(p.Position, s.natives), nil
+}
+
+func (s *Server) hover(params json.RawMessage) (interface{}, error) {
+	var p TextDocumentPositionParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	sym, span := doc.symbolAt(p.Position)
+	if sym == nil {
+		return nil, nil
+	}
+	r := doc.spanRange(span)
+	return Hover{
+		Contents: MarkupContent{Kind: "plaintext", Value: doc.detail(sym)},
+		Range:    &r,
+	}, nil
+}
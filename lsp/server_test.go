@@ -0,0 +1,304 @@
+package lsp
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"testing"
+)
+
+// client - скриптованный клиент, который общается с сервером через каналы в памяти
+type client struct {
+	t    *testing.T
+	conn *conn
+	id   int
+	done chan error
+}
+
+func newClient(t *testing.T) *client {
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	c := &client{t: t, conn: newConn(clientIn, clientOut), done: make(chan error, 1)}
+	go func() {
+		err := NewServer(serverIn, serverOut).Run()
+		serverOut.Close()
+		c.done <- err
+	}()
+
+	c.call("initialize", map[string]interface{}{}, nil)
+	c.notify("initialized", map[string]interface{}{})
+	return c
+}
+
+func (c *client) notify(method string, params interface{}) {
+	if err := c.conn.notify(method, params); err != nil {
+		c.t.Fatalf("%s: %s", method, err)
+	}
+}
+
+// call отправляет запрос и ждет ответ на него; уведомления сервера по дороге пропускаются
+func (c *client) call(method string, params interface{}, result interface{}) *responseError {
+	c.id++
+	raw, _ := json.Marshal(params)
+	id := json.RawMessage(strconv.Itoa(c.id))
+	if err := c.conn.write(&message{ID: &id, Method: method, Params: raw}); err != nil {
+		c.t.Fatalf("%s: %s", method, err)
+	}
+	for {
+		msg, err := c.conn.read()
+		if err != nil {
+			c.t.Fatalf("%s: %s", method, err)
+		}
+		if msg.ID == nil || string(*msg.ID) != string(id) {
+			continue
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result != nil {
+			if err := json.Unmarshal(msg.Result, result); err != nil {
+				c.t.Fatalf("%s: %s", method, err)
+			}
+		}
+		return nil
+	}
+}
+
+// diagnostics ждет следующую публикацию диагностик
+func (c *client) diagnostics() PublishDiagnosticsParams {
+	for {
+		msg, err := c.conn.read()
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		if msg.Method == "textDocument/publishDiagnostics" {
+			var params PublishDiagnosticsParams
+			if err := json.Unmarshal(msg.Params, &params); err != nil {
+				c.t.Fatal(err)
+			}
+			return params
+		}
+	}
+}
+
+func (c *client) open(uri, text string) PublishDiagnosticsParams {
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: uri, LanguageID: "strawberry", Version: 1, Text: text},
+	})
+	return c.diagnostics()
+}
+
+func (c *client) close() {
+	if err := c.call("shutdown", nil, nil); err != nil {
+		c.t.Fatalf("shutdown: %s", err.Message)
+	}
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		c.t.Fatalf("server: %s", err)
+	}
+}
+
+func at(uri string, line, character int) TextDocumentPositionParams {
+	return TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     Position{Line: line, Character: character},
+	}
+}
+
+const source = `var count = 0;
+fun add(a, b) {
+    var sum = a + b;
+    return sum;
+}
+class Counter < Base {
+    inc() {
+        count = add(count, 1);
+    }
+}
+print add(1, 2);
+`
+
+func TestDiagnostics(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+
+	if params := c.open("file:///a.berry", source); len(params.Diagnostics) != 0 {
+		t.Fatalf("expected no diagnostics, got %+v", params.Diagnostics)
+	}
+
+	// две ошибки в разных операторах: обе должны быть найдены
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   TextDocumentIdentifier{URI: "file:///a.berry"},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "return 1;\nfun f() { break; }\nprint 1 +;\n"}},
+	})
+	params := c.diagnostics()
+	expected := []struct {
+		line, character int
+	}{{2, 9}, {0, 0}, {1, 10}}
+	if len(params.Diagnostics) != len(expected) {
+		t.Fatalf("expected %d diagnostics, got %+v", len(expected), params.Diagnostics)
+	}
+	for i, pos := range expected {
+		start := params.Diagnostics[i].Range.Start
+		if start.Line != pos.line || start.Character != pos.character {
+			t.Fatalf("diagnostic [%d]: expected %d:%d, got %+v", i, pos.line, pos.character, params.Diagnostics[i])
+		}
+	}
+
+	c.notify("textDocument/didClose", DidCloseTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: "file:///a.berry"}})
+	if params := c.diagnostics(); len(params.Diagnostics) != 0 {
+		t.Fatalf("expected diagnostics to be cleared, got %+v", params.Diagnostics)
+	}
+}
+
+func TestDefinitionAndReferences(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+	c.open("file:///a.berry", source)
+
+	tests := []struct {
+		line, character  int // позиция использования
+		defLine, defChar int
+	}{
+		{3, 12, 2, 8}, // sum
+		{2, 14, 1, 8}, // параметр a
+		{7, 16, 1, 4}, // add в методе
+		{10, 7, 1, 4}, // add на верхнем уровне
+		{7, 8, 0, 4},  // глобальная count
+		{1, 5, 1, 4},  // само объявление
+	}
+	for i, test := range tests {
+		var loc *Location
+		if err := c.call("textDocument/definition", at("file:///a.berry", test.line, test.character), &loc); err != nil {
+			t.Fatalf("test [%d]: %s", i, err.Message)
+		}
+		if loc == nil {
+			t.Fatalf("test [%d]: no definition found", i)
+		}
+		if loc.Range.Start.Line != test.defLine || loc.Range.Start.Character != test.defChar {
+			t.Fatalf("test [%d]: expected %d:%d, got %+v", i, test.defLine, test.defChar, loc.Range)
+		}
+	}
+
+	var loc *Location
+	c.call("textDocument/definition", at("file:///a.berry", 10, 0), &loc)
+	if loc != nil {
+		t.Fatalf("expected no definition for keyword, got %+v", loc)
+	}
+
+	var params ReferenceParams
+	params.TextDocumentPositionParams = at("file:///a.berry", 1, 5)
+	params.Context.IncludeDeclaration = true
+	var refs []Location
+	c.call("textDocument/references", params, &refs)
+	if len(refs) != 3 {
+		t.Fatalf("expected 3 references of add, got %+v", refs)
+	}
+	params.Context.IncludeDeclaration = false
+	c.call("textDocument/references", params, &refs)
+	if len(refs) != 2 {
+		t.Fatalf("expected 2 uses of add, got %+v", refs)
+	}
+}
+
+func TestDocumentSymbol(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+	c.open("file:///a.berry", source)
+
+	var symbols []DocumentSymbol
+	c.call("textDocument/documentSymbol", DocumentSymbolParams{TextDocument: TextDocumentIdentifier{URI: "file:///a.berry"}}, &symbols)
+	if len(symbols) != 2 {
+		t.Fatalf("expected 2 symbols, got %+v", symbols)
+	}
+	if symbols[0].Name != "add" || symbols[0].Kind != SymbolKindFunction || symbols[0].Detail != "(a, b)" {
+		t.Fatalf("unexpected function symbol %+v", symbols[0])
+	}
+	class := symbols[1]
+	if class.Name != "Counter" || class.Kind != SymbolKindClass || class.Detail != "class Counter < Base" {
+		t.Fatalf("unexpected class symbol %+v", class)
+	}
+	if len(class.Children) != 1 || class.Children[0].Name != "inc" || class.Children[0].Kind != SymbolKindMethod {
+		t.Fatalf("unexpected methods %+v", class.Children)
+	}
+	if class.Range.Start.Line != 5 || class.Range.End.Line != 9 {
+		t.Fatalf("unexpected class range %+v", class.Range)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+	c.open("file:///a.berry", source)
+
+	var items []CompletionItem
+	c.call("textDocument/completion", at("file:///a.berry", 3, 4), &items)
+	labels := make(map[string]int)
+	for _, item := range items {
+		labels[item.Label] = item.Kind
+	}
+	expected := map[string]int{
+		"sum":     CompletionKindVariable,
+		"a":       CompletionKindVariable,
+		"count":   CompletionKindVariable,
+		"add":     CompletionKindFunction,
+		"Counter": CompletionKindClass,
+		"clock":   CompletionKindFunction,
+		"while":   CompletionKindKeyword,
+	}
+	for label, kind := range expected {
+		if got, ok := labels[label]; !ok || got != kind {
+			t.Fatalf("expected %q with kind %d, got %+v", label, kind, items)
+		}
+	}
+
+	// локальные имена функции add не видны в методе класса
+	c.call("textDocument/completion", at("file:///a.berry", 7, 8), &items)
+	for _, item := range items {
+		if item.Label == "sum" || item.Label == "a" {
+			t.Fatalf("unexpected local %q outside its scope", item.Label)
+		}
+	}
+}
+
+func TestHover(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+	c.open("file:///a.berry", source)
+
+	var hover *Hover
+	c.call("textDocument/hover", at("file:///a.berry", 10, 7), &hover)
+	if hover == nil || hover.Contents.Value != "fun add(a, b)" {
+		t.Fatalf("unexpected hover %+v", hover)
+	}
+}
+
+func TestUTF16Positions(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+	// "ё" занимает два байта, но одну единицу UTF-16; "𝄞" - четыре байта и две единицы
+	c.open("file:///u.berry", "var s = \"ё𝄞\"; print s;\n")
+
+	var loc *Location
+	c.call("textDocument/definition", at("file:///u.berry", 0, 21), &loc)
+	if loc == nil || loc.Range.Start.Character != 4 {
+		t.Fatalf("unexpected definition %+v", loc)
+	}
+	var refs []Location
+	var params ReferenceParams
+	params.TextDocumentPositionParams = at("file:///u.berry", 0, 4)
+	params.Context.IncludeDeclaration = true
+	c.call("textDocument/references", params, &refs)
+	if len(refs) != 2 || refs[1].Range.Start.Character != 21 {
+		t.Fatalf("unexpected references %+v", refs)
+	}
+}
+
+func TestUnknownMethod(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+	err := c.call("textDocument/rename", map[string]interface{}{}, nil)
+	if err == nil || err.Code != codeMethodNotFound {
+		t.Fatalf("expected method not found error, got %+v", err)
+	}
+}
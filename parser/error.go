@@ -1,9 +1,65 @@
 package parser
 
+import (
+	"fmt"
+	"github.com/Dor1ma/Strawberry/token"
+	"io"
+)
+
+// Error - синтаксическая ошибка
+type Error struct {
+	Pos      token.Position
+	Expected token.Token // ожидаемый токен; token.Illegal, если ожидался не конкретный токен
+	Found    token.Token // токен, на котором произошла ошибка
+	Msg      string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// ErrorList - все синтаксические ошибки, найденные при разборе, упорядоченные по позиции
+type ErrorList []*Error
+
+// before сообщает, что все ошибки списка стоят раньше pos
+func (list ErrorList) before(pos token.Position) bool {
+	return len(list) == 0 || list[len(list)-1].Pos.Offset < pos.Offset
+}
+
+func (list ErrorList) Error() string {
+	switch len(list) {
+	case 0:
+		return "no errors"
+	case 1:
+		return list[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", list[0], len(list)-1)
+}
+
+// Err возвращает список как error или nil, если ошибок нет
+func (list ErrorList) Err() error {
+	if len(list) == 0 {
+		return nil
+	}
+	return list
+}
+
+// parseError - паника, которой парсер раскручивает стек до ближайшей точки восстановления
 type parseError struct {
-	s string
+	err *Error
 }
 
 func (p *parseError) Error() string {
-	return p.s
+	return p.err.Error()
+}
+
+// PrintError печатает ошибку в w; для ErrorList каждая ошибка печатается на отдельной строке
+func PrintError(w io.Writer, err error) {
+	if list, ok := err.(ErrorList); ok {
+		for _, e := range list {
+			fmt.Fprintln(w, e)
+		}
+	} else if err != nil {
+		fmt.Fprintln(w, err)
+	}
 }
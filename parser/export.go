@@ -13,7 +13,10 @@ func ParseExpr(input string) (expr ast.Expression, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			if parseErr, ok := r.(parseError); ok {
-				err = &parseErr
+				err = p.errors.Err()
+				if err == nil {
+					err = &parseErr
+				}
 				expr = nil
 			} else {
 				panic(r)
@@ -5,118 +5,235 @@ import (
 	"github.com/Dor1ma/Strawberry/ast"
 	"github.com/Dor1ma/Strawberry/lexer"
 	"github.com/Dor1ma/Strawberry/token"
-	"os"
 	"strings"
 )
 
 type Parser struct {
 	l *lexer.Lexer
 
-	tok token.Token
-	lit string
+	tok  token.Token
+	lit  string
+	span token.Span // положение текущего токена
+
+	prevEnd token.Position // конец предыдущего токена
+	prevTok token.Token    // предыдущий токен
+	ahead   []lookahead    // токены, прочитанные через peek, но еще не разобранные
+
+	errors ErrorList
 
 	trace  bool
 	indent int
 }
 
+type lookahead struct {
+	tok  token.Token
+	lit  string
+	span token.Span
+}
+
 func (p *Parser) nextToken() token.Token {
 	if p.isAtEnd() {
 		return token.EOF
 	}
-	tok, lit := p.l.NextToken()
-	p.tok = tok
-	p.lit = lit
-	return tok
+	var next lookahead
+	if len(p.ahead) > 0 {
+		next, p.ahead = p.ahead[0], p.ahead[1:]
+	} else {
+		next.tok, next.lit, next.span = p.l.NextToken()
+	}
+	p.prevEnd = p.span.To
+	p.prevTok = p.tok
+	p.tok = next.tok
+	p.lit = next.lit
+	p.span = next.span
+	return p.tok
+}
+
+// peek возвращает токен, идущий через n токенов после текущего, не сдвигая разбор
+func (p *Parser) peek(n int) token.Token {
+	for len(p.ahead) < n {
+		if k := len(p.ahead); p.tok == token.EOF || (k > 0 && p.ahead[k-1].tok == token.EOF) {
+			return token.EOF
+		}
+		var next lookahead
+		next.tok, next.lit, next.span = p.l.NextToken()
+		p.ahead = append(p.ahead, next)
+	}
+	return p.ahead[n-1].tok
 }
 
-// Parse возвращает все операторы
+// Parse возвращает все операторы. После синтаксической ошибки разбор продолжается,
+// а на месте испорченных операторов и выражений в дереве остаются ast.BadStmt и ast.BadExpr.
+// Все найденные ошибки возвращаются одним ErrorList.
 func (p *Parser) Parse() (statements []ast.Statement, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			if parseErr, ok := r.(parseError); ok {
-				statements = nil
-				err = &parseErr
-				p.synchronize()
-			} else {
-				panic(r)
-			}
-		}
-	}()
 	// Здесь происходит парсинг всей программы
 	for !p.isAtEnd() {
 		stmt := p.parseDeclaration()
 		statements = append(statements, stmt)
 	}
-	return statements, nil
+	return statements, p.errors.Err()
+}
+
+// Errors возвращает ошибки, найденные к текущему моменту
+func (p *Parser) Errors() ErrorList {
+	return p.errors
 }
 
-func (p *Parser) parseDeclaration() ast.Statement {
+func (p *Parser) parseDeclaration() (stmt ast.Statement) {
+	start := p.span.From
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(parseError); !ok {
+				panic(r)
+			}
+			p.synchronize()
+			if p.span.From == start {
+				// ничего не прочитано - пропускаем токен, чтобы не зациклиться
+				p.nextToken()
+			}
+			stmt = &ast.BadStmt{Span: p.spanFrom(start)}
+		}
+	}()
 	if p.match(token.Var) {
-		return p.parseVarDeclaration()
+		return p.parseVarDeclaration(start)
 	}
-	if p.match(token.Fun) {
-		return p.parseFunctionDeclaration()
+	// fun ( - анонимная функция в начале оператора-выражения
+	if p.check(token.Fun) && p.peek(1) != token.LeftParen {
+		p.nextToken()
+		return p.parseFunctionDeclaration(start)
 	}
 	if p.match(token.Class) {
-		return p.parseClassDeclaration()
+		return p.parseClassDeclaration(start)
 	}
 	return p.parseStatement()
 }
 
-func (p *Parser) parseVarDeclaration() *ast.VarStmt {
-	name := p.lit
+func (p *Parser) parseVarDeclaration(start token.Position) *ast.VarStmt {
+	name, nameSpan := p.lit, p.span
 	p.expect(token.Identifier, "Expect variable name.")
 	var stmt = &ast.VarStmt{
 		Name: &ast.Identifier{
+			Span: nameSpan,
 			Name: name,
 		},
 	}
 	var initializer ast.Expression
 	if p.match(token.Equal) {
-		initializer = p.parseExpression()
+		initializer = p.parseExpressionOrBad(token.Semicolon)
 	}
 	p.expect(token.Semicolon, "Expect ';' after variable declaration.")
 	stmt.Initializer = initializer
+	stmt.Span = p.spanFrom(start)
 	return stmt
 }
 
-func (p *Parser) parseFunctionDeclaration() *ast.FunctionStmt {
-	name := p.lit
+func (p *Parser) parseFunctionDeclaration(start token.Position) *ast.FunctionStmt {
+	name, nameSpan := p.lit, p.span
 	p.expect(token.Identifier, "Expect function name.")
 	p.expect(token.LeftParen, "Expect '(' after function name.")
 	fun := &ast.FunctionStmt{
-		Name:   name,
-		Params: make([]*ast.Identifier, 0),
-		Body:   make([]ast.Statement, 0),
+		Name:     name,
+		NameSpan: nameSpan,
+		Params:   p.parseParams(),
 	}
-	if !p.match(token.RightParen) {
-		for {
-			lit := p.lit
-			p.expect(token.Identifier, "Expect parameter name.")
-			if len(fun.Params) >= 255 {
-				p.error("Cannot have more than 255 parameters.")
-			}
-			ident := &ast.Identifier{Name: lit}
-			fun.Params = append(fun.Params, ident)
-			if !p.match(token.Comma) {
-				break
-			}
+	fun.Body = p.parseFunctionBody()
+	fun.Span = p.spanFrom(start)
+	return fun
+}
+
+// parseParams разбирает список параметров после '(' вместе с закрывающей скобкой
+func (p *Parser) parseParams() []*ast.Identifier {
+	params := make([]*ast.Identifier, 0)
+	if p.match(token.RightParen) {
+		return params
+	}
+	for {
+		lit, span := p.lit, p.span
+		p.expect(token.Identifier, "Expect parameter name.")
+		if len(params) >= 255 {
+			p.error("Cannot have more than 255 parameters.")
+		}
+		params = append(params, &ast.Identifier{Span: span, Name: lit})
+		if !p.match(token.Comma) {
+			break
 		}
-		p.expect(token.RightParen, "Expect ')' after parameters.")
 	}
+	p.expect(token.RightParen, "Expect ')' after parameters.")
+	return params
+}
+
+func (p *Parser) parseFunctionBody() []ast.Statement {
 	p.expect(token.LeftBrace, "Expect '{' before function body.")
-	fun.Body = p.parseBlockStatement().Statements
+	return p.parseBlockStatement(p.prevEnd).Statements
+}
+
+// parseFunctionExpr разбирает анонимную функцию после 'fun'
+func (p *Parser) parseFunctionExpr(start token.Position) *ast.FunctionExpr {
+	p.expect(token.LeftParen, "Expect '(' after 'fun'.")
+	fun := &ast.FunctionExpr{
+		Params: p.parseParams(),
+	}
+	fun.Body = p.parseFunctionBody()
+	fun.Span = p.spanFrom(start)
 	return fun
 }
 
-func (p *Parser) parseClassDeclaration() *ast.ClassStmt {
-	name := p.lit
+// isArrowFunction проверяет, что с текущей '(' начинается стрелочная функция: (a, b) =>
+func (p *Parser) isArrowFunction() bool {
+	i := 1
+	if p.peek(i) == token.RightParen {
+		return p.peek(i+1) == token.Arrow
+	}
+	for p.peek(i) == token.Identifier {
+		switch p.peek(i + 1) {
+		case token.Comma:
+			i += 2
+		case token.RightParen:
+			return p.peek(i+2) == token.Arrow
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// parseArrowFunction разбирает (a, b) => expr. Тело - неявный return выражения
+func (p *Parser) parseArrowFunction(start token.Position) *ast.FunctionExpr {
+	p.expect(token.LeftParen, "Expect '(' before parameters.")
+	params := p.parseParams()
+	p.expect(token.Arrow, "Expect '=>' after parameters.")
+	value := p.parseExpression()
+	return &ast.FunctionExpr{
+		Span:   p.spanFrom(start),
+		Params: params,
+		Body: []ast.Statement{&ast.ReturnStmt{
+			Span:    token.Span{From: value.Pos(), To: value.End()},
+			Keyword: token.Return,
+			Value:   value,
+		}},
+		Arrow: true,
+	}
+}
+
+func (p *Parser) parseClassDeclaration(start token.Position) *ast.ClassStmt {
+	name, nameSpan := p.lit, p.span
 	p.expect(token.Identifier, "Expect class name.")
+
+	var superClass *ast.VariableExpr
+	if p.match(token.Less) {
+		superClass = &ast.VariableExpr{
+			Span:     p.span,
+			Name:     p.lit,
+			Distance: -1,
+		}
+		p.expect(token.Identifier, "Expect superclass name.")
+	}
+
 	p.expect(token.LeftBrace, "Expect '{' after class name.")
 
 	methods := make([]*ast.FunctionStmt, 0)
 	for p.check(token.Identifier) {
-		method := p.parseFunctionDeclaration()
+		method := p.parseFunctionDeclaration(p.span.From)
 		method.IsInitializer = method.Name == "init"
 		methods = append(methods, method)
 	}
@@ -124,44 +241,63 @@ func (p *Parser) parseClassDeclaration() *ast.ClassStmt {
 	p.expect(token.RightBrace, "Expect '}' after class block.")
 
 	return &ast.ClassStmt{
-		Name:    name,
-		Methods: methods,
+		Span:       p.spanFrom(start),
+		Name:       name,
+		NameSpan:   nameSpan,
+		SuperClass: superClass,
+		Methods:    methods,
 	}
 }
 
 func (p *Parser) parseStatement() ast.Statement {
+	start := p.span.From
 	if p.match(token.Print) {
-		return p.parsePrintStatement()
+		return p.parsePrintStatement(start)
 	}
 	if p.match(token.If) {
-		return p.parseIfStatement()
+		return p.parseIfStatement(start)
 	}
 	if p.match(token.While) {
-		return p.parseWhileStatement()
+		return p.parseWhileStatement(start)
 	}
 	if p.match(token.For) {
-		return p.parseForStatement()
+		return p.parseForStatement(start)
 	}
 	if p.match(token.LeftBrace) {
-		return p.parseBlockStatement()
+		return p.parseBlockStatement(start)
 	}
 	if p.match(token.Return) {
-		return p.parseReturnStatement()
+		return p.parseReturnStatement(start)
+	}
+	if p.match(token.Try) {
+		return p.parseTryStatement(start)
+	}
+	if p.match(token.Throw) {
+		return p.parseThrowStatement(start)
+	}
+	if p.match(token.Break) {
+		p.expect(token.Semicolon, "Expect ';' after 'break'.")
+		return &ast.BreakStmt{Span: p.spanFrom(start)}
+	}
+	if p.match(token.Continue) {
+		p.expect(token.Semicolon, "Expect ';' after 'continue'.")
+		return &ast.ContinueStmt{Span: p.spanFrom(start)}
 	}
 	return p.parseExprStatement()
 }
 
-func (p *Parser) parsePrintStatement() ast.Statement {
-	expr := p.parseExpression()
+func (p *Parser) parsePrintStatement(start token.Position) ast.Statement {
+	expr := p.parseExpressionOrBad(token.Semicolon)
 	p.expect(token.Semicolon, "Expect ';' after value.")
 	return &ast.PrintStmt{
+		Span:       p.spanFrom(start),
 		Expression: expr,
 	}
 }
 
-func (p *Parser) parseIfStatement() ast.Statement {
+func (p *Parser) parseIfStatement(start token.Position) ast.Statement {
 	p.expect(token.LeftParen, "Expect '(' after 'if'.")
-	condition := p.parseExpression()
+	condition := p.parseExpressionOrBad(token.RightParen)
 	p.expect(token.RightParen, "Expect ')' after if condition.")
 	thenBranch := p.parseStatement()
 	var elseBranch ast.Statement
@@ -169,29 +305,31 @@ func (p *Parser) parseIfStatement() ast.Statement {
 		elseBranch = p.parseStatement()
 	}
 	return &ast.IfStmt{
+		Span:       p.spanFrom(start),
 		Condition:  condition,
 		ThenBranch: thenBranch,
 		ElseBranch: elseBranch,
 	}
 }
 
-func (p *Parser) parseWhileStatement() ast.Statement {
+func (p *Parser) parseWhileStatement(start token.Position) ast.Statement {
 	p.expect(token.LeftParen, "Expect '(' after 'while'.")
-	condition := p.parseExpression()
+	condition := p.parseExpressionOrBad(token.RightParen)
 	p.expect(token.RightParen, "Expect ')' after while condition.")
 	body := p.parseStatement()
 	return &ast.WhileStmt{
+		Span:      p.spanFrom(start),
 		Condition: condition,
 		Body:      body,
 	}
 }
 
-func (p *Parser) parseForStatement() ast.Statement {
+func (p *Parser) parseForStatement(start token.Position) ast.Statement {
 	p.expect(token.LeftParen, "Expect '(' after 'for'.")
 	var initializer ast.Statement
 	if !p.match(token.Semicolon) {
-		if p.match(token.Var) {
-			initializer = p.parseVarDeclaration()
+		if varStart := p.span.From; p.match(token.Var) {
+			initializer = p.parseVarDeclaration(varStart)
 		} else {
 			initializer = p.parseExprStatement()
 		}
@@ -199,41 +337,37 @@ func (p *Parser) parseForStatement() ast.Statement {
 
 	var condition ast.Expression
 	if !p.match(token.Semicolon) {
-		condition = p.parseExpression()
+		condition = p.parseExpressionOrBad(token.Semicolon)
 		p.expect(token.Semicolon, "Expect ';' after loop condition.")
 	}
 
 	var increment ast.Expression
 	if !p.match(token.RightParen) {
-		increment = p.parseExpression()
+		increment = p.parseExpressionOrBad(token.RightParen)
 		p.expect(token.RightParen, "Expect ')' after for clause.")
 	}
 
 	body := p.parseStatement()
+	span := p.spanFrom(start)
 
-	if increment != nil {
-		body = &ast.BlockStmt{
-			Statements: []ast.Statement{
-				body,
-				&ast.ExprStmt{
-					Expression: increment,
-				},
-			},
-		}
-	}
 	if condition == nil {
 		condition = &ast.Literal{
+			Span:  span,
 			Token: token.True,
 			Value: "true",
 		}
 	}
+	// шаг хранится отдельно от тела, чтобы continue не пропускал его
 	body = &ast.WhileStmt{
+		Span:      span,
 		Condition: condition,
 		Body:      body,
+		Increment: increment,
 	}
 
 	if initializer != nil {
 		body = &ast.BlockStmt{
+			Span: span,
 			Statements: []ast.Statement{
 				initializer,
 				body,
@@ -243,38 +377,96 @@ func (p *Parser) parseForStatement() ast.Statement {
 	return body
 }
 
-func (p *Parser) parseBlockStatement() *ast.BlockStmt {
+// parseBlockStatement разбирает блок, открывающая скобка которого начинается в start
+func (p *Parser) parseBlockStatement(start token.Position) *ast.BlockStmt {
 	statements := make([]ast.Statement, 0)
 	for !(p.check(token.RightBrace) || p.isAtEnd()) {
 		statements = append(statements, p.parseDeclaration())
 	}
 	p.expect(token.RightBrace, "Expect '}' after block.")
 	return &ast.BlockStmt{
+		Span:       p.spanFrom(start),
 		Statements: statements,
 	}
 }
 
 func (p *Parser) parseExprStatement() ast.Statement {
-	expr := p.parseExpression()
+	expr := p.parseExpressionOrBad(token.Semicolon)
 	p.expect(token.Semicolon, "Expect ';' after expression.")
 	return &ast.ExprStmt{
+		Span:       p.spanFrom(expr.Pos()),
 		Expression: expr,
 	}
 }
 
-func (p *Parser) parseReturnStatement() ast.Statement {
-	stmt := &ast.ReturnStmt{}
+func (p *Parser) parseReturnStatement(start token.Position) ast.Statement {
+	stmt := &ast.ReturnStmt{Keyword: token.Return}
 	if !p.match(token.Semicolon) {
-		stmt.Value = p.parseExpression()
+		stmt.Value = p.parseExpressionOrBad(token.Semicolon)
 		p.expect(token.Semicolon, "Expect ';' after return value.")
 	}
+	stmt.Span = p.spanFrom(start)
 	return stmt
 }
 
+func (p *Parser) parseTryStatement(start token.Position) ast.Statement {
+	stmt := &ast.TryStmt{}
+	blockStart := p.span.From
+	p.expect(token.LeftBrace, "Expect '{' after 'try'.")
+	stmt.Body = p.parseBlockStatement(blockStart)
+
+	if p.match(token.Catch) {
+		p.expect(token.LeftParen, "Expect '(' after 'catch'.")
+		name, nameSpan := p.lit, p.span
+		p.expect(token.Identifier, "Expect exception variable name.")
+		stmt.CatchName = &ast.Identifier{Span: nameSpan, Name: name}
+		p.expect(token.RightParen, "Expect ')' after exception variable.")
+		blockStart = p.span.From
+		p.expect(token.LeftBrace, "Expect '{' before catch body.")
+		stmt.CatchBody = p.parseBlockStatement(blockStart)
+	}
+	if p.match(token.Finally) {
+		blockStart = p.span.From
+		p.expect(token.LeftBrace, "Expect '{' after 'finally'.")
+		stmt.FinallyBody = p.parseBlockStatement(blockStart)
+	}
+	if stmt.CatchBody == nil && stmt.FinallyBody == nil {
+		p.errorExpected(token.Catch, "Expect 'catch' or 'finally' after try block.")
+	}
+
+	stmt.Span = p.spanFrom(start)
+	return stmt
+}
+
+func (p *Parser) parseThrowStatement(start token.Position) ast.Statement {
+	value := p.parseExpressionOrBad(token.Semicolon)
+	p.expect(token.Semicolon, "Expect ';' after thrown value.")
+	return &ast.ThrowStmt{
+		Span:  p.spanFrom(start),
+		Value: value,
+	}
+}
+
 func (p *Parser) parseExpression() ast.Expression {
 	return p.parseAssignment()
 }
 
+// parseExpressionOrBad разбирает выражение, а при синтаксической ошибке пропускает токены
+// до одного из stop (вне вложенных скобок) и возвращает ast.BadExpr
+func (p *Parser) parseExpressionOrBad(stop ...token.Token) (expr ast.Expression) {
+	start := p.span.From
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(parseError); !ok {
+				panic(r)
+			}
+			p.skipTo(stop...)
+			expr = &ast.BadExpr{Span: p.spanFrom(start)}
+		}
+	}()
+	return p.parseExpression()
+}
+
 func (p *Parser) parseAssignment() ast.Expression {
 	expr := p.parseOr()
 	if p.match(token.Equal) {
@@ -284,11 +476,13 @@ func (p *Parser) parseAssignment() ast.Expression {
 			p.error("Invalid assignment target.")
 		case ast.LeftExpr:
 			return &ast.AssignExpr{
+				Span:  p.spanFrom(expr.Pos()),
 				Left:  e,
 				Value: v,
 			}
 		case *ast.GetExpr:
 			return &ast.SetExpr{
+				Span:   p.spanFrom(expr.Pos()),
 				Object: e.Object,
 				Name:   e.Name,
 				Value:  v,
@@ -303,6 +497,7 @@ func (p *Parser) parseOr() ast.Expression {
 	if p.match(token.Or) {
 		right := p.parseAnd()
 		expr = &ast.LogicalExpr{
+			Span:     p.spanFrom(expr.Pos()),
 			Left:     expr,
 			Operator: token.Or,
 			Right:    right,
@@ -316,6 +511,7 @@ func (p *Parser) parseAnd() ast.Expression {
 	if p.match(token.And) {
 		right := p.parseEquality()
 		expr = &ast.LogicalExpr{
+			Span:     p.spanFrom(expr.Pos()),
 			Left:     expr,
 			Operator: token.And,
 			Right:    right,
@@ -330,6 +526,7 @@ func (p *Parser) parseEquality() ast.Expression {
 	for p.match(token.EqualEqual, token.NotEqual) {
 		right := p.parseComparison()
 		expr = &ast.BinaryExpr{
+			Span:     p.spanFrom(expr.Pos()),
 			Left:     expr,
 			Operator: operator,
 			Right:    right,
@@ -345,6 +542,7 @@ func (p *Parser) parseComparison() ast.Expression {
 	for p.match(token.Greater, token.GreaterThanOrEqual, token.Less, token.LessThanOrEqual) {
 		right := p.parseAddition()
 		expr = &ast.BinaryExpr{
+			Span:     p.spanFrom(expr.Pos()),
 			Left:     expr,
 			Operator: operator,
 			Right:    right,
@@ -360,6 +558,7 @@ func (p *Parser) parseAddition() ast.Expression {
 	for p.match(token.Plus, token.Minus) {
 		right := p.parseMultiplacation()
 		expr = &ast.BinaryExpr{
+			Span:     p.spanFrom(expr.Pos()),
 			Left:     expr,
 			Operator: operator,
 			Right:    right,
@@ -375,6 +574,7 @@ func (p *Parser) parseMultiplacation() ast.Expression {
 	for p.match(token.Slash, token.Star) {
 		right := p.parseUnary()
 		expr = &ast.BinaryExpr{
+			Span:     p.spanFrom(expr.Pos()),
 			Left:     expr,
 			Operator: operator,
 			Right:    right,
@@ -385,10 +585,11 @@ func (p *Parser) parseMultiplacation() ast.Expression {
 }
 
 func (p *Parser) parseUnary() ast.Expression {
-	operator := p.tok
+	operator, start := p.tok, p.span.From
 	if p.match(token.Not, token.Minus) {
 		right := p.parseUnary()
 		return &ast.UnaryExpr{
+			Span:     p.spanFrom(start),
 			Operator: operator,
 			Right:    right,
 		}
@@ -404,11 +605,12 @@ func (p *Parser) parseCall() ast.Expression {
 		} else if p.match(token.Dot) {
 			name := p.lit
 			p.expect(token.Identifier, "Expect property or method name after '.'.")
-			expr = &ast.GetExpr{Object: expr, Name: name}
+			expr = &ast.GetExpr{Span: p.spanFrom(expr.Pos()), Object: expr, Name: name}
 		} else if p.match(token.LeftBracket) {
 			index := p.parseExpression()
 			p.expect(token.RightBracket, "Expect ']' after array index.")
 			expr = &ast.ArrayIndex{
+				Span:  p.spanFrom(expr.Pos()),
 				Array: expr,
 				Index: index,
 			}
@@ -419,15 +621,15 @@ func (p *Parser) parseCall() ast.Expression {
 	return expr
 }
 
-func (p *Parser) parseArrayExpr() *ast.ArrayExpr {
+func (p *Parser) parseArrayExpr(start token.Position) *ast.ArrayExpr {
 	var elements []ast.Expression
 
 	if p.match(token.RightBracket) {
-		return &ast.ArrayExpr{Elements: elements}
+		return &ast.ArrayExpr{Span: p.spanFrom(start), Elements: elements}
 	}
 
 	for {
-		element := p.parseExpression()
+		element := p.parseExpressionOrBad(token.Comma, token.RightBracket)
 		elements = append(elements, element)
 
 		if p.match(token.Comma) {
@@ -438,7 +640,37 @@ func (p *Parser) parseArrayExpr() *ast.ArrayExpr {
 		break
 	}
 
-	return &ast.ArrayExpr{Elements: elements}
+	return &ast.ArrayExpr{Span: p.spanFrom(start), Elements: elements}
+}
+
+func (p *Parser) parseMapExpr(start token.Position) *ast.MapExpr {
+	expr := &ast.MapExpr{
+		Keys:   make([]ast.Expression, 0),
+		Values: make([]ast.Expression, 0),
+	}
+
+	if p.match(token.RightBrace) {
+		expr.Span = p.spanFrom(start)
+		return expr
+	}
+
+	for {
+		key := p.parseExpressionOrBad(token.Colon, token.Comma, token.RightBrace)
+		p.expect(token.Colon, "Expect ':' after map key.")
+		value := p.parseExpressionOrBad(token.Comma, token.RightBrace)
+		expr.Keys = append(expr.Keys, key)
+		expr.Values = append(expr.Values, value)
+
+		if p.match(token.Comma) {
+			continue
+		}
+
+		p.expect(token.RightBrace, "Expect '}' after map entries.")
+		break
+	}
+
+	expr.Span = p.spanFrom(start)
+	return expr
 }
 
 func (p *Parser) finishCall(expr ast.Expression) ast.Expression {
@@ -447,10 +679,11 @@ func (p *Parser) finishCall(expr ast.Expression) ast.Expression {
 		Arguments: make([]ast.Expression, 0),
 	}
 	if p.match(token.RightParen) {
+		call.Span = p.spanFrom(expr.Pos())
 		return call
 	}
 	for {
-		arg := p.parseExpression()
+		arg := p.parseExpressionOrBad(token.Comma, token.RightParen)
 		if len(call.Arguments) >= 255 {
 			p.error("Cannot have more than 255 arguments.")
 		}
@@ -460,51 +693,117 @@ func (p *Parser) finishCall(expr ast.Expression) ast.Expression {
 		}
 	}
 	p.expect(token.RightParen, "Expect ')' after arguments.")
+	call.Span = p.spanFrom(expr.Pos())
 	return call
 }
 
 func (p *Parser) parsePrimary() (expr ast.Expression) {
-	tok, lit := p.tok, p.lit
+	tok, lit, span := p.tok, p.lit, p.span
 	switch tok {
 	default:
 		p.error("Expect expression.")
 	case token.True, token.False, token.Nil, token.String, token.Number:
 		expr = &ast.Literal{
+			Span:  span,
 			Token: tok,
 			Value: lit,
 		}
 	case token.Identifier:
 		expr = &ast.VariableExpr{
+			Span:     span,
 			Name:     lit,
 			Distance: -1,
 		}
 	case token.This:
-		expr = &ast.ThisExpr{}
+		expr = &ast.ThisExpr{Span: span}
+	case token.Super:
+		p.nextToken()
+		p.expect(token.Dot, "Expect '.' after 'super'.")
+		method := p.lit
+		p.expect(token.Identifier, "Expect superclass method name.")
+		return &ast.SuperExpr{
+			Span:     p.spanFrom(span.From),
+			Method:   method,
+			Distance: -1,
+		}
+	case token.Fun:
+		p.nextToken()
+		return p.parseFunctionExpr(span.From)
 	case token.LeftParen:
+		if p.isArrowFunction() {
+			return p.parseArrowFunction(span.From)
+		}
 		p.nextToken()
 		inner := p.parseExpression()
 		p.expect(token.RightParen, "Expect ) after expression.")
 		expr = &ast.GroupingExpr{
+			Span:       p.spanFrom(span.From),
 			Expression: inner,
 		}
 		return
 
 	case token.LeftBracket:
 		p.nextToken()
-		return p.parseArrayExpr()
+		return p.parseArrayExpr(span.From)
+	case token.LeftBrace:
+		p.nextToken()
+		return p.parseMapExpr(span.From)
 	}
 	p.nextToken()
 	return expr
 }
 
+// synchronize пропускает токены до начала следующего оператора. Блок, открытый
+// среди пропущенных токенов, пропускается вместе с закрывающей скобкой
 func (p *Parser) synchronize() {
+	depth := 0
 	for !p.isAtEnd() {
 		switch p.tok {
 		case token.Semicolon:
-			p.nextToken()
-			return
-		case token.Class, token.Fun, token.Var, token.If, token.While, token.Print, token.Return:
-			return
+			if depth == 0 {
+				p.nextToken()
+				return
+			}
+		case token.LeftBrace:
+			depth++
+		case token.RightBrace:
+			if depth == 0 {
+				return
+			}
+			depth--
+		case token.Class, token.Fun, token.Var, token.If, token.While, token.For, token.Print, token.Return,
+			token.Break, token.Continue, token.Try, token.Throw:
+			if depth == 0 {
+				return
+			}
+		}
+		p.nextToken()
+	}
+}
+
+// skipTo пропускает токены до одного из stop, не выходя за пределы текущего оператора.
+// Токены внутри вложенных скобок пропускаются целиком.
+func (p *Parser) skipTo(stop ...token.Token) {
+	depth := 0
+	for !p.isAtEnd() {
+		if depth == 0 {
+			for _, tok := range stop {
+				if p.tok == tok {
+					return
+				}
+			}
+			if p.tok == token.Semicolon || p.tok == token.RightBrace {
+				return
+			}
+		}
+		switch p.tok {
+		case token.LeftParen, token.LeftBracket, token.LeftBrace:
+			depth++
+		case token.RightParen, token.RightBracket, token.RightBrace:
+			if depth == 0 {
+				return
+			}
+			depth--
 		}
 		p.nextToken()
 	}
@@ -525,13 +824,55 @@ func (p *Parser) expect(tok token.Token, msg string) {
 		p.nextToken()
 		return
 	}
-	p.error(msg)
+	p.errorExpected(tok, msg)
 }
 
 func (p *Parser) error(msg string) {
-	s := fmt.Sprintf("%s %s", p.l.Pos(), msg)
-	fmt.Fprintln(os.Stderr, s)
-	panic(parseError{s})
+	p.errorExpected(token.Illegal, msg)
+}
+
+// errorExpected запоминает ошибку и раскручивает стек до ближайшей точки восстановления
+func (p *Parser) errorExpected(expected token.Token, msg string) {
+	err := &Error{
+		Pos:      p.span.From,
+		Expected: expected,
+		Found:    p.tok,
+		Msg:      msg,
+	}
+	// ';' не хватает сразу после предыдущего токена, а не там, где начинается следующий
+	if expected == token.Semicolon && p.prevEnd.IsValid() {
+		err.Pos = p.prevEnd
+	}
+	// на недопустимом токене и сразу после него ошибку уже сообщил лексер.
+	// Ошибка не дальше последней найденной - следствие той же ошибки
+	if p.tok != token.Illegal && p.prevTok != token.Illegal && p.errors.before(err.Pos) {
+		p.errors = append(p.errors, err)
+	}
+	panic(parseError{err})
+}
+
+func (p *Parser) lexError(pos token.Position, msg string) {
+	err := &Error{
+		Pos:      pos,
+		Expected: token.Illegal,
+		Found:    token.Illegal,
+		Msg:      msg,
+	}
+	// лексер мог забежать вперед через peek, поэтому ошибку вставляем по позиции
+	i := len(p.errors)
+	for i > 0 && p.errors[i-1].Pos.Offset > pos.Offset {
+		i--
+	}
+	p.errors = append(p.errors[:i], append(ErrorList{err}, p.errors[i:]...)...)
+}
+
+// spanFrom возвращает участок текста от start до конца последнего прочитанного токена
+func (p *Parser) spanFrom(start token.Position) token.Span {
+	end := p.prevEnd
+	if end.Offset < start.Offset {
+		end = start
+	}
+	return token.Span{From: start, To: end}
 }
 
 func (p *Parser) check(tok token.Token) bool {
@@ -550,6 +891,7 @@ func New(l *lexer.Lexer) *Parser {
 	parser := &Parser{
 		l: l,
 	}
+	l.SetErrorHandler(parser.lexError)
 	parser.nextToken()
 	return parser
 }
@@ -3,6 +3,8 @@ package parser
 import (
 	"github.com/Dor1ma/Strawberry/ast"
 	"github.com/Dor1ma/Strawberry/lexer"
+	"github.com/Dor1ma/Strawberry/token"
+	"strings"
 	"testing"
 )
 
@@ -102,6 +104,68 @@ func TestParseExpressionRecover(t *testing.T) {
 	}
 }
 
+func TestParseMapExpr(t *testing.T) {
+	tests := []parserTest{
+		{
+			input:    "{}",
+			expected: "{}",
+		},
+		{
+			input:    `{"a": 1, 2: x + 1, true: [1]}`,
+			expected: "{a: 1, 2: (x + 1), true: [1]}",
+		},
+		{
+			input:    `m["a"] = {"b": {}}`,
+			expected: "m[a] = {b: {}}",
+		},
+	}
+	testExpr(t, tests)
+}
+
+func TestParseFunctionExpr(t *testing.T) {
+	tests := []parserTest{
+		{
+			input:    "fun (a, b) { return a + b; }",
+			expected: "fun (a, b) { return (a + b); }",
+		},
+		{
+			input:    "(a, b) => a + b",
+			expected: "(a, b) => (a + b)",
+		},
+		{
+			input:    "() => 1",
+			expected: "() => 1",
+		},
+		{
+			input:    "(a) => (b) => a * b",
+			expected: "(a) => (b) => (a * b)",
+		},
+		{
+			input:    "map(xs, (x) => x + 1)",
+			expected: "map(xs, (x) => (x + 1))",
+		},
+		{
+			input:    "(a) + (b)",
+			expected: "((a) + (b))",
+		},
+		{
+			input:    "f = fun () { print a; }",
+			expected: "f = fun () { print a; }",
+		},
+	}
+	testExpr(t, tests)
+}
+
+func TestParseAnonymousFunctionStatement(t *testing.T) {
+	input := `fun (x) { print x; }(1);
+	fun named() {}`
+	expected := []string{
+		"fun (x) { print x; }(1);",
+		"fun named() {  }",
+	}
+	testAstString(t, input, expected)
+}
+
 func TestParsePrintStatement(t *testing.T) {
 	input := `var a = 0;
 		a = a + 10;
@@ -182,6 +246,13 @@ func TestParseConditionStatement(t *testing.T) {
 			}`,
 			expected: "while (true) " + block(printStmt),
 		},
+		{
+			input: `while (a < 2) {
+				if (a) break;
+				continue;
+			}`,
+			expected: "while ((a < 2)) " + block("if (a) break;continue;"),
+		},
 	}
 	for i, test := range tests {
 		p := newParserFromInput(test.input)
@@ -223,6 +294,36 @@ func TestParseFunction(t *testing.T) {
 	testAstString(t, input, expected)
 }
 
+func TestParseTryStatement(t *testing.T) {
+	input := `try {
+		print a;
+	} catch (e) {
+		throw e;
+	}
+	try { print a; } finally { print a; }
+	try { print a; } catch (err) { print a; } finally { print a; }
+	throw "x";`
+	expected := []string{
+		"try " + block(printStmt) + " catch (e) " + block("throw e;"),
+		"try " + block(printStmt) + " finally " + block(printStmt),
+		"try " + block(printStmt) + " catch (err) " + block(printStmt) + " finally " + block(printStmt),
+		"throw x;",
+	}
+	testAstString(t, input, expected)
+}
+
+func TestParseTryError(t *testing.T) {
+	p := New(lexer.NewFile("err.berry", "try { }\nprint 1;"))
+	_, err := p.Parse()
+	if err == nil {
+		t.Fatalf("parser doesn't fail.")
+	}
+	expected := "err.berry:2:1: Expect 'catch' or 'finally' after try block."
+	if err.Error() != expected {
+		t.Fatalf("expected error is %q. got %q", expected, err.Error())
+	}
+}
+
 func TestParseClass(t *testing.T) {
 	input := `class A {}
 	class B {}
@@ -235,6 +336,31 @@ func TestParseClass(t *testing.T) {
 	testAstString(t, input, expected)
 }
 
+func TestParseSuperClass(t *testing.T) {
+	input := `class A {}
+	class B < A {
+		fn() {
+			return super.fn();
+		}
+	}`
+	p := newParserFromInput(input)
+	statements, err := p.Parse()
+	if err != nil {
+		t.Fatalf("parse failed. error: %s", err.Error())
+	}
+	class, ok := statements[1].(*ast.ClassStmt)
+	if !ok {
+		t.Fatalf("expected *ast.ClassStmt. got %T", statements[1])
+	}
+	if class.String() != "class B < A" {
+		t.Errorf("expected text is %q. got %q", "class B < A", class.String())
+	}
+	body := class.Methods[0].Body[0].String()
+	if body != "return super.fn();" {
+		t.Errorf("expected method body is %q. got %q", "return super.fn();", body)
+	}
+}
+
 func newParserFromInput(input string) *Parser {
 	l := lexer.New(input)
 	return New(l)
@@ -287,3 +413,194 @@ func testAstString(t *testing.T, input string, expected []string) {
 		}
 	}
 }
+
+func TestParseNodePosition(t *testing.T) {
+	input := `var a = 1;
+fun f(x) {
+    return x + a;
+}
+print f(2);`
+	p := New(lexer.NewFile("pos.berry", input))
+	statements, err := p.Parse()
+	if err != nil {
+		t.Fatalf("parse failed. error: %s", err.Error())
+	}
+	tests := []struct {
+		node ast.Node
+		pos  string
+		end  string
+	}{
+		{statements[0], "pos.berry:1:1", "pos.berry:1:11"},
+		{statements[0].(*ast.VarStmt).Initializer, "pos.berry:1:9", "pos.berry:1:10"},
+		{statements[1], "pos.berry:2:1", "pos.berry:4:2"},
+		{statements[1].(*ast.FunctionStmt).Params[0], "pos.berry:2:7", "pos.berry:2:8"},
+		{statements[1].(*ast.FunctionStmt).Body[0], "pos.berry:3:5", "pos.berry:3:18"},
+		{statements[1].(*ast.FunctionStmt).Body[0].(*ast.ReturnStmt).Value, "pos.berry:3:12", "pos.berry:3:17"},
+		{statements[2], "pos.berry:5:1", "pos.berry:5:12"},
+		{statements[2].(*ast.PrintStmt).Expression, "pos.berry:5:7", "pos.berry:5:11"},
+	}
+	for i, test := range tests {
+		if pos := test.node.Pos().String(); pos != test.pos {
+			t.Errorf("test [%d]: expected position of %s is %s. got %s", i, test.node, test.pos, pos)
+		}
+		if end := test.node.End().String(); end != test.end {
+			t.Errorf("test [%d]: expected end of %s is %s. got %s", i, test.node, test.end, end)
+		}
+	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	p := New(lexer.NewFile("err.berry", "var a = 1;\nvar = 2;"))
+	_, err := p.Parse()
+	if err == nil {
+		t.Fatalf("parser doesn't fail.")
+	}
+	expected := "err.berry:2:5: Expect variable name."
+	if err.Error() != expected {
+		t.Fatalf("expected error is %q. got %q", expected, err.Error())
+	}
+}
+
+func TestParseMultipleErrors(t *testing.T) {
+	input := `var a = 1 + ;
+print a;
+var = 2;
+fun f(x) {
+    print x
+    return x;
+}
+if (a >) print "x";
+print "ok";`
+	p := newParserFromInput(input)
+	statements, err := p.Parse()
+	if err == nil {
+		t.Fatalf("parser doesn't fail.")
+	}
+	list, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("expected error type is ErrorList. got %T", err)
+	}
+
+	expectedErrors := []struct {
+		pos      string
+		expected token.Token
+		found    token.Token
+	}{
+		{"1:13", token.Illegal, token.Semicolon},
+		{"3:5", token.Identifier, token.Equal},
+		{"5:12", token.Semicolon, token.Return},
+		{"8:8", token.Illegal, token.RightParen},
+	}
+	if len(list) != len(expectedErrors) {
+		t.Fatalf("should get %d errors. got %d: %v", len(expectedErrors), len(list), list)
+	}
+	for i, expected := range expectedErrors {
+		e := list[i]
+		if e.Pos.String() != expected.pos || e.Expected != expected.expected || e.Found != expected.found {
+			t.Errorf("error [%d]: expected %s (expected %s, found %s). got %s (expected %s, found %s)",
+				i, expected.pos, expected.expected, expected.found, e.Pos, e.Expected, e.Found)
+		}
+	}
+
+	expectedStmts := []string{
+		"var a = <bad expression>;",
+		"print a;",
+		"<bad statement>",
+		"fun f(x) { <bad statement>return x; }",
+		"if (<bad expression>) print x;",
+		"print ok;",
+	}
+	if len(statements) != len(expectedStmts) {
+		t.Fatalf("length of statements should be %d. got %d", len(expectedStmts), len(statements))
+	}
+	for i, stmt := range statements {
+		if stmt.String() != expectedStmts[i] {
+			t.Errorf("test [%d]: expected text is %q. got %q", i, expectedStmts[i], stmt.String())
+		}
+	}
+}
+
+func TestParseLexerError(t *testing.T) {
+	p := newParserFromInput("var a = \"abc;\nprint a;")
+	_, err := p.Parse()
+	list, ok := err.(ErrorList)
+	if !ok || len(list) != 1 {
+		t.Fatalf("expected one error. got %v", err)
+	}
+	if list[0].Msg != "unterminated string" {
+		t.Fatalf("expected lexer error. got %q", list[0].Msg)
+	}
+}
+
+func TestParseErrorsOnAdjacentLines(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			input:    "print 1\nwhile (true { }",
+			expected: []string{"1:8: Expect ';' after value.", "2:13: Expect ')' after while condition."},
+		},
+		{
+			input:    "print 1; print 2 print 3;",
+			expected: []string{"1:17: Expect ';' after value."},
+		},
+		{
+			// после незакрытого комментария лексер уже сообщил об ошибке
+			input:    "print 1;\n/* abc",
+			expected: []string{"2:1: unterminated comment"},
+		},
+		{
+			// тело функции пропускается целиком, без ошибок внутри него
+			input:    "var a = 1;\nfun f(x { return x; }\nprint a;",
+			expected: []string{"2:9: Expect ')' after parameters."},
+		},
+		{
+			input:    "var b = [1, 2,;\nprint b",
+			expected: []string{"1:15: Expect expression.", "2:8: Expect ';' after value."},
+		},
+		{
+			input:    "print (1;\nprint \"abc",
+			expected: []string{"1:9: Expect ) after expression.", "2:11: unterminated string"},
+		},
+	}
+	for i, test := range tests {
+		_, err := newParserFromInput(test.input).Parse()
+		list, ok := err.(ErrorList)
+		if !ok {
+			t.Fatalf("test [%d]: expected error type is ErrorList. got %T", i, err)
+		}
+		var got []string
+		for j, e := range list {
+			if j > 0 && e.Pos.Offset <= list[j-1].Pos.Offset {
+				t.Errorf("test [%d]: error %s is not after %s", i, e.Pos, list[j-1].Pos)
+			}
+			got = append(got, e.Error())
+		}
+		if strings.Join(got, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("test [%d]: expected errors %q. got %q", i, test.expected, got)
+		}
+	}
+}
+
+func TestParseUnexpectedChar(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"print 1;\nprint 3 % 2;", "2:9: unexpected character '%'"},
+		{"var a = 1 ^ 2;", "1:11: unexpected character '^'"},
+		{"@print 1;", "1:1: unexpected character '@'"},
+		{"print 1;\n#", "2:1: unexpected character '#'"},
+	}
+	for i, test := range tests {
+		_, err := newParserFromInput(test.input).Parse()
+		list, ok := err.(ErrorList)
+		if !ok || len(list) != 1 {
+			t.Fatalf("test [%d]: expected one error. got %v", i, err)
+		}
+		if list[0].Error() != test.expected {
+			t.Errorf("test [%d]: expected error %q. got %q", i, test.expected, list[0].Error())
+		}
+	}
+}
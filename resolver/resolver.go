@@ -1,6 +1,7 @@
 package resolver
 
 import (
+	"fmt"
 	"github.com/Dor1ma/Strawberry/ast"
 	"github.com/Dor1ma/Strawberry/errors"
 	"github.com/Dor1ma/Strawberry/token"
@@ -20,260 +21,409 @@ type classType int
 const (
 	ClassNone classType = iota
 	Class
+	Subclass
 )
 
-var (
-	scopes          = NewScopes()
-	curFunctionType = FunctionNone
-	curClassType    = ClassNone
-)
+// Resolver вычисляет для каждой локальной переменной расстояние до окружения, в котором она определена,
+// и проверяет ошибки, которые видны до выполнения. Каждому интерпретатору нужен свой Resolver.
+type Resolver struct {
+	scopes          Scopes
+	curFunctionType functionType
+	curClassType    classType
+	loopDepth       int // число циклов, в которые вложен текущий оператор
+
+	listener   Listener
+	scopeSpans []token.Span         // участки исходника открытых областей видимости
+	symbols    []map[string]*Symbol // объявления открытых областей, заполняются, если задан listener
+}
 
-func Resolve(node ast.Node) {
+// конструктор
+func New() *Resolver {
+	return &Resolver{
+		scopes:          NewScopes(),
+		curFunctionType: FunctionNone,
+		curClassType:    ClassNone,
+	}
+}
+
+// ErrorList - ошибки resolver, по одной на оператор верхнего уровня, в порядке появления
+type ErrorList []*errors.RuntimeError
+
+func (list ErrorList) Error() string {
+	switch len(list) {
+	case 0:
+		return "no errors"
+	case 1:
+		return list[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", list[0], len(list)-1)
+}
+
+// Check разрешает операторы, ничего не выполняя, и возвращает найденные ошибки как ErrorList.
+// Каждый оператор верхнего уровня разрешается новым Resolver, как в языковом сервере:
+// ошибка в одном операторе не скрывает ошибки в остальных
+func Check(statements []ast.Statement) error {
+	var list ErrorList
+	for _, stmt := range statements {
+		if err := check(stmt); err != nil {
+			list = append(list, err)
+		}
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	return list
+}
+
+func check(stmt ast.Statement) (err *errors.RuntimeError) {
+	defer func() {
+		if r := recover(); r != nil {
+			runErr, ok := r.(errors.RuntimeError)
+			if !ok {
+				panic(r)
+			}
+			err = &runErr
+		}
+	}()
+	New().Resolve(stmt)
+	return nil
+}
+
+// Resolve обходит узел и записывает расстояния в VariableExpr, ThisExpr и SuperExpr.
+// Ошибку сообщает паникой errors.RuntimeError.
+func (r *Resolver) Resolve(node ast.Node) {
 	switch n := node.(type) {
 	default:
 		panic("Resolve failed: unknown ast type.")
 	case *ast.VariableExpr:
-		resolveVariableExpr(n)
+		r.resolveVariableExpr(n)
 	case *ast.AssignExpr:
-		resolveAssignExpr(n)
+		r.resolveAssignExpr(n)
 	case *ast.BinaryExpr:
-		resolveBinaryExpr(n)
+		r.resolveBinaryExpr(n)
 	case *ast.UnaryExpr:
-		resolveUnaryExpr(n)
+		r.resolveUnaryExpr(n)
 	case *ast.LogicalExpr:
-		resolveLogicalExpr(n)
+		r.resolveLogicalExpr(n)
 	case *ast.GroupingExpr:
-		resolveGroupExpr(n)
+		r.resolveGroupExpr(n)
 	case *ast.CallExpr:
-		resolveCallExpr(n)
+		r.resolveCallExpr(n)
+	case *ast.FunctionExpr:
+		r.resolveFunction(n.Span, n.Params, n.Body, Function)
 	case *ast.GetExpr:
-		resolveGetExpr(n)
+		r.resolveGetExpr(n)
 	case *ast.SetExpr:
-		resolveSetExpr(n)
+		r.resolveSetExpr(n)
 	case *ast.ThisExpr:
-		resolveThisExpr(n)
+		r.resolveThisExpr(n)
+	case *ast.SuperExpr:
+		r.resolveSuperExpr(n)
 	case *ast.ArrayExpr:
-		resolveArrayExpr(n)
+		r.resolveArrayExpr(n)
 	case *ast.ArrayIndex:
-		resolveArrayIndex(n)
-	case *ast.Literal:
+		r.resolveArrayIndex(n)
+	case *ast.MapExpr:
+		r.resolveMapExpr(n)
+	case *ast.Literal, *ast.BadExpr, *ast.BadStmt:
 		// скип
 	case *ast.BlockStmt:
-		resolveBlockStmt(n)
+		r.resolveBlockStmt(n)
 	case *ast.VarStmt:
-		resolveVarStmt(n)
+		r.resolveVarStmt(n)
 	case *ast.FunctionStmt:
-		resolveFunctionStmt(n)
+		r.resolveFunctionStmt(n)
 	case *ast.ExprStmt:
-		resolveExprStmt(n)
+		r.resolveExprStmt(n)
 	case *ast.IfStmt:
-		resolveIfStmt(n)
+		r.resolveIfStmt(n)
 	case *ast.WhileStmt:
-		resolveWhileStmt(n)
+		r.resolveWhileStmt(n)
 	case *ast.PrintStmt:
-		resolvePrintStmt(n)
+		r.resolvePrintStmt(n)
 	case *ast.ReturnStmt:
-		resolveReturnStmt(n)
+		r.resolveReturnStmt(n)
+	case *ast.BreakStmt:
+		r.resolveLoopControl(n, token.Break)
+	case *ast.ContinueStmt:
+		r.resolveLoopControl(n, token.Continue)
 	case *ast.ClassStmt:
-		resolveClassStmt(n)
+		r.resolveClassStmt(n)
+	case *ast.TryStmt:
+		r.resolveTryStmt(n)
+	case *ast.ThrowStmt:
+		r.Resolve(n.Value)
 	}
 }
 
-func resolveVariableExpr(expr *ast.VariableExpr) {
-	if exist, init := scopes.check(expr.Name); exist && !init {
-		errors.Error(token.Identifier, "Cannot read local variable in its own initializer.")
+func (r *Resolver) resolveVariableExpr(expr *ast.VariableExpr) {
+	if exist, init := r.scopes.check(expr.Name); exist && !init {
+		errors.Error(expr.Pos(), token.Identifier, "Cannot read local variable in its own initializer.")
 		return
 	}
-	resolveLocal(expr, expr.Name)
+	r.resolveLocal(expr, expr.Name)
 }
 
-func resolveLocal(expr ast.Expression, name string) {
+func (r *Resolver) resolveLocal(expr ast.Expression, name string) {
 	switch n := expr.(type) {
 	case *ast.VariableExpr:
-		for i := len(scopes) - 1; i >= 0; i-- {
-			if _, ok := scopes[i][name]; ok {
-				n.Distance = len(scopes) - 1 - i
+		scope := -1
+		for i := len(r.scopes) - 1; i >= 0; i-- {
+			if _, ok := r.scopes[i][name]; ok {
+				n.Distance = len(r.scopes) - 1 - i
+				scope = i
+				break
+			}
+		}
+		r.use(name, n.Span, scope)
+	case *ast.SuperExpr:
+		for i := len(r.scopes) - 1; i >= 0; i-- {
+			if _, ok := r.scopes[i][name]; ok {
+				n.Distance = len(r.scopes) - 1 - i
+				break
 			}
 		}
 	case *ast.ThisExpr:
 		exist := false
-		for i := len(scopes) - 1; i >= 0; i-- {
-			if _, ok := scopes[i][name]; ok {
+		for i := len(r.scopes) - 1; i >= 0; i-- {
+			if _, ok := r.scopes[i][name]; ok {
 				exist = true
 				break
 			}
 		}
 		if !exist {
-			errors.Error(token.This, "Cannot use 'this' outside of a class.")
+			errors.Error(n.Pos(), token.This, "Cannot use 'this' outside of a class.")
 		}
 	}
 }
 
-func resolveAssignExpr(expr *ast.AssignExpr) {
-	Resolve(expr.Value)
+func (r *Resolver) resolveAssignExpr(expr *ast.AssignExpr) {
+	r.Resolve(expr.Value)
 
 	switch left := expr.Left.(type) {
 	case *ast.VariableExpr:
-		resolveLocal(expr.Left, left.Name)
+		r.resolveLocal(expr.Left, left.Name)
 	case *ast.ArrayIndex:
-		Resolve(left.Index)
-		resolveLocal(left.Array, left.String())
+		r.Resolve(left.Array)
+		r.Resolve(left.Index)
 	default:
 		panic("unsupported assignable type")
 	}
 }
 
-func resolveArrayExpr(expr *ast.ArrayExpr) {
+func (r *Resolver) resolveArrayExpr(expr *ast.ArrayExpr) {
 	for _, element := range expr.Elements {
-		Resolve(element)
+		r.Resolve(element)
 	}
 }
 
-func resolveArrayIndex(expr *ast.ArrayIndex) {
-	Resolve(expr.Array)
-	Resolve(expr.Index)
+func (r *Resolver) resolveMapExpr(expr *ast.MapExpr) {
+	for i, key := range expr.Keys {
+		r.Resolve(key)
+		r.Resolve(expr.Values[i])
+	}
+}
+
+func (r *Resolver) resolveArrayIndex(expr *ast.ArrayIndex) {
+	r.Resolve(expr.Array)
+	r.Resolve(expr.Index)
 }
 
-func resolveBinaryExpr(expr *ast.BinaryExpr) {
-	Resolve(expr.Left)
-	Resolve(expr.Right)
+func (r *Resolver) resolveBinaryExpr(expr *ast.BinaryExpr) {
+	r.Resolve(expr.Left)
+	r.Resolve(expr.Right)
 }
 
-func resolveUnaryExpr(expr *ast.UnaryExpr) {
-	Resolve(expr.Right)
+func (r *Resolver) resolveUnaryExpr(expr *ast.UnaryExpr) {
+	r.Resolve(expr.Right)
 }
 
-func resolveLogicalExpr(expr *ast.LogicalExpr) {
-	Resolve(expr.Left)
-	Resolve(expr.Right)
+func (r *Resolver) resolveLogicalExpr(expr *ast.LogicalExpr) {
+	r.Resolve(expr.Left)
+	r.Resolve(expr.Right)
 }
 
-func resolveGroupExpr(expr *ast.GroupingExpr) {
-	Resolve(expr.Expression)
+func (r *Resolver) resolveGroupExpr(expr *ast.GroupingExpr) {
+	r.Resolve(expr.Expression)
 }
 
-func resolveCallExpr(expr *ast.CallExpr) {
-	Resolve(expr.Callee)
+func (r *Resolver) resolveCallExpr(expr *ast.CallExpr) {
+	r.Resolve(expr.Callee)
 
 	for _, arg := range expr.Arguments {
-		Resolve(arg)
+		r.Resolve(arg)
 	}
 }
 
-func resolveGetExpr(expr *ast.GetExpr) {
-	Resolve(expr.Object)
+func (r *Resolver) resolveGetExpr(expr *ast.GetExpr) {
+	r.Resolve(expr.Object)
 }
 
-func resolveSetExpr(expr *ast.SetExpr) {
-	Resolve(expr.Object)
-	Resolve(expr.Value)
+func (r *Resolver) resolveSetExpr(expr *ast.SetExpr) {
+	r.Resolve(expr.Object)
+	r.Resolve(expr.Value)
 }
 
-func resolveThisExpr(expr *ast.ThisExpr) {
-	if curClassType == ClassNone {
-		errors.Error(token.This, "Cannot use 'this' outside of a class.")
+func (r *Resolver) resolveThisExpr(expr *ast.ThisExpr) {
+	if r.curClassType == ClassNone {
+		errors.Error(expr.Pos(), token.This, "Cannot use 'this' outside of a class.")
 		return
 	}
-	resolveLocal(expr, "this")
+	r.resolveLocal(expr, "this")
 }
 
-func resolveBlockStmt(block *ast.BlockStmt) {
-	scopes.begin()
-	resolveBlock(block.Statements)
-	scopes.end()
+func (r *Resolver) resolveSuperExpr(expr *ast.SuperExpr) {
+	switch r.curClassType {
+	case ClassNone:
+		errors.Error(expr.Pos(), token.Super, "Cannot use 'super' outside of a class.")
+		return
+	case Class:
+		errors.Error(expr.Pos(), token.Super, "Cannot use 'super' in a class with no superclass.")
+		return
+	}
+	r.resolveLocal(expr, "super")
 }
 
-func resolveBlock(statements []ast.Statement) {
+func (r *Resolver) resolveBlockStmt(block *ast.BlockStmt) {
+	r.beginScope(block.Span)
+	defer r.endScope()
+	r.resolveBlock(block.Statements)
+}
+
+func (r *Resolver) resolveBlock(statements []ast.Statement) {
 	for _, stmt := range statements {
-		Resolve(stmt)
+		r.Resolve(stmt)
 	}
 }
 
-func resolveVarStmt(stmt *ast.VarStmt) {
+func (r *Resolver) resolveVarStmt(stmt *ast.VarStmt) {
 	name := stmt.Name.Name
-	scopes.declare(name)
+	r.scopes.declare(name, stmt.Name.Pos())
 	if stmt.Initializer != nil {
-		Resolve(stmt.Initializer)
+		r.Resolve(stmt.Initializer)
 	}
-	scopes.define(name)
+	r.scopes.define(name)
+	r.notifyDeclare(name, stmt.Name.Span, VariableSymbol)
 }
 
-func resolveFunctionStmt(stmt *ast.FunctionStmt) {
-	scopes.declare(stmt.Name)
-	scopes.define(stmt.Name)
-	resolveFunction(stmt, Function)
+func (r *Resolver) resolveFunctionStmt(stmt *ast.FunctionStmt) {
+	r.declare(stmt.Name, stmt.NameSpan, FunctionSymbol)
+	r.resolveFunction(stmt.Span, stmt.Params, stmt.Body, Function)
 }
 
-func resolveFunction(function *ast.FunctionStmt, typ functionType) {
-	enclosingFunction := curFunctionType
-	curFunctionType = typ
+func (r *Resolver) resolveFunction(span token.Span, params []*ast.Identifier, body []ast.Statement, typ functionType) {
+	enclosingFunction, enclosingLoopDepth := r.curFunctionType, r.loopDepth
+	r.curFunctionType, r.loopDepth = typ, 0
 	defer func() {
-		curFunctionType = enclosingFunction
+		r.curFunctionType, r.loopDepth = enclosingFunction, enclosingLoopDepth
 	}()
 
-	scopes.begin()
-	for _, param := range function.Params {
-		scopes.declare(param.Name)
-		scopes.define(param.Name)
+	r.beginScope(span)
+	defer r.endScope()
+	for _, param := range params {
+		r.declare(param.Name, param.Span, ParameterSymbol)
 	}
-	resolveBlock(function.Body)
-	scopes.end()
+	r.resolveBlock(body)
 }
 
-func resolveExprStmt(stmt *ast.ExprStmt) {
-	Resolve(stmt.Expression)
+func (r *Resolver) resolveExprStmt(stmt *ast.ExprStmt) {
+	r.Resolve(stmt.Expression)
 }
 
-func resolveIfStmt(stmt *ast.IfStmt) {
-	Resolve(stmt.Condition)
-	Resolve(stmt.ThenBranch)
+func (r *Resolver) resolveIfStmt(stmt *ast.IfStmt) {
+	r.Resolve(stmt.Condition)
+	r.Resolve(stmt.ThenBranch)
 	if stmt.ElseBranch != nil {
-		Resolve(stmt.ElseBranch)
+		r.Resolve(stmt.ElseBranch)
 	}
 }
 
-func resolveWhileStmt(stmt *ast.WhileStmt) {
-	Resolve(stmt.Condition)
-	Resolve(stmt.Body)
+func (r *Resolver) resolveWhileStmt(stmt *ast.WhileStmt) {
+	r.Resolve(stmt.Condition)
+	r.loopDepth++
+	defer func() {
+		r.loopDepth--
+	}()
+	r.Resolve(stmt.Body)
+	if stmt.Increment != nil {
+		r.Resolve(stmt.Increment)
+	}
 }
 
-func resolvePrintStmt(stmt *ast.PrintStmt) {
-	Resolve(stmt.Expression)
+func (r *Resolver) resolveLoopControl(stmt ast.Statement, tok token.Token) {
+	if r.loopDepth == 0 {
+		errors.Error(stmt.Pos(), tok, fmt.Sprintf("Cannot use '%s' outside of a loop.", tok))
+	}
 }
 
-func resolveReturnStmt(stmt *ast.ReturnStmt) {
-	if curFunctionType == FunctionNone {
-		errors.Error(token.Return, "Cannot return from top-level code.")
+func (r *Resolver) resolvePrintStmt(stmt *ast.PrintStmt) {
+	r.Resolve(stmt.Expression)
+}
+
+func (r *Resolver) resolveReturnStmt(stmt *ast.ReturnStmt) {
+	if r.curFunctionType == FunctionNone {
+		errors.Error(stmt.Pos(), token.Return, "Cannot return from top-level code.")
 		return
 	}
 	if stmt.Value != nil {
-		if curFunctionType == Initializer {
-			errors.Error(token.Return, "Cannot return a value from an initializer.")
+		if r.curFunctionType == Initializer {
+			errors.Error(stmt.Pos(), token.Return, "Cannot return a value from an initializer.")
 			return
 		}
-		Resolve(stmt.Value)
+		r.Resolve(stmt.Value)
+	}
+}
+
+func (r *Resolver) resolveTryStmt(stmt *ast.TryStmt) {
+	r.Resolve(stmt.Body)
+	if stmt.CatchBody != nil {
+		r.resolveCatch(stmt.CatchName, stmt.CatchBody)
+	}
+	if stmt.FinallyBody != nil {
+		r.Resolve(stmt.FinallyBody)
 	}
 }
 
-func resolveClassStmt(stmt *ast.ClassStmt) {
-	scopes.declare(stmt.Name)
-	scopes.define(stmt.Name)
+// переменная исключения живет в своем окружении, вокруг блока catch
+func (r *Resolver) resolveCatch(name *ast.Identifier, body *ast.BlockStmt) {
+	r.beginScope(token.Span{From: name.Pos(), To: body.End()})
+	defer r.endScope()
+	r.declare(name.Name, name.Span, VariableSymbol)
+	r.Resolve(body)
+}
+
+func (r *Resolver) resolveClassStmt(stmt *ast.ClassStmt) {
+	r.declare(stmt.Name, stmt.NameSpan, ClassSymbol)
 
-	enclosingClass := curClassType
-	curClassType = Class
+	enclosingClass := r.curClassType
+	r.curClassType = Class
 	defer func() {
-		curClassType = enclosingClass
+		r.curClassType = enclosingClass
 	}()
 
-	scopes.begin()
-	scopes.declare("this")
-	scopes.define("this")
+	if stmt.SuperClass != nil {
+		if stmt.SuperClass.Name == stmt.Name {
+			errors.Error(stmt.SuperClass.Pos(), token.Class, "A class cannot inherit from itself.")
+			return
+		}
+		r.curClassType = Subclass
+		r.Resolve(stmt.SuperClass)
+
+		r.beginScope(stmt.Span)
+		r.scopes.declare("super", stmt.SuperClass.Pos())
+		r.scopes.define("super")
+		defer r.endScope()
+	}
+
+	r.beginScope(stmt.Span)
+	defer r.endScope()
+	r.scopes.declare("this", stmt.Pos())
+	r.scopes.define("this")
 	for _, method := range stmt.Methods {
 		typ := Method
 		if method.IsInitializer {
 			typ = Initializer
 		}
-		resolveFunction(method, typ)
+		r.resolveFunction(method.Span, method.Params, method.Body, typ)
 	}
-	scopes.end()
 }
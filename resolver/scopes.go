@@ -45,13 +45,13 @@ func (s Scopes) isEmpty() bool {
 	return len(s) == 0
 }
 
-func (s Scopes) declare(name string) {
+func (s Scopes) declare(name string, pos token.Position) {
 	if s.isEmpty() {
 		return
 	}
 	scope := s.peek()
 	if _, ok := scope[name]; ok {
-		errors.Error(token.Var, fmt.Sprintf("variable name %q has been already delcared in this scope.", name))
+		errors.Error(pos, token.Var, fmt.Sprintf("variable name %q has been already delcared in this scope.", name))
 	}
 	scope[name] = false
 }
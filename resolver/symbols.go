@@ -0,0 +1,87 @@
+package resolver
+
+import (
+	"github.com/Dor1ma/Strawberry/token"
+)
+
+// SymbolKind - вид объявления
+type SymbolKind int
+
+const (
+	VariableSymbol SymbolKind = iota
+	FunctionSymbol
+	ClassSymbol
+	ParameterSymbol
+)
+
+// Symbol - объявление имени, найденное Resolver
+type Symbol struct {
+	Name  string
+	Kind  SymbolKind
+	Span  token.Span // участок имени в объявлении
+	Scope token.Span // где объявление видно; нулевой для глобальных
+}
+
+// Global сообщает, что имя объявлено на верхнем уровне
+func (sym *Symbol) Global() bool {
+	return !sym.Scope.From.IsValid()
+}
+
+// Listener получает объявления и использования имен во время обхода. Нужен инструментам
+// вроде языкового сервера, интерпретатору и генератору байт-кода он не нужен
+type Listener interface {
+	// Declare вызывается для каждого объявления переменной, параметра, функции или класса
+	Declare(sym *Symbol)
+	// Use вызывается для каждого использования имени. sym - локальное объявление,
+	// nil для глобальных имен: их объявление может идти и после использования
+	Use(name string, span token.Span, sym *Symbol)
+}
+
+// SetListener задает Listener; nil отключает уведомления
+func (r *Resolver) SetListener(listener Listener) {
+	r.listener = listener
+}
+
+// beginScope открывает область видимости; span - участок исходника, где видны ее объявления
+func (r *Resolver) beginScope(span token.Span) {
+	r.scopes.begin()
+	r.scopeSpans = append(r.scopeSpans, span)
+	r.symbols = append(r.symbols, make(map[string]*Symbol))
+}
+
+func (r *Resolver) endScope() {
+	r.scopes.end()
+	r.scopeSpans = r.scopeSpans[:len(r.scopeSpans)-1]
+	r.symbols = r.symbols[:len(r.symbols)-1]
+}
+
+// declare объявляет и сразу определяет имя
+func (r *Resolver) declare(name string, span token.Span, kind SymbolKind) {
+	r.scopes.declare(name, span.From)
+	r.scopes.define(name)
+	r.notifyDeclare(name, span, kind)
+}
+
+func (r *Resolver) notifyDeclare(name string, span token.Span, kind SymbolKind) {
+	if r.listener == nil {
+		return
+	}
+	sym := &Symbol{Name: name, Kind: kind, Span: span}
+	if len(r.scopeSpans) > 0 {
+		sym.Scope = r.scopeSpans[len(r.scopeSpans)-1]
+		r.symbols[len(r.symbols)-1][name] = sym
+	}
+	r.listener.Declare(sym)
+}
+
+// use сообщает об использовании имени, объявленного в области scope (-1 - глобальное имя)
+func (r *Resolver) use(name string, span token.Span, scope int) {
+	if r.listener == nil {
+		return
+	}
+	var sym *Symbol
+	if scope >= 0 {
+		sym = r.symbols[scope][name]
+	}
+	r.listener.Use(name, span, sym)
+}
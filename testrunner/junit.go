@@ -0,0 +1,113 @@
+package testrunner
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Отчет в формате JUnit XML, который понимают CI-системы. Файл тестов - testsuite,
+// тест - testcase; файл, который не удалось разобрать, дает testcase "setup" с error
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	File      string        `xml:"file,attr,omitempty"`
+	Line      int           `xml:"line,attr,omitempty"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	SystemOut *junitText    `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",cdata"`
+}
+
+type junitText struct {
+	Text string `xml:",cdata"`
+}
+
+// WriteJUnit пишет результаты в формате JUnit XML
+func WriteJUnit(w io.Writer, results []FileResult) error {
+	var suites junitSuites
+	var total float64
+	for _, result := range results {
+		suite := junitSuite{Name: result.File, Time: seconds(result.Duration.Seconds())}
+		total += result.Duration.Seconds()
+		if result.Err != nil {
+			text := errorText(result.Err)
+			suite.Cases = append(suite.Cases, junitCase{
+				Name:      "setup",
+				Classname: result.File,
+				Time:      suite.Time,
+				Error:     &junitMessage{Message: firstLine(text), Text: text},
+			})
+			suite.Tests, suite.Errors = 1, 1
+		}
+		for _, test := range result.Tests {
+			c := junitCase{
+				Name:      test.Name,
+				Classname: result.File,
+				File:      test.Pos.Filename,
+				Line:      test.Pos.Line,
+				Time:      seconds(test.Duration.Seconds()),
+			}
+			if test.Output != "" {
+				c.SystemOut = &junitText{Text: test.Output}
+			}
+			if test.Err != nil {
+				text := errorText(test.Err)
+				c.Failure = &junitMessage{Message: firstLine(text), Text: text}
+				suite.Failures++
+			}
+			suite.Cases = append(suite.Cases, c)
+			suite.Tests++
+		}
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Errors += suite.Errors
+		suites.Suites = append(suites.Suites, suite)
+	}
+	suites.Time = seconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(s float64) string {
+	return fmt.Sprintf("%.3f", s)
+}
+
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(text, "\n")
+	return line
+}
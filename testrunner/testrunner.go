@@ -0,0 +1,232 @@
+// Package testrunner запускает тесты, написанные на Strawberry. Тест - функция верхнего уровня
+// без параметров с именем test_* в файле *_test.berry. Каждый тест выполняется в новом
+// интерпретаторе: сначала заново выполняются операторы верхнего уровня файла, затем вызывается
+// функция теста. Тест не прошел, если вызов закончился ошибкой, например неудачной проверкой
+// assert или assertEqual.
+package testrunner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Dor1ma/Strawberry/ast"
+	"github.com/Dor1ma/Strawberry/errors"
+	"github.com/Dor1ma/Strawberry/interpreter"
+	"github.com/Dor1ma/Strawberry/lexer"
+	"github.com/Dor1ma/Strawberry/parser"
+	"github.com/Dor1ma/Strawberry/resolver"
+	"github.com/Dor1ma/Strawberry/token"
+)
+
+// Options - настройки запуска
+type Options struct {
+	Run     *regexp.Regexp // выполнять только тесты, имя которых подходит; nil - все
+	Verbose bool           // печатать каждый тест, а не только упавшие
+	Output  io.Writer      // куда печатать отчет; nil - os.Stdout
+}
+
+// Result - результат одного теста
+type Result struct {
+	Name     string
+	Pos      token.Position // объявление функции теста
+	Err      error          // nil, если тест прошел
+	Output   string         // что тест напечатал
+	Duration time.Duration
+}
+
+// FileResult - результаты тестов одного файла
+type FileResult struct {
+	File     string
+	Tests    []Result
+	Err      error // файл не удалось прочитать или разобрать, тесты не запускались
+	Duration time.Duration
+}
+
+// Failed возвращает число упавших тестов
+func (f *FileResult) Failed() int {
+	n := 0
+	for _, test := range f.Tests {
+		if test.Err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// IsTestFile сообщает, что в файле по соглашению лежат тесты
+func IsTestFile(path string) bool {
+	return strings.HasSuffix(filepath.Base(path), "_test.berry")
+}
+
+// Discover разбирает файл и возвращает объявления его тестов в порядке следования
+func Discover(filename string, src []byte) ([]*ast.FunctionStmt, error) {
+	statements, err := parse(filename, src)
+	if err != nil {
+		return nil, err
+	}
+	var tests []*ast.FunctionStmt
+	for _, stmt := range statements {
+		if fn, ok := stmt.(*ast.FunctionStmt); ok && strings.HasPrefix(fn.Name, "test_") {
+			tests = append(tests, fn)
+		}
+	}
+	return tests, nil
+}
+
+func parse(filename string, src []byte) ([]ast.Statement, error) {
+	statements, err := parser.New(lexer.NewFile(filename, string(src))).Parse()
+	if err != nil {
+		return nil, err
+	}
+	if err := resolver.Check(statements); err != nil {
+		return nil, err
+	}
+	return statements, nil
+}
+
+// Run выполняет тесты файлов и печатает отчет по мере выполнения
+func Run(files []string, opts Options) []FileResult {
+	if opts.Output == nil {
+		opts.Output = os.Stdout
+	}
+	results := make([]FileResult, 0, len(files))
+	for _, file := range files {
+		result := RunFile(file, opts)
+		summary(opts.Output, &result)
+		results = append(results, result)
+	}
+	return results
+}
+
+// RunFile выполняет тесты одного файла. Если задан opts.Output, в него печатаются упавшие
+// тесты, а с Verbose - все
+func RunFile(path string, opts Options) (result FileResult) {
+	result.File = path
+	start := time.Now()
+	defer func() { result.Duration = time.Since(start) }()
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	tests, err := Discover(path, src)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	for _, fn := range tests {
+		if opts.Run != nil && !opts.Run.MatchString(fn.Name) {
+			continue
+		}
+		if opts.Verbose && opts.Output != nil {
+			fmt.Fprintf(opts.Output, "=== RUN   %s\n", fn.Name)
+		}
+		test := runTest(path, src, fn)
+		if opts.Output != nil && (opts.Verbose || test.Err != nil) {
+			report(opts.Output, &test)
+		}
+		result.Tests = append(result.Tests, test)
+	}
+	return result
+}
+
+// runTest выполняет файл в новом интерпретаторе и вызывает функцию теста
+func runTest(path string, src []byte, fn *ast.FunctionStmt) (result Result) {
+	result.Name, result.Pos = fn.Name, fn.Pos()
+	start := time.Now()
+	defer func() { result.Duration = time.Since(start) }()
+
+	if len(fn.Params) != 0 {
+		result.Err = fmt.Errorf("%s: test function %s must have no parameters", fn.Pos(), fn.Name)
+		return result
+	}
+	// каждому интерпретатору - свое дерево: resolver записывает в него расстояния
+	statements, err := parse(path, src)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	var out bytes.Buffer
+	interp := interpreter.New(interpreter.Options{Stdout: &out, Stderr: &out, Stdin: strings.NewReader("")})
+	defer func() { result.Output = out.String() }()
+	if result.Err = interp.Interpret(statements); result.Err != nil {
+		return result
+	}
+	call := &ast.CallExpr{
+		Span:   fn.Span,
+		Callee: &ast.VariableExpr{Span: fn.NameSpan, Name: fn.Name, Distance: -1},
+	}
+	_, result.Err = interp.Eval(call)
+	return result
+}
+
+// report печатает результат теста в стиле go test: ошибку и вывод теста с отступом
+func report(w io.Writer, test *Result) {
+	status := "PASS"
+	if test.Err != nil {
+		status = "FAIL"
+	}
+	fmt.Fprintf(w, "--- %s: %s (%.2fs)\n", status, test.Name, test.Duration.Seconds())
+	if test.Err != nil {
+		printIndented(w, errorText(test.Err))
+	}
+	if test.Output != "" {
+		printIndented(w, strings.TrimSuffix(test.Output, "\n"))
+	}
+}
+
+// summary печатает итог файла
+func summary(w io.Writer, result *FileResult) {
+	switch {
+	case result.Err != nil:
+		fmt.Fprintf(w, "FAIL\t%s [setup failed]\n", result.File)
+		printIndented(w, errorText(result.Err))
+	case len(result.Tests) == 0:
+		fmt.Fprintf(w, "?   \t%s\t[no tests to run]\n", result.File)
+	case result.Failed() > 0:
+		fmt.Fprintf(w, "FAIL\t%s\t%.3fs\n", result.File, result.Duration.Seconds())
+	default:
+		fmt.Fprintf(w, "ok  \t%s\t%.3fs\n", result.File, result.Duration.Seconds())
+	}
+}
+
+// errorText - сообщение об ошибке с позицией. Если ошибка случилась глубже функции теста,
+// добавляется стек вызовов, начиная с теста
+func errorText(err error) string {
+	switch list := err.(type) {
+	case parser.ErrorList:
+		return joinErrors(list)
+	case resolver.ErrorList:
+		return joinErrors(list)
+	}
+	text := err.Error()
+	if runErr, ok := err.(*errors.RuntimeError); ok && len(runErr.Frames()) > 2 {
+		text += "\nTraceback (most recent call last):"
+		for _, frame := range runErr.Frames()[1:] {
+			text += "\n  " + frame.String()
+		}
+	}
+	return text
+}
+
+// joinErrors печатает каждую ошибку списка на своей строке
+func joinErrors[E error](list []E) string {
+	lines := make([]string, len(list))
+	for i, e := range list {
+		lines[i] = e.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func printIndented(w io.Writer, text string) {
+	for _, line := range strings.Split(text, "\n") {
+		fmt.Fprintf(w, "    %s\n", line)
+	}
+}
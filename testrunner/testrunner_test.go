@@ -0,0 +1,116 @@
+package testrunner
+
+import (
+	"bytes"
+	"encoding/xml"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var files = []string{"testdata/math_test.berry", "testdata/broken_test.berry", "testdata/empty_test.berry"}
+
+func TestDiscover(t *testing.T) {
+	tests, err := Discover("a_test.berry", []byte("fun test_a() {}\nfun helper() {}\nvar test_b = 1;\nfun test_c() {}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tests) != 2 || tests[0].Name != "test_a" || tests[1].Name != "test_c" || tests[1].Pos().Line != 4 {
+		t.Fatalf("unexpected tests %v", tests)
+	}
+	if _, err := Discover("a_test.berry", []byte("fun test_a() { return; }\nreturn 1;\n")); err == nil {
+		t.Fatal("expected a resolve error")
+	}
+	if !IsTestFile("dir/math_test.berry") || IsTestFile("math.berry") || IsTestFile("test.berry") {
+		t.Fatal("unexpected IsTestFile result")
+	}
+}
+
+// durations делает время в отчете постоянным
+var durations = regexp.MustCompile(`\d+\.\d+s`)
+
+func TestRun(t *testing.T) {
+	var out bytes.Buffer
+	results := Run(files, Options{Output: &out})
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	math := results[0]
+	if len(math.Tests) != 5 || math.Failed() != 4 || math.Tests[0].Err != nil {
+		t.Fatalf("unexpected results %+v", math)
+	}
+	if math.Tests[1].Output != "computing\n" {
+		t.Fatalf("unexpected test output %q", math.Tests[1].Output)
+	}
+	if results[1].Err == nil || len(results[2].Tests) != 0 {
+		t.Fatalf("unexpected results %+v", results[1:])
+	}
+
+	report := durations.ReplaceAllString(out.String(), "Xs")
+	for _, expected := range []string{
+		"--- FAIL: test_add_fails (Xs)\n" +
+			"    testdata/math_test.berry:20:5: assertEqual failed: got 4, want 5\n" +
+			"    computing\n",
+		// ошибка в помощнике показывает, откуда он вызван
+		"    testdata/math_test.berry:9:5: assertEqual failed: sum of 1 and 1: got 2, want 3\n" +
+			"    Traceback (most recent call last):\n" +
+			"      testdata/math_test.berry:26:5 in test_helper\n" +
+			"      testdata/math_test.berry:9:5 in checkSum\n",
+		"    testdata/math_test.berry:30:5: assertEqual failed (-want +got):\n" +
+			"        one\n      - 2\n      + two\n        three\n",
+		"    testdata/math_test.berry:39:11: Operands must be numbers or strings.\n",
+		"FAIL\ttestdata/math_test.berry\tXs\n",
+		"FAIL\ttestdata/broken_test.berry [setup failed]\n" +
+			"    testdata/broken_test.berry:2:16: Expect ')' after arguments.\n",
+		"?   \ttestdata/empty_test.berry\t[no tests to run]\n",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Fatalf("expected %q in report:\n%s", expected, report)
+		}
+	}
+	if strings.Contains(report, "test_add ") || strings.Contains(report, "=== RUN") {
+		t.Fatalf("passed tests are reported only with Verbose:\n%s", report)
+	}
+}
+
+func TestRunFilter(t *testing.T) {
+	var out bytes.Buffer
+	results := Run(files[:1], Options{Run: regexp.MustCompile("^test_add"), Verbose: true, Output: &out})
+	if len(results[0].Tests) != 2 {
+		t.Fatalf("expected 2 tests, got %+v", results[0].Tests)
+	}
+	report := durations.ReplaceAllString(out.String(), "Xs")
+	if !strings.HasPrefix(report, "=== RUN   test_add\n--- PASS: test_add (Xs)\n=== RUN   test_add_fails\n") {
+		t.Fatalf("unexpected verbose report:\n%s", report)
+	}
+}
+
+func TestJUnit(t *testing.T) {
+	results := Run(files, Options{Output: &bytes.Buffer{}})
+	var buf bytes.Buffer
+	if err := WriteJUnit(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	var suites junitSuites
+	if err := xml.Unmarshal(buf.Bytes(), &suites); err != nil {
+		t.Fatalf("invalid XML: %s\n%s", err, buf.String())
+	}
+	if suites.Tests != 6 || suites.Failures != 4 || suites.Errors != 1 || len(suites.Suites) != 3 {
+		t.Fatalf("unexpected totals %+v", suites)
+	}
+	cases := suites.Suites[0].Cases
+	if cases[0].Name != "test_add" || cases[0].Failure != nil || cases[0].Line != 12 {
+		t.Fatalf("unexpected test case %+v", cases[0])
+	}
+	failure := cases[3].Failure
+	if failure == nil || failure.Message != "testdata/math_test.berry:30:5: assertEqual failed (-want +got):" ||
+		!strings.Contains(failure.Text, "\n  - 2\n  + two\n") {
+		t.Fatalf("unexpected failure %+v", failure)
+	}
+	if cases[1].SystemOut == nil || cases[1].SystemOut.Text != "computing\n" {
+		t.Fatalf("unexpected system-out %+v", cases[1].SystemOut)
+	}
+	if setup := suites.Suites[1].Cases[0]; setup.Name != "setup" || setup.Error == nil {
+		t.Fatalf("unexpected setup case %+v", setup)
+	}
+}
@@ -0,0 +1,41 @@
+package token
+
+import "fmt"
+
+// Position - позиция в исходном тексте
+type Position struct {
+	Filename string // имя файла, может быть пустым
+	Offset   int    // смещение в байтах, начиная с 0
+	Line     int    // номер строки, начиная с 1
+	Column   int    // номер колонки (в символах), начиная с 1
+}
+
+// IsValid сообщает, заполнена ли позиция.
+func (pos Position) IsValid() bool { return pos.Line > 0 }
+
+// String возвращает позицию в виде file:line:col (или line:col, если имени файла нет)
+func (pos Position) String() string {
+	s := pos.Filename
+	if pos.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
+}
+
+// Span - участок исходного текста: From включительно, To не включительно.
+type Span struct {
+	From Position
+	To   Position
+}
+
+// Pos возвращает начало участка
+func (s Span) Pos() Position { return s.From }
+
+// End возвращает позицию сразу после участка
+func (s Span) End() Position { return s.To }
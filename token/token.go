@@ -19,6 +19,7 @@ const (
 	LeftBracket  // [
 	RightBracket // ]
 	Comma        // ,
+	Colon        // :
 	Dot          // .
 	Minus        // -
 	Plus         // +
@@ -30,6 +31,7 @@ const (
 	NotEqual           // !=
 	Equal              // =
 	EqualEqual         // ==
+	Arrow              // =>
 	Greater            // >
 	GreaterThanOrEqual // >=
 	Less               // <
@@ -41,22 +43,28 @@ const (
 
 	keywordBegin
 
-	And    // and
-	Class  // class
-	Else   // else
-	False  // false
-	Fun    // fun
-	For    // for
-	If     // if
-	Nil    // nil
-	Or     // or
-	Print  // print
-	Return // return
-	Super  // super
-	This   // this
-	True   // true
-	Var    // var
-	While  // while
+	And      // and
+	Break    // break
+	Catch    // catch
+	Class    // class
+	Continue // continue
+	Else     // else
+	False    // false
+	Finally  // finally
+	Fun      // fun
+	For      // for
+	If       // if
+	Nil      // nil
+	Or       // or
+	Print    // print
+	Return   // return
+	Super    // super
+	This     // this
+	Throw    // throw
+	True     // true
+	Try      // try
+	Var      // var
+	While    // while
 
 	keywordEnd
 )
@@ -72,6 +80,7 @@ var tokens = [...]string{
 	LeftBracket:        "[",
 	RightBracket:       "]",
 	Comma:              ",",
+	Colon:              ":",
 	Dot:                ".",
 	Minus:              "-",
 	Plus:               "+",
@@ -82,6 +91,7 @@ var tokens = [...]string{
 	NotEqual:           "!=",
 	Equal:              "=",
 	EqualEqual:         "==",
+	Arrow:              "=>",
 	Greater:            ">",
 	GreaterThanOrEqual: ">=",
 	Less:               "<",
@@ -90,9 +100,13 @@ var tokens = [...]string{
 	String:             "string",
 	Number:             "number",
 	And:                "and",
+	Break:              "break",
+	Catch:              "catch",
 	Class:              "class",
+	Continue:           "continue",
 	Else:               "else",
 	False:              "false",
+	Finally:            "finally",
 	Fun:                "fun",
 	For:                "for",
 	If:                 "if",
@@ -102,7 +116,9 @@ var tokens = [...]string{
 	Return:             "return",
 	Super:              "super",
 	This:               "this",
+	Throw:              "throw",
 	True:               "true",
+	Try:                "try",
 	Var:                "var",
 	While:              "while",
 }
@@ -139,6 +155,15 @@ func (tok Token) MarshalJSON() ([]byte, error) {
 	return json.Marshal(tok.String())
 }
 
+// Keywords returns all keywords in declaration order.
+func Keywords() []string {
+	names := make([]string, 0, int(keywordEnd)-int(keywordBegin)-1)
+	for i := int(keywordBegin) + 1; i < int(keywordEnd); i++ {
+		names = append(names, tokens[i])
+	}
+	return names
+}
+
 // Lookup returns the token type associated with a given string.
 func Lookup(ident string) Token {
 	if tok, ok := keywords[ident]; ok {
@@ -9,9 +9,13 @@ func TestIdentIsKeyword(t *testing.T) {
 	}{
 		{"abc", Identifier},
 		{"and", And},
+		{"break", Break},
+		{"catch", Catch},
 		{"class", Class},
+		{"continue", Continue},
 		{"else", Else},
 		{"false", False},
+		{"finally", Finally},
 		{"fun", Fun},
 		{"for", For},
 		{"if", If},
@@ -21,7 +25,9 @@ func TestIdentIsKeyword(t *testing.T) {
 		{"return", Return},
 		{"super", Super},
 		{"this", This},
+		{"throw", Throw},
 		{"true", True},
+		{"try", Try},
 		{"var", Var},
 		{"while", While},
 	}
@@ -0,0 +1,28 @@
+package valuer
+
+// Error - ошибка времени выполнения, перехваченная в catch. Свойства message и trace доступны из скрипта
+type Error struct {
+	Message string
+	Trace   []string // кадры стека в виде "позиция in функция", самый последний вызов - в конце
+}
+
+func (*Error) Type() Type { return ErrorType }
+
+func (e *Error) String() string {
+	return e.Message
+}
+
+// Property возвращает свойство ошибки по имени
+func (e *Error) Property(name string) (Valuer, bool) {
+	switch name {
+	case "message":
+		return &String{Value: e.Message}, true
+	case "trace":
+		elements := make([]Valuer, len(e.Trace))
+		for i, frame := range e.Trace {
+			elements[i] = &String{Value: frame}
+		}
+		return &Array{Elements: elements}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,108 @@
+package valuer
+
+import "strings"
+
+// MapKey - нормализованный ключ словаря. Ключами могут быть только строки, числа и булевы значения,
+// два ключа совпадают, если у них одинаковый тип и одинаковое значение
+type MapKey struct {
+	Type  Type
+	Value interface{}
+}
+
+// NewMapKey возвращает ключ для значения v. Второй результат false, если v не может быть ключом
+func NewMapKey(v Valuer) (MapKey, bool) {
+	switch k := v.(type) {
+	case *Number:
+		if k.Value == 0 {
+			// -0 и 0 - один и тот же ключ
+			return MapKey{Type: NumberType, Value: float64(0)}, true
+		}
+		return MapKey{Type: NumberType, Value: k.Value}, true
+	case *String:
+		return MapKey{Type: StringType, Value: k.Value}, true
+	case *Boolean:
+		return MapKey{Type: BooleanType, Value: k.Value}, true
+	}
+	return MapKey{}, false
+}
+
+// Valuer восстанавливает значение, из которого был получен ключ
+func (k MapKey) Valuer() Valuer {
+	switch k.Type {
+	case NumberType:
+		return &Number{Value: k.Value.(float64)}
+	case StringType:
+		return &String{Value: k.Value.(string)}
+	case BooleanType:
+		return &Boolean{Value: k.Value.(bool)}
+	}
+	panic("invalid map key")
+}
+
+// Map - словарь. Ключи перебираются в порядке добавления
+type Map struct {
+	entries map[MapKey]Valuer
+	keys    []MapKey
+}
+
+func NewMap() *Map {
+	return &Map{entries: make(map[MapKey]Valuer)}
+}
+
+func (*Map) Type() Type { return MapType }
+
+func (m *Map) String() string {
+	entries := make([]string, len(m.keys))
+	for i, key := range m.keys {
+		entries[i] = key.Valuer().String() + ": " + m.entries[key].String()
+	}
+	return "{" + strings.Join(entries, ", ") + "}"
+}
+
+func (m *Map) Get(key MapKey) (Valuer, bool) {
+	v, ok := m.entries[key]
+	return v, ok
+}
+
+func (m *Map) Set(key MapKey, v Valuer) {
+	if _, ok := m.entries[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.entries[key] = v
+}
+
+// Delete удаляет ключ и сообщает, был ли он в словаре
+func (m *Map) Delete(key MapKey) bool {
+	if _, ok := m.entries[key]; !ok {
+		return false
+	}
+	delete(m.entries, key)
+	for i, k := range m.keys {
+		if k == key {
+			m.keys = append(m.keys[:i], m.keys[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+func (m *Map) Len() int {
+	return len(m.keys)
+}
+
+// Keys возвращает ключи в порядке добавления
+func (m *Map) Keys() []Valuer {
+	keys := make([]Valuer, len(m.keys))
+	for i, key := range m.keys {
+		keys[i] = key.Valuer()
+	}
+	return keys
+}
+
+// MapMethodArity - число аргументов встроенных методов словаря
+var MapMethodArity = map[string]int{
+	"keys":   0,
+	"len":    0,
+	"has":    1,
+	"delete": 1,
+}
@@ -0,0 +1,22 @@
+package valuer
+
+// NativeFn - реализация встроенной функции на Go. Ошибка превращается в ошибку времени выполнения
+// с позицией вызова
+type NativeFn func(args []Valuer) (Valuer, error)
+
+// NativeFunction - функция, написанная на Go и доступная из скриптов
+type NativeFunction struct {
+	Name      string
+	NumParams int // VariadicArity, если число аргументов проверяет сама функция
+	Fn        NativeFn
+}
+
+func (*NativeFunction) Type() Type { return FunctionType }
+
+func (fn *NativeFunction) String() string {
+	return "<native fn " + fn.Name + ">"
+}
+
+func (fn *NativeFunction) Arity() int {
+	return fn.NumParams
+}
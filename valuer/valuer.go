@@ -12,10 +12,15 @@ var typeMap = map[Type]string{
 	StringType:   "string",
 	BooleanType:  "bool",
 	ArrayType:    "array",
+	MapType:      "map",
 	NilType:      "nil",
 	FunctionType: "function",
 	ReturnType:   "return",
+	BreakType:    "break",
+	ContinueType: "continue",
 	ClassType:    "class",
+	InstanceType: "instance",
+	ErrorType:    "error",
 }
 
 type Type int
@@ -28,8 +33,12 @@ const (
 	NilType                      // nil
 	FunctionType                 // function
 	ReturnType                   // return
+	BreakType                    // break
+	ContinueType                 // continue
 	ClassType                    // class
 	InstanceType                 // instance
+	MapType                      // map
+	ErrorType                    // error
 )
 
 func (typ Type) String() string {
@@ -44,11 +53,16 @@ type Valuer interface {
 	String() string
 }
 
+// Callable - значение, которое можно вызвать: функция, класс или встроенная функция
 type Callable interface {
-	call()
+	Valuer
+	// Arity возвращает число параметров, VariadicArity - если их число не фиксировано
 	Arity() int
 }
 
+// VariadicArity - арность функций, которые принимают любое число аргументов
+const VariadicArity = -1
+
 type Number struct {
 	Value float64
 }
@@ -82,6 +96,9 @@ func (*Nil) Type() Type { return NilType }
 
 func (*Nil) String() string { return "nil" }
 
+// LambdaName - имя анонимных функций в трассировке стека
+const LambdaName = "lambda"
+
 type Function struct {
 	Name          string
 	Params        []*ast.Identifier
@@ -92,8 +109,6 @@ type Function struct {
 
 func (*Function) Type() Type { return FunctionType }
 
-func (*Function) call() {}
-
 func (fn *Function) String() string {
 	return "<fn " + fn.Name + ">"
 }
@@ -107,10 +122,11 @@ func (fn *Function) Bind(instance *Instance) *Function {
 	environment := NewEnclosing(fn.Closure)
 	environment.Define("this", instance)
 	return &Function{
-		Name:    fn.Name,
-		Params:  fn.Params,
-		Body:    fn.Body,
-		Closure: environment,
+		Name:          fn.Name,
+		Params:        fn.Params,
+		Body:          fn.Body,
+		Closure:       environment,
+		IsInitializer: fn.IsInitializer,
 	}
 }
 
@@ -124,6 +140,19 @@ func (rt *ReturnValue) String() string {
 	return rt.Value.String()
 }
 
+// Break и Continue поднимаются из тела цикла так же, как ReturnValue из функции
+type Break struct{}
+
+func (*Break) Type() Type { return BreakType }
+
+func (*Break) String() string { return "break" }
+
+type Continue struct{}
+
+func (*Continue) Type() Type { return ContinueType }
+
+func (*Continue) String() string { return "continue" }
+
 type Array struct {
 	Elements []Valuer
 }
@@ -141,14 +170,13 @@ func (a *Array) String() string {
 }
 
 type ClassValue struct {
-	Name    string
-	Methods map[string]*Function
+	Name       string
+	SuperClass *ClassValue // nil, если у класса нет родителя
+	Methods    map[string]*Function
 }
 
 func (*ClassValue) Type() Type { return ClassType }
 
-func (*ClassValue) call() {}
-
 func (c *ClassValue) Arity() int {
 	initializer := c.FindMethod("init")
 	if initializer != nil {
@@ -161,10 +189,14 @@ func (c *ClassValue) String() string {
 	return "class " + c.Name
 }
 
+// FindMethod ищет метод в классе, а затем по цепочке родительских классов
 func (c *ClassValue) FindMethod(key string) *Function {
 	if method, ok := c.Methods[key]; ok {
 		return method
 	}
+	if c.SuperClass != nil {
+		return c.SuperClass.FindMethod(key)
+	}
 	return nil
 }
 
@@ -173,7 +205,7 @@ type Instance struct {
 	Fields map[string]Valuer
 }
 
-func (*Instance) Type() Type { return ClassType }
+func (*Instance) Type() Type { return InstanceType }
 
 func (i *Instance) String() string {
 	return i.Klass.Name + " instance"
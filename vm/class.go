@@ -0,0 +1,99 @@
+package virtm
+
+import "fmt"
+
+// Class - класс: методы хранятся как замыкания, родительский класс - nil, если его нет
+type Class struct {
+	name       string
+	superClass *Class
+	methods    map[string]*Closure
+}
+
+// findMethod ищет метод в классе, а затем по цепочке родительских классов
+func (class *Class) findMethod(name string) *Closure {
+	for cur := class; cur != nil; cur = cur.superClass {
+		if method, ok := cur.methods[name]; ok {
+			return method
+		}
+	}
+	return nil
+}
+
+// bind возвращает метод, у которого в окружении определен this
+func (closure *Closure) bind(this StackValue) *Closure {
+	env := newEnvironment(closure.env)
+	env.define("this", this)
+	bound := *closure
+	bound.env = env
+	return &bound
+}
+
+func (virtualMachine *VirtualMachine) newInstanceID() string {
+	virtualMachine.arrayCounter++
+	return fmt.Sprintf("instance_%d", virtualMachine.arrayCounter)
+}
+
+// newInstance размещает в куче экземпляр класса без полей
+func (virtualMachine *VirtualMachine) newInstance(class *Class) StackValue {
+	instanceID := virtualMachine.newInstanceID()
+	virtualMachine.heap[instanceID] = GCObject{
+		entries: make(map[StackValue]StackValue),
+		class:   class,
+	}
+	return StackValue{Value: instanceID, ValueType: INSTANCE}
+}
+
+func propertyKey(name string) StackValue {
+	return StackValue{Value: name, ValueType: STRING}
+}
+
+// getProperty возвращает поле экземпляра или метод, привязанный к нему
+func (virtualMachine *VirtualMachine) getProperty(object StackValue, name string) StackValue {
+	switch object.ValueType {
+	case INSTANCE:
+		obj := virtualMachine.heap[object.Value.(string)]
+		if value, ok := obj.entries[propertyKey(name)]; ok {
+			return value
+		}
+		if method := obj.class.findMethod(name); method != nil {
+			return StackValue{Value: method.bind(object), ValueType: FUNCTION}
+		}
+	case ERROR:
+		if value, ok := virtualMachine.heap[object.Value.(string)].entries[propertyKey(name)]; ok {
+			return value
+		}
+	default:
+		virtualMachine.error("Only instances have properties.")
+	}
+	virtualMachine.error(fmt.Sprintf("Undefined propterty %s.", name))
+	return StackValue{}
+}
+
+func (virtualMachine *VirtualMachine) setProperty(object StackValue, name string, value StackValue) {
+	if object.ValueType != INSTANCE {
+		virtualMachine.error("Only instances have properties.")
+	}
+	virtualMachine.heap[object.Value.(string)].entries[propertyKey(name)] = value
+}
+
+// superMethod возвращает метод родительского класса, привязанный к this. distance - расстояние
+// до окружения с super, this определен в окружении на одно ближе
+func (virtualMachine *VirtualMachine) superMethod(name string, distance int) StackValue {
+	superClass, ok := virtualMachine.env.ancestor(distance).get("super")
+	if !ok || superClass.ValueType != CLASS {
+		virtualMachine.error("Cannot use 'super' outside of a class.")
+	}
+	this, ok := virtualMachine.env.ancestor(distance - 1).get("this")
+	if !ok {
+		virtualMachine.error("Cannot use 'super' outside of a method.")
+	}
+	method := superClass.Value.(*Class).findMethod(name)
+	if method == nil {
+		virtualMachine.error(fmt.Sprintf("Undefined property %s.", name))
+	}
+	return StackValue{Value: method.bind(this), ValueType: FUNCTION}
+}
+
+func (virtualMachine *VirtualMachine) instanceString(instanceID string) string {
+	return virtualMachine.heap[instanceID].class.name + " instance"
+}
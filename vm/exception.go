@@ -0,0 +1,84 @@
+package virtm
+
+import (
+	"fmt"
+	"github.com/Dor1ma/Strawberry/errors"
+)
+
+// handler - обработчик исключений, установленный TRY_START. Хранит состояние VM,
+// к которому она возвращается при исключении
+type handler struct {
+	target    int // адрес catch в функции, где установлен обработчик
+	stackSize int
+	callDepth int
+	env       *Environment
+}
+
+func (virtualMachine *VirtualMachine) newErrorID() string {
+	virtualMachine.arrayCounter++
+	return fmt.Sprintf("error_%d", virtualMachine.arrayCounter)
+}
+
+// newError создает в куче ошибку со свойствами message и trace
+func (virtualMachine *VirtualMachine) newError(message string, frames []errors.Frame) StackValue {
+	trace := make([]StackValue, len(frames))
+	for i, frame := range frames {
+		trace[i] = StackValue{Value: frame.String(), ValueType: STRING}
+	}
+	traceID := virtualMachine.newArrayID()
+	virtualMachine.heap[traceID] = GCObject{data: trace}
+
+	obj := GCObject{entries: map[StackValue]StackValue{
+		{Value: "message", ValueType: STRING}: {Value: message, ValueType: STRING},
+		{Value: "trace", ValueType: STRING}:   {Value: traceID, ValueType: ARRAY},
+	}}
+	errorID := virtualMachine.newErrorID()
+	virtualMachine.heap[errorID] = obj
+	return StackValue{Value: errorID, ValueType: ERROR}
+}
+
+// errorMessage возвращает текст, который увидит пользователь, если исключение не перехватят
+func (virtualMachine *VirtualMachine) errorMessage(value StackValue) string {
+	switch value.ValueType {
+	case ERROR:
+		return virtualMachine.heap[value.Value.(string)].entries[StackValue{Value: "message", ValueType: STRING}].Value.(string)
+	case STRING:
+		return value.Value.(string)
+	case ARRAY:
+		return fmt.Sprint(virtualMachine.heap[value.Value.(string)].data)
+	case MAP:
+		return virtualMachine.mapString(value.Value.(string))
+	}
+	return value.String()
+}
+
+// handleError передает ошибку ближайшему обработчику. Возвращает false, если обработчиков нет
+func (virtualMachine *VirtualMachine) handleError(runErr *errors.RuntimeError) bool {
+	n := len(virtualMachine.handlers)
+	if n == 0 {
+		return false
+	}
+	h := virtualMachine.handlers[n-1]
+	virtualMachine.handlers = virtualMachine.handlers[:n-1]
+
+	value, ok := runErr.Thrown().(StackValue)
+	if !ok {
+		value = virtualMachine.newError(runErr.Msg(), runErr.Frames())
+	}
+
+	// разматываем вызовы, сделанные после установки обработчика
+	if len(virtualMachine.frames) > h.callDepth {
+		caller := virtualMachine.frames[h.callDepth]
+		virtualMachine.stack = caller.stack
+		virtualMachine.function = caller.function
+		virtualMachine.frames = virtualMachine.frames[:h.callDepth]
+		virtualMachine.calls = virtualMachine.calls[:h.callDepth]
+	}
+	virtualMachine.env = h.env
+	virtualMachine.stack = virtualMachine.stack[:h.stackSize]
+
+	virtualMachine.stack.Push(value)
+	virtualMachine.programCounter = h.target
+	virtualMachine.line, virtualMachine.last = 0, -1
+	return true
+}
@@ -3,14 +3,20 @@ package virtm
 type GCObject struct {
 	marked bool
 	data   []StackValue
+
+	// только для словарей: значения по ключу и ключи в порядке добавления
+	entries map[StackValue]StackValue
+	keys    []StackValue
+
+	class *Class // только для экземпляров, поля хранятся в entries
 }
 
 func (gc *VirtualMachine) MarkRoots() {
 	for _, item := range gc.stack {
 		gc.Mark(item)
 	}
-	for _, scope := range gc.variables {
-		for _, value := range scope {
+	for env := gc.env; env != nil; env = env.enclosing {
+		for _, value := range env.values {
 			gc.Mark(value)
 		}
 	}
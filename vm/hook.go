@@ -0,0 +1,160 @@
+package virtm
+
+import (
+	"sort"
+
+	"github.com/Dor1ma/Strawberry/ast"
+	bytecode_gen "github.com/Dor1ma/Strawberry/bytecode"
+	"github.com/Dor1ma/Strawberry/errors"
+	"github.com/Dor1ma/Strawberry/resolver"
+	"github.com/Dor1ma/Strawberry/token"
+)
+
+// Hook получает управление во время выполнения программы. Пока его метод не вернул управление,
+// программа стоит. Так к VM подключается отладчик
+type Hook interface {
+	// Statement вызывается перед первой инструкцией каждого оператора: когда меняется строка
+	// исходника, после перехода назад (следующая итерация цикла) и при входе в функцию
+	Statement(pos token.Position)
+	// Return вызывается после возврата из функции; pos - место вызова
+	Return(pos token.Position)
+}
+
+// SetHook задает Hook; nil отключает его
+func (virtualMachine *VirtualMachine) SetHook(hook Hook) {
+	virtualMachine.hook = hook
+}
+
+// statement сообщает hook о начале нового оператора. Пролог функции, который определяет
+// параметры, и неявный возврат nil в конце помечены позицией объявления функции и не сообщаются
+func (virtualMachine *VirtualMachine) statement() {
+	pos := virtualMachine.pos()
+	backward := virtualMachine.instruction <= virtualMachine.last
+	virtualMachine.last = virtualMachine.instruction
+	if !pos.IsValid() || pos.Line == virtualMachine.line && !backward {
+		return
+	}
+	if function := virtualMachine.function; function != virtualMachine.program.Functions[0] &&
+		pos == function.Chunk.Position(len(function.Chunk.Code)-1) {
+		return
+	}
+	virtualMachine.line = pos.Line
+	virtualMachine.hook.Statement(pos)
+}
+
+// CallStack возвращает копию стека активных вызовов, внешний вызов первым
+func (virtualMachine *VirtualMachine) CallStack() []errors.CallFrame {
+	return append([]errors.CallFrame(nil), virtualMachine.calls...)
+}
+
+// Pos возвращает позицию исполняемой инструкции
+func (virtualMachine *VirtualMachine) Pos() token.Position {
+	return virtualMachine.pos()
+}
+
+// Env возвращает текущее окружение. Цепочка Enclosing заканчивается глобальным окружением
+func (virtualMachine *VirtualMachine) Env() *Environment {
+	return virtualMachine.env
+}
+
+// Enclosing возвращает объемлющее окружение, nil для глобального
+func (env *Environment) Enclosing() *Environment {
+	return env.enclosing
+}
+
+// Names возвращает имена переменных окружения без объемлющих, по алфавиту
+func (env *Environment) Names() []string {
+	names := make([]string, 0, len(env.values))
+	for name := range env.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup возвращает переменную окружения без поиска в объемлющих
+func (env *Environment) Lookup(name string) (StackValue, bool) {
+	value, ok := env.values[name]
+	return value, ok
+}
+
+// Eval вычисляет выражение в текущем окружении остановленной программы. Выражение компилируется
+// в отдельную программу, которая исполняется с окружениями и кучей этой VM
+func (virtualMachine *VirtualMachine) Eval(expr ast.Expression) (value StackValue, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			runErr, ok := r.(errors.RuntimeError)
+			if !ok {
+				panic(r)
+			}
+			err = &runErr
+		}
+	}()
+	virtualMachine.bindNames(expr)
+	cg := &bytecode_gen.CodeGenerator{}
+	cg.GenerateExpression(expr)
+	program, err := cg.Program()
+	if err != nil {
+		return StackValue{}, err
+	}
+
+	sub := &VirtualMachine{
+		stdout:       virtualMachine.stdout,
+		stack:        make(StackStruct, 0),
+		program:      program,
+		function:     program.Functions[0],
+		globals:      virtualMachine.globals,
+		env:          virtualMachine.env,
+		heap:         virtualMachine.heap,
+		arrayCounter: virtualMachine.arrayCounter,
+	}
+	runErr := sub.Run()
+	virtualMachine.arrayCounter = sub.arrayCounter
+	if runErr != nil {
+		return StackValue{}, runErr
+	}
+	return sub.stack.Pop(), nil
+}
+
+// bindNames задает расстояния до переменных выражения по цепочке окружений: resolver не знает,
+// в каком месте программы она остановлена. Функции внутри выражения разрешаются как на верхнем уровне
+func (virtualMachine *VirtualMachine) bindNames(expr ast.Expression) {
+	ast.Inspect(expr, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.VariableExpr:
+			n.Distance = virtualMachine.distance(n.Name)
+		case *ast.SuperExpr:
+			n.Distance = virtualMachine.distance("super")
+		case *ast.FunctionExpr:
+			resolver.New().Resolve(n)
+			return false
+		}
+		return true
+	})
+}
+
+// distance возвращает расстояние до окружения, где определено имя, или -1 для глобальных
+func (virtualMachine *VirtualMachine) distance(name string) int {
+	d := 0
+	for env := virtualMachine.env; env != nil && env != virtualMachine.globals; env = env.enclosing {
+		if _, ok := env.values[name]; ok {
+			return d
+		}
+		d++
+	}
+	return -1
+}
+
+// Truthy сообщает, считается ли значение истинным в условии. Правила те же, что в интерпретаторе:
+// истинны true, ненулевые числа и непустые строки, остальные значения ложны
+func Truthy(value StackValue) bool {
+	switch value.ValueType {
+	case BOOL:
+		return value.Value.(bool)
+	case NUMBER:
+		return value.Value.(float64) != 0
+	case STRING:
+		return value.Value.(string) != ""
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package virtm
+
+import (
+	"fmt"
+	"github.com/Dor1ma/Strawberry/valuer"
+	"strings"
+)
+
+func nilValue() StackValue {
+	return StackValue{ValueType: NIL}
+}
+
+func isMapKey(value StackValue) bool {
+	return value.ValueType == NUMBER || value.ValueType == BOOL || value.ValueType == STRING
+}
+
+// mapKey проверяет, что значение может быть ключом словаря
+func (virtualMachine *VirtualMachine) mapKey(key StackValue) StackValue {
+	if isMapKey(key) {
+		return key
+	}
+	virtualMachine.error("Map keys must be strings, numbers or booleans.")
+	return StackValue{}
+}
+
+func (virtualMachine *VirtualMachine) mapSet(obj *GCObject, key, value StackValue) {
+	key = virtualMachine.mapKey(key)
+	if _, ok := obj.entries[key]; !ok {
+		obj.keys = append(obj.keys, key)
+	}
+	obj.entries[key] = value
+}
+
+func (virtualMachine *VirtualMachine) callMapMethod(mapID, name string, arguments []StackValue) StackValue {
+	arity, ok := valuer.MapMethodArity[name]
+	if !ok {
+		virtualMachine.error(fmt.Sprintf("Undefined map method %s.", name))
+	}
+	if arity != len(arguments) {
+		virtualMachine.error(fmt.Sprintf("Expected %d arguments but got %d", arity, len(arguments)))
+	}
+
+	obj := virtualMachine.heap[mapID]
+	if len(arguments) == 1 && !isMapKey(arguments[0]) {
+		// такого ключа в словаре быть не может
+		return StackValue{Value: false, ValueType: BOOL}
+	}
+	switch name {
+	case "keys":
+		keys := make([]StackValue, len(obj.keys))
+		copy(keys, obj.keys)
+		arrayID := virtualMachine.newArrayID()
+		virtualMachine.heap[arrayID] = GCObject{data: keys}
+		return StackValue{Value: arrayID, ValueType: ARRAY}
+	case "len":
+		return numberValue(float64(len(obj.keys)))
+	case "has":
+		_, ok := obj.entries[virtualMachine.mapKey(arguments[0])]
+		return StackValue{Value: ok, ValueType: BOOL}
+	case "delete":
+		key := virtualMachine.mapKey(arguments[0])
+		_, ok := obj.entries[key]
+		if ok {
+			delete(obj.entries, key)
+			for i, k := range obj.keys {
+				if k == key {
+					obj.keys = append(obj.keys[:i], obj.keys[i+1:]...)
+					break
+				}
+			}
+			virtualMachine.heap[mapID] = obj
+		}
+		return StackValue{Value: ok, ValueType: BOOL}
+	}
+	panic("unknown map method " + name)
+}
+
+func (virtualMachine *VirtualMachine) mapString(mapID string) string {
+	obj := virtualMachine.heap[mapID]
+	entries := make([]string, len(obj.keys))
+	for i, key := range obj.keys {
+		entries[i] = virtualMachine.Display(key) + ": " + virtualMachine.Display(obj.entries[key])
+	}
+	return "{" + strings.Join(entries, ", ") + "}"
+}
@@ -0,0 +1,143 @@
+package virtm
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/Dor1ma/Strawberry/valuer"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Встроенные функции VM - те же, что и в интерпретаторе, с теми же сообщениями об ошибках.
+// Они лежат в глобальном окружении как значения FUNCTION с *Native вместо *Closure
+
+// Native - встроенная функция, написанная на Go
+type Native struct {
+	name  string
+	arity int // число аргументов или valuer.VariadicArity, если fn проверяет их сама
+	fn    func(vm *VirtualMachine, args []StackValue) StackValue
+}
+
+func (virtualMachine *VirtualMachine) defineNatives() {
+	for _, native := range []*Native{
+		{name: "len", arity: 1, fn: nativeLen},
+		{name: "clock", arity: 0, fn: nativeClock},
+		{name: "str", arity: 1, fn: nativeStr},
+		{name: "num", arity: 1, fn: nativeNum},
+		{name: "type", arity: 1, fn: nativeType},
+		{name: "input", arity: valuer.VariadicArity, fn: nativeInput},
+		{name: "push", arity: 2, fn: nativePush},
+		{name: "pop", arity: 1, fn: nativePop},
+	} {
+		virtualMachine.globals.define(native.name, StackValue{Value: native, ValueType: FUNCTION})
+	}
+}
+
+// callNative вызывает встроенную функцию и кладет результат в стек. Аргументы лежат в args, первый - на вершине
+func (virtualMachine *VirtualMachine) callNative(native *Native, args StackStruct) {
+	if native.arity != valuer.VariadicArity && native.arity != len(args) {
+		virtualMachine.error(fmt.Sprintf("Expected %d arguments but got %d", native.arity, len(args)))
+	}
+	arguments := make([]StackValue, len(args))
+	for i := range arguments {
+		arguments[i] = args[len(args)-1-i]
+	}
+	virtualMachine.stack.Push(native.fn(virtualMachine, arguments))
+}
+
+func nativeLen(vm *VirtualMachine, args []StackValue) StackValue {
+	switch args[0].ValueType {
+	case STRING:
+		return numberValue(float64(utf8.RuneCountInString(args[0].Value.(string))))
+	case ARRAY:
+		return numberValue(float64(len(vm.heap[args[0].Value.(string)].data)))
+	case MAP:
+		return numberValue(float64(len(vm.heap[args[0].Value.(string)].keys)))
+	}
+	vm.error(fmt.Sprintf("len() expects a string, array or map, got %s.", args[0].ValueType))
+	return StackValue{}
+}
+
+func nativeClock(vm *VirtualMachine, args []StackValue) StackValue {
+	return numberValue(float64(time.Now().UnixNano()) / float64(time.Second))
+}
+
+func nativeStr(vm *VirtualMachine, args []StackValue) StackValue {
+	return StackValue{Value: vm.Display(args[0]), ValueType: STRING}
+}
+
+func nativeNum(vm *VirtualMachine, args []StackValue) StackValue {
+	switch args[0].ValueType {
+	case NUMBER:
+		return args[0]
+	case BOOL:
+		if args[0].Value.(bool) {
+			return numberValue(1)
+		}
+		return numberValue(0)
+	case STRING:
+		s := args[0].Value.(string)
+		n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			vm.error(fmt.Sprintf("num() cannot convert %q to a number.", s))
+		}
+		return numberValue(n)
+	}
+	vm.error(fmt.Sprintf("num() cannot convert %s to a number.", args[0].ValueType))
+	return StackValue{}
+}
+
+func nativeType(vm *VirtualMachine, args []StackValue) StackValue {
+	return StackValue{Value: string(args[0].ValueType), ValueType: STRING}
+}
+
+// nativeInput печатает необязательное приглашение и читает строку из stdin. В конце ввода возвращает nil.
+func nativeInput(vm *VirtualMachine, args []StackValue) StackValue {
+	if len(args) > 1 {
+		vm.error(fmt.Sprintf("Expected at most 1 arguments but got %d", len(args)))
+	}
+	if len(args) == 1 {
+		fmt.Fprint(vm.stdout, vm.Display(args[0]))
+	}
+	line, err := vm.stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return nilValue()
+	}
+	return StackValue{Value: strings.TrimRight(line, "\r\n"), ValueType: STRING}
+}
+
+// nativePush добавляет элемент в конец массива и возвращает новую длину
+func nativePush(vm *VirtualMachine, args []StackValue) StackValue {
+	if args[0].ValueType != ARRAY {
+		vm.error(fmt.Sprintf("push() expects an array, got %s.", args[0].ValueType))
+	}
+	arrayID := args[0].Value.(string)
+	arr := vm.heap[arrayID]
+	arr.data = append(arr.data, args[1])
+	vm.heap[arrayID] = arr
+	return numberValue(float64(len(arr.data)))
+}
+
+// nativePop удаляет и возвращает последний элемент массива
+func nativePop(vm *VirtualMachine, args []StackValue) StackValue {
+	if args[0].ValueType != ARRAY {
+		vm.error(fmt.Sprintf("pop() expects an array, got %s.", args[0].ValueType))
+	}
+	arrayID := args[0].Value.(string)
+	arr := vm.heap[arrayID]
+	if len(arr.data) == 0 {
+		vm.error("pop() from empty array.")
+	}
+	last := arr.data[len(arr.data)-1]
+	arr.data = arr.data[:len(arr.data)-1]
+	vm.heap[arrayID] = arr
+	return last
+}
+
+// SetInput задает, откуда читает input(); по умолчанию os.Stdin
+func (virtualMachine *VirtualMachine) SetInput(r io.Reader) {
+	virtualMachine.stdin = bufio.NewReader(r)
+}
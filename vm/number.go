@@ -0,0 +1,40 @@
+package virtm
+
+import "github.com/Dor1ma/Strawberry/valuer"
+
+// Числа в VM, как и valuer.Number в интерпретаторе, хранятся как float64
+
+func numberValue(value float64) StackValue {
+	return StackValue{Value: value, ValueType: NUMBER}
+}
+
+// formatNumber печатает число так же, как valuer.Number
+func formatNumber(value float64) string {
+	return (&valuer.Number{Value: value}).String()
+}
+
+func (virtualMachine *VirtualMachine) checkNumberOperand(a StackValue) float64 {
+	if a.ValueType != NUMBER {
+		virtualMachine.error("Operand must be a number.")
+	}
+	return a.Value.(float64)
+}
+
+func (virtualMachine *VirtualMachine) checkNumberOperands(a, b StackValue) (float64, float64) {
+	if a.ValueType != NUMBER || b.ValueType != NUMBER {
+		virtualMachine.error("Operands must be numbers.")
+	}
+	return a.Value.(float64), b.Value.(float64)
+}
+
+// index проверяет индекс массива; дробная часть отбрасывается, как в интерпретаторе
+func (virtualMachine *VirtualMachine) index(index StackValue, length int) int {
+	if index.ValueType != NUMBER {
+		virtualMachine.error("Index out of bounds.")
+	}
+	value := index.Value.(float64)
+	if value < 0 || int(value) >= length {
+		virtualMachine.error("Index out of bounds.")
+	}
+	return int(value)
+}
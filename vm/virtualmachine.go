@@ -1,17 +1,28 @@
 package virtm
 
 import (
+	"bufio"
 	"fmt"
 	bytecode_gen "github.com/Dor1ma/Strawberry/bytecode"
-	"strconv"
+	"github.com/Dor1ma/Strawberry/errors"
+	"github.com/Dor1ma/Strawberry/token"
+	"io"
+	"os"
+	"runtime"
 	"strings"
 )
 
 const (
-	INT    = "int"
-	BOOL   = "bool"
-	STRING = "string"
-	ARRAY  = "array"
+	NUMBER   = "number"
+	BOOL     = "bool"
+	STRING   = "string"
+	ARRAY    = "array"
+	MAP      = "map"
+	ERROR    = "error"
+	FUNCTION = "function"
+	CLASS    = "class"
+	INSTANCE = "instance"
+	NIL      = "nil"
 )
 
 var isTailOptimizationEnabled = false
@@ -25,29 +36,84 @@ type StackValue struct {
 
 func (sv StackValue) String() string {
 	switch sv.ValueType {
-	case INT:
-		return fmt.Sprintf("%d", sv.Value.(int))
+	case NUMBER:
+		return formatNumber(sv.Value.(float64))
 	case BOOL:
 		return fmt.Sprintf("%t", sv.Value.(bool))
 	case STRING:
 		return fmt.Sprintf("'%s'", sv.Value.(string))
 	case ARRAY:
 		return fmt.Sprintf("[%s]", sv.Value)
+	case FUNCTION:
+		if native, ok := sv.Value.(*Native); ok {
+			return "<native fn " + native.name + ">"
+		}
+		return "<fn " + sv.Value.(*Closure).function.Name + ">"
+	case CLASS:
+		return "class " + sv.Value.(*Class).name
+	case NIL:
+		return "nil"
 	default:
 		return "UNKNOWN TYPE"
 	}
 }
 
+// Display возвращает значение в том виде, в каком его печатает print
+func (virtualMachine *VirtualMachine) Display(value StackValue) string {
+	switch value.ValueType {
+	case ARRAY:
+		data := virtualMachine.heap[value.Value.(string)].data
+		elements := make([]string, len(data))
+		for i, element := range data {
+			elements[i] = virtualMachine.Display(element)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	case MAP:
+		return virtualMachine.mapString(value.Value.(string))
+	case ERROR:
+		return virtualMachine.errorMessage(value)
+	case INSTANCE:
+		return virtualMachine.instanceString(value.Value.(string))
+	}
+	return valueString(value)
+}
+
+// valueString возвращает строку или число без кавычек, как они выглядят при сложении со строкой
+func valueString(sv StackValue) string {
+	if sv.ValueType == STRING {
+		return sv.Value.(string)
+	}
+	return sv.String()
+}
+
 type VirtualMachine struct {
-	stack           StackStruct
-	bytecode        []string
-	programCounter  int
-	variables       Variables
-	labels          map[string]int
-	heap            map[string]GCObject
-	arrayCounter    int
-	callStack       []StackStruct
-	returnAddresses StackStruct
+	stdout         io.Writer
+	stdin          *bufio.Reader
+	stack          StackStruct
+	program        *bytecode_gen.Program
+	function       *bytecode_gen.Function // исполняемая функция
+	programCounter int
+	instruction    int // начало исполняемой инструкции
+	globals        *Environment
+	env            *Environment // текущее окружение
+	heap           map[string]GCObject
+	arrayCounter   int
+	frames         []frame            // состояние вызывающих функций
+	calls          []errors.CallFrame // активные вызовы для трассировки стека
+	handlers       []handler          // обработчики исключений, последний - самый внутренний
+
+	hook Hook
+	line int // строка, о которой последней сообщили hook в текущей функции
+	last int // адрес предыдущей инструкции текущей функции, -1 после вызова и возврата
+}
+
+// frame - состояние функции, которая ждет возврата из вызова
+type frame struct {
+	function      *bytecode_gen.Function
+	returnAddress int
+	stack         StackStruct
+	env           *Environment
+	line          int
 }
 
 func (vm *VirtualMachine) newArrayID() string {
@@ -55,72 +121,181 @@ func (vm *VirtualMachine) newArrayID() string {
 	return fmt.Sprintf("array_%d", vm.arrayCounter)
 }
 
-func NewVirtualMachine(bytecode []string) *VirtualMachine {
-	return &VirtualMachine{
-		stack:           make(StackStruct, 0),
-		bytecode:        bytecode,
-		programCounter:  0,
-		variables:       *CreateVariables(),
-		labels:          make(map[string]int),
-		arrayCounter:    0,
-		heap:            make(map[string]GCObject),
-		returnAddresses: make(StackStruct, 0),
+func (vm *VirtualMachine) newMapID() string {
+	vm.arrayCounter++
+	return fmt.Sprintf("map_%d", vm.arrayCounter)
+}
+
+// NewVirtualMachine создает VM, которая исполняет нулевую функцию программы
+func NewVirtualMachine(program *bytecode_gen.Program) *VirtualMachine {
+	globals := newEnvironment(nil)
+	virtualMachine := &VirtualMachine{
+		stdout:   os.Stdout,
+		stdin:    bufio.NewReader(os.Stdin),
+		stack:    make(StackStruct, 0),
+		program:  program,
+		function: program.Functions[0],
+		globals:  globals,
+		env:      globals,
+		heap:     make(map[string]GCObject),
+	}
+	virtualMachine.defineNatives()
+	return virtualMachine
+}
+
+// Load читает программу, скомпилированную в файл .berryc, и создает VM для нее.
+// Поврежденный файл или файл другой версии формата возвращает ошибку
+func Load(in io.Reader) (*VirtualMachine, error) {
+	program, err := bytecode_gen.Read(in)
+	if err != nil {
+		return nil, err
 	}
+	return NewVirtualMachine(program), nil
 }
 
-func (virtualMachine *VirtualMachine) Run() {
-	virtualMachine.prepareLabels()
-	/*virtualMachine.cleanBytecode()*/
+// Run исполняет программу. Ошибка времени выполнения, не пойманная в try, возвращается как
+// *errors.RuntimeError. Нарушение инвариантов VM, например нехватка значений в стеке, тоже
+// возвращается ошибкой, а не роняет процесс
+func (virtualMachine *VirtualMachine) Run() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch r.(type) {
+			case string, runtime.Error:
+				err = fmt.Errorf("%s: internal error: %v", virtualMachine.pos(), r)
+			default:
+				// например, остановка программы отладчиком
+				panic(r)
+			}
+		}
+	}()
+	for {
+		runErr := virtualMachine.runUntilError()
+		if runErr == nil {
+			return nil
+		}
+		if !virtualMachine.handleError(runErr) {
+			return runErr
+		}
+	}
+}
 
-	for virtualMachine.programCounter < len(virtualMachine.bytecode) {
+// runUntilError исполняет инструкции до конца программы или до первой ошибки времени выполнения
+func (virtualMachine *VirtualMachine) runUntilError() (runErr *errors.RuntimeError) {
+	defer func() {
+		if r := recover(); r != nil {
+			if err, ok := r.(errors.RuntimeError); ok {
+				err = err.WithFrames(errors.BuildFrames(virtualMachine.calls, virtualMachine.pos()))
+				runErr = &err
+			} else {
+				panic(r)
+			}
+		}
+	}()
 
-		command := virtualMachine.bytecode[virtualMachine.programCounter]
+	for virtualMachine.programCounter < len(virtualMachine.function.Chunk.Code) {
+		virtualMachine.instruction = virtualMachine.programCounter
+		opcode := bytecode_gen.Opcode(virtualMachine.function.Chunk.Code[virtualMachine.programCounter])
 		virtualMachine.programCounter++
+		if virtualMachine.hook != nil {
+			virtualMachine.statement()
+		}
 
-		virtualMachine.execute(command)
+		virtualMachine.execute(opcode, virtualMachine.readOperands(opcode))
 	}
+	return nil
 }
 
-func (virtualMachine *VirtualMachine) execute(command string) {
-	instructions := strings.Fields(command)
+// pos возвращает позицию в исходнике для исполняемой инструкции
+func (virtualMachine *VirtualMachine) pos() token.Position {
+	return virtualMachine.function.Chunk.Position(virtualMachine.instruction)
+}
 
-	instruction := instructions[0]
+// readOperands читает операнды инструкции и переводит programCounter на следующую
+func (virtualMachine *VirtualMachine) readOperands(opcode bytecode_gen.Opcode) (operands [2]int) {
+	if !opcode.Valid() {
+		panic(fmt.Sprintf("Unknown opcode %d", opcode))
+	}
+	for i, width := range bytecode_gen.OperandWidths(opcode) {
+		operands[i] = bytecode_gen.ReadOperand(virtualMachine.function.Chunk.Code, virtualMachine.programCounter, width)
+		virtualMachine.programCounter += width
+	}
+	return operands
+}
 
-	var nonParsedArgument string
-	if len(instructions) > 1 {
-		nonParsedArgument = strings.Join(instructions[1:], " ")
+// constant возвращает значение из пула констант исполняемой функции
+func (virtualMachine *VirtualMachine) constant(index int) StackValue {
+	c := virtualMachine.function.Chunk.Constants[index]
+	if c.Type == bytecode_gen.NumberConstant {
+		return numberValue(c.Number)
 	}
+	return StackValue{Value: c.String, ValueType: STRING}
+}
 
-	argument, argType := virtualMachine.parseArgument(nonParsedArgument)
+// name возвращает имя переменной или свойства из пула констант
+func (virtualMachine *VirtualMachine) name(index int) string {
+	return virtualMachine.function.Chunk.Constants[index].String
+}
 
-	value := StackValue{Value: argument, ValueType: argType}
+func (virtualMachine *VirtualMachine) error(msg string) {
+	errors.Error(virtualMachine.pos(), token.Illegal, msg)
+}
 
-	switch instruction {
+func (virtualMachine *VirtualMachine) execute(opcode bytecode_gen.Opcode, operands [2]int) {
+	switch opcode {
 	case bytecode_gen.PUSH_CONST:
-		virtualMachine.stack.Push(value)
+		virtualMachine.stack.Push(virtualMachine.constant(operands[0]))
+
+	case bytecode_gen.NIL:
+		virtualMachine.stack.Push(nilValue())
+
+	case bytecode_gen.TRUE:
+		virtualMachine.stack.Push(StackValue{Value: true, ValueType: BOOL})
+
+	case bytecode_gen.FALSE:
+		virtualMachine.stack.Push(StackValue{Value: false, ValueType: BOOL})
 
 	case bytecode_gen.PUSH_VAR:
-		varValue := virtualMachine.variables.Get(nonParsedArgument)
+		name := virtualMachine.name(operands[0])
+		env := virtualMachine.variableEnv(operands[1])
+		varValue, ok := env.get(name)
+		if !ok {
+			virtualMachine.error(fmt.Sprintf("Undefined variable %s.", name))
+		}
 		virtualMachine.stack.Push(varValue)
 
 	case bytecode_gen.STORE_VAR:
 		poppedValue := virtualMachine.stack.Pop()
-		virtualMachine.variables.Set(nonParsedArgument, poppedValue)
+		name := virtualMachine.name(operands[0])
+		env := virtualMachine.variableEnv(operands[1])
+		if !env.assign(name, poppedValue) {
+			virtualMachine.error(fmt.Sprintf("Undefined variable %s.", name))
+		}
+
+	case bytecode_gen.DEFINE_VAR:
+		virtualMachine.env.define(virtualMachine.name(operands[0]), virtualMachine.stack.Pop())
+
+	case bytecode_gen.POP:
+		virtualMachine.stack.Pop()
+
+	case bytecode_gen.DUP:
+		top := virtualMachine.stack.Pop()
+		virtualMachine.stack.Push(top)
+		virtualMachine.stack.Push(top)
 
 	case bytecode_gen.ADD:
 		b := virtualMachine.stack.Pop()
 		a := virtualMachine.stack.Pop()
 
 		result := StackValue{}
-		switch a.ValueType {
-
-		case INT:
-			result.Value = a.Value.(int) + b.Value.(int)
-			result.ValueType = INT
-		case STRING:
-			result.Value = a.Value.(string) + b.Value.(string)
+		switch {
+		case a.ValueType == NUMBER && b.ValueType == NUMBER:
+			result = numberValue(a.Value.(float64) + b.Value.(float64))
+		case a.ValueType == STRING && (b.ValueType == STRING || b.ValueType == NUMBER),
+			a.ValueType == NUMBER && b.ValueType == STRING:
+			// строка и число складываются как строки, число печатается так же, как в print
+			result.Value = valueString(a) + valueString(b)
 			result.ValueType = STRING
-		case ARRAY:
+		case a.ValueType == ARRAY:
 			arr := virtualMachine.heap[a.Value.(string)]
 
 			arr.data = append(arr.data, b)
@@ -129,7 +304,7 @@ func (virtualMachine *VirtualMachine) execute(command string) {
 			result.Value = a.Value.(string)
 			result.ValueType = ARRAY
 		default:
-			panic("unsupported operation ADD for this type")
+			virtualMachine.error("Operands must be numbers or strings.")
 		}
 
 		virtualMachine.stack.Push(result)
@@ -138,188 +313,111 @@ func (virtualMachine *VirtualMachine) execute(command string) {
 		b := virtualMachine.stack.Pop()
 		a := virtualMachine.stack.Pop()
 
-		result := StackValue{}
-		switch a.ValueType {
-
-		case INT:
-			result.Value = a.Value.(int) - b.Value.(int)
-			result.ValueType = INT
-		default:
-			panic("unsupported operation SUB for this type")
-		}
-
-		virtualMachine.stack.Push(result)
+		x, y := virtualMachine.checkNumberOperands(a, b)
+		virtualMachine.stack.Push(numberValue(x - y))
 
 	case bytecode_gen.MUL:
 		b := virtualMachine.stack.Pop()
 		a := virtualMachine.stack.Pop()
 
-		result := StackValue{}
-		switch a.ValueType {
-
-		case INT:
-			result.Value = a.Value.(int) * b.Value.(int)
-			result.ValueType = INT
-		default:
-			panic("unsupported operation MUL for this type")
-		}
-
-		virtualMachine.stack.Push(result)
+		x, y := virtualMachine.checkNumberOperands(a, b)
+		virtualMachine.stack.Push(numberValue(x * y))
 
 	case bytecode_gen.DIV:
 		b := virtualMachine.stack.Pop()
 		a := virtualMachine.stack.Pop()
 
-		result := StackValue{}
-		if a.ValueType == INT && b.ValueType == INT {
-			if b.Value.(int) == 0 {
-				panic("Division by zero")
-			}
-			result.Value = a.Value.(int) / b.Value.(int)
-			result.ValueType = INT
-		} else {
-			panic("unsupported operation DIV for these types")
+		x, y := virtualMachine.checkNumberOperands(a, b)
+		if y == 0 {
+			virtualMachine.error("Divisor can't be 0.")
 		}
-
-		virtualMachine.stack.Push(result)
+		virtualMachine.stack.Push(numberValue(x / y))
 
 	case bytecode_gen.NEG:
 		a := virtualMachine.stack.Pop()
 
-		if a.ValueType != INT {
-			panic("unsupported operation NEG for non-integer type")
-		}
-
-		result := StackValue{Value: -a.Value.(int), ValueType: INT}
-		virtualMachine.stack.Push(result)
+		virtualMachine.stack.Push(numberValue(-virtualMachine.checkNumberOperand(a)))
 
 	case bytecode_gen.NOT:
 		a := virtualMachine.stack.Pop()
 
-		if a.ValueType != BOOL {
-			panic("unsupported operation NOT for non-boolean type")
-		}
-
-		result := StackValue{Value: !a.Value.(bool), ValueType: BOOL}
-		virtualMachine.stack.Push(result)
+		virtualMachine.stack.Push(StackValue{Value: !Truthy(a), ValueType: BOOL})
 
 	case bytecode_gen.AND:
+		// генератор вычисляет and и or с переходами; AND и OR считают оба операнда уже вычисленными
 		b := virtualMachine.stack.Pop()
 		a := virtualMachine.stack.Pop()
 
-		if a.ValueType == BOOL && b.ValueType == BOOL {
-			result := StackValue{Value: a.Value.(bool) && b.Value.(bool), ValueType: BOOL}
-			virtualMachine.stack.Push(result)
-		} else {
-			panic("unsupported operation AND for non-boolean types")
+		if Truthy(a) {
+			a = b
 		}
+		virtualMachine.stack.Push(a)
 
 	case bytecode_gen.OR:
 		b := virtualMachine.stack.Pop()
 		a := virtualMachine.stack.Pop()
 
-		if a.ValueType == BOOL && b.ValueType == BOOL {
-			result := StackValue{Value: a.Value.(bool) || b.Value.(bool), ValueType: BOOL}
-			virtualMachine.stack.Push(result)
-		} else {
-			panic("unsupported operation OR for non-boolean types")
+		if !Truthy(a) {
+			a = b
 		}
+		virtualMachine.stack.Push(a)
 
 	case bytecode_gen.LESS_THAN:
 		b := virtualMachine.stack.Pop()
 		a := virtualMachine.stack.Pop()
 
-		if a.ValueType == INT && b.ValueType == INT {
-			result := StackValue{Value: a.Value.(int) < b.Value.(int), ValueType: BOOL}
-			virtualMachine.stack.Push(result)
-		} else {
-			panic("unsupported operation LESS_THAN for these types")
-		}
+		x, y := virtualMachine.checkNumberOperands(a, b)
+		virtualMachine.stack.Push(StackValue{Value: x < y, ValueType: BOOL})
 
 	case bytecode_gen.GREATER_THAN:
 		b := virtualMachine.stack.Pop()
 		a := virtualMachine.stack.Pop()
 
-		if a.ValueType == INT && b.ValueType == INT {
-			result := StackValue{Value: a.Value.(int) > b.Value.(int), ValueType: BOOL}
-			virtualMachine.stack.Push(result)
-		} else {
-			panic("unsupported operation GREATER_THAN for these types")
-		}
+		x, y := virtualMachine.checkNumberOperands(a, b)
+		virtualMachine.stack.Push(StackValue{Value: x > y, ValueType: BOOL})
 
 	case bytecode_gen.LESS_EQUAL_THAN:
 		b := virtualMachine.stack.Pop()
 		a := virtualMachine.stack.Pop()
 
-		if a.ValueType == INT && b.ValueType == INT {
-			result := StackValue{Value: a.Value.(int) <= b.Value.(int), ValueType: BOOL}
-			virtualMachine.stack.Push(result)
-		} else {
-			panic("unsupported operation LESS_EQUAL_THAN for these types")
-		}
+		x, y := virtualMachine.checkNumberOperands(a, b)
+		virtualMachine.stack.Push(StackValue{Value: x <= y, ValueType: BOOL})
 
 	case bytecode_gen.GREATER_EQUAL_THAN:
 		b := virtualMachine.stack.Pop()
 		a := virtualMachine.stack.Pop()
 
-		if a.ValueType == INT && b.ValueType == INT {
-			result := StackValue{Value: a.Value.(int) >= b.Value.(int), ValueType: BOOL}
-			virtualMachine.stack.Push(result)
-		} else {
-			panic("unsupported operation GREATER_EQUAL_THAN for these types")
-		}
+		x, y := virtualMachine.checkNumberOperands(a, b)
+		virtualMachine.stack.Push(StackValue{Value: x >= y, ValueType: BOOL})
 
 	case bytecode_gen.EQUAL:
 		b := virtualMachine.stack.Pop()
 		a := virtualMachine.stack.Pop()
 
-		if a.ValueType == b.ValueType {
-			result := StackValue{Value: a.Value == b.Value, ValueType: BOOL}
-			virtualMachine.stack.Push(result)
-		} else {
-			panic("unsupported operation EQUAL for mismatched types")
-		}
+		// значения разных типов не равны, как в интерпретаторе
+		result := StackValue{Value: a == b, ValueType: BOOL}
+		virtualMachine.stack.Push(result)
 
 	case bytecode_gen.NOT_EQUAL:
 		b := virtualMachine.stack.Pop()
 		a := virtualMachine.stack.Pop()
 
-		if a.ValueType == b.ValueType {
-			result := StackValue{Value: a.Value != b.Value, ValueType: BOOL}
-			virtualMachine.stack.Push(result)
-		} else {
-			panic("unsupported operation NOT_EQUAL for mismatched types")
-		}
+		result := StackValue{Value: a != b, ValueType: BOOL}
+		virtualMachine.stack.Push(result)
 
 	case bytecode_gen.JUMP:
-		label := nonParsedArgument
-		if index, exists := virtualMachine.labels[nonParsedArgument]; exists {
-			virtualMachine.programCounter = index
-		} else {
-			panic(fmt.Sprintf("Label not found: %s", label))
-		}
+		// смещение отсчитывается от следующей инструкции
+		virtualMachine.programCounter += operands[0]
 
 	case bytecode_gen.JUMP_IF_FALSE:
 		condition := virtualMachine.stack.Pop()
-		label := nonParsedArgument
 
-		if condition.ValueType != BOOL {
-			panic("JUMP_IF_FALSE requires a boolean condition")
-		}
-
-		if !condition.Value.(bool) {
-			if index, exists := virtualMachine.labels[label]; exists {
-				virtualMachine.programCounter = index
-			} else {
-				panic(fmt.Sprintf("Label not found: %s", label))
-			}
+		if !Truthy(condition) {
+			virtualMachine.programCounter += operands[0]
 		}
 
 	case bytecode_gen.NEW_ARRAY:
-		size, err := strconv.Atoi(nonParsedArgument)
-		if err != nil || size < 0 {
-			panic("Invalid size for NEW_ARRAY")
-		}
+		size := operands[0]
 
 		newArray := make([]StackValue, size)
 
@@ -339,15 +437,42 @@ func (virtualMachine *VirtualMachine) execute(command string) {
 		virtualMachine.heap[arrayID] = obj
 		virtualMachine.stack.Push(StackValue{Value: arrayID, ValueType: ARRAY})
 
+	case bytecode_gen.NEW_MAP:
+		size := operands[0]
+
+		pairs := make([]StackValue, 2*size)
+		for i := len(pairs) - 1; i >= 0; i-- {
+			pairs[i] = virtualMachine.stack.Pop()
+		}
+
+		obj := GCObject{entries: make(map[StackValue]StackValue)}
+		for i := 0; i < len(pairs); i += 2 {
+			virtualMachine.mapSet(&obj, pairs[i], pairs[i+1])
+		}
+
+		mapID := virtualMachine.newMapID()
+		virtualMachine.heap[mapID] = obj
+		virtualMachine.stack.Push(StackValue{Value: mapID, ValueType: MAP})
+
+	case bytecode_gen.MAP_KEY:
+		virtualMachine.mapKey(virtualMachine.stack[len(virtualMachine.stack)-1])
+
 	case bytecode_gen.ARRAY_GET:
 		index := virtualMachine.stack.Pop()
 		arrayRef := virtualMachine.stack.Pop()
 
-		if index.ValueType != INT {
-			panic("ARRAY_GET requires an integer index")
+		if arrayRef.ValueType == MAP {
+			obj := virtualMachine.heap[arrayRef.Value.(string)]
+			value, ok := obj.entries[virtualMachine.mapKey(index)]
+			if !ok {
+				value = nilValue()
+			}
+			virtualMachine.stack.Push(value)
+			return
 		}
+
 		if arrayRef.ValueType != ARRAY {
-			panic("ARRAY_GET requires an array reference")
+			virtualMachine.error("Only arrays and maps can be indexed.")
 		}
 
 		arrayID := arrayRef.Value.(string)
@@ -356,10 +481,7 @@ func (virtualMachine *VirtualMachine) execute(command string) {
 			panic("Array not found")
 		}
 
-		idx := index.Value.(int)
-		if idx < 0 || idx >= len(arr.data) {
-			panic("Index out of bounds for ARRAY_GET")
-		}
+		idx := virtualMachine.index(index, len(arr.data))
 
 		virtualMachine.stack.Push(arr.data[idx])
 
@@ -368,11 +490,16 @@ func (virtualMachine *VirtualMachine) execute(command string) {
 		arrayRef := virtualMachine.stack.Pop()
 		pop := virtualMachine.stack.Pop()
 
-		if index.ValueType != INT {
-			panic("ARRAY_SET requires an integer index")
+		if arrayRef.ValueType == MAP {
+			mapID := arrayRef.Value.(string)
+			obj := virtualMachine.heap[mapID]
+			virtualMachine.mapSet(&obj, index, pop)
+			virtualMachine.heap[mapID] = obj
+			return
 		}
+
 		if arrayRef.ValueType != ARRAY {
-			panic("ARRAY_SET requires an array reference")
+			virtualMachine.error("Only arrays and maps can be indexed.")
 		}
 
 		arrayID := arrayRef.Value.(string)
@@ -381,152 +508,222 @@ func (virtualMachine *VirtualMachine) execute(command string) {
 			panic("Array not found")
 		}
 
-		idx := index.Value.(int)
-		if idx < 0 || idx >= len(arr.data) {
-			panic("Index out of bounds for ARRAY_SET")
-		}
+		idx := virtualMachine.index(index, len(arr.data))
 
 		arr.data[idx] = pop
 		virtualMachine.heap[arrayID] = arr
 
-	case bytecode_gen.LABEL:
-
-	case bytecode_gen.FALSE_LABEL, bytecode_gen.LOOP_START_LABEL, bytecode_gen.LOOP_END_LABEL, bytecode_gen.SCOPE_START:
+	case bytecode_gen.SCOPE_START:
+		virtualMachine.env = newEnvironment(virtualMachine.env)
 
 	case bytecode_gen.SCOPE_END:
+		virtualMachine.env = virtualMachine.env.enclosing
 		virtualMachine.Collect()
 
 	case bytecode_gen.CALL_FUNCTION:
-		argumentCount := virtualMachine.stack.Pop()
-
 		newStack := StackStruct{}
-		for i := 0; i < argumentCount.Value.(int); i++ {
+		for i := 0; i < operands[0]; i++ {
 			newStack.Push(virtualMachine.stack.Pop())
 		}
 
-		if isTailOptimizationEnabled {
-			nextInstruction := virtualMachine.bytecode[virtualMachine.programCounter]
-			if strings.TrimSpace(nextInstruction) == bytecode_gen.RETURN {
-				virtualMachine.stack = newStack
-				virtualMachine.programCounter = virtualMachine.labels[nonParsedArgument]
-				virtualMachine.variables.NewScope()
-				return
-			}
-		}
-
-		savedStack := virtualMachine.stack
-		virtualMachine.callStack = append(virtualMachine.callStack, savedStack)
-		virtualMachine.stack = newStack
-		virtualMachine.variables.NewScope()
-		virtualMachine.returnAddresses.Push(StackValue{virtualMachine.programCounter, INT})
+		virtualMachine.call(virtualMachine.stack.Pop(), newStack)
 
-		virtualMachine.programCounter = virtualMachine.labels[nonParsedArgument]
+	case bytecode_gen.CALL_METHOD:
+		name := virtualMachine.name(operands[0])
+		arguments := make([]StackValue, operands[1])
+		for i := len(arguments) - 1; i >= 0; i-- {
+			arguments[i] = virtualMachine.stack.Pop()
+		}
+		object := virtualMachine.stack.Pop()
 
-	case bytecode_gen.RETURN:
-		if len(virtualMachine.callStack) == 0 {
-			return
+		switch object.ValueType {
+		case MAP:
+			virtualMachine.stack.Push(virtualMachine.callMapMethod(object.Value.(string), name, arguments))
+		case INSTANCE:
+			newStack := StackStruct{}
+			for i := len(arguments) - 1; i >= 0; i-- {
+				newStack.Push(arguments[i])
+			}
+			virtualMachine.call(virtualMachine.getProperty(object, name), newStack)
+		default:
+			virtualMachine.error(fmt.Sprintf("Undefined method %s", name))
 		}
 
-		savedStack := virtualMachine.callStack[len(virtualMachine.callStack)-1]
-		virtualMachine.callStack = virtualMachine.callStack[:len(virtualMachine.callStack)-1]
+	case bytecode_gen.RETURN:
+		virtualMachine.returnFromCall()
 
-		returnedValue := virtualMachine.stack.Pop()
-		virtualMachine.stack = savedStack
-		virtualMachine.stack.Push(returnedValue)
+	case bytecode_gen.CLASS:
+		class := &Class{name: virtualMachine.name(operands[0]), methods: make(map[string]*Closure)}
+		virtualMachine.stack.Push(StackValue{Value: class, ValueType: CLASS})
 
-		virtualMachine.variables.PopScope()
-		returnAddress := virtualMachine.returnAddresses.Pop()
-		virtualMachine.programCounter = returnAddress.Value.(int)
+	case bytecode_gen.INHERIT:
+		// под вершиной стека - создаваемый класс, на вершине - родительский, он остается в стеке для super
+		superClass := virtualMachine.stack[len(virtualMachine.stack)-1]
+		class := virtualMachine.stack[len(virtualMachine.stack)-2]
+		if superClass.ValueType != CLASS {
+			virtualMachine.error("Superclass must be a class.")
+		}
+		class.Value.(*Class).superClass = superClass.Value.(*Class)
 
-	case bytecode_gen.PRINT:
-		pop := virtualMachine.stack.Pop()
+	case bytecode_gen.METHOD:
+		method := virtualMachine.stack.Pop().Value.(*Closure)
+		class := virtualMachine.stack[len(virtualMachine.stack)-1].Value.(*Class)
+		class.methods[virtualMachine.name(operands[0])] = method
 
-		if pop.ValueType == ARRAY {
-			fmt.Println(virtualMachine.heap[pop.Value.(string)].data)
-		} else {
-			fmt.Println(pop)
+	case bytecode_gen.THIS:
+		this, ok := virtualMachine.env.get("this")
+		if !ok {
+			virtualMachine.error("Cannot use 'this' outside of a class.")
 		}
+		virtualMachine.stack.Push(this)
 
-	case bytecode_gen.FUNC:
-		if len(instructions) < 2 {
-			panic("FUNC requires a function name")
-		}
+	case bytecode_gen.SUPER:
+		virtualMachine.stack.Push(virtualMachine.superMethod(virtualMachine.name(operands[0]), operands[1]))
 
-		functionName := instructions[1]
+	case bytecode_gen.SET_PROPERTY:
+		value := virtualMachine.stack.Pop()
+		object := virtualMachine.stack.Pop()
+		virtualMachine.setProperty(object, virtualMachine.name(operands[0]), value)
+		virtualMachine.stack.Push(value)
 
-		virtualMachine.labels[functionName] = virtualMachine.programCounter
+	case bytecode_gen.TRY_START:
+		virtualMachine.handlers = append(virtualMachine.handlers, handler{
+			target:    virtualMachine.programCounter + operands[0],
+			stackSize: len(virtualMachine.stack),
+			callDepth: len(virtualMachine.frames),
+			env:       virtualMachine.env,
+		})
 
-		for virtualMachine.programCounter < len(virtualMachine.bytecode) {
-			currentInstructions := strings.Fields(virtualMachine.bytecode[virtualMachine.programCounter])
+	case bytecode_gen.TRY_END:
+		virtualMachine.handlers = virtualMachine.handlers[:len(virtualMachine.handlers)-1]
 
-			if currentInstructions[0] == bytecode_gen.END_FUNC {
-				break
-			}
-			virtualMachine.programCounter++
-		}
+	case bytecode_gen.THROW:
+		thrown := virtualMachine.stack.Pop()
+		errors.Throw(virtualMachine.pos(), thrown, virtualMachine.errorMessage(thrown))
 
-		if virtualMachine.programCounter >= len(virtualMachine.bytecode) {
-			panic(fmt.Sprintf("END_FUNC not found for function %s", functionName))
-		}
+	case bytecode_gen.GET_PROPERTY:
+		virtualMachine.stack.Push(virtualMachine.getProperty(virtualMachine.stack.Pop(), virtualMachine.name(operands[0])))
 
-	case bytecode_gen.END_FUNC:
-		if len(instructions) < 2 {
-			panic("FUNC_END requires a function name")
-		}
+	case bytecode_gen.PRINT:
+		pop := virtualMachine.stack.Pop()
 
-		functionName := instructions[1]
-		if _, exists := virtualMachine.labels[functionName]; !exists {
-			panic(fmt.Sprintf("END_FUNC found for unknown function %s", functionName))
+		fmt.Fprintln(virtualMachine.stdout, virtualMachine.Display(pop))
+
+	case bytecode_gen.FUNC:
+		closure := &Closure{
+			function: virtualMachine.program.Functions[operands[0]],
+			env:      virtualMachine.env,
 		}
+		virtualMachine.stack.Push(StackValue{Value: closure, ValueType: FUNCTION})
 
 	default:
-		fmt.Printf("Unknown command: %s\n", instruction)
+		panic(fmt.Sprintf("Unknown opcode %s", opcode))
 	}
 }
 
-func (virtualMachine *VirtualMachine) prepareLabels() {
-	for i, command := range virtualMachine.bytecode {
-		if strings.HasPrefix(command, bytecode_gen.LABEL) {
-			parts := strings.Fields(command)
-			if len(parts) > 1 {
-				virtualMachine.labels[parts[1]] = i
+// call вызывает функцию или создает экземпляр класса. Аргументы лежат в args, первый - на вершине
+func (virtualMachine *VirtualMachine) call(callee StackValue, args StackStruct) {
+	var closure *Closure
+	switch callee.ValueType {
+	case FUNCTION:
+		if native, ok := callee.Value.(*Native); ok {
+			virtualMachine.callNative(native, args)
+			return
+		}
+		closure = callee.Value.(*Closure)
+	case CLASS:
+		class := callee.Value.(*Class)
+		instance := virtualMachine.newInstance(class)
+		initializer := class.findMethod("init")
+		if initializer == nil {
+			if len(args) != 0 {
+				virtualMachine.error(fmt.Sprintf("Expected %d arguments but got %d", 0, len(args)))
 			}
+			virtualMachine.stack.Push(instance)
+			return
 		}
+		// init возвращает this, поэтому результат вызова - сам экземпляр
+		closure = initializer.bind(instance)
+	default:
+		virtualMachine.error("Can only call functions and classes.")
+	}
+	function := closure.function
+	if function.Arity != len(args) {
+		virtualMachine.error(fmt.Sprintf("Expected %d arguments but got %d", function.Arity, len(args)))
 	}
-}
 
-/*func (virtualMachine *VirtualMachine) cleanBytecode() {
-	newBytecode := []string{}
-	for _, command := range virtualMachine.bytecode {
-		if !strings.HasPrefix(command, bytecode_gen.LABEL) {
-			newBytecode = append(newBytecode, command)
+	if isTailOptimizationEnabled {
+		code := virtualMachine.function.Chunk.Code
+		next := virtualMachine.programCounter
+		if next < len(code) && bytecode_gen.Opcode(code[next]) == bytecode_gen.RETURN && len(virtualMachine.frames) > 0 {
+			// кадр текущей функции переиспользуется вызываемой
+			if n := len(virtualMachine.calls); n > 0 {
+				virtualMachine.calls[n-1].Function = function.Name
+			}
+			virtualMachine.function = function
+			virtualMachine.stack = args
+			virtualMachine.env = newEnvironment(closure.env)
+			virtualMachine.programCounter = 0
+			virtualMachine.line, virtualMachine.last = 0, -1
+			return
 		}
 	}
-	virtualMachine.bytecode = newBytecode
-}*/
 
-func (virtualMachine *VirtualMachine) PrintBytecode() {
-	fmt.Printf("\n\n")
-	fmt.Printf("PRINT BYTECODE FUNCTION:\n\n")
+	virtualMachine.frames = append(virtualMachine.frames, frame{
+		function:      virtualMachine.function,
+		returnAddress: virtualMachine.programCounter,
+		stack:         virtualMachine.stack,
+		env:           virtualMachine.env,
+		line:          virtualMachine.line,
+	})
+	virtualMachine.calls = append(virtualMachine.calls, errors.CallFrame{Function: function.Name, Call: virtualMachine.pos()})
+
+	virtualMachine.function = function
+	virtualMachine.stack = args
+	virtualMachine.env = newEnvironment(closure.env)
+	virtualMachine.programCounter = 0
+	virtualMachine.line, virtualMachine.last = 0, -1
+}
 
-	for _, bc := range virtualMachine.bytecode {
-		fmt.Printf("%s", bc)
+func (virtualMachine *VirtualMachine) returnFromCall() {
+	n := len(virtualMachine.frames)
+	if n == 0 {
+		return
+	}
+	caller := virtualMachine.frames[n-1]
+	virtualMachine.frames = virtualMachine.frames[:n-1]
+
+	returnedValue := virtualMachine.stack.Pop()
+	virtualMachine.stack = caller.stack
+	virtualMachine.stack.Push(returnedValue)
+
+	virtualMachine.function = caller.function
+	virtualMachine.env = caller.env
+	call := virtualMachine.calls[len(virtualMachine.calls)-1]
+	virtualMachine.calls = virtualMachine.calls[:len(virtualMachine.calls)-1]
+	virtualMachine.programCounter = caller.returnAddress
+	virtualMachine.line, virtualMachine.last = caller.line, -1
+	if virtualMachine.hook != nil {
+		virtualMachine.hook.Return(call.Call)
 	}
 }
 
-func (virtualMachine *VirtualMachine) parseArgument(arg string) (interface{}, ValueType) {
-	if intValue, err := strconv.Atoi(arg); err == nil {
-		return intValue, INT
+// variableEnv возвращает окружение переменной по расстоянию, которое вычислил resolver
+func (virtualMachine *VirtualMachine) variableEnv(distance int) *Environment {
+	if distance == bytecode_gen.GlobalDistance {
+		return virtualMachine.globals
 	}
+	return virtualMachine.env.ancestor(distance)
+}
 
-	if arg == "true" {
-		return true, BOOL
-	}
-	if arg == "false" {
-		return false, BOOL
-	}
+// PrintBytecode печатает листинг исполняемой программы
+func (virtualMachine *VirtualMachine) PrintBytecode() {
+	bytecode_gen.Disassemble(os.Stdout, virtualMachine.program)
+}
 
-	return arg, STRING
+// SetOutput задает, куда печатает print; по умолчанию os.Stdout
+func (virtualMachine *VirtualMachine) SetOutput(w io.Writer) {
+	virtualMachine.stdout = w
 }
 
 func (virtualMachine *VirtualMachine) EnableTailRecursionOptimization() {
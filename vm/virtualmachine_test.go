@@ -0,0 +1,356 @@
+package virtm
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Dor1ma/Strawberry/ast"
+	bytecode_gen "github.com/Dor1ma/Strawberry/bytecode"
+	"github.com/Dor1ma/Strawberry/interpreter"
+	"github.com/Dor1ma/Strawberry/lexer"
+	"github.com/Dor1ma/Strawberry/parser"
+	"github.com/Dor1ma/Strawberry/resolver"
+	"strings"
+	"testing"
+)
+
+// engineTest - программа, которая должна одинаково выполняться VM и интерпретатором.
+// err - текст ошибки времени выполнения или "", если программа завершается без ошибки
+type engineTest struct {
+	input    string
+	expected string
+	err      string
+}
+
+// testEngines выполняет каждую программу на обоих движках. configure, если задан,
+// включает оптимизации генератора байт-кода
+func testEngines(t *testing.T, tests []engineTest, configure func(*bytecode_gen.CodeGenerator)) {
+	t.Helper()
+	for i, test := range tests {
+		for _, engine := range []string{"interp", "vm"} {
+			var out string
+			var err error
+			if engine == "vm" {
+				out, err = runVM(t, test.input, configure)
+			} else {
+				out, err = runInterpreter(t, test.input)
+			}
+			if out != test.expected {
+				t.Errorf("test [%d] %s: expected output %q. got %q", i, engine, test.expected, out)
+			}
+			if got := errorText(err); got != test.err {
+				t.Errorf("test [%d] %s: expected error %q. got %q", i, engine, test.err, got)
+			}
+		}
+	}
+}
+
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func runVM(t *testing.T, input string, configure func(*bytecode_gen.CodeGenerator)) (string, error) {
+	t.Helper()
+	generator := &bytecode_gen.CodeGenerator{}
+	if configure != nil {
+		configure(generator)
+	}
+	if err := generator.GenerateProgram(parse(t, input)); err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	program, err := generator.Program()
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	// сгенерированный код должен проходить ту же проверку, что и файл .berryc
+	if err := program.Validate(); err != nil {
+		t.Fatalf("invalid program: %s", err)
+	}
+	var out bytes.Buffer
+	vm := NewVirtualMachine(program)
+	vm.SetOutput(&out)
+	vm.SetInput(strings.NewReader(""))
+	err = vm.Run()
+	return out.String(), err
+}
+
+func runInterpreter(t *testing.T, input string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	interp := interpreter.New(interpreter.Options{Stdout: &out, Stderr: &out, Stdin: strings.NewReader("")})
+	err := interp.Interpret(parse(t, input))
+	return out.String(), err
+}
+
+func parse(t *testing.T, input string) []ast.Statement {
+	t.Helper()
+	statements, err := parser.New(lexer.New(input)).Parse()
+	if err != nil {
+		t.Fatalf("parse error: %s", err)
+	}
+	if err := resolver.Check(statements); err != nil {
+		t.Fatalf("resolve error: %s", err)
+	}
+	return statements
+}
+
+func TestDisplay(t *testing.T) {
+	tests := []engineTest{
+		{input: `print [1, [2.5, "a"], nil, true];`, expected: "[1, [2.5, a], nil, true]\n"},
+		{
+			input:    `var m = {"a": [1, 2], "b": {"c": nil}}; print m; print [m, {}];`,
+			expected: "{a: [1, 2], b: {c: nil}}\n[{a: [1, 2], b: {c: nil}}, {}]\n",
+		},
+		{input: `var a = []; print a; print {1.5: a, false: "x"};`, expected: "[]\n{1.5: [], false: x}\n"},
+	}
+	testEngines(t, tests, nil)
+}
+
+func TestNatives(t *testing.T) {
+	tests := []engineTest{
+		{input: `print len("héllo") + len([1, 2]) + len({"a": 1});`, expected: "8\n"},
+		{input: `var a = [1]; print push(a, "x"); print a; print pop(a); print a;`, expected: "2\n[1, x]\nx\n[1]\n"},
+		{input: `print str([1, "a", nil]) + "!"; print str(1.5) + "";`, expected: "[1, a, nil]!\n1.5\n"},
+		{input: `print num(" 2.5 ") * 2; print num(true) + num(false);`, expected: "5\n1\n"},
+		{input: `print type(1) + " " + type("") + " " + type(nil) + " " + type(true) + " " + type(len) + " " + type({});`,
+			expected: "number string nil bool function map\n"},
+		{input: `print len; print clock() > 0;`, expected: "<native fn len>\ntrue\n"},
+		{input: `print input("name? ");`, expected: "name? nil\n"},
+		{input: `var f = len; print f([1, 2, 3]);`, expected: "3\n"},
+		{input: `try { pop([]); } catch (e) { print e; }`, expected: "pop() from empty array.\n"},
+		{input: `print len(1);`, err: "1:7: len() expects a string, array or map, got number."},
+		{input: `print num("x");`, err: `1:7: num() cannot convert "x" to a number.`},
+		{input: `len(1, 2);`, err: "1:1: Expected 1 arguments but got 2"},
+	}
+	testEngines(t, tests, nil)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []engineTest{
+		{
+			input: `fun makeCounter() { var i = 0; fun count() { i = i + 1; return i; } return count; }
+var c = makeCounter(); c(); print c();
+var d = makeCounter(); print d();`,
+			expected: "2\n1\n",
+		},
+		{
+			// замыкание видит переменную, которая была в области видимости при объявлении
+			input: `var a = "global";
+{ fun show() { print a; } show(); var a = "block"; show(); print a; }`,
+			expected: "global\nglobal\nblock\n",
+		},
+		{
+			input: `var fs = [];
+for (var i = 0; i < 3; i = i + 1) { var j = i; fun f() { return j; } push(fs, f); }
+print fs[0]() + fs[1]() + fs[2]();`,
+			expected: "3\n",
+		},
+		{
+			input: `fun outer() { var x = 1; fun middle() { fun inner() { x = x + 10; return x; } return inner; } return middle(); }
+var f = outer(); f(); print f();`,
+			expected: "21\n",
+		},
+		{
+			input:    `var add = fun (a, b) { return a + b; }; var twice = (f, x) => f(f(x, x), x); print twice(add, 2);`,
+			expected: "6\n",
+		},
+		{
+			input:    `fun apply(f) { return f(3); } var k = 4; print apply((x) => x * k);`,
+			expected: "12\n",
+		},
+		{
+			input: `fun f(a) { return a; } f(1, 2);`,
+			err:   "1:24: Expected 1 arguments but got 2",
+		},
+	}
+	testEngines(t, tests, nil)
+}
+
+func TestTruthiness(t *testing.T) {
+	tests := []engineTest{
+		{input: `if (1) print "a"; if (0) print "b"; if ("") print "c"; if ("x") print "d";`, expected: "a\nd\n"},
+		{input: `if (nil) print "a"; else print "b"; print !nil; print !0; print !"x";`, expected: "b\ntrue\ntrue\nfalse\n"},
+		{input: `print nil or "d"; print 1 and 2; print 0 or ""; print false and 1; print "a" or 1;`, expected: "d\n2\n\nfalse\na\n"},
+		{input: `var i = 3; while (i) { print i; i = i - 1; }`, expected: "3\n2\n1\n"},
+		{
+			// правый операнд не вычисляется, если результат ясен по левому
+			input:    `fun f() { print "f"; return true; } print false and f(); print true or f(); print nil or f();`,
+			expected: "false\ntrue\nf\ntrue\n",
+		},
+		{input: `var a = nil; var b = a or (a = 5); print a; print b;`, expected: "5\n5\n"},
+	}
+	testEngines(t, tests, nil)
+}
+
+func TestClasses(t *testing.T) {
+	tests := []engineTest{
+		{
+			input: `class Point { init(x, y) { this.x = x; this.y = y; } sum() { return this.x + this.y; } }
+var p = Point(1, 2); print p.sum(); p.x = 10; print p.sum(); print p;`,
+			expected: "3\n12\nPoint instance\n",
+		},
+		{
+			input: `class A { name() { return "A"; } hello() { return "hello " + this.name(); } }
+class B < A { name() { return "B"; } hello() { return super.hello() + "!"; } }
+print B().hello(); print A().hello();`,
+			expected: "hello B!\nhello A\n",
+		},
+		{
+			// метод, взятый у экземпляра, помнит this
+			input:    `class C { init() { this.n = 7; } get() { return this.n; } } var g = C().get; print g();`,
+			expected: "7\n",
+		},
+		{
+			input:    `class Counter { init() { this.n = 0; } inc() { this.n = this.n + 1; return this; } } print Counter().inc().inc().n;`,
+			expected: "2\n",
+		},
+		{input: `class E {} print E; E().missing;`, expected: "class E\n", err: "1:21: Undefined propterty missing."},
+		{input: `var x = 1; class F < x {}`, err: "1:22: Superclass must be a class."},
+	}
+	testEngines(t, tests, nil)
+}
+
+func TestNumbers(t *testing.T) {
+	tests := []engineTest{
+		{input: `print 0.1 + 0.2; print 7 / 2; print 1 / 3; print -2.5 * 4;`, expected: "0.30000000000000004\n3.5\n0.3333333333333333\n-10\n"},
+		{input: `print 1e21 * 10; print 100000000000000000000; print 2.0;`, expected: "10000000000000000000000\n100000000000000000000\n2\n"},
+		{input: `print 1.5 < 2; print 2 <= 2.0; print 3 == 3.0; print 0.5 != 0.25 * 2;`, expected: "true\ntrue\ntrue\nfalse\n"},
+		{input: `print "n=" + 2.5; print 1.25 + "!";`, expected: "n=2.5\n1.25!\n"},
+		{input: `var a = [10, 20, 30]; print a[1.9];`, expected: "20\n"},
+		{input: `var a = 1; print a / (a - 1);`, err: "1:22: Divisor can't be 0."},
+		{input: `print -"a";`, err: "1:7: Operand must be a number."},
+		{input: `print 1 - "a";`, err: "1:7: Operands must be numbers."},
+	}
+	testEngines(t, tests, nil)
+}
+
+// largeProgram - скрипт, в котором больше 256 разных констант и переходы через сотни байт кода
+func largeProgram() (string, string) {
+	var src, out strings.Builder
+	src.WriteString("var sum = 0;\nvar i = 0;\nwhile (i < 2) {\n")
+	total := 0
+	for n := 1; n <= 300; n++ {
+		fmt.Fprintf(&src, "    sum = sum + %d;\n", n)
+		total += n
+	}
+	src.WriteString("    i = i + 1;\n}\nprint sum;\n")
+	fmt.Fprintf(&out, "%d\n", 2*total)
+	return src.String(), out.String()
+}
+
+func TestEncoding(t *testing.T) {
+	large, largeOut := largeProgram()
+	tests := []engineTest{
+		{input: large, expected: largeOut},
+		{
+			// у каждой функции свой пул констант
+			input:    `fun a() { return "a" + 1.5; } fun b() { return 2.5 + "b"; } print a() + b(); print "a" + 2.5;`,
+			expected: "a1.52.5b\na2.5\n",
+		},
+		{
+			input: `fun outer() { var s = "x"; fun inner(n) { if (n == 0) return s; return inner(n - 1) + n; } return inner(3); }
+print outer();`,
+			expected: "x123\n",
+		},
+		{input: `var i = 0; while (true) { i = i + 1; if (i > 5) break; } print i;`, expected: "6\n"},
+	}
+	testEngines(t, tests, nil)
+
+	// константы функции лежат в ее собственном пуле
+	generator := &bytecode_gen.CodeGenerator{}
+	if err := generator.GenerateProgram(parse(t, `fun f() { return "inside"; } print "top";`)); err != nil {
+		t.Fatal(err)
+	}
+	program, err := generator.Program()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range program.Functions {
+		for _, c := range fn.Chunk.Constants {
+			if c.String == "inside" && fn.Name != "f" || c.String == "top" && fn.Name == "f" {
+				t.Errorf("constant %s is in the pool of %s", c.Format(), fn.Name)
+			}
+		}
+	}
+}
+
+func TestMaps(t *testing.T) {
+	tests := []engineTest{
+		{input: `var m = {"a": 1, 2: "two", true: [1]}; print m; print m["a"] + m.len(); print m[2]; print m[true];`, expected: "{a: 1, 2: two, true: [1]}\n4\ntwo\n[1]\n"},
+		{input: `var m = {}; m["x"] = 1; m["y"] = 2; m["x"] = 3; print m; print m.keys(); print m["z"];`, expected: "{x: 3, y: 2}\n[x, y]\nnil\n"},
+		{input: `var m = {"a": 1}; print m.has("a"); print m.delete("a"); print m.has("a"); print m.delete([]);`, expected: "true\ntrue\nfalse\nfalse\n"},
+		{input: `var m = {"in": {"k": [1, 2]}}; m["in"]["k"][1] = 5; print m;`, expected: "{in: {k: [1, 5]}}\n"},
+		{input: `var m = {}; m[[1]] = 1;`, err: "1:15: Map keys must be strings, numbers or booleans."},
+		{input: `var m = {[1]: 2};`, err: "1:10: Map keys must be strings, numbers or booleans."},
+		{
+			// ключ проверяется до вычисления значения
+			input:    `fun f() { print "f"; return 1; } var m = {"a": f(), nil: f()};`,
+			expected: "f\n",
+			err:      "1:53: Map keys must be strings, numbers or booleans.",
+		},
+		{input: `var m = {"a": 1}; print m[nil];`, err: "1:27: Map keys must be strings, numbers or booleans."},
+		{input: `var a = [1]; a[1] = 2;`, err: "1:16: Index out of bounds."},
+		{input: `var a = [1]; print a[-1];`, err: "1:22: Index out of bounds."},
+		{input: `var m = {}; m.nope();`, err: "1:13: Undefined map method nope."},
+	}
+	testEngines(t, tests, nil)
+}
+
+func TestLoopControl(t *testing.T) {
+	tests := []engineTest{
+		{input: `for (var i = 0; i < 10; i = i + 1) { if (i == 3) break; print i; }`, expected: "0\n1\n2\n"},
+		{input: `for (var i = 0; i < 5; i = i + 1) { if (i == 1 or i == 3) continue; print i; }`, expected: "0\n2\n4\n"},
+		{
+			input:    `var i = 0; while (i < 3) { i = i + 1; var j = 0; while (true) { j = j + 1; if (j > i) break; if (j == 1) continue; print i * 10 + j; } }`,
+			expected: "22\n32\n33\n",
+		},
+		{
+			// break и continue закрывают области видимости тела цикла
+			input:    `var fs = []; for (var i = 0; i < 4; i = i + 1) { var k = i * 2; if (i == 1) continue; if (i == 3) break; push(fs, () => k); } print fs[0]() + fs[1]();`,
+			expected: "4\n",
+		},
+		{
+			input:    `fun f() { for (var i = 0; i < 5; i = i + 1) { try { if (i == 2) break; } finally { print "f" + i; } } return "done"; } print f();`,
+			expected: "f0\nf1\nf2\ndone\n",
+		},
+	}
+	testEngines(t, tests, nil)
+}
+
+func TestUnrolledLoops(t *testing.T) {
+	tests := []engineTest{
+		{input: `for (var i = 0; i < 3; i = i + 1) print i;`, expected: "0\n1\n2\n"},
+		{input: `for (var i = 2; i < 4; i = i + 1) print i;`, expected: "2\n3\n"},
+		{input: `for (var i = 1; i <= 3; i = i + 1) { var s = "#" + i; print s; }`, expected: "#1\n#2\n#3\n"},
+		{input: `for (var i = 5; i < 3; i = i + 1) print i; print "none";`, expected: "none\n"},
+		{input: `var k = 10; while (k < 3) { print k; k = k + 1; }`, expected: ""},
+		{
+			// счетчик, который меняется в теле, не дает развернуть цикл
+			input:    `for (var i = 0; i < 6; i = i + 1) { print i; i = i + 1; }`,
+			expected: "0\n2\n4\n",
+		},
+		{input: `for (var i = 0; i < 3; i = i + 1) { var f = () => i = 10; f(); print i; }`, expected: "10\n"},
+		{input: `var fs = []; for (var i = 0; i < 2; i = i + 1) push(fs, () => i); print fs[0]() + fs[1]();`, expected: "4\n"},
+		{input: `for (var i = 0; i < 100; i = i + 1) { if (i == 2) break; print i; }`, expected: "0\n1\n"},
+	}
+	testEngines(t, tests, func(cg *bytecode_gen.CodeGenerator) { cg.EnableLoopEnrolling() })
+}
+
+func TestRunInvalidProgram(t *testing.T) {
+	// программа не проходит Validate: PRINT снимает значение с пустого стека
+	program := &bytecode_gen.Program{Functions: []*bytecode_gen.Function{{
+		Name:  "<script>",
+		Chunk: bytecode_gen.Chunk{Code: []byte{byte(bytecode_gen.RETURN), byte(bytecode_gen.PRINT)}},
+	}}}
+	if program.Validate() == nil {
+		t.Fatal("invalid program passes validation")
+	}
+	vm := NewVirtualMachine(program)
+	vm.SetOutput(&bytes.Buffer{})
+	err := vm.Run()
+	if err == nil || !strings.Contains(err.Error(), "internal error: Stack underflow!") {
+		t.Fatalf("expected internal error, got %v", err)
+	}
+}
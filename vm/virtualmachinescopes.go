@@ -1,53 +1,54 @@
 package virtm
 
-import "fmt"
+import bytecode_gen "github.com/Dor1ma/Strawberry/bytecode"
 
-type Scope map[string]StackValue
+// Environment - окружение с переменными. Окружения связаны в цепочку так же, как области видимости
+// в resolver, поэтому переменную можно найти по расстоянию, которое он вычислил
+type Environment struct {
+	values    map[string]StackValue
+	enclosing *Environment
+}
 
-func (scope *Scope) get(name string) StackValue {
-	if value, ok := (*scope)[name]; ok {
-		return value
+func newEnvironment(enclosing *Environment) *Environment {
+	return &Environment{
+		values:    make(map[string]StackValue),
+		enclosing: enclosing,
 	}
-
-	panic(fmt.Sprintf("No variable in scope named: %s", name))
 }
 
-func (scope *Scope) set(name string, value StackValue) {
-	(*scope)[name] = value
+func (env *Environment) define(name string, value StackValue) {
+	env.values[name] = value
 }
 
-type Variables []Scope
-
-func (variables Variables) Get(name string) StackValue {
-	for index, _ := range variables {
-		scope := variables[len(variables)-1-index]
-
-		if value, ok := scope[name]; ok {
-			return value
+func (env *Environment) get(name string) (StackValue, bool) {
+	for cur := env; cur != nil; cur = cur.enclosing {
+		if value, ok := cur.values[name]; ok {
+			return value, true
 		}
 	}
-
-	panic(fmt.Sprintf("Variable %s is not defined", name))
+	return StackValue{}, false
 }
 
-func (variables *Variables) Set(name string, value StackValue) {
-	scope := (*variables)[len(*variables)-1]
-
-	scope.set(name, value)
-}
-
-func (variables *Variables) PopScope() {
-	*variables = (*variables)[:len(*variables)-1]
+func (env *Environment) assign(name string, value StackValue) bool {
+	for cur := env; cur != nil; cur = cur.enclosing {
+		if _, ok := cur.values[name]; ok {
+			cur.values[name] = value
+			return true
+		}
+	}
+	return false
 }
 
-func (variables *Variables) NewScope() {
-	*variables = append(*variables, make(Scope))
+func (env *Environment) ancestor(distance int) *Environment {
+	cur := env
+	for i := 0; i < distance && cur.enclosing != nil; i++ {
+		cur = cur.enclosing
+	}
+	return cur
 }
 
-func CreateVariables() *Variables {
-	vars := &Variables{}
-
-	vars.NewScope()
-
-	return vars
+// Closure - функция вместе с окружением, в котором она создана
+type Closure struct {
+	function *bytecode_gen.Function
+	env      *Environment
 }